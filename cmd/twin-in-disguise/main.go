@@ -21,11 +21,15 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/files"
 	"github.com/savaki/twin-in-disguise/server"
+	"github.com/savaki/twin-in-disguise/signatures"
+	"github.com/savaki/twin-in-disguise/translator"
 	"github.com/urfave/cli/v2"
 	"google.golang.org/api/option"
 )
@@ -69,6 +73,12 @@ func (w *responseWriterWrapper) WriteHeader(statusCode int) {
 	w.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Unwrap returns the underlying http.ResponseWriter so http.ResponseController can
+// reach its Flush and SetWriteDeadline methods (required for streaming responses)
+func (w *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func main() {
 	app := &cli.App{
 		Name:    "twin-in-disguise",
@@ -92,6 +102,152 @@ func main() {
 				Usage:   "Enable debug logging (shows Gemini API calls)",
 				EnvVars: []string{"DEBUG"},
 			},
+			&cli.IntFlag{
+				Name:    "retry-max-attempts",
+				Usage:   "Maximum attempts for a Gemini request, including the first (1 disables retries)",
+				EnvVars: []string{"RETRY_MAX_ATTEMPTS"},
+				Value:   translator.DefaultRetryMaxAttempts,
+			},
+			&cli.DurationFlag{
+				Name:    "retry-deadline",
+				Usage:   "Overall time budget for retrying a Gemini request (0 for no limit)",
+				EnvVars: []string{"RETRY_DEADLINE"},
+				Value:   translator.DefaultRetryDeadline,
+			},
+			&cli.StringFlag{
+				Name:    "tool-args-strategy",
+				Usage:   "What to do with function call arguments that don't match the tool's schema after repairs: passthrough, error or reprompt",
+				EnvVars: []string{"TOOL_ARGS_STRATEGY"},
+				Value:   string(translator.ToolArgsPassthrough),
+			},
+			&cli.Int64Flag{
+				Name:    "url-fetch-max-bytes",
+				Usage:   "Largest image or document downloaded for a URL source",
+				EnvVars: []string{"URL_FETCH_MAX_BYTES"},
+				Value:   translator.DefaultURLFetchMaxBytes,
+			},
+			&cli.Int64Flag{
+				Name:    "url-fetch-max-request-bytes",
+				Usage:   "Total size of the URL sources downloaded for a single request (0 for no limit)",
+				EnvVars: []string{"URL_FETCH_MAX_REQUEST_BYTES"},
+				Value:   translator.DefaultURLFetchMaxRequestBytes,
+			},
+			&cli.IntFlag{
+				Name:    "url-fetch-max-sources",
+				Usage:   "Number of URL sources downloaded for a single request (0 for no limit)",
+				EnvVars: []string{"URL_FETCH_MAX_SOURCES"},
+				Value:   translator.DefaultURLFetchMaxSources,
+			},
+			&cli.DurationFlag{
+				Name:    "url-fetch-cache-ttl",
+				Usage:   "How long downloaded URL sources are reused by later requests (0 to disable)",
+				EnvVars: []string{"URL_FETCH_CACHE_TTL"},
+				Value:   translator.DefaultURLFetchCacheTTL,
+			},
+			&cli.DurationFlag{
+				Name:    "url-fetch-timeout",
+				Usage:   "Time limit for downloading a URL source",
+				EnvVars: []string{"URL_FETCH_TIMEOUT"},
+				Value:   translator.DefaultURLFetchTimeout,
+			},
+			&cli.BoolFlag{
+				Name:    "url-fetch-allow-private-networks",
+				Usage:   "Allow URL sources that resolve to loopback, private or link-local addresses",
+				EnvVars: []string{"URL_FETCH_ALLOW_PRIVATE_NETWORKS"},
+			},
+			&cli.BoolFlag{
+				Name:    "context-caching",
+				Usage:   "Map cache_control breakpoints to Gemini context caches",
+				EnvVars: []string{"CONTEXT_CACHING"},
+				Value:   true,
+			},
+			&cli.BoolFlag{
+				Name:    "validate-models",
+				Usage:   "Reject requests for models that Gemini doesn't list as supporting generateContent",
+				EnvVars: []string{"VALIDATE_MODELS"},
+				Value:   true,
+			},
+			&cli.StringFlag{
+				Name:    "model-map",
+				Usage:   "Routes from requested model names to Gemini models, e.g. 'claude-*-haiku-*=gemini-2.5-flash,claude-sonnet-*=gemini-3-pro-preview'",
+				EnvVars: []string{"MODEL_MAP"},
+			},
+			&cli.StringFlag{
+				Name:    "model-map-file",
+				Usage:   "File with one pattern=model route per line, tried after --model-map",
+				EnvVars: []string{"MODEL_MAP_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "response-model",
+				Usage:   "Model name carried by responses to routed requests: requested or resolved",
+				EnvVars: []string{"RESPONSE_MODEL"},
+				Value:   string(translator.ResponseModelRequested),
+			},
+			&cli.StringFlag{
+				Name:    "files-store",
+				Usage:   "Where files uploaded through the Files API are kept: memory or bolt (on disk, survives restarts)",
+				EnvVars: []string{"FILES_STORE"},
+				Value:   files.StoreMemory,
+			},
+			&cli.StringFlag{
+				Name:    "files-store-path",
+				Usage:   "Database file for --files-store=bolt",
+				EnvVars: []string{"FILES_STORE_PATH"},
+				Value:   "files.db",
+			},
+			&cli.Int64Flag{
+				Name:    "files-memory-bytes",
+				Usage:   "Total size of file content kept by --files-store=memory; the least recently used content is evicted beyond it",
+				EnvVars: []string{"FILES_MEMORY_BYTES"},
+				Value:   files.DefaultMemoryBytes,
+			},
+			&cli.Int64Flag{
+				Name:    "files-max-bytes",
+				Usage:   "Largest file accepted by the Files API",
+				EnvVars: []string{"FILES_MAX_BYTES"},
+				Value:   files.DefaultMaxBytes,
+			},
+			&cli.IntFlag{
+				Name:    "signature-cache-max-entries",
+				Usage:   "Maximum number of cached thought signatures (0 for no limit)",
+				EnvVars: []string{"SIGNATURE_CACHE_MAX_ENTRIES"},
+				Value:   signatures.DefaultMaxEntries,
+			},
+			&cli.Int64Flag{
+				Name:    "signature-cache-max-bytes",
+				Usage:   "Maximum total size of cached thought signatures in bytes (0 for no limit)",
+				EnvVars: []string{"SIGNATURE_CACHE_MAX_BYTES"},
+				Value:   signatures.DefaultMaxBytes,
+			},
+			&cli.DurationFlag{
+				Name:    "signature-cache-ttl",
+				Usage:   "How long thought signatures are cached (0 for no expiry)",
+				EnvVars: []string{"SIGNATURE_CACHE_TTL"},
+				Value:   signatures.DefaultTTL,
+			},
+			&cli.StringFlag{
+				Name:    "signature-store",
+				Usage:   "Where thought signatures are kept: memory, bolt (on disk, survives restarts), redis (shared) or stateless (in tool_use IDs)",
+				EnvVars: []string{"SIGNATURE_STORE"},
+				Value:   signatures.StoreMemory,
+			},
+			&cli.StringFlag{
+				Name:    "signature-store-path",
+				Usage:   "Database file for --signature-store=bolt",
+				EnvVars: []string{"SIGNATURE_STORE_PATH"},
+				Value:   "signatures.db",
+			},
+			&cli.StringFlag{
+				Name:    "redis-url",
+				Usage:   "Redis server for --signature-store=redis",
+				EnvVars: []string{"REDIS_URL"},
+				Value:   signatures.DefaultRedisURL,
+			},
+			&cli.StringFlag{
+				Name:    "signature-hmac-key",
+				Usage:   "Key authenticating tool_use IDs for --signature-store=stateless (shared by all replicas)",
+				EnvVars: []string{"SIGNATURE_HMAC_KEY"},
+			},
 		},
 		Action: runServer,
 	}
@@ -111,11 +267,137 @@ func runServer(c *cli.Context) error {
 	verbose := c.Bool("verbose")
 	debug := c.Bool("debug")
 
+	opts := proxyOptions{
+		retryPolicy:        translator.DefaultRetryPolicy(),
+		signatureStore:     c.String("signature-store"),
+		signatureStorePath: c.String("signature-store-path"),
+		signatureHMACKey:   c.String("signature-hmac-key"),
+		redisURL:           c.String("redis-url"),
+		filesStore:         c.String("files-store"),
+		filesStorePath:     c.String("files-store-path"),
+		filesMemoryBytes:   c.Int64("files-memory-bytes"),
+		filesMaxBytes:      c.Int64("files-max-bytes"),
+		contextCaching:     c.Bool("context-caching"),
+		validateModels:     c.Bool("validate-models"),
+		signatureCache: signatures.Config{
+			MaxEntries: c.Int("signature-cache-max-entries"),
+			MaxBytes:   c.Int64("signature-cache-max-bytes"),
+			TTL:        c.Duration("signature-cache-ttl"),
+		},
+	}
+	opts.retryPolicy.MaxAttempts = c.Int("retry-max-attempts")
+	opts.retryPolicy.Deadline = c.Duration("retry-deadline")
+	if opts.retryPolicy.MaxAttempts < 1 {
+		return fmt.Errorf("--retry-max-attempts must be at least 1")
+	}
+	toolArgsStrategy, err := translator.ParseToolArgsStrategy(c.String("tool-args-strategy"))
+	if err != nil {
+		return err
+	}
+	opts.toolArgsStrategy = toolArgsStrategy
+	opts.urlFetchPolicy = translator.URLFetchPolicy{
+		MaxBytes:             c.Int64("url-fetch-max-bytes"),
+		MaxRequestBytes:      c.Int64("url-fetch-max-request-bytes"),
+		MaxSources:           c.Int("url-fetch-max-sources"),
+		Timeout:              c.Duration("url-fetch-timeout"),
+		CacheTTL:             c.Duration("url-fetch-cache-ttl"),
+		AllowPrivateNetworks: c.Bool("url-fetch-allow-private-networks"),
+	}
+	if opts.urlFetchPolicy.MaxBytes < 1 || opts.urlFetchPolicy.Timeout <= 0 {
+		return fmt.Errorf("--url-fetch-max-bytes and --url-fetch-timeout must be positive")
+	}
+	if opts.urlFetchPolicy.MaxRequestBytes < 0 || opts.urlFetchPolicy.MaxSources < 0 || opts.urlFetchPolicy.CacheTTL < 0 {
+		return fmt.Errorf("--url-fetch-max-request-bytes, --url-fetch-max-sources and --url-fetch-cache-ttl must not be negative")
+	}
+	if opts.filesMaxBytes < 1 || opts.filesMemoryBytes < 1 {
+		return fmt.Errorf("--files-max-bytes and --files-memory-bytes must be positive")
+	}
+	opts.modelMap, err = loadModelMap(c.String("model-map"), c.String("model-map-file"))
+	if err != nil {
+		return err
+	}
+	opts.responseModel, err = translator.ParseResponseModel(c.String("response-model"))
+	if err != nil {
+		return err
+	}
+
 	ctx := context.Background()
-	return startProxyServer(ctx, apiKey, port, verbose, debug)
+	return startProxyServer(ctx, apiKey, port, verbose, debug, opts)
+}
+
+// proxyOptions holds the tuning flags passed through to the proxy server
+type proxyOptions struct {
+	retryPolicy        translator.RetryPolicy
+	toolArgsStrategy   translator.ToolArgsStrategy
+	urlFetchPolicy     translator.URLFetchPolicy
+	signatureCache     signatures.Config
+	signatureStore     string // memory, bolt, redis or stateless
+	signatureStorePath string // Database file for the bolt store
+	signatureHMACKey   string // Key for the stateless mode
+	redisURL           string // Server for the redis store
+	filesStore         string // memory or bolt
+	filesStorePath     string // Database file for the bolt file store
+	filesMemoryBytes   int64  // Content cap of the memory file store
+	filesMaxBytes      int64
+	contextCaching     bool // Map cache_control breakpoints to Gemini context caches
+	validateModels     bool // Reject requests for models Gemini doesn't list
+	modelMap           *translator.ModelMap
+	responseModel      translator.ResponseModel
+}
+
+// loadModelMap builds the model map from the --model-map flag and the routes in the
+// --model-map-file file, in that order
+func loadModelMap(spec, filename string) (*translator.ModelMap, error) {
+	routes, err := translator.ParseModelRoutes(strings.NewReader(spec))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --model-map: %w", err)
+	}
+	if filename != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open model map file: %w", err)
+		}
+		defer f.Close()
+		fileRoutes, err := translator.ParseModelRoutes(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid model map file %s: %w", filename, err)
+		}
+		routes = append(routes, fileRoutes...)
+	}
+	return translator.NewModelMap(routes...)
 }
 
-func startProxyServer(ctx context.Context, apiKey string, port int, verbose, debug bool) error {
+// openFileStore creates the Files API store selected by --files-store
+func openFileStore(opts proxyOptions) (files.Store, error) {
+	switch opts.filesStore {
+	case files.StoreMemory:
+		return files.NewMemoryStore(opts.filesMemoryBytes), nil
+	case files.StoreBolt:
+		return files.NewBoltStore(opts.filesStorePath)
+	default:
+		return nil, fmt.Errorf("unknown files store %q (expected %s or %s)", opts.filesStore, files.StoreMemory, files.StoreBolt)
+	}
+}
+
+// openSignatureStore creates the thought signature store selected by --signature-store
+func openSignatureStore(opts proxyOptions) (signatures.Store, error) {
+	switch opts.signatureStore {
+	case signatures.StoreMemory:
+		return signatures.NewMemoryStore(opts.signatureCache), nil
+	case signatures.StoreBolt:
+		return signatures.NewBoltStore(opts.signatureStorePath, opts.signatureCache, 0)
+	case signatures.StoreRedis:
+		return signatures.NewRedisStore(opts.redisURL, opts.signatureCache)
+	case signatures.StoreStateless:
+		// Signatures travel in tool_use IDs; the store is left unused
+		return signatures.NewMemoryStore(opts.signatureCache), nil
+	default:
+		return nil, fmt.Errorf("unknown signature store %q (expected %s, %s, %s or %s)", opts.signatureStore,
+			signatures.StoreMemory, signatures.StoreBolt, signatures.StoreRedis, signatures.StoreStateless)
+	}
+}
+
+func startProxyServer(ctx context.Context, apiKey string, port int, verbose, debug bool, opts proxyOptions) error {
 	// Initialize Gemini client
 	geminiClient, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
 	if err != nil {
@@ -126,10 +408,48 @@ func startProxyServer(ctx context.Context, apiKey string, port int, verbose, deb
 	// Create server with API key for thought signature support
 	srv := server.NewWithAPIKey(geminiClient, apiKey)
 	srv.SetDebug(debug)
+	srv.SetRetryPolicy(opts.retryPolicy)
+	srv.SetToolArgsStrategy(opts.toolArgsStrategy)
+	srv.SetURLFetchPolicy(opts.urlFetchPolicy)
+	srv.SetContextCaching(opts.contextCaching)
+	srv.SetModelValidation(opts.validateModels)
+	srv.SetModelMap(opts.modelMap)
+	srv.SetResponseModel(opts.responseModel)
+	if n := opts.modelMap.Len(); n > 0 {
+		log.Printf("Model map: %d routes", n)
+	}
+
+	signatureStore, err := openSignatureStore(opts)
+	if err != nil {
+		return err
+	}
+	defer signatureStore.Close()
+	srv.SetSignatureStore(signatureStore)
+
+	if opts.signatureStore == signatures.StoreStateless {
+		codec, err := signatures.NewCodec([]byte(opts.signatureHMACKey))
+		if err != nil {
+			return fmt.Errorf("--signature-store=%s requires --signature-hmac-key: %w", signatures.StoreStateless, err)
+		}
+		srv.SetStatelessSignatures(codec)
+	}
+
+	fileStore, err := openFileStore(opts)
+	if err != nil {
+		return err
+	}
+	defer fileStore.Close()
+	srv.SetFileStore(fileStore)
+	srv.SetMaxFileBytes(opts.filesMaxBytes)
 
 	// Setup HTTP routes
 	mux := http.NewServeMux()
 	mux.HandleFunc("/v1/messages", srv.HandleMessages)
+	mux.HandleFunc("/v1/messages/count_tokens", srv.HandleCountTokens)
+	mux.HandleFunc("/v1/models", srv.HandleModels)
+	mux.HandleFunc("/v1/models/", srv.HandleModels)
+	mux.HandleFunc("/v1/files", srv.HandleFiles)
+	mux.HandleFunc("/v1/files/", srv.HandleFiles)
 
 	// Wrap with logging middleware
 	handler := loggingMiddleware(mux, debug)
@@ -185,6 +505,10 @@ serverRunning:
 
 	log.Println("\nShutting down server...")
 
+	stats := srv.SignatureStoreStats()
+	log.Printf("Thought signature store: %d entries, %d bytes, %d hits, %d misses, %d evictions, %d expirations, %d fallbacks",
+		stats.Entries, stats.Bytes, stats.Hits, stats.Misses, stats.Evictions, stats.Expirations, stats.Fallbacks)
+
 	// Graceful shutdown with timeout
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -192,6 +516,9 @@ serverRunning:
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		return fmt.Errorf("server forced to shutdown: %w", err)
 	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to delete context caches: %v", err)
+	}
 
 	log.Println("Server stopped")
 	return nil
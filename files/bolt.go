@@ -0,0 +1,155 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package files
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the bolt store, both keyed by file ID
+var (
+	boltFilesBucket   = []byte("files")         // JSON encoded File
+	boltContentBucket = []byte("file_contents") // Raw content
+)
+
+// BoltStore is a Store backed by an embedded bbolt database file, so uploaded files
+// survive proxy restarts
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (or creates) the database file at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file store %s: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltFilesBucket, boltContentBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize file store %s: %w", path, err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Put implements Store
+func (s *BoltStore) Put(_ context.Context, file File, content []byte) error {
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltFilesBucket).Put([]byte(file.ID), data); err != nil {
+			return err
+		}
+		return tx.Bucket(boltContentBucket).Put([]byte(file.ID), content)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write file %s: %w", file.ID, err)
+	}
+	return nil
+}
+
+// Get implements Store
+func (s *BoltStore) Get(_ context.Context, id string) (File, bool, error) {
+	var (
+		file  File
+		found bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(boltFilesBucket).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, &file)
+	})
+	if err != nil {
+		return File{}, false, fmt.Errorf("failed to read file %s: %w", id, err)
+	}
+	return file, found, nil
+}
+
+// Content implements Store
+func (s *BoltStore) Content(_ context.Context, id string) ([]byte, bool, error) {
+	var content []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(boltContentBucket).Get([]byte(id)); value != nil {
+			// Values are only valid for the life of the transaction
+			content = append([]byte{}, value...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read content of file %s: %w", id, err)
+	}
+	return content, content != nil, nil
+}
+
+// List implements Store
+func (s *BoltStore) List(_ context.Context) ([]File, error) {
+	var files []File
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltFilesBucket).ForEach(func(k, v []byte) error {
+			var file File
+			if err := json.Unmarshal(v, &file); err != nil {
+				return fmt.Errorf("corrupt entry for file %s: %w", k, err)
+			}
+			files = append(files, file)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+
+	sortNewestFirst(files)
+	return files, nil
+}
+
+// Delete implements Store
+func (s *BoltStore) Delete(_ context.Context, id string) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		found = tx.Bucket(boltFilesBucket).Get([]byte(id)) != nil
+		if err := tx.Bucket(boltFilesBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltContentBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete file %s: %w", id, err)
+	}
+	return found, nil
+}
+
+// Close implements Store
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package files
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "files.db"))
+	if err != nil {
+		t.Fatalf("failed to open bolt store: %v", err)
+	}
+	defer store.Close()
+	testStore(t, store)
+}
+
+func TestBoltStore_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "files.db")
+
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("failed to open bolt store: %v", err)
+	}
+	file := File{ID: "file_a", Filename: "a.pdf", MimeType: "application/pdf", SizeBytes: 4, GeminiURI: "https://example.com/files/a"}
+	if err := store.Put(ctx, file, []byte("%PDF")); err != nil {
+		t.Fatalf("failed to put file: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	store, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen bolt store: %v", err)
+	}
+	defer store.Close()
+
+	got, ok, err := store.Get(ctx, "file_a")
+	if err != nil || !ok || got != file {
+		t.Errorf("expected %+v after reopening, got %+v (ok=%t, err=%v)", file, got, ok, err)
+	}
+	content, ok, err := store.Content(ctx, "file_a")
+	if err != nil || !ok || string(content) != "%PDF" {
+		t.Errorf("unexpected content after reopening: %q (ok=%t, err=%v)", content, ok, err)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package files keeps track of files uploaded through the Anthropic Files API and the
+// Gemini File API files that back them.
+package files
+
+import (
+	"container/list"
+	"context"
+	"crypto/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store kinds accepted by the --files-store flag
+const (
+	StoreMemory = "memory"
+	StoreBolt   = "bolt"
+)
+
+// DefaultMaxBytes is the largest file accepted for upload, matching the Anthropic limit
+const DefaultMaxBytes = 500 << 20
+
+// DefaultMemoryBytes is the total size of file content MemoryStore keeps by default
+const DefaultMemoryBytes = 1 << 30
+
+// IDPrefix starts every file ID
+const IDPrefix = "file_"
+
+// File describes an uploaded file and the Gemini file holding its content. Gemini deletes
+// its copy at ExpiresAt; the content kept in the store is used to upload it again.
+type File struct {
+	ID         string    `json:"id"`
+	Filename   string    `json:"filename"`
+	MimeType   string    `json:"mime_type"`
+	SizeBytes  int64     `json:"size_bytes"`
+	CreatedAt  time.Time `json:"created_at"`
+	GeminiName string    `json:"gemini_name"` // "files/{id}"
+	GeminiURI  string    `json:"gemini_uri"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// NewID returns a new random file ID
+func NewID() string {
+	return IDPrefix + strings.ToLower(rand.Text())
+}
+
+// Store persists files and their content by file ID. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Put creates or replaces a file and its content
+	Put(ctx context.Context, file File, content []byte) error
+
+	// Get returns the file with the ID
+	Get(ctx context.Context, id string) (File, bool, error)
+
+	// Content returns the content of the file with the ID
+	Content(ctx context.Context, id string) ([]byte, bool, error)
+
+	// List returns all files, newest first
+	List(ctx context.Context) ([]File, error)
+
+	// Delete removes the file with the ID, reporting whether it existed
+	Delete(ctx context.Context, id string) (bool, error)
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// sortNewestFirst orders files by creation time, newest first, breaking ties by ID so that
+// pagination is stable
+func sortNewestFirst(files []File) {
+	slices.SortFunc(files, func(a, b File) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+}
+
+// memoryEntry is a file held by MemoryStore. content is nil once evicted.
+type memoryEntry struct {
+	file    File
+	content []byte
+	element *list.Element // Position in MemoryStore.lru while content is held
+}
+
+// MemoryStore is a Store that keeps files in memory. Files are lost when the proxy
+// restarts, although Gemini keeps its copies until they expire.
+//
+// The metadata of every file is kept, but the total size of the content held is capped:
+// once the cap is exceeded, the content of the least recently used files is evicted. A file
+// whose content has been evicted can still be referenced until Gemini deletes its copy, but
+// can no longer be downloaded or uploaded again.
+type MemoryStore struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*memoryEntry
+	lru      *list.List // IDs of files holding content, most recently used first
+}
+
+// NewMemoryStore creates an empty in-memory store holding up to maxBytes of file content
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		entries:  map[string]*memoryEntry{},
+		lru:      list.New(),
+	}
+}
+
+// Put implements Store
+func (s *MemoryStore) Put(_ context.Context, file File, content []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[file.ID]; ok {
+		s.evict(entry)
+	}
+	entry := &memoryEntry{file: file}
+	s.entries[file.ID] = entry
+	if int64(len(content)) > s.maxBytes {
+		return nil // Holding it would evict everything else
+	}
+	entry.content, entry.element = content, s.lru.PushFront(file.ID)
+	s.bytes += int64(len(content))
+
+	for s.bytes > s.maxBytes && s.lru.Len() > 0 {
+		s.evict(s.entries[s.lru.Back().Value.(string)])
+	}
+	return nil
+}
+
+// evict drops the content of entry. The caller must hold s.mu.
+func (s *MemoryStore) evict(entry *memoryEntry) {
+	if entry.element == nil {
+		return
+	}
+	s.lru.Remove(entry.element)
+	s.bytes -= int64(len(entry.content))
+	entry.content, entry.element = nil, nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, id string) (File, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return File{}, false, nil
+	}
+	return entry.file, true, nil
+}
+
+// Content implements Store. Content that has been evicted is reported as missing.
+func (s *MemoryStore) Content(_ context.Context, id string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok || entry.element == nil {
+		return nil, false, nil
+	}
+	s.lru.MoveToFront(entry.element)
+	return entry.content, true, nil
+}
+
+// List implements Store
+func (s *MemoryStore) List(_ context.Context) ([]File, error) {
+	s.mu.Lock()
+	files := make([]File, 0, len(s.entries))
+	for _, entry := range s.entries {
+		files = append(files, entry.file)
+	}
+	s.mu.Unlock()
+
+	sortNewestFirst(files)
+	return files, nil
+}
+
+// Delete implements Store
+func (s *MemoryStore) Delete(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	s.evict(entry)
+	delete(s.entries, id)
+	return true, nil
+}
+
+// Close implements Store
+func (s *MemoryStore) Close() error {
+	return nil
+}
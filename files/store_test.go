@@ -0,0 +1,140 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package files
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStore exercises a Store through its interface
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, id := range []string{"file_a", "file_b", "file_c"} {
+		file := File{ID: id, Filename: id + ".txt", MimeType: "text/plain", CreatedAt: created.Add(time.Duration(i) * time.Minute)}
+		if err := store.Put(ctx, file, []byte("content of "+id)); err != nil {
+			t.Fatalf("failed to put %s: %v", id, err)
+		}
+	}
+
+	file, ok, err := store.Get(ctx, "file_b")
+	if err != nil || !ok || file.Filename != "file_b.txt" || !file.CreatedAt.Equal(created.Add(time.Minute)) {
+		t.Errorf("unexpected file_b: %+v (ok=%t, err=%v)", file, ok, err)
+	}
+	content, ok, err := store.Content(ctx, "file_b")
+	if err != nil || !ok || string(content) != "content of file_b" {
+		t.Errorf("unexpected content of file_b: %q (ok=%t, err=%v)", content, ok, err)
+	}
+	if _, ok, _ := store.Get(ctx, "file_missing"); ok {
+		t.Error("expected a miss for an unknown ID")
+	}
+
+	// Replacing a file keeps its place in the list
+	file.GeminiName = "files/renewed"
+	if err := store.Put(ctx, file, []byte("content of file_b")); err != nil {
+		t.Fatalf("failed to replace file_b: %v", err)
+	}
+
+	list, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list files: %v", err)
+	}
+	var ids []string
+	for _, file := range list {
+		ids = append(ids, file.ID)
+	}
+	if got := strings.Join(ids, ","); got != "file_c,file_b,file_a" {
+		t.Errorf("expected newest first, got %s", got)
+	}
+	if list[1].GeminiName != "files/renewed" {
+		t.Errorf("expected the replaced file, got %+v", list[1])
+	}
+
+	if deleted, err := store.Delete(ctx, "file_b"); err != nil || !deleted {
+		t.Errorf("expected file_b to be deleted (err=%v)", err)
+	}
+	if deleted, _ := store.Delete(ctx, "file_b"); deleted {
+		t.Error("expected the second delete to report a missing file")
+	}
+	if _, ok, _ := store.Content(ctx, "file_b"); ok {
+		t.Error("expected the content to be deleted with the file")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(DefaultMemoryBytes)
+	defer store.Close()
+	testStore(t, store)
+}
+
+func TestMemoryStore_EvictsLeastRecentlyUsedContent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(10)
+	defer store.Close()
+
+	put := func(id, content string) {
+		t.Helper()
+		if err := store.Put(ctx, File{ID: id}, []byte(content)); err != nil {
+			t.Fatalf("failed to put %s: %v", id, err)
+		}
+	}
+	hasContent := func(id string) bool {
+		_, ok, _ := store.Content(ctx, id)
+		return ok
+	}
+
+	put("file_a", "aaaa")
+	put("file_b", "bbbb")
+	hasContent("file_a") // file_b becomes the least recently used
+	put("file_c", "cccc")
+
+	if hasContent("file_b") {
+		t.Error("expected the content of file_b to be evicted")
+	}
+	if !hasContent("file_a") || !hasContent("file_c") {
+		t.Error("expected the content of file_a and file_c to be kept")
+	}
+	if _, ok, _ := store.Get(ctx, "file_b"); !ok {
+		t.Error("expected the metadata of file_b to be kept")
+	}
+	if store.bytes != 8 {
+		t.Errorf("expected 8 bytes held, got %d", store.bytes)
+	}
+
+	if deleted, _ := store.Delete(ctx, "file_a"); !deleted || store.bytes != 4 {
+		t.Errorf("expected deleting file_a to release its content, %d bytes held", store.bytes)
+	}
+
+	// Content larger than the cap isn't held at all, rather than evicting everything else
+	put("file_d", "ddddddddddddddd")
+	if hasContent("file_d") || !hasContent("file_c") {
+		t.Error("expected oversized content not to be held")
+	}
+}
+
+func TestNewID(t *testing.T) {
+	id := NewID()
+	if !strings.HasPrefix(id, IDPrefix) || len(id) != len(IDPrefix)+26 {
+		t.Errorf("unexpected ID %q", id)
+	}
+	if id == NewID() {
+		t.Error("expected IDs to be unique")
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/savaki/twin-in-disguise/translator"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// HandleCountTokens handles POST /v1/messages/count_tokens requests.
+//
+// The request is translated exactly as for /v1/messages (system prompt, tools, messages,
+// images and documents) and counted with Gemini's countTokens. Counts are reused for a
+// short while for identical requests; see translator.TokenCounter.
+func (s *Server) HandleCountTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondMethodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	if s.tokenCounter == nil {
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, "Counting tokens requires a Gemini API key")
+		return
+	}
+
+	req, _, ok := s.readMessagesRequest(w, r)
+	if !ok {
+		return
+	}
+
+	model, _ := s.modelMap.Resolve(req.Model)
+	names := translator.NewToolNames(req)
+	geminiReq, err := s.buildHTTPRequest(model, names.Request(req))
+	if err != nil {
+		respondGenerationError(w, err)
+		return
+	}
+	// Generation settings have no bearing on the size of the prompt
+	geminiReq.GenerationConfig = nil
+
+	// Retries of countTokens can outlast the server's write timeout
+	clearWriteDeadline(w)
+	tokens, err := s.tokenCounter.Count(r.Context(), model, geminiReq)
+	if err != nil {
+		log.Printf("Failed to count tokens: %v", err)
+		respondGenerationError(w, err)
+		return
+	}
+
+	if s.debug {
+		log.Printf("[DEBUG] Counted %d input tokens for model %s", tokens, model)
+	}
+	respondJSON(w, http.StatusOK, types.AnthropicTokenCount{InputTokens: tokens})
+}
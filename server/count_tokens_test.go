@@ -0,0 +1,124 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestHandleCountTokens(t *testing.T) {
+	var (
+		calls int
+		body  struct {
+			GenerateContentRequest map[string]json.RawMessage `json:"generateContentRequest"`
+		}
+	)
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/models/gemini-2.5-flash:countTokens" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"totalTokens": 1234}`)
+	})
+
+	request := `{
+		"model": "gemini-2.5-flash",
+		"system": "You are a coding agent",
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}}],
+		"messages": [{"role": "user", "content": [
+			{"type": "text", "text": "What is in this image?"},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+		]}]
+	}`
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages/count_tokens", strings.NewReader(request))
+		w := httptest.NewRecorder()
+		srv.HandleCountTokens(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var count types.AnthropicTokenCount
+		if err := json.Unmarshal(w.Body.Bytes(), &count); err != nil || count.InputTokens != 1234 {
+			t.Errorf("expected 1234 input tokens, got %s", w.Body.String())
+		}
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the second count to be reused, got %d calls", calls)
+	}
+	for _, field := range []string{"model", "contents", "systemInstruction", "tools"} {
+		if _, ok := body.GenerateContentRequest[field]; !ok {
+			t.Errorf("expected %s in the countTokens request, got %v", field, body.GenerateContentRequest)
+		}
+	}
+	if _, ok := body.GenerateContentRequest["generationConfig"]; ok {
+		t.Error("expected no generation config in the countTokens request")
+	}
+	if contents := string(body.GenerateContentRequest["contents"]); !strings.Contains(contents, "inlineData") {
+		t.Errorf("expected the image to be counted, got %s", contents)
+	}
+}
+
+func TestHandleCountTokens_Errors(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": {"code": 404, "message": "models/gemini-unknown is not found", "status": "NOT_FOUND"}}`)
+	})
+
+	tests := []struct {
+		name      string
+		method    string
+		body      string
+		status    int
+		errorType string
+	}{
+		{name: "method", method: http.MethodGet, status: http.StatusMethodNotAllowed, errorType: types.ErrorTypeInvalidRequest},
+		{name: "invalid JSON", method: http.MethodPost, body: "{", status: http.StatusBadRequest, errorType: types.ErrorTypeInvalidRequest},
+		{
+			name:      "unknown model",
+			method:    http.MethodPost,
+			body:      `{"model": "gemini-unknown", "messages": [{"role": "user", "content": "Hi"}]}`,
+			status:    http.StatusNotFound,
+			errorType: types.ErrorTypeNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/messages/count_tokens", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			srv.HandleCountTokens(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			var errResp types.AnthropicErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil || errResp.Error.Type != tt.errorType {
+				t.Errorf("expected a %s, got %s", tt.errorType, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,405 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/files"
+	"github.com/savaki/twin-in-disguise/translator"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// fileExpiryMargin is how long before Gemini deletes its copy of a file that the file is
+// uploaded again, so that it can't expire while a request is in flight
+const fileExpiryMargin = 10 * time.Minute
+
+// multipartOverhead allows for the multipart framing around an uploaded file
+const multipartOverhead = 1 << 20
+
+// fileUploadTimeout bounds reading an upload and storing it in Gemini, replacing the
+// server's read and write timeouts, which are too short for large files
+const fileUploadTimeout = 10 * time.Minute
+
+// HandleFiles handles the Files API:
+//
+//	POST   /v1/files              upload a file (multipart/form-data with a "file" field)
+//	GET    /v1/files              list files, newest first
+//	GET    /v1/files/{id}         file metadata
+//	DELETE /v1/files/{id}         delete a file
+//	GET    /v1/files/{id}/content download a file
+//
+// Uploads are stored in the Gemini File API, where messages reference them as file data.
+// The content is also kept in the file store, so that it can be downloaded and uploaded
+// again once Gemini deletes its copy, 48 hours after the upload.
+func (s *Server) HandleFiles(w http.ResponseWriter, r *http.Request) {
+	if s.geminiHTTPClient == nil {
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, "The Files API requires a Gemini API key")
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/files"), "/")
+	id, resource, _ := strings.Cut(path, "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		s.uploadFile(w, r)
+	case id == "" && r.Method == http.MethodGet:
+		s.listFiles(w, r)
+	case id == "":
+		respondMethodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	case resource == "" && r.Method == http.MethodGet:
+		s.getFile(w, r, id)
+	case resource == "" && r.Method == http.MethodDelete:
+		s.deleteFile(w, r, id)
+	case resource == "":
+		respondMethodNotAllowed(w, r, http.MethodGet, http.MethodDelete)
+	case resource == "content" && r.Method == http.MethodGet:
+		s.downloadFile(w, r, id)
+	case resource == "content":
+		respondMethodNotAllowed(w, r, http.MethodGet)
+	default:
+		respondError(w, http.StatusNotFound, types.ErrorTypeNotFound, fmt.Sprintf("Not found: %s", r.URL.Path))
+	}
+}
+
+// uploadFile uploads the "file" field of a multipart form to Gemini and records it
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	maxRequestBytes := s.maxFileBytes + multipartOverhead
+	if r.ContentLength > maxRequestBytes {
+		respondError(w, http.StatusRequestEntityTooLarge, types.ErrorTypeRequestTooLarge, fmt.Sprintf("File exceeds the maximum size of %d bytes", s.maxFileBytes))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(fileUploadTimeout)
+	for _, err := range []error{rc.SetReadDeadline(deadline), rc.SetWriteDeadline(deadline)} {
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Failed to extend deadline for upload: %v", err)
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, fmt.Sprintf("Expected a multipart/form-data request: %v", err))
+		return
+	}
+
+	var (
+		filename string
+		declared string
+		content  []byte
+		found    bool
+	)
+	for !found {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && part.FormName() == "file" {
+			filename, declared, found = part.FileName(), part.Header.Get("Content-Type"), true
+			content, err = readUploadPart(part, s.maxFileBytes)
+		}
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) || errors.Is(err, errFileTooLarge) {
+				respondError(w, http.StatusRequestEntityTooLarge, types.ErrorTypeRequestTooLarge, fmt.Sprintf("File exceeds the maximum size of %d bytes", s.maxFileBytes))
+				return
+			}
+			respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, fmt.Sprintf("Failed to read upload: %v", err))
+			return
+		}
+	}
+
+	switch {
+	case !found:
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, "Missing file field")
+		return
+	case len(content) == 0:
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, "File is empty")
+		return
+	case filename == "":
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, "File has no filename")
+		return
+	}
+
+	file := files.File{
+		ID:        files.NewID(),
+		Filename:  filepath.Base(filename),
+		MimeType:  detectMediaType(filename, declared, content),
+		SizeBytes: int64(len(content)),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.storeFile(ctx, &file, content); err != nil {
+		log.Printf("Failed to upload file %s: %v", file.Filename, err)
+		respondGenerationError(w, err)
+		return
+	}
+
+	if s.debug {
+		log.Printf("[DEBUG] Uploaded %s (%s, %d bytes) as %s", file.Filename, file.MimeType, file.SizeBytes, file.GeminiName)
+	}
+	respondJSON(w, http.StatusOK, anthropicFile(file))
+}
+
+// errFileTooLarge reports an upload larger than the maximum file size
+var errFileTooLarge = errors.New("file too large")
+
+// readUploadPart reads an uploaded file of at most maxBytes. The read stops as soon as the
+// limit is exceeded, so an oversized file is never held in full.
+func readUploadPart(part io.Reader, maxBytes int64) ([]byte, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(part, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > maxBytes {
+		return nil, errFileTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// storeFile uploads content to Gemini, fills in the Gemini fields of file and saves it
+func (s *Server) storeFile(ctx context.Context, file *files.File, content []byte) error {
+	geminiFile, err := s.geminiHTTPClient.UploadFile(ctx, file.Filename, file.MimeType, content)
+	if err != nil {
+		return err
+	}
+
+	file.GeminiName = geminiFile.Name
+	file.GeminiURI = geminiFile.URI
+	file.ExpiresAt = geminiFile.ExpirationTime
+	return s.fileStore.Put(ctx, *file, content)
+}
+
+// listFiles lists files, newest first. after_id pages towards older files and before_id
+// towards newer ones.
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request) {
+	all, err := s.fileStore.List(r.Context())
+	if err != nil {
+		log.Printf("Failed to list files: %v", err)
+		respondError(w, http.StatusInternalServerError, types.ErrorTypeAPI, "Failed to list files")
+		return
+	}
+
+	page, hasMore, ok := paginate(w, r, all, func(file files.File) string { return file.ID }, "file")
+	if !ok {
+		return
+	}
+
+	list := types.AnthropicFileList{Data: []types.AnthropicFile{}, HasMore: hasMore}
+	for _, file := range page {
+		list.Data = append(list.Data, anthropicFile(file))
+	}
+	if len(page) > 0 {
+		list.FirstID, list.LastID = page[0].ID, page[len(page)-1].ID
+	}
+	respondJSON(w, http.StatusOK, list)
+}
+
+// getFile returns the metadata of a file
+func (s *Server) getFile(w http.ResponseWriter, r *http.Request, id string) {
+	file, ok := s.findFile(r.Context(), w, id)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, anthropicFile(file))
+}
+
+// deleteFile deletes a file from Gemini and the file store
+func (s *Server) deleteFile(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	file, ok := s.findFile(ctx, w, id)
+	if !ok {
+		return
+	}
+
+	if time.Now().Before(file.ExpiresAt) {
+		err := s.geminiHTTPClient.DeleteFile(ctx, file.GeminiName)
+		if err != nil && !isFileGone(err) {
+			log.Printf("Failed to delete Gemini file %s: %v", file.GeminiName, err)
+			respondGenerationError(w, err)
+			return
+		}
+	}
+
+	if _, err := s.fileStore.Delete(ctx, id); err != nil {
+		log.Printf("Failed to delete file %s: %v", id, err)
+		respondError(w, http.StatusInternalServerError, types.ErrorTypeAPI, "Failed to delete file")
+		return
+	}
+	respondJSON(w, http.StatusOK, types.AnthropicFileDeleted{ID: id, Type: types.ObjectTypeFileDeleted})
+}
+
+// downloadFile returns the content of a file
+func (s *Server) downloadFile(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	file, ok := s.findFile(ctx, w, id)
+	if !ok {
+		return
+	}
+
+	content, ok, err := s.fileStore.Content(ctx, id)
+	if err != nil {
+		log.Printf("Failed to read content of file %s: %v", id, err)
+		respondError(w, http.StatusInternalServerError, types.ErrorTypeAPI, "Failed to read file content")
+		return
+	}
+	if !ok {
+		respondError(w, http.StatusNotFound, types.ErrorTypeNotFound, fmt.Sprintf("Content of file %s is no longer available", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", file.MimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// findFile looks up a file, responding with not_found_error if it doesn't exist
+func (s *Server) findFile(ctx context.Context, w http.ResponseWriter, id string) (files.File, bool) {
+	file, ok, err := s.fileStore.Get(ctx, id)
+	if err != nil {
+		log.Printf("Failed to read file %s: %v", id, err)
+		respondError(w, http.StatusInternalServerError, types.ErrorTypeAPI, "Failed to read file")
+		return files.File{}, false
+	}
+	if !ok {
+		respondError(w, http.StatusNotFound, types.ErrorTypeNotFound, fmt.Sprintf("File not found: %s", id))
+		return files.File{}, false
+	}
+	return file, true
+}
+
+// lookupFile implements translator.FileLookup. Files whose Gemini copy has expired, or is
+// about to, are uploaded again from the file store.
+func (s *Server) lookupFile(ctx context.Context, id string) (string, string, error) {
+	file, ok, err := s.fileStore.Get(ctx, id)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read file %s: %w", id, err)
+	}
+	if !ok {
+		return "", "", translator.NewInvalidRequestError("file %s not found", id)
+	}
+
+	if fileExpiring(file) {
+		if s.geminiHTTPClient == nil {
+			return "", "", translator.NewInvalidRequestError("file %s has expired and can't be uploaded again without a Gemini API key", id)
+		}
+		// Concurrent requests for the file share a single upload, which isn't cancelled
+		// with the request that happened to start it
+		v, err, _ := s.fileUploads.Do(id, func() (interface{}, error) {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fileUploadTimeout)
+			defer cancel()
+			return s.renewFile(ctx, id)
+		})
+		if err != nil {
+			return "", "", err
+		}
+		file = v.(files.File)
+	}
+
+	return file.GeminiURI, file.MimeType, nil
+}
+
+// renewFile uploads a file to Gemini again from the file store, unless it was renewed
+// since it was last read
+func (s *Server) renewFile(ctx context.Context, id string) (files.File, error) {
+	file, ok, err := s.fileStore.Get(ctx, id)
+	if err != nil {
+		return files.File{}, fmt.Errorf("failed to read file %s: %w", id, err)
+	}
+	if !ok {
+		return files.File{}, translator.NewInvalidRequestError("file %s not found", id)
+	}
+	if !fileExpiring(file) {
+		return file, nil
+	}
+
+	content, ok, err := s.fileStore.Content(ctx, id)
+	if err != nil {
+		return files.File{}, fmt.Errorf("failed to read content of file %s: %w", id, err)
+	}
+	if !ok {
+		return files.File{}, translator.NewInvalidRequestError("file %s has expired and its content is no longer available", id)
+	}
+	if err := s.storeFile(ctx, &file, content); err != nil {
+		return files.File{}, err
+	}
+	log.Printf("Uploaded file %s to Gemini again as %s after its copy expired", id, file.GeminiName)
+	return file, nil
+}
+
+// fileExpiring reports whether Gemini has deleted its copy of a file, or is about to
+func fileExpiring(file files.File) bool {
+	return time.Now().Add(fileExpiryMargin).After(file.ExpiresAt)
+}
+
+// isFileGone reports whether a Gemini error means the file no longer exists. Gemini
+// answers with permission denied rather than not found for files that have been deleted.
+func isFileGone(err error) bool {
+	var apiErr *translator.APIError
+	return errors.As(err, &apiErr) &&
+		(apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusForbidden)
+}
+
+// detectMediaType returns the media type of an upload: the declared type if there is a
+// useful one, else the type implied by the file extension, else the type sniffed from the
+// content
+func detectMediaType(filename, declared string, content []byte) string {
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(filename))); err == nil {
+		return mediaType
+	}
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+	return mediaType
+}
+
+// anthropicFile converts a stored file to its Files API representation. The proxy keeps
+// the content of uploads, so files are reported as downloadable, although the memory store
+// may since have evicted the content.
+func anthropicFile(file files.File) types.AnthropicFile {
+	return types.AnthropicFile{
+		ID:           file.ID,
+		Type:         types.ObjectTypeFile,
+		Filename:     file.Filename,
+		MimeType:     file.MimeType,
+		SizeBytes:    file.SizeBytes,
+		CreatedAt:    file.CreatedAt,
+		Downloadable: true,
+	}
+}
+
+// respondMethodNotAllowed rejects a request made with a method the resource doesn't support
+func respondMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	respondError(w, http.StatusMethodNotAllowed, types.ErrorTypeInvalidRequest, fmt.Sprintf("Method %s is not allowed for %s", r.Method, r.URL.Path))
+}
@@ -0,0 +1,365 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/translator"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// geminiFiles is a stand-in for the Gemini File API that also answers generateContent
+type geminiFiles struct {
+	t         *testing.T
+	expiresIn time.Duration // Lifetime of uploaded files
+
+	mu      sync.Mutex
+	files   map[string]string // Name to uploaded content
+	uploads int
+	request translator.GenerateContentRequest // Last generateContent request
+}
+
+func newFilesTestServer(t *testing.T) (*Server, *geminiFiles) {
+	t.Helper()
+	gemini := &geminiFiles{t: t, expiresIn: 48 * time.Hour, files: map[string]string{}}
+	return newTestServer(t, gemini.ServeHTTP), gemini
+}
+
+func (g *geminiFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/upload/files" && r.Header.Get("X-Goog-Upload-Command") == "start":
+		w.Header().Set("X-Goog-Upload-URL", fmt.Sprintf("http://%s/upload/files?upload_id=%d&type=%s",
+			r.Host, g.uploads, r.Header.Get("X-Goog-Upload-Header-Content-Type")))
+
+	case r.URL.Path == "/upload/files":
+		data, _ := io.ReadAll(r.Body)
+		g.uploads++
+		name := fmt.Sprintf("files/f%d", g.uploads)
+		g.files[name] = string(data)
+		json.NewEncoder(w).Encode(map[string]interface{}{"file": types.GeminiFile{
+			Name:           name,
+			MimeType:       r.URL.Query().Get("type"),
+			SizeBytes:      int64(len(data)),
+			ExpirationTime: time.Now().Add(g.expiresIn).UTC(),
+			URI:            "https://generativelanguage.googleapis.com/v1beta/" + name,
+			State:          types.FileStateActive,
+		}})
+
+	case strings.HasPrefix(r.URL.Path, "/files/") && r.Method == http.MethodDelete:
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if _, ok := g.files[name]; !ok {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error": {"code": 403, "message": "Permission denied", "status": "PERMISSION_DENIED"}}`)
+			return
+		}
+		delete(g.files, name)
+		fmt.Fprint(w, "{}")
+
+	case strings.HasSuffix(r.URL.Path, ":generateContent"):
+		g.request = translator.GenerateContentRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&g.request); err != nil {
+			g.t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "A report"}]}, "finishReason": "STOP"}]}`)
+
+	default:
+		g.t.Errorf("unexpected Gemini request: %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+// upload sends a Files API upload of content with the given declared content type
+func upload(t *testing.T, srv *Server, filename, contentType, content string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filename))
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatalf("failed to create form: %v", err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/files", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	srv.HandleFiles(w, req)
+	return w
+}
+
+// uploadFile uploads content and returns the new file
+func uploadFile(t *testing.T, srv *Server, filename, contentType, content string) types.AnthropicFile {
+	t.Helper()
+	w := upload(t, srv, filename, contentType, content)
+	if w.Code != http.StatusOK {
+		t.Fatalf("upload of %s failed with %d: %s", filename, w.Code, w.Body.String())
+	}
+	var file types.AnthropicFile
+	if err := json.Unmarshal(w.Body.Bytes(), &file); err != nil {
+		t.Fatalf("invalid file: %v", err)
+	}
+	return file
+}
+
+// filesRequest sends a Files API request other than an upload
+func filesRequest(srv *Server, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	srv.HandleFiles(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestHandleFiles(t *testing.T) {
+	srv, gemini := newFilesTestServer(t)
+
+	report := uploadFile(t, srv, "report.pdf", "application/octet-stream", "%PDF-1.4\n")
+	if !strings.HasPrefix(report.ID, "file_") || report.Type != "file" || report.Filename != "report.pdf" ||
+		report.MimeType != types.MediaTypePDF || report.SizeBytes != 9 || !report.Downloadable || report.CreatedAt.IsZero() {
+		t.Errorf("unexpected file: %+v", report)
+	}
+	if gemini.files["files/f1"] != "%PDF-1.4\n" {
+		t.Errorf("expected the upload to reach Gemini, got %v", gemini.files)
+	}
+
+	logo := uploadFile(t, srv, "logo", "", "\x89PNG\r\n\x1a\n")
+	notes := uploadFile(t, srv, "notes.txt", "text/plain; charset=utf-8", "Meeting notes")
+	if logo.MimeType != "image/png" || notes.MimeType != "text/plain" {
+		t.Errorf("unexpected media types %s and %s", logo.MimeType, notes.MimeType)
+	}
+
+	// Metadata
+	w := filesRequest(srv, http.MethodGet, "/v1/files/"+report.ID)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"filename":"report.pdf"`) {
+		t.Errorf("unexpected metadata response %d: %s", w.Code, w.Body.String())
+	}
+
+	// Listing pages from newest to oldest
+	list := func(query string) types.AnthropicFileList {
+		t.Helper()
+		w := filesRequest(srv, http.MethodGet, "/v1/files"+query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("list failed with %d: %s", w.Code, w.Body.String())
+		}
+		var list types.AnthropicFileList
+		json.Unmarshal(w.Body.Bytes(), &list)
+		return list
+	}
+	ids := func(list types.AnthropicFileList) []string {
+		var ids []string
+		for _, file := range list.Data {
+			ids = append(ids, file.ID)
+		}
+		return ids
+	}
+	if page := list(""); fmt.Sprint(ids(page)) != fmt.Sprint([]string{notes.ID, logo.ID, report.ID}) || page.HasMore {
+		t.Errorf("unexpected list: %+v", page)
+	}
+	page := list("?limit=2")
+	if fmt.Sprint(ids(page)) != fmt.Sprint([]string{notes.ID, logo.ID}) || !page.HasMore || page.FirstID != notes.ID || page.LastID != logo.ID {
+		t.Errorf("unexpected first page: %+v", page)
+	}
+	if page := list("?limit=2&after_id=" + page.LastID); fmt.Sprint(ids(page)) != fmt.Sprint([]string{report.ID}) || page.HasMore {
+		t.Errorf("unexpected second page: %+v", page)
+	}
+	if page := list("?limit=1&before_id=" + report.ID); fmt.Sprint(ids(page)) != fmt.Sprint([]string{logo.ID}) || !page.HasMore {
+		t.Errorf("unexpected page before %s: %+v", report.ID, page)
+	}
+
+	// Download
+	w = filesRequest(srv, http.MethodGet, "/v1/files/"+notes.ID+"/content")
+	if w.Code != http.StatusOK || w.Body.String() != "Meeting notes" || w.Header().Get("Content-Type") != "text/plain" ||
+		w.Header().Get("Content-Disposition") != `attachment; filename=notes.txt` {
+		t.Errorf("unexpected download %d %v: %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	// Delete removes the Gemini file too
+	w = filesRequest(srv, http.MethodDelete, "/v1/files/"+report.ID)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"type":"file_deleted"`) {
+		t.Errorf("unexpected delete response %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := gemini.files["files/f1"]; ok {
+		t.Error("expected the Gemini file to be deleted")
+	}
+	for _, target := range []string{"/v1/files/" + report.ID, "/v1/files/" + report.ID + "/content"} {
+		if w := filesRequest(srv, http.MethodGet, target); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), types.ErrorTypeNotFound) {
+			t.Errorf("expected not_found_error for %s, got %d: %s", target, w.Code, w.Body.String())
+		}
+	}
+
+	// A Gemini file that is already gone doesn't prevent deletion
+	delete(gemini.files, "files/f2")
+	if w := filesRequest(srv, http.MethodDelete, "/v1/files/"+logo.ID); w.Code != http.StatusOK {
+		t.Errorf("expected delete to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleFiles_InvalidRequests(t *testing.T) {
+	srv, gemini := newFilesTestServer(t)
+	srv.SetMaxFileBytes(8)
+
+	if w := upload(t, srv, "large.txt", "text/plain", "more than eight bytes"); w.Code != http.StatusRequestEntityTooLarge ||
+		!strings.Contains(w.Body.String(), types.ErrorTypeRequestTooLarge) {
+		t.Errorf("expected request_too_large, got %d: %s", w.Code, w.Body.String())
+	}
+	// A declared length beyond the limit is rejected before the body is read
+	req := httptest.NewRequest(http.MethodPost, "/v1/files", iotest.ErrReader(errors.New("body read")))
+	req.ContentLength = 8 + multipartOverhead + 1
+	w := httptest.NewRecorder()
+	srv.HandleFiles(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected request_too_large without reading the body, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := upload(t, srv, "empty.txt", "text/plain", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an empty file to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	if gemini.uploads != 0 {
+		t.Errorf("expected nothing to be uploaded to Gemini, got %d uploads", gemini.uploads)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodPost, "/v1/files", http.StatusBadRequest}, // Not multipart
+		{http.MethodPut, "/v1/files", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/v1/files/file_1", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/files/file_1", http.StatusNotFound},
+		{http.MethodGet, "/v1/files/file_1/other", http.StatusNotFound},
+		{http.MethodGet, "/v1/files?limit=0", http.StatusBadRequest},
+		{http.MethodGet, "/v1/files?after_id=file_1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := filesRequest(srv, tt.method, tt.target); w.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d: %s", tt.method, tt.target, tt.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleMessages_FileSource(t *testing.T) {
+	srv, gemini := newFilesTestServer(t)
+	report := uploadFile(t, srv, "report.pdf", "application/pdf", "%PDF-1.4\n")
+
+	send := func(fileID string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{
+			"model": "gemini-2.5-flash",
+			"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+			"messages": [{"role": "user", "content": [
+				{"type": "document", "source": {"type": "file", "file_id": %q}},
+				{"type": "text", "text": "Summarize this"}
+			]}]
+		}`, fileID)
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.HandleMessages(w, req)
+		return w
+	}
+
+	w := send(report.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	fileData := gemini.request.Contents[0].Parts[0].FileData
+	if fileData == nil || fileData.FileURI != "https://generativelanguage.googleapis.com/v1beta/files/f1" || fileData.MimeType != types.MediaTypePDF {
+		t.Errorf("expected file data for the upload, got %+v", gemini.request.Contents[0].Parts[0])
+	}
+
+	w = send("file_missing")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "file_missing not found") {
+		t.Errorf("expected an unknown file to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleMessages_FileSourceExpired(t *testing.T) {
+	srv, gemini := newFilesTestServer(t)
+	gemini.expiresIn = time.Minute // Within the expiry margin, as if uploaded two days ago
+	report := uploadFile(t, srv, "report.pdf", "application/pdf", "%PDF-1.4\n")
+	gemini.expiresIn = 48 * time.Hour
+
+	body := fmt.Sprintf(`{
+		"model": "gemini-2.5-flash",
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [{"role": "user", "content": [{"type": "document", "source": {"type": "file", "file_id": %q}}]}]
+	}`, report.ID)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		srv.HandleMessages(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	// Uploaded again once, on the first request
+	if gemini.uploads != 2 || gemini.files["files/f2"] != "%PDF-1.4\n" {
+		t.Errorf("expected the file to be uploaded again once, got %d uploads", gemini.uploads)
+	}
+	if fileData := gemini.request.Contents[0].Parts[0].FileData; fileData == nil || !strings.HasSuffix(fileData.FileURI, "/files/f2") {
+		t.Errorf("expected the new Gemini file to be referenced, got %+v", gemini.request.Contents[0].Parts[0])
+	}
+}
+
+func TestLookupFile_ConcurrentRenewal(t *testing.T) {
+	srv, gemini := newFilesTestServer(t)
+	gemini.expiresIn = time.Minute
+	report := uploadFile(t, srv, "report.pdf", "application/pdf", "%PDF-1.4\n")
+	gemini.expiresIn = 48 * time.Hour
+
+	var wg sync.WaitGroup
+	uris := make([]string, 8)
+	for i := range uris {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uri, _, err := srv.lookupFile(context.Background(), report.ID)
+			if err != nil {
+				t.Errorf("lookup failed: %v", err)
+			}
+			uris[i] = uri
+		}()
+	}
+	wg.Wait()
+
+	if gemini.uploads != 2 {
+		t.Errorf("expected the file to be uploaded again once, got %d uploads", gemini.uploads)
+	}
+	for _, uri := range uris {
+		if !strings.HasSuffix(uri, "/files/f2") {
+			t.Errorf("expected every lookup to return the new Gemini file, got %q", uri)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/translator"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// maxSimilarModels is the number of close matches suggested for an unknown model
+const maxSimilarModels = 3
+
+// modelCreatedAt is the created_at of every model; Gemini doesn't say when a model was
+// released
+var modelCreatedAt = time.Unix(0, 0).UTC()
+
+// HandleModels handles the Models API:
+//
+//	GET /v1/models      list models, in the order Gemini lists them
+//	GET /v1/models/{id} a single model
+//
+// Models come from Gemini's models.list, limited to those that support generateContent,
+// and the list is refreshed hourly. Aliases in the model map are listed ahead of them,
+// provided the model they route to is listed; a name matching a glob route can also be
+// looked up.
+func (s *Server) HandleModels(w http.ResponseWriter, r *http.Request) {
+	if s.models == nil {
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, "The Models API requires a Gemini API key")
+		return
+	}
+	if r.Method != http.MethodGet {
+		respondMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/models"), "/")
+	if id == "" {
+		s.listModels(w, r)
+		return
+	}
+
+	resolved, routed := s.modelMap.Resolve(id)
+	model, ok, err := s.models.Model(r.Context(), resolved)
+	if err != nil {
+		log.Printf("Failed to list models: %v", err)
+		respondGenerationError(w, err)
+		return
+	}
+	if !ok {
+		respondError(w, http.StatusNotFound, types.ErrorTypeNotFound, fmt.Sprintf("model: %s", id))
+		return
+	}
+	if routed {
+		respondJSON(w, http.StatusOK, aliasModel(id, model))
+		return
+	}
+	respondJSON(w, http.StatusOK, anthropicModel(model))
+}
+
+// listModels returns a page of models
+func (s *Server) listModels(w http.ResponseWriter, r *http.Request) {
+	models, err := s.models.Models(r.Context())
+	if err != nil {
+		log.Printf("Failed to list models: %v", err)
+		respondGenerationError(w, err)
+		return
+	}
+
+	byID := map[string]types.GeminiModel{}
+	for _, model := range models {
+		byID[translator.ModelID(model)] = model
+	}
+
+	all := make([]types.AnthropicModel, 0, len(models))
+	for _, alias := range s.modelMap.Aliases() {
+		if model, ok := byID[strings.TrimPrefix(alias.Model, "models/")]; ok {
+			all = append(all, aliasModel(alias.Pattern, model))
+		}
+	}
+	for _, model := range models {
+		all = append(all, anthropicModel(model))
+	}
+	page, hasMore, ok := paginate(w, r, all, func(model types.AnthropicModel) string { return model.ID }, "model")
+	if !ok {
+		return
+	}
+
+	list := types.AnthropicModelList{Data: page, HasMore: hasMore}
+	if len(page) > 0 {
+		list.FirstID, list.LastID = page[0].ID, page[len(page)-1].ID
+	}
+	respondJSON(w, http.StatusOK, list)
+}
+
+// checkModel rejects a request for a model that Gemini doesn't list as supporting
+// generateContent with a not_found_error suggesting close matches. Model names are
+// resolved through the model map first. If the model list can't be fetched, the request
+// is let through for Gemini to decide.
+func (s *Server) checkModel(ctx context.Context, w http.ResponseWriter, id string) bool {
+	if s.models == nil || !s.validateModels {
+		return true
+	}
+	if id == "" {
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, "model: Field required")
+		return false
+	}
+	resolved, routed := s.modelMap.Resolve(id)
+	if strings.HasPrefix(resolved, "tunedModels/") {
+		return true
+	}
+
+	models, err := s.models.Models(ctx)
+	if err != nil {
+		log.Printf("Warning: failed to list models, not checking model %s: %v", id, err)
+		return true
+	}
+	ids := make([]string, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, translator.ModelID(model))
+	}
+	if slices.Contains(ids, strings.TrimPrefix(resolved, "models/")) {
+		return true
+	}
+
+	message := fmt.Sprintf("model: %s", id)
+	if routed {
+		// The model map is at fault, not the client
+		message += fmt.Sprintf(" is routed to %s, which isn't available", resolved)
+	} else {
+		for _, alias := range s.modelMap.Aliases() {
+			ids = append(ids, alias.Pattern)
+		}
+	}
+	if similar := translator.SimilarModelIDs(resolved, ids, maxSimilarModels); len(similar) > 0 {
+		message += fmt.Sprintf(" (did you mean %s?)", strings.Join(similar, ", "))
+	}
+	respondError(w, http.StatusNotFound, types.ErrorTypeNotFound, message)
+	return false
+}
+
+// responseModelName returns the model name a response carries, given the requested
+// model and the Gemini model it was routed to
+func (s *Server) responseModelName(requested, resolved string) string {
+	if s.responseModel == translator.ResponseModelResolved || requested == "" {
+		return resolved
+	}
+	return requested
+}
+
+// anthropicModel converts a Gemini model to its Models API representation
+func anthropicModel(model types.GeminiModel) types.AnthropicModel {
+	id := translator.ModelID(model)
+	displayName := model.DisplayName
+	if displayName == "" {
+		displayName = id
+	}
+	return types.AnthropicModel{
+		ID:          id,
+		Type:        types.ObjectTypeModel,
+		DisplayName: displayName,
+		CreatedAt:   modelCreatedAt,
+	}
+}
+
+// aliasModel returns the Models API representation of an alias routed to model
+func aliasModel(alias string, model types.GeminiModel) types.AnthropicModel {
+	result := anthropicModel(model)
+	result.ID = alias
+	result.DisplayName = fmt.Sprintf("%s (%s)", alias, result.DisplayName)
+	return result
+}
@@ -0,0 +1,242 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/translator"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// newModelsTestServer serves models.list with a few models, and generateContent for any
+// model
+func newModelsTestServer(t *testing.T) *Server {
+	t.Helper()
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/models" {
+			fmt.Fprint(w, `{"models": [
+				{"name": "models/gemini-2.5-flash", "displayName": "Gemini 2.5 Flash", "supportedGenerationMethods": ["generateContent"]},
+				{"name": "models/gemini-2.5-pro", "displayName": "Gemini 2.5 Pro", "supportedGenerationMethods": ["generateContent"]},
+				{"name": "models/gemini-3-pro-preview", "supportedGenerationMethods": ["generateContent"]},
+				{"name": "models/text-embedding-004", "supportedGenerationMethods": ["embedContent"]}
+			]}`)
+			return
+		}
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}`)
+	})
+	srv.SetModelValidation(true)
+	return srv
+}
+
+func TestHandleModels_List(t *testing.T) {
+	srv := newModelsTestServer(t)
+
+	list := func(query string) types.AnthropicModelList {
+		t.Helper()
+		w := httptest.NewRecorder()
+		srv.HandleModels(w, httptest.NewRequest(http.MethodGet, "/v1/models"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var list types.AnthropicModelList
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("invalid list: %v", err)
+		}
+		return list
+	}
+
+	all := list("")
+	if len(all.Data) != 3 || all.HasMore || all.FirstID != "gemini-2.5-flash" || all.LastID != "gemini-3-pro-preview" {
+		t.Fatalf("unexpected list: %+v", all)
+	}
+	if model := all.Data[0]; model.Type != types.ObjectTypeModel || model.DisplayName != "Gemini 2.5 Flash" || model.CreatedAt.IsZero() {
+		t.Errorf("unexpected model: %+v", model)
+	}
+	if model := all.Data[2]; model.DisplayName != "gemini-3-pro-preview" {
+		t.Errorf("expected the ID as display name, got %+v", model)
+	}
+
+	page := list("?limit=1&after_id=gemini-2.5-flash")
+	if len(page.Data) != 1 || page.Data[0].ID != "gemini-2.5-pro" || !page.HasMore {
+		t.Errorf("unexpected page: %+v", page)
+	}
+}
+
+func TestHandleModels_Get(t *testing.T) {
+	srv := newModelsTestServer(t)
+
+	w := httptest.NewRecorder()
+	srv.HandleModels(w, httptest.NewRequest(http.MethodGet, "/v1/models/gemini-2.5-pro", nil))
+	var model types.AnthropicModel
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &model) != nil || model.ID != "gemini-2.5-pro" {
+		t.Errorf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/v1/models/text-embedding-004", http.StatusNotFound},
+		{http.MethodPost, "/v1/models", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/v1/models?limit=0", http.StatusBadRequest},
+		{http.MethodGet, "/v1/models?after_id=gemini-1.0-pro", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		srv.HandleModels(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tt.method, tt.path, tt.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestHandleMessages_UnknownModel(t *testing.T) {
+	srv := newModelsTestServer(t)
+
+	send := func(model string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"model": %q, "max_tokens": 100, "messages": [{"role": "user", "content": "Hi"}]}`, model)
+		w := httptest.NewRecorder()
+		srv.HandleMessages(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		return w
+	}
+
+	w := send("gemini-3-pro")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+	var errResp types.AnthropicErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &errResp)
+	if errResp.Error.Type != types.ErrorTypeNotFound || !strings.Contains(errResp.Error.Message, "did you mean gemini-3-pro-preview") {
+		t.Errorf("expected a not_found_error suggesting gemini-3-pro-preview, got %s", w.Body.String())
+	}
+
+	if w := send("gemini-2.5-flash"); w.Code != http.StatusOK {
+		t.Errorf("expected a known model to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+
+	srv.SetModelValidation(false)
+	if w := send("gemini-3-pro"); w.Code != http.StatusOK {
+		t.Errorf("expected any model to be accepted without validation, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleMessages_ModelMap(t *testing.T) {
+	var paths []string
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"Hi\"}]}, \"finishReason\": \"STOP\"}]}\n\n")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}`)
+	})
+	modelMap, err := translator.NewModelMap(translator.ModelRoute{Pattern: "claude-*-haiku-*", Model: "gemini-2.5-flash"})
+	if err != nil {
+		t.Fatalf("NewModelMap failed: %v", err)
+	}
+	srv.SetModelMap(modelMap)
+
+	send := func(stream bool) string {
+		t.Helper()
+		body := fmt.Sprintf(`{"model": "claude-3-5-haiku-latest", "max_tokens": 100, "stream": %t, "messages": [{"role": "user", "content": "Hi"}]}`, stream)
+		w := httptest.NewRecorder()
+		srv.HandleMessages(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if stream {
+			// The model is in the message_start event
+			_, data, _ := strings.Cut(w.Body.String(), "data: ")
+			data, _, _ = strings.Cut(data, "\n")
+			var event types.AnthropicStreamEvent
+			json.Unmarshal([]byte(data), &event)
+			return event.Message.Model
+		}
+		var resp types.AnthropicResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Model
+	}
+
+	for _, stream := range []bool{false, true} {
+		if model := send(stream); model != "claude-3-5-haiku-latest" {
+			t.Errorf("stream=%t: expected the requested model to be echoed, got %s", stream, model)
+		}
+	}
+	srv.SetResponseModel(translator.ResponseModelResolved)
+	for _, stream := range []bool{false, true} {
+		if model := send(stream); model != "gemini-2.5-flash" {
+			t.Errorf("stream=%t: expected the resolved model to be echoed, got %s", stream, model)
+		}
+	}
+
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/models/gemini-2.5-flash:") {
+			t.Errorf("expected requests to be routed to gemini-2.5-flash, got %s", path)
+		}
+	}
+}
+
+func TestHandleModels_Aliases(t *testing.T) {
+	srv := newModelsTestServer(t)
+	modelMap, err := translator.NewModelMap(
+		translator.ModelRoute{Pattern: "claude-sonnet-4-5", Model: "gemini-2.5-pro"},
+		translator.ModelRoute{Pattern: "claude-opus-4-1", Model: "gemini-1.0-ultra"},
+		translator.ModelRoute{Pattern: "claude-*-haiku-*", Model: "gemini-2.5-flash"},
+	)
+	if err != nil {
+		t.Fatalf("NewModelMap failed: %v", err)
+	}
+	srv.SetModelMap(modelMap)
+
+	w := httptest.NewRecorder()
+	srv.HandleModels(w, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	var list types.AnthropicModelList
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Data) != 4 || list.Data[0].ID != "claude-sonnet-4-5" || list.Data[0].DisplayName != "claude-sonnet-4-5 (Gemini 2.5 Pro)" {
+		t.Errorf("expected the alias of an available model ahead of the Gemini models, got %+v", list.Data)
+	}
+
+	w = httptest.NewRecorder()
+	srv.HandleModels(w, httptest.NewRequest(http.MethodGet, "/v1/models/claude-3-5-haiku-latest", nil))
+	var model types.AnthropicModel
+	if json.Unmarshal(w.Body.Bytes(), &model); w.Code != http.StatusOK || model.ID != "claude-3-5-haiku-latest" {
+		t.Errorf("expected a name matching a glob route to be found, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Requests for aliases are validated against the model they are routed to
+	send := func(model string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"model": %q, "max_tokens": 100, "messages": [{"role": "user", "content": "Hi"}]}`, model)
+		w := httptest.NewRecorder()
+		srv.HandleMessages(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		return w
+	}
+	if w := send("claude-sonnet-4-5"); w.Code != http.StatusOK {
+		t.Errorf("expected an alias to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("claude-opus-4-1"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "is routed to gemini-1.0-ultra") {
+		t.Errorf("expected an alias of an unavailable model to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := send("claude-sonet-4-5"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "did you mean claude-sonnet-4-5") {
+		t.Errorf("expected aliases to be suggested, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// List limits shared by the Files and Models APIs
+const (
+	defaultListLimit = 20
+	maxListLimit     = 1000
+)
+
+// paginate returns the page of all selected by the limit, after_id and before_id query
+// parameters, and whether there are more items beyond it. kind names the items in error
+// messages. On invalid parameters the error response has already been sent and ok is
+// false.
+func paginate[T any](w http.ResponseWriter, r *http.Request, all []T, id func(T) string, kind string) (page []T, hasMore, ok bool) {
+	query := r.URL.Query()
+
+	limit := defaultListLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxListLimit {
+			respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+			return nil, false, false
+		}
+		limit = n
+	}
+
+	indexOf := func(cursor string) int {
+		return slices.IndexFunc(all, func(item T) bool { return id(item) == cursor })
+	}
+	start, end := 0, len(all)
+	afterID, beforeID := query.Get("after_id"), query.Get("before_id")
+	for _, cursor := range []struct {
+		name, id string
+		apply    func(int)
+	}{
+		{"after_id", afterID, func(i int) { start = i + 1 }},
+		{"before_id", beforeID, func(i int) { end = i }},
+	} {
+		if cursor.id == "" {
+			continue
+		}
+		i := indexOf(cursor.id)
+		if i < 0 {
+			respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, fmt.Sprintf("%s refers to unknown %s %s", cursor.name, kind, cursor.id))
+			return nil, false, false
+		}
+		cursor.apply(i)
+	}
+
+	page = []T{}
+	if start < end {
+		page = all[start:end]
+	}
+	hasMore = len(page) > limit
+	if hasMore && beforeID != "" && afterID == "" {
+		page = page[len(page)-limit:] // The items just before the cursor
+	} else if hasMore {
+		page = page[:limit]
+	}
+	return page, hasMore, true
+}
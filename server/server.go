@@ -18,40 +18,73 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"sync"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/files"
+	"github.com/savaki/twin-in-disguise/signatures"
 	"github.com/savaki/twin-in-disguise/translator"
 	"github.com/savaki/twin-in-disguise/types"
+	"golang.org/x/sync/singleflight"
 )
 
 // Server handles Anthropic API requests and proxies them to Gemini
 type Server struct {
-	geminiClient        *genai.Client
-	geminiHTTPClient    *translator.GeminiHTTPClient
-	debug               bool
-	thoughtSignatures   map[string]string // Maps tool_use ID to thought signature
-	thoughtSignaturesMu sync.RWMutex
+	geminiClient      *genai.Client
+	geminiHTTPClient  *translator.GeminiHTTPClient
+	debug             bool
+	retryPolicy       translator.RetryPolicy
+	thoughtSignatures signatures.Store  // Maps tool_use ID to thought signature
+	toolUseIDs        *signatures.Codec // Non-nil in stateless mode: signatures travel in tool_use IDs
+	toolArgsStrategy  translator.ToolArgsStrategy
+	urlFetcher        *translator.URLFetcher   // Downloads image and document URL sources
+	fileStore         files.Store              // Files uploaded through the Files API
+	maxFileBytes      int64                    // Largest file accepted for upload
+	fileUploads       singleflight.Group       // Uploads of expired files to Gemini, by file ID
+	contextCache      *translator.ContextCache // Maps cache_control breakpoints to Gemini caches; nil if disabled
+	tokenCounter      *translator.TokenCounter // Answers count_tokens requests
+	models            *translator.ModelCatalog // Gemini models that support generateContent
+	validateModels    bool                     // Reject requests for models Gemini doesn't list
+	modelMap          *translator.ModelMap     // Routes requested model names to Gemini models
+	responseModel     translator.ResponseModel // Which model name responses carry
 }
 
 // New creates a new proxy server
 func New(geminiClient *genai.Client) *Server {
 	return &Server{
 		geminiClient:      geminiClient,
-		thoughtSignatures: make(map[string]string),
+		retryPolicy:       translator.DefaultRetryPolicy(),
+		thoughtSignatures: signatures.NewMemoryStore(signatures.DefaultConfig()),
+		toolArgsStrategy:  translator.ToolArgsPassthrough,
+		urlFetcher:        translator.NewURLFetcher(translator.DefaultURLFetchPolicy()),
+		fileStore:         files.NewMemoryStore(files.DefaultMemoryBytes),
+		maxFileBytes:      files.DefaultMaxBytes,
+		responseModel:     translator.ResponseModelRequested,
 	}
 }
 
 // NewWithAPIKey creates a new proxy server with HTTP client support for thought signatures
 func NewWithAPIKey(geminiClient *genai.Client, apiKey string) *Server {
+	httpClient := translator.NewGeminiHTTPClient(apiKey)
 	return &Server{
 		geminiClient:      geminiClient,
-		geminiHTTPClient:  translator.NewGeminiHTTPClient(apiKey),
-		thoughtSignatures: make(map[string]string),
+		geminiHTTPClient:  httpClient,
+		retryPolicy:       translator.DefaultRetryPolicy(),
+		thoughtSignatures: signatures.NewMemoryStore(signatures.DefaultConfig()),
+		toolArgsStrategy:  translator.ToolArgsPassthrough,
+		urlFetcher:        translator.NewURLFetcher(translator.DefaultURLFetchPolicy()),
+		fileStore:         files.NewMemoryStore(files.DefaultMemoryBytes),
+		maxFileBytes:      files.DefaultMaxBytes,
+		contextCache:      translator.NewContextCache(httpClient),
+		tokenCounter:      translator.NewTokenCounter(httpClient, translator.DefaultTokenCountTTL),
+		models:            translator.NewModelCatalog(httpClient, translator.DefaultModelListTTL),
+		validateModels:    true,
+		responseModel:     translator.ResponseModelRequested,
 	}
 }
 
@@ -60,6 +93,106 @@ func (s *Server) SetDebug(debug bool) {
 	s.debug = debug
 }
 
+// SetRetryPolicy configures how transient Gemini failures are retried on both the HTTP
+// and SDK paths
+func (s *Server) SetRetryPolicy(policy translator.RetryPolicy) {
+	s.retryPolicy = policy
+	if s.geminiHTTPClient != nil {
+		s.geminiHTTPClient.SetRetryPolicy(policy)
+	}
+}
+
+// SetSignatureStore replaces the thought signature store, e.g. with a persistent store or
+// one with configured limits. The caller remains responsible for closing the store.
+func (s *Server) SetSignatureStore(store signatures.Store) {
+	s.thoughtSignatures = store
+}
+
+// SetStatelessSignatures switches to stateless mode: thought signatures are embedded in
+// the tool_use IDs returned to the client, authenticated by codec, and recovered from the
+// IDs the client sends back. The signature store is not used in this mode, so any number
+// of replicas sharing the codec key can serve a conversation.
+func (s *Server) SetStatelessSignatures(codec *signatures.Codec) {
+	s.toolUseIDs = codec
+}
+
+// SetToolArgsStrategy sets what happens to function calls whose arguments don't match the
+// tool's input schema after repairs
+func (s *Server) SetToolArgsStrategy(strategy translator.ToolArgsStrategy) {
+	s.toolArgsStrategy = strategy
+}
+
+// SetURLFetchPolicy limits what is downloaded for image and document URL sources
+func (s *Server) SetURLFetchPolicy(policy translator.URLFetchPolicy) {
+	s.urlFetcher = translator.NewURLFetcher(policy)
+}
+
+// SetFileStore replaces the store of files uploaded through the Files API, e.g. with one
+// that persists them. The caller remains responsible for closing the store.
+func (s *Server) SetFileStore(store files.Store) {
+	s.fileStore = store
+}
+
+// SetMaxFileBytes limits the size of files uploaded through the Files API
+func (s *Server) SetMaxFileBytes(maxBytes int64) {
+	s.maxFileBytes = maxBytes
+}
+
+// SetContextCaching enables or disables mapping cache_control breakpoints to Gemini
+// context caches. It is enabled by default when the server has an API key.
+func (s *Server) SetContextCaching(enabled bool) {
+	switch {
+	case !enabled:
+		s.contextCache = nil
+	case s.contextCache == nil && s.geminiHTTPClient != nil:
+		s.contextCache = translator.NewContextCache(s.geminiHTTPClient)
+	}
+}
+
+// SetModelValidation enables or disables rejecting requests for models that Gemini
+// doesn't list as supporting generateContent. It is enabled by default when the server
+// has an API key.
+func (s *Server) SetModelValidation(enabled bool) {
+	s.validateModels = enabled
+}
+
+// SetModelMap routes requested model names, such as Claude model names hard-coded in a
+// client, to Gemini models
+func (s *Server) SetModelMap(modelMap *translator.ModelMap) {
+	s.modelMap = modelMap
+}
+
+// SetResponseModel sets whether responses carry the requested model name or the Gemini
+// model it was routed to
+func (s *Server) SetResponseModel(mode translator.ResponseModel) {
+	s.responseModel = mode
+}
+
+// Shutdown releases the Gemini resources held by the server: context caches created for
+// cache_control breakpoints are deleted rather than left to expire
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.contextCache == nil {
+		return nil
+	}
+	return s.contextCache.Close(ctx)
+}
+
+// SignatureStoreStats returns the thought signature store counters
+func (s *Server) SignatureStoreStats() signatures.Stats {
+	return s.thoughtSignatures.Stats()
+}
+
+// logSchemaIssues logs the parts of each tool's input schema that Gemini can't represent
+// exactly and were dropped or approximated
+func logSchemaIssues(tools []types.AnthropicTool) {
+	for _, tool := range tools {
+		_, issues := translator.ConvertSchema(tool.InputSchema)
+		for _, issue := range issues {
+			log.Printf("[DEBUG]   Tool %s schema: %s", tool.Name, issue)
+		}
+	}
+}
+
 // HandleMessages handles POST /v1/messages requests
 func (s *Server) HandleMessages(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -72,47 +205,92 @@ func (s *Server) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[DEBUG]   Content-Length: %d", r.ContentLength)
 	}
 
-	// Parse Anthropic request
+	anthropicReq, body, ok := s.readMessagesRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Requested model names may be routed to another Gemini model
+	geminiModelID, _ := s.modelMap.Resolve(anthropicReq.Model)
+
+	if s.debug {
+		log.Printf("[DEBUG]   Model: %s", geminiModelID)
+		logSchemaIssues(anthropicReq.Tools)
+	}
+
+	if geminiModelID != anthropicReq.Model {
+		log.Printf("Request: model=%s (requested %s) stream=%t", geminiModelID, anthropicReq.Model, anthropicReq.Stream)
+	} else {
+		log.Printf("Request: model=%s stream=%t", geminiModelID, anthropicReq.Stream)
+	}
+
+	if anthropicReq.Stream {
+		s.streamContent(ctx, w, geminiModelID, anthropicReq, body)
+		return
+	}
+
+	// Generation, including retries of failed Gemini calls, can outlast the server's write
+	// timeout, after which the response could no longer be written
+	clearWriteDeadline(w)
+
+	// Generate content
+	anthropicResp, err := s.generateContent(ctx, geminiModelID, anthropicReq)
+	if err != nil {
+		logGenerationError(err, body)
+		respondGenerationError(w, err)
+		return
+	}
+	anthropicResp.Model = s.responseModelName(anthropicReq.Model, geminiModelID)
+
+	// Send response
+	respondJSON(w, http.StatusOK, anthropicResp)
+}
+
+// readMessagesRequest reads a Messages API request and prepares it for translation: the
+// model is checked, built-in tools are expanded, and file and URL sources resolved. It
+// returns the request and its body; on failure the error response has already been sent
+// and ok is false.
+func (s *Server) readMessagesRequest(w http.ResponseWriter, r *http.Request) (req *types.AnthropicRequest, body []byte, ok bool) {
+	ctx := r.Context()
+
 	// Read body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		respondError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
-		return
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return nil, nil, false
 	}
 
 	// Parse Anthropic request
 	var anthropicReq types.AnthropicRequest
 	if err := json.Unmarshal(body, &anthropicReq); err != nil {
 		log.Printf("Failed to parse request: %v\nRequest body: %s", err, string(body))
-		respondError(w, http.StatusBadRequest, fmt.Sprintf("Failed to parse request: %v", err))
-		return
+		respondError(w, http.StatusBadRequest, types.ErrorTypeInvalidRequest, fmt.Sprintf("Failed to parse request: %v", err))
+		return nil, nil, false
 	}
 
-	// Use the model from the request body directly
-	geminiModelID := anthropicReq.Model
-
-	if s.debug {
-		log.Printf("[DEBUG]   Model: %s", geminiModelID)
+	// Unknown models are rejected before any files are uploaded or URLs fetched
+	if !s.checkModel(ctx, w, anthropicReq.Model) {
+		return nil, nil, false
 	}
 
-	log.Printf("Request: model=%s", geminiModelID)
+	// Built-in client tools (bash, text editor, computer use) arrive without an input schema
+	anthropicReq.Tools = translator.ExpandBuiltinTools(anthropicReq.Tools)
 
-	// Generate content
-	anthropicResp, err := s.generateContent(ctx, geminiModelID, &anthropicReq)
-	if err != nil {
-		// Pretty print the request body for the log
-		var prettyRequest bytes.Buffer
-		if err := json.Indent(&prettyRequest, body, "", "  "); err != nil {
-			prettyRequest.WriteString(string(body)) // Fallback to raw
-		}
+	// Uploaded files are referenced by the URL of the Gemini file holding them
+	if err := translator.ResolveFileSources(ctx, anthropicReq.Messages, s.lookupFile); err != nil {
+		log.Printf("Failed to resolve file sources: %v", err)
+		respondGenerationError(w, err)
+		return nil, nil, false
+	}
 
-		log.Printf("Generation error: %v\nRequest body:\n%s", err, prettyRequest.String())
-		respondError(w, http.StatusInternalServerError, fmt.Sprintf("Generation failed: %v", err))
-		return
+	// Gemini can't read arbitrary URLs, so URL sources are downloaded and inlined
+	if err := s.urlFetcher.ResolveURLSources(ctx, anthropicReq.Messages); err != nil {
+		log.Printf("Failed to resolve URL sources: %v", err)
+		respondGenerationError(w, err)
+		return nil, nil, false
 	}
 
-	// Send response
-	respondJSON(w, http.StatusOK, anthropicResp)
+	return &anthropicReq, body, true
 }
 
 // injectThoughtSignatures injects cached thought signatures into tool_use blocks.
@@ -129,38 +307,64 @@ func (s *Server) HandleMessages(w http.ResponseWriter, r *http.Request) {
 //     (e.g., in tool_result blocks), we inject the cached signature back
 //  4. This allows Gemini to maintain context about previous function calls
 //
-// The thought signature cache is stored in memory for the lifetime of the server.
+// Signatures are kept in a signatures.Store: in memory by default, bounded by entry count,
+// total size and TTL, or on disk so they survive restarts. A signature that has been
+// evicted can no longer be injected, in which case Gemini may reject the follow-up
+// request. Store failures are logged and otherwise ignored.
 //
-// TODO: The thought signature cache is never garbage collected and will slowly
-// leak memory over time. If this project gains traction, implement proper cache
-// eviction (e.g., LRU cache with TTL or size limits).
-func (s *Server) injectThoughtSignatures(req *types.AnthropicRequest) {
-	s.thoughtSignaturesMu.RLock()
-	defer s.thoughtSignaturesMu.RUnlock()
-
+// In stateless mode (see SetStatelessSignatures) the signature is decoded from the
+// tool_use ID instead, and an ID that fails authentication is rejected with an
+// invalid_request_error.
+func (s *Server) injectThoughtSignatures(ctx context.Context, req *types.AnthropicRequest) error {
 	for i := range req.Messages {
 		for j := range req.Messages[i].Content {
 			block := &req.Messages[i].Content[j]
-			if block.Type == types.ContentTypeToolUse && block.ID != "" {
-				if sig, ok := s.thoughtSignatures[block.ID]; ok {
-					block.ThoughtSignature = sig
-					if s.debug {
-						log.Printf("[DEBUG] Injected thought signature for tool_use %s", block.ID)
-					}
+			if block.Type != types.ContentTypeToolUse || block.ID == "" {
+				continue
+			}
+
+			var (
+				sig string
+				ok  bool
+				err error
+			)
+			if s.toolUseIDs != nil {
+				sig, ok, err = s.toolUseIDs.DecodeToolUseID(block.ID)
+				if err != nil {
+					return translator.NewInvalidRequestError("messages.%d.content.%d: %v", i, j, err)
+				}
+			} else {
+				sig, ok, err = s.thoughtSignatures.Get(ctx, block.ID)
+				if err != nil {
+					log.Printf("Warning: failed to load thought signature: %v", err)
+					continue
+				}
+			}
+
+			if ok {
+				block.ThoughtSignature = sig
+				if s.debug {
+					log.Printf("[DEBUG] Injected thought signature for tool_use %s", block.ID)
 				}
 			}
 		}
 	}
+	return nil
 }
 
 // cacheThoughtSignatures caches thought signatures from the response
-func (s *Server) cacheThoughtSignatures(resp *types.AnthropicResponse) {
-	s.thoughtSignaturesMu.Lock()
-	defer s.thoughtSignaturesMu.Unlock()
+func (s *Server) cacheThoughtSignatures(ctx context.Context, resp *types.AnthropicResponse) {
+	if s.toolUseIDs != nil {
+		// Stateless mode: the signatures are already carried by the tool_use IDs
+		return
+	}
 
 	for _, block := range resp.Content {
 		if block.Type == types.ContentTypeToolUse && block.ID != "" && block.ThoughtSignature != "" {
-			s.thoughtSignatures[block.ID] = block.ThoughtSignature
+			if err := s.thoughtSignatures.Set(ctx, block.ID, block.ThoughtSignature); err != nil {
+				log.Printf("Warning: failed to store thought signature: %v", err)
+				continue
+			}
 			if s.debug {
 				log.Printf("[DEBUG] Cached thought signature for tool_use %s", block.ID)
 			}
@@ -173,13 +377,16 @@ func (s *Server) generateContent(ctx context.Context, modelID string, req *types
 	hasTools := len(req.Tools) > 0
 
 	// Inject cached thought signatures into the request
-	s.injectThoughtSignatures(req)
+	if err := s.injectThoughtSignatures(ctx, req); err != nil {
+		return nil, err
+	}
 
-	// Check if we have thought signatures in the messages (after injection)
+	// Check if we have thought signatures in the messages (after injection), either on
+	// tool_use blocks or on thinking blocks from a previous turn
 	hasThoughtSignatures := false
 	for _, msg := range req.Messages {
 		for _, block := range msg.Content {
-			if block.ThoughtSignature != "" {
+			if block.ThoughtSignature != "" || block.Type == types.ContentTypeThinking || block.Type == types.ContentTypeRedactedThinking {
 				hasThoughtSignatures = true
 				break
 			}
@@ -189,18 +396,34 @@ func (s *Server) generateContent(ctx context.Context, modelID string, req *types
 		}
 	}
 
-	// Use HTTP client if we have tools or thought signatures, and the HTTP client is available
+	// Thinking models need a thinkingConfig, which the genai SDK can't send
+	hasThinking := req.Thinking != nil || translator.SupportsThinking(modelID)
+
+	// Context caches are managed through the REST API
+	hasCacheControl := s.contextCache != nil && len(translator.CacheBreakpoints(req)) > 0
+
+	// Stop sequences are emulated by the proxy. Streaming from Gemini lets generation be
+	// cut off as soon as one matches, rather than paying for the whole response.
+	if len(translator.StopSequences(req.StopSequences)) > 0 && s.geminiHTTPClient != nil {
+		converter, err := s.streamWithHTTP(ctx, modelID, req, func(...types.AnthropicStreamEvent) error { return nil })
+		if err != nil {
+			return nil, err
+		}
+		return converter.Response(), nil
+	}
+
+	// Use HTTP client if we have tools, thought signatures, thinking or cache_control, and the HTTP client is available
 	// This is necessary because:
 	// 1. Gemini requires thought signatures for function calling
-	// 2. The genai SDK doesn't support thought signatures
+	// 2. The genai SDK doesn't support thought signatures or thinking configuration
 	// 3. We need to preserve thought signatures across multi-turn conversations
-	if (hasTools || hasThoughtSignatures) && s.geminiHTTPClient != nil {
+	if (hasTools || hasThoughtSignatures || hasThinking || hasCacheControl) && s.geminiHTTPClient != nil {
 		resp, err := s.generateContentWithHTTP(ctx, modelID, req)
 		if err != nil {
 			return nil, err
 		}
 		// Cache thought signatures from the response
-		s.cacheThoughtSignatures(resp)
+		s.cacheThoughtSignatures(ctx, resp)
 		return resp, nil
 	}
 
@@ -210,16 +433,88 @@ func (s *Server) generateContent(ctx context.Context, modelID string, req *types
 		return nil, err
 	}
 	// Cache thought signatures from the response (if any)
-	s.cacheThoughtSignatures(resp)
+	s.cacheThoughtSignatures(ctx, resp)
 	return resp, nil
 }
 
 func (s *Server) generateContentWithHTTP(ctx context.Context, modelID string, req *types.AnthropicRequest) (*types.AnthropicResponse, error) {
+	names := translator.NewToolNames(req)
+	geminiReq, cacheCreated, err := s.buildCachedHTTPRequest(ctx, modelID, names.Request(req))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.debug {
+		log.Printf("[DEBUG] Using HTTP client for thought signature support")
+		log.Printf("[DEBUG]   Model: %s", modelID)
+		log.Printf("[DEBUG]   Message count: %d", len(geminiReq.Contents))
+	}
+
+	opts := translator.ResponseOptions{
+		ToolUseIDs:   s.toolUseIDEncoder(),
+		ToolNames:    names,
+		Tools:        translator.NewToolValidator(req.Tools, s.toolArgsStrategy),
+		CacheCreated: cacheCreated,
+	}
+	var prior types.AnthropicUsage // Usage of the discarded attempt
+	for reprompted := false; ; reprompted = true {
+		// Call Gemini API via HTTP
+		resp, err := s.geminiHTTPClient.GenerateContent(ctx, modelID, geminiReq)
+		if err != nil {
+			return nil, fmt.Errorf("gemini API error: %w", err)
+		}
+
+		// Convert response
+		anthropicResp, err := translator.ToAnthropicResponseFromCustom(resp, modelID, opts)
+		var argsErr *translator.InvalidToolArgsError
+		if errors.As(err, &argsErr) && s.toolArgsStrategy == translator.ToolArgsReprompt && !reprompted {
+			log.Printf("Re-prompting Gemini to correct function calls: %v", argsErr)
+			geminiReq.Contents = append(geminiReq.Contents, translator.RepromptContents(resp.Candidates[0].Content, argsErr)...)
+			if resp.UsageMetadata != nil {
+				prior = translator.ToAnthropicUsage(resp.UsageMetadata, opts.CacheCreated)
+			}
+			opts.CacheCreated = false // The retry reads the cache the first attempt created
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert response: %w", err)
+		}
+		anthropicResp.Usage = translator.AddUsage(anthropicResp.Usage, prior)
+
+		finalizeResponse(req, anthropicResp)
+
+		return anthropicResp, nil
+	}
+}
+
+// buildCachedHTTPRequest builds the Gemini request with buildHTTPRequest, then moves the
+// prompt prefix marked with cache_control into a Gemini context cache. It also reports
+// whether the context cache was created for this request.
+func (s *Server) buildCachedHTTPRequest(ctx context.Context, modelID string, req *types.AnthropicRequest) (*translator.GenerateContentRequest, bool, error) {
+	geminiReq, err := s.buildHTTPRequest(modelID, req)
+	if err != nil {
+		return nil, false, err
+	}
+	var created bool
+	if s.contextCache != nil {
+		created = s.contextCache.Apply(ctx, modelID, req, geminiReq)
+		if s.debug && geminiReq.CachedContent != "" {
+			log.Printf("[DEBUG]   Using context cache %s", geminiReq.CachedContent)
+		}
+	}
+	return geminiReq, created, nil
+}
+
+// buildHTTPRequest translates an Anthropic request into a Gemini request for the HTTP client
+func (s *Server) buildHTTPRequest(modelID string, req *types.AnthropicRequest) (*translator.GenerateContentRequest, error) {
 	// Convert messages to custom Gemini contents (with thought signature support)
 	contents, err := translator.ToCustomGeminiContents(req.Messages)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert messages: %w", err)
 	}
+	if !translator.SupportsMultimodalFunctionResponse(modelID) {
+		translator.FlattenFunctionResponses(contents)
+	}
 
 	// Build request
 	geminiReq := &translator.GenerateContentRequest{
@@ -252,10 +547,15 @@ func (s *Server) generateContentWithHTTP(ctx context.Context, modelID string, re
 	}
 
 	// Configure generation parameters
-	maxOutputTokens := int32(65536)
-	geminiReq.GenerationConfig = &translator.GenerationConfig{
-		MaxOutputTokens: &maxOutputTokens,
+	geminiReq.GenerationConfig = translator.ToGenerationConfig(req)
+
+	// Configure thinking. Thought summaries are requested so they can be surfaced as
+	// Anthropic thinking blocks.
+	thinkingConfig, warning := translator.ToThinkingConfig(modelID, req.Thinking)
+	if warning != "" {
+		log.Printf("Warning: %s", warning)
 	}
+	geminiReq.GenerationConfig.ThinkingConfig = thinkingConfig
 
 	// Convert tools
 	if len(req.Tools) > 0 {
@@ -268,30 +568,17 @@ func (s *Server) generateContentWithHTTP(ctx context.Context, modelID string, re
 				}},
 			})
 		}
+		geminiReq.ToolConfig = translator.ToToolConfig(req.ToolChoice)
 	}
 
-	if s.debug {
-		log.Printf("[DEBUG] Using HTTP client for thought signature support")
-		log.Printf("[DEBUG]   Model: %s", modelID)
-		log.Printf("[DEBUG]   Message count: %d", len(contents))
-	}
-
-	// Call Gemini API via HTTP
-	resp, err := s.geminiHTTPClient.GenerateContent(ctx, modelID, geminiReq)
-	if err != nil {
-		return nil, fmt.Errorf("gemini API error: %w", err)
-	}
-
-	// Convert response
-	anthropicResp, err := translator.ToAnthropicResponseFromCustom(resp, modelID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert response: %w", err)
-	}
-
-	return anthropicResp, nil
+	return geminiReq, nil
 }
 
 func (s *Server) generateContentWithSDK(ctx context.Context, modelID string, req *types.AnthropicRequest) (*types.AnthropicResponse, error) {
+	// Tool names are sent to Gemini as valid function names and restored in the response
+	names := translator.NewToolNames(req)
+	req = names.Request(req)
+
 	// Create Gemini model
 	model := s.geminiClient.GenerativeModel(modelID)
 
@@ -321,8 +608,11 @@ func (s *Server) generateContentWithSDK(ctx context.Context, modelID string, req
 	}
 
 	// Configure generation parameters
-	maxOutputTokens := int32(65536)
-	model.GenerationConfig.MaxOutputTokens = &maxOutputTokens
+	generationConfig := translator.ToGenerationConfig(req)
+	model.GenerationConfig.MaxOutputTokens = generationConfig.MaxOutputTokens
+	model.GenerationConfig.Temperature = generationConfig.Temperature
+	model.GenerationConfig.TopP = generationConfig.TopP
+	model.GenerationConfig.TopK = generationConfig.TopK
 
 	// Convert tools
 	if len(req.Tools) > 0 {
@@ -331,6 +621,7 @@ func (s *Server) generateContentWithSDK(ctx context.Context, modelID string, req
 			return nil, fmt.Errorf("failed to convert tools: %w", err)
 		}
 		model.Tools = tools
+		model.ToolConfig = translator.ToGeminiToolConfig(req.ToolChoice)
 	}
 
 	// Convert messages to contents
@@ -355,15 +646,35 @@ func (s *Server) generateContentWithSDK(ctx context.Context, modelID string, req
 		if s.debug {
 			log.Printf("[DEBUG] Using single-turn GenerateContent")
 		}
-		resp, err = model.GenerateContent(ctx, contents[0].Parts...)
+		err = translator.Retry(ctx, s.retryPolicy, func(ctx context.Context) error {
+			var err error
+			resp, err = model.GenerateContent(ctx, contents[0].Parts...)
+			return err
+		})
 	} else {
 		// Multi-turn - use chat session
 		if s.debug {
 			log.Printf("[DEBUG] Using multi-turn chat session (history: %d messages)", len(contents)-1)
 		}
-		chat := model.StartChat()
-		chat.History = contents[:len(contents)-1]
-		resp, err = chat.SendMessage(ctx, contents[len(contents)-1].Parts...)
+		err = translator.Retry(ctx, s.retryPolicy, func(ctx context.Context) error {
+			// Start a fresh session per attempt so a failed attempt can't leave history behind
+			chat := model.StartChat()
+			chat.History = contents[:len(contents)-1]
+			var err error
+			resp, err = chat.SendMessage(ctx, contents[len(contents)-1].Parts...)
+			return err
+		})
+	}
+
+	// The SDK reports blocked prompts and safety stops as errors; translate them into
+	// a regular response so they surface as a refusal
+	var blockedErr *genai.BlockedError
+	if errors.As(err, &blockedErr) {
+		resp = &genai.GenerateContentResponse{PromptFeedback: blockedErr.PromptFeedback}
+		if blockedErr.Candidate != nil {
+			resp.Candidates = []*genai.Candidate{blockedErr.Candidate}
+		}
+		err = nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("gemini API error: %w", err)
@@ -374,6 +685,7 @@ func (s *Server) generateContentWithSDK(ctx context.Context, modelID string, req
 		log.Printf("[DEBUG]   Candidates: %d", len(resp.Candidates))
 		if resp.UsageMetadata != nil {
 			log.Printf("[DEBUG]   Input tokens: %d", resp.UsageMetadata.PromptTokenCount)
+			log.Printf("[DEBUG]   Cached tokens: %d", resp.UsageMetadata.CachedContentTokenCount)
 			log.Printf("[DEBUG]   Output tokens: %d", resp.UsageMetadata.CandidatesTokenCount)
 			log.Printf("[DEBUG]   Total tokens: %d", resp.UsageMetadata.TotalTokenCount)
 		}
@@ -384,10 +696,50 @@ func (s *Server) generateContentWithSDK(ctx context.Context, modelID string, req
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert response: %w", err)
 	}
+	names.RestoreToolUse(anthropicResp.Content)
+
+	finalizeResponse(req, anthropicResp)
 
 	return anthropicResp, nil
 }
 
+// toolUseIDEncoder returns the encoder for tool_use IDs in stateless mode, or nil
+func (s *Server) toolUseIDEncoder() translator.ToolUseIDEncoder {
+	if s.toolUseIDs == nil {
+		return nil
+	}
+	return s.toolUseIDs
+}
+
+// finalizeResponse emulates request options that Gemini can't express natively:
+// disable_parallel_tool_use and stop sequences. Stop sequences are only applied here when
+// the response couldn't be streamed; see generateContent.
+func finalizeResponse(req *types.AnthropicRequest, resp *types.AnthropicResponse) {
+	if translator.ParallelToolUseDisabled(req.ToolChoice) {
+		translator.LimitToolUse(resp)
+	}
+
+	translator.ApplyStopSequences(resp, translator.StopSequences(req.StopSequences))
+}
+
+// clearWriteDeadline lifts the server's write timeout for a response that waits on Gemini
+func clearWriteDeadline(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Failed to clear write deadline: %v", err)
+	}
+}
+
+// logGenerationError logs a failed generation along with the pretty-printed request body
+func logGenerationError(err error, body []byte) {
+	var prettyRequest bytes.Buffer
+	if err := json.Indent(&prettyRequest, body, "", "  "); err != nil {
+		prettyRequest.WriteString(string(body)) // Fallback to raw
+	}
+
+	log.Printf("Generation error: %v\nRequest body:\n%s", err, prettyRequest.String())
+}
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -396,8 +748,17 @@ func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	}
 }
 
-func respondError(w http.ResponseWriter, status int, message string) {
-	response := map[string]string{types.ResponseFieldError: message}
+// respondError writes an Anthropic error response:
+//
+//	{"type": "error", "error": {"type": "invalid_request_error", "message": "..."}}
+func respondError(w http.ResponseWriter, status int, errorType string, message string) {
+	response := types.AnthropicErrorResponse{
+		Type: types.ResponseTypeError,
+		Error: types.AnthropicErrorBody{
+			Type:    errorType,
+			Message: message,
+		},
+	}
 
 	// Log the error response
 	if status >= 400 {
@@ -406,3 +767,11 @@ func respondError(w http.ResponseWriter, status int, message string) {
 
 	respondJSON(w, status, response)
 }
+
+// respondGenerationError writes an upstream failure as an Anthropic error response. Gemini
+// errors keep their meaning (rate limits, overload, bad requests, ...) so that clients can
+// decide whether to retry.
+func respondGenerationError(w http.ResponseWriter, err error) {
+	status, body := translator.ToAnthropicError(err)
+	respondError(w, status, body.Type, body.Message)
+}
@@ -20,16 +20,46 @@ import (
 	"encoding/json"
 	fmt "fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/signatures"
+	"github.com/savaki/twin-in-disguise/translator"
 	"github.com/savaki/twin-in-disguise/types"
 	"google.golang.org/api/option"
 )
 
+// newTestServer creates a Server whose HTTP client talks to the given fake Gemini handler
+func newTestServer(t *testing.T, handler http.HandlerFunc) *Server {
+	t.Helper()
+
+	client, err := genai.NewClient(context.Background(), option.WithAPIKey("test-key"))
+	if err != nil {
+		t.Fatalf("failed to create Gemini client: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	srv := NewWithAPIKey(client, "test-key")
+	srv.geminiHTTPClient.SetBaseURL(ts.URL)
+	srv.SetModelValidation(false) // Test handlers don't implement models.list
+	srv.SetRetryPolicy(translator.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	})
+	return srv
+}
+
 func TestHandleInvoke_Live(t *testing.T) {
 	apiKey := os.Getenv("GEMINI_API_KEY")
 	if apiKey == "" {
@@ -260,7 +290,7 @@ func TestNewWithAPIKey(t *testing.T) {
 	}
 
 	if srv.thoughtSignatures == nil {
-		t.Error("expected thought signatures map to be initialized")
+		t.Error("expected thought signatures cache to be initialized")
 	}
 }
 
@@ -357,12 +387,10 @@ func TestInjectAndCacheThoughtSignatures(t *testing.T) {
 	}
 
 	// Cache the thought signature
-	srv.cacheThoughtSignatures(resp)
+	srv.cacheThoughtSignatures(context.Background(), resp)
 
 	// Verify it was cached
-	srv.thoughtSignaturesMu.RLock()
-	sig, ok := srv.thoughtSignatures["tool_123"]
-	srv.thoughtSignaturesMu.RUnlock()
+	sig, ok, _ := srv.thoughtSignatures.Get(context.Background(), "tool_123")
 
 	if !ok {
 		t.Error("expected thought signature to be cached")
@@ -389,7 +417,9 @@ func TestInjectAndCacheThoughtSignatures(t *testing.T) {
 	}
 
 	// Inject the thought signature
-	srv.injectThoughtSignatures(req)
+	if err := srv.injectThoughtSignatures(context.Background(), req); err != nil {
+		t.Fatalf("failed to inject thought signatures: %v", err)
+	}
 
 	// Verify it was injected
 	if req.Messages[0].Content[0].ThoughtSignature != "I need to search" {
@@ -614,6 +644,7 @@ func TestGenerateContent_PathSelection(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			srv := tt.setupServer()
+			srv.SetRetryPolicy(translator.RetryPolicy{MaxAttempts: 1})
 
 			// This will fail because we don't have a valid API key,
 			// but it will exercise the path selection logic
@@ -628,3 +659,786 @@ func TestGenerateContent_PathSelection(t *testing.T) {
 		})
 	}
 }
+
+func TestHandleMessages_SamplingParameters(t *testing.T) {
+	var geminiReq map[string]interface{}
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+			t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		// Requests with stop sequences are streamed, so generation stops at a match
+		if !strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			t.Errorf("expected streaming endpoint, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "alpha beta STOP6 gamma"}]}, "finishReason": "STOP"}]}`+"\n\n")
+	})
+
+	body := `{
+		"model": "gemini-3-pro-preview",
+		"max_tokens": 2048,
+		"temperature": 0.2,
+		"top_p": 0.8,
+		"top_k": 20,
+		"stop_sequences": ["STOP1", "STOP2", "STOP3", "STOP4", "STOP5", "STOP6"],
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	config, _ := geminiReq["generationConfig"].(map[string]interface{})
+	if config["maxOutputTokens"] != float64(2048) {
+		t.Errorf("expected maxOutputTokens 2048, got %v", config["maxOutputTokens"])
+	}
+	if config["temperature"] != 0.2 {
+		t.Errorf("expected temperature 0.2, got %v", config["temperature"])
+	}
+	if config["topK"] != float64(20) {
+		t.Errorf("expected topK 20, got %v", config["topK"])
+	}
+	if stops, ok := config["stopSequences"]; ok {
+		t.Errorf("expected stop sequences to be emulated by the proxy, got %v", stops)
+	}
+
+	var response types.AnthropicResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.StopReason != "stop_sequence" || response.StopSequence != "STOP6" {
+		t.Errorf("expected stop_sequence STOP6, got %q %q", response.StopReason, response.StopSequence)
+	}
+	if len(response.Content) != 1 || response.Content[0].Text != "alpha beta " {
+		t.Errorf("expected truncated text 'alpha beta ', got %+v", response.Content)
+	}
+}
+
+func TestHandleMessages_NativeStopSequence(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "alpha STOP1 beta"}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 7, "candidatesTokenCount": 3}}`+"\n\n")
+		// Not returned: the proxy stops reading once the stop sequence matches
+		fmt.Fprint(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": " gamma"}]}}]}`+"\n\n")
+	})
+
+	// STOP1 is within Gemini's five stop sequence limit, but must still be reported
+	body := `{
+		"model": "gemini-3-pro-preview",
+		"max_tokens": 100,
+		"stop_sequences": ["STOP1", "STOP2"],
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response types.AnthropicResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.StopReason != types.StopReasonStopSequence || response.StopSequence != "STOP1" {
+		t.Errorf("expected stop_sequence STOP1, got %q %q", response.StopReason, response.StopSequence)
+	}
+	if len(response.Content) != 1 || response.Content[0].Text != "alpha " {
+		t.Errorf("expected truncated text 'alpha ', got %+v", response.Content)
+	}
+	if response.Usage.InputTokens != 7 || response.Usage.OutputTokens != 3 {
+		t.Errorf("expected usage from the stream, got %+v", response.Usage)
+	}
+}
+
+func TestHandleMessages_OutlastsWriteTimeout(t *testing.T) {
+	var calls atomic.Int32
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(150 * time.Millisecond)
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error": {"code": 503, "message": "overloaded", "status": "UNAVAILABLE"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}]}, "finishReason": "STOP"}]}`)
+	})
+
+	// The retried generation takes longer than the server's write timeout
+	proxy := httptest.NewUnstartedServer(http.HandlerFunc(srv.HandleMessages))
+	proxy.Config.WriteTimeout = 100 * time.Millisecond
+	proxy.Start()
+	t.Cleanup(proxy.Close)
+
+	body := `{"model": "gemini-3-pro-preview", "max_tokens": 100, "messages": [{"role": "user", "content": "Hi"}]}`
+	resp, err := http.Post(proxy.URL+"/v1/messages", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("expected the response to be written, got %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response types.AnthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || len(response.Content) != 1 || response.Content[0].Text != "Hello" {
+		t.Errorf("expected the retried response, got %+v (err=%v)", response, err)
+	}
+}
+
+func TestHandleMessages_ToolChoice(t *testing.T) {
+	var geminiReq map[string]interface{}
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+			t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [
+			{"functionCall": {"name": "get_weather", "args": {"location": "Paris"}}},
+			{"functionCall": {"name": "get_weather", "args": {"location": "London"}}}
+		]}, "finishReason": "STOP"}]}`)
+	})
+
+	body := `{
+		"model": "gemini-2.0-flash",
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "tool", "name": "get_weather", "disable_parallel_tool_use": true},
+		"messages": [{"role": "user", "content": "Weather in Paris and London?"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	toolConfig, _ := geminiReq["toolConfig"].(map[string]interface{})
+	callingConfig, _ := toolConfig["functionCallingConfig"].(map[string]interface{})
+	if callingConfig["mode"] != "ANY" {
+		t.Errorf("expected mode ANY, got %v", callingConfig["mode"])
+	}
+	if names, _ := callingConfig["allowedFunctionNames"].([]interface{}); len(names) != 1 || names[0] != "get_weather" {
+		t.Errorf("expected allowedFunctionNames [get_weather], got %v", callingConfig["allowedFunctionNames"])
+	}
+
+	var response types.AnthropicResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.Content) != 1 || response.Content[0].Input["location"] != "Paris" {
+		t.Errorf("expected only the first tool_use to be kept, got %+v", response.Content)
+	}
+	if response.StopReason != "tool_use" {
+		t.Errorf("expected stop_reason tool_use, got %q", response.StopReason)
+	}
+}
+
+func TestHandleMessages_ToolSchemaConversion(t *testing.T) {
+	var geminiReq struct {
+		Tools []struct {
+			FunctionDeclarations []struct {
+				Parameters map[string]interface{} `json:"parameters"`
+			} `json:"functionDeclarations"`
+		} `json:"tools"`
+	}
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+			t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "ok"}]}, "finishReason": "STOP"}]}`)
+	})
+	srv.SetDebug(true)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	body := `{
+		"model": "gemini-2.0-flash",
+		"tools": [{"name": "search", "input_schema": {
+			"$schema": "http://json-schema.org/draft-07/schema#",
+			"type": "object",
+			"$defs": {"query": {"type": "string", "pattern": "^[a-z]+$"}},
+			"properties": {
+				"query": {"$ref": "#/$defs/query"},
+				"limit": {"anyOf": [{"type": "integer"}, {"type": "null"}], "default": 10}
+			},
+			"additionalProperties": false
+		}}],
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if len(geminiReq.Tools) != 1 || len(geminiReq.Tools[0].FunctionDeclarations) != 1 {
+		t.Fatalf("expected one function declaration, got %+v", geminiReq.Tools)
+	}
+	parameters, _ := json.Marshal(geminiReq.Tools[0].FunctionDeclarations[0].Parameters)
+	expected := `{"properties":{"limit":{"nullable":true,"type":"integer"},"query":{"type":"string"}},"type":"object"}`
+	if string(parameters) != expected {
+		t.Errorf("unexpected parameters\nGot:  %s\nWant: %s", parameters, expected)
+	}
+
+	for _, want := range []string{
+		`Tool search schema: #/properties/limit: dropped unsupported keyword "default"`,
+		`Tool search schema: #/properties/query: dropped unsupported keyword "pattern"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected debug log %q, got:\n%s", want, logs.String())
+		}
+	}
+}
+
+func TestHandleMessages_ToolArgsReprompt(t *testing.T) {
+	var requests []translator.GenerateContentRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var geminiReq translator.GenerateContentRequest
+		if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+			t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		requests = append(requests, geminiReq)
+
+		args := `{}`
+		if len(requests) > 1 {
+			args = `{"location": "Paris", "days": "3"}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"candidates": [{"content": {"role": "model", "parts": [
+			{"functionCall": {"name": "get_weather", "args": %s}, "thoughtSignature": "sig-1"}
+		]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5}}`, args)
+	})
+	srv.SetToolArgsStrategy(translator.ToolArgsReprompt)
+
+	body := `{
+		"model": "gemini-2.5-flash",
+		"tools": [{"name": "get_weather", "input_schema": {
+			"type": "object",
+			"properties": {"location": {"type": "string"}, "days": {"type": "integer"}},
+			"required": ["location"]
+		}}],
+		"messages": [{"role": "user", "content": "Weather in Paris?"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected the model to be re-prompted once, got %d requests", len(requests))
+	}
+
+	// The re-prompt sends back the model's turn and the validation error
+	contents := requests[1].Contents
+	if len(contents) != 3 {
+		t.Fatalf("expected 3 contents in the re-prompt, got %d", len(contents))
+	}
+	if contents[1].Role != "model" || contents[1].Parts[0].ThoughtSignature != "sig-1" {
+		t.Errorf("expected the model turn with its thought signature, got %+v", contents[1])
+	}
+	response := contents[2].Parts[0].FunctionResponse
+	if response == nil || !strings.Contains(fmt.Sprint(response.Response["error"]), `"location" is required`) {
+		t.Errorf("expected the validation error in a function response, got %+v", contents[2])
+	}
+
+	var anthropicResp types.AnthropicResponse
+	if err := json.NewDecoder(w.Body).Decode(&anthropicResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(anthropicResp.Content) != 1 || anthropicResp.Content[0].Input["days"] != float64(3) {
+		t.Errorf("expected the corrected and repaired call, got %+v", anthropicResp.Content)
+	}
+	if anthropicResp.Usage.InputTokens != 20 || anthropicResp.Usage.OutputTokens != 10 {
+		t.Errorf("expected the usage of both attempts, got %+v", anthropicResp.Usage)
+	}
+}
+
+func TestHandleMessages_ToolArgsError(t *testing.T) {
+	calls := 0
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [
+			{"functionCall": {"name": "get_weather", "args": {}}}
+		]}, "finishReason": "STOP"}]}`)
+	})
+	srv.SetToolArgsStrategy(translator.ToolArgsReprompt)
+
+	body := `{
+		"model": "gemini-2.5-flash",
+		"tools": [{"name": "get_weather", "input_schema": {
+			"type": "object",
+			"properties": {"location": {"type": "string"}},
+			"required": ["location"]
+		}}],
+		"messages": [{"role": "user", "content": "Weather?"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if calls != 2 {
+		t.Errorf("expected one re-prompt, got %d calls", calls)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status 500, got %d: %s", w.Code, w.Body.String())
+	}
+	var response types.AnthropicErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Error.Type != "api_error" || !strings.Contains(response.Error.Message, `"location" is required`) {
+		t.Errorf("unexpected error: %+v", response.Error)
+	}
+}
+
+func TestHandleMessages_ToolNameMapping(t *testing.T) {
+	const toolName = "mcp__github__search repositories by topic and language (with pagination)"
+	geminiName := translator.SanitizeFunctionName(toolName)
+
+	var geminiReq translator.GenerateContentRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+			t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"candidates": [{"content": {"role": "model", "parts": [
+			{"functionCall": {"name": %q, "args": {"topic": "go"}}}
+		]}, "finishReason": "STOP"}]}`, geminiName)
+	})
+
+	body := fmt.Sprintf(`{
+		"model": "gemini-2.5-flash",
+		"tools": [{"name": %[1]q, "input_schema": {"type": "object", "properties": {"topic": {"type": "string"}}}}],
+		"tool_choice": {"type": "tool", "name": %[1]q},
+		"messages": [
+			{"role": "user", "content": "Find Go repositories"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": %[1]q, "input": {"topic": "golang"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": "none"}]}
+		]
+	}`, toolName)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := geminiReq.Tools[0].FunctionDeclarations[0].Name; got != geminiName {
+		t.Errorf("expected declaration %q, got %q", geminiName, got)
+	}
+	if got := geminiReq.ToolConfig.FunctionCallingConfig.AllowedFunctionNames; len(got) != 1 || got[0] != geminiName {
+		t.Errorf("expected allowed function %q, got %v", geminiName, got)
+	}
+	if got := geminiReq.Contents[1].Parts[0].FunctionCall.Name; got != geminiName {
+		t.Errorf("expected function call in history to be %q, got %q", geminiName, got)
+	}
+	if got := geminiReq.Contents[2].Parts[0].FunctionResponse.Name; got != geminiName {
+		t.Errorf("expected function response in history to be %q, got %q", geminiName, got)
+	}
+
+	var response types.AnthropicResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(response.Content) != 1 || response.Content[0].Name != toolName {
+		t.Errorf("expected tool_use for %q, got %+v", toolName, response.Content)
+	}
+}
+
+func TestHandleMessages_BuiltinTools(t *testing.T) {
+	var geminiReq translator.GenerateContentRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+			t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [
+			{"functionCall": {"name": "bash", "args": {"command": "ls -la"}}}
+		]}, "finishReason": "STOP"}]}`)
+	})
+
+	body := `{
+		"model": "gemini-2.5-flash",
+		"tools": [{"type": "bash_20250124", "name": "bash"}],
+		"messages": [{"role": "user", "content": "List the files"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	declaration := geminiReq.Tools[0].FunctionDeclarations[0]
+	if declaration.Name != "bash" || declaration.Description == "" {
+		t.Errorf("unexpected declaration: %+v", declaration)
+	}
+	parameters, _ := declaration.Parameters.(map[string]interface{})
+	properties, _ := parameters[types.SchemaFieldProperties].(map[string]interface{})
+	if _, ok := properties["command"]; !ok {
+		t.Errorf("expected a command parameter, got %v", declaration.Parameters)
+	}
+
+	var response types.AnthropicResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.StopReason != types.StopReasonToolUse || response.Content[0].Name != "bash" || response.Content[0].Input["command"] != "ls -la" {
+		t.Errorf("unexpected response: %+v", response)
+	}
+}
+
+func TestHandleMessages_ToolResultImage(t *testing.T) {
+	tests := []struct {
+		model  string
+		nested bool // Image sent inside the function response
+	}{
+		{model: "gemini-3-pro-preview", nested: true},
+		{model: "gemini-2.5-flash", nested: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			var geminiReq translator.GenerateContentRequest
+			srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+					t.Errorf("failed to decode Gemini request: %v", err)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "A dialog is open"}]}, "finishReason": "STOP"}]}`)
+			})
+
+			body := fmt.Sprintf(`{
+				"model": %q,
+				"tools": [{"type": "computer_20250124", "name": "computer", "display_width_px": 1024, "display_height_px": 768}],
+				"messages": [
+					{"role": "user", "content": "What's on screen?"},
+					{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "computer", "input": {"action": "screenshot"}}]},
+					{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "is_error": true, "content": [
+						{"type": "text", "text": "Screen is locked"},
+						{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+					]}]}
+				]
+			}`, tt.model)
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+			w := httptest.NewRecorder()
+
+			srv.HandleMessages(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			parts := geminiReq.Contents[2].Parts
+			response := parts[0].FunctionResponse
+			if response == nil || response.Response["error"] != "Screen is locked" {
+				t.Fatalf("expected an error function response, got %+v", parts[0])
+			}
+			if tt.nested {
+				if len(parts) != 1 || len(response.Parts) != 1 || response.Parts[0].InlineData.MimeType != "image/png" {
+					t.Errorf("expected the image inside the function response, got %+v", parts)
+				}
+			} else {
+				if len(parts) != 3 || len(response.Parts) != 0 || parts[2].InlineData == nil {
+					t.Errorf("expected the image after the function response, got %+v", parts)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMessages_URLSource(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+	}))
+	defer files.Close()
+
+	var geminiReq translator.GenerateContentRequest
+	calls := 0
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+			t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "A logo"}]}, "finishReason": "STOP"}]}`)
+	})
+
+	body := fmt.Sprintf(`{
+		"model": "gemini-2.5-flash",
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [{"role": "user", "content": [
+			{"type": "image", "source": {"type": "url", "url": %q}},
+			{"type": "text", "text": "What is this?"}
+		]}]
+	}`, files.URL+"/logo.png")
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		srv.HandleMessages(w, req)
+		return w
+	}
+
+	// The test server listens on a loopback address, which is blocked by default
+	w := send()
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "not publicly routable") {
+		t.Fatalf("expected the private URL to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 0 {
+		t.Errorf("expected Gemini not to be called, got %d calls", calls)
+	}
+
+	policy := translator.DefaultURLFetchPolicy()
+	policy.AllowPrivateNetworks = true
+	srv.SetURLFetchPolicy(policy)
+
+	w = send()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	image := geminiReq.Contents[0].Parts[0].InlineData
+	if image == nil || image.MimeType != "image/png" || image.Data != "iVBORw0KGgo=" {
+		t.Errorf("expected the image to be inlined, got %+v", geminiReq.Contents[0].Parts[0])
+	}
+}
+
+func TestHandleMessages_CacheControl(t *testing.T) {
+	var (
+		geminiReq translator.GenerateContentRequest
+		cached    translator.CachedContent
+		deleted   []string
+	)
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/cachedContents":
+			json.NewDecoder(r.Body).Decode(&cached)
+			fmt.Fprintf(w, `{"name": "cachedContents/abc", "expireTime": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/"))
+			fmt.Fprint(w, "{}")
+		default:
+			json.NewDecoder(r.Body).Decode(&geminiReq)
+			fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Done"}]}, "finishReason": "STOP"}]}`)
+		}
+	})
+
+	body := `{
+		"model": "gemini-2.5-flash",
+		"system": [{"type": "text", "text": "You are a coding agent", "cache_control": {"type": "ephemeral", "ttl": "1h"}}],
+		"messages": [{"role": "user", "content": "Hello"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if cached.TTL != "3600s" || cached.SystemInstruction == nil || len(cached.Contents) != 0 {
+		t.Errorf("expected the system prompt to be cached for an hour, got %+v", cached)
+	}
+	if geminiReq.CachedContent != "cachedContents/abc" || geminiReq.SystemInstruction != nil || len(geminiReq.Contents) != 1 {
+		t.Errorf("expected the request to reference the cache, got %+v", geminiReq)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "cachedContents/abc" {
+		t.Errorf("expected the cache to be deleted on shutdown, got %v", deleted)
+	}
+}
+
+func TestHandleMessages_ErrorEnvelope(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, `{"error": {"code": 503, "message": "The model is overloaded.", "status": "UNAVAILABLE"}}`)
+	})
+
+	body := `{
+		"model": "gemini-2.0-flash",
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != 529 {
+		t.Fatalf("expected status 529, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var response types.AnthropicErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Type != "error" {
+		t.Errorf("expected type error, got %q", response.Type)
+	}
+	if response.Error.Type != "overloaded_error" {
+		t.Errorf("expected overloaded_error, got %q", response.Error.Type)
+	}
+	if response.Error.Message != "The model is overloaded." {
+		t.Errorf("unexpected message %q", response.Error.Message)
+	}
+}
+
+func TestHandleMessages_InvalidJSON(t *testing.T) {
+	srv := NewWithAPIKey(nil, "test-key")
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte("{not json")))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", w.Code)
+	}
+
+	var response types.AnthropicErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if response.Type != "error" || response.Error.Type != "invalid_request_error" {
+		t.Errorf("expected invalid_request_error envelope, got %+v", response)
+	}
+}
+
+func TestHandleMessages_RetriesTransientErrors(t *testing.T) {
+	calls := 0
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": {"code": 429, "message": "quota", "status": "RESOURCE_EXHAUSTED"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}]}, "finishReason": "STOP"}]}`)
+	})
+
+	body := `{
+		"model": "gemini-2.0-flash",
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", calls)
+	}
+}
+
+func TestHandleMessages_StatelessSignatures(t *testing.T) {
+	var geminiReq translator.GenerateContentRequest
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		geminiReq = translator.GenerateContentRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&geminiReq); err != nil {
+			t.Errorf("failed to decode Gemini request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [
+			{"functionCall": {"name": "get_weather", "args": {"location": "Paris"}}, "thoughtSignature": "c2lnbmF0dXJl"}
+		]}, "finishReason": "STOP"}]}`)
+	})
+	codec, err := signatures.NewCodec([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("failed to create codec: %v", err)
+	}
+	srv.SetStatelessSignatures(codec)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		srv.HandleMessages(w, req)
+		return w
+	}
+
+	// First turn: the tool_use ID carries the signature
+	w := send(`{
+		"model": "gemini-3-pro-preview",
+		"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+		"messages": [{"role": "user", "content": "Weather in Paris?"}]
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response types.AnthropicResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	toolID := response.Content[0].ID
+	if !strings.HasPrefix(toolID, signatures.ToolUseIDPrefix) {
+		t.Fatalf("expected encoded tool_use ID, got %q", toolID)
+	}
+	if stats := srv.SignatureStoreStats(); stats.Entries != 0 {
+		t.Errorf("expected nothing to be stored in stateless mode, got %+v", stats)
+	}
+
+	// Second turn, possibly on another replica: the signature is recovered from the ID
+	followUp := func(id string) string {
+		return fmt.Sprintf(`{
+			"model": "gemini-3-pro-preview",
+			"tools": [{"name": "get_weather", "input_schema": {"type": "object"}}],
+			"messages": [
+				{"role": "user", "content": "Weather in Paris?"},
+				{"role": "assistant", "content": [{"type": "tool_use", "id": %q, "name": "get_weather", "input": {"location": "Paris"}}]},
+				{"role": "user", "content": [{"type": "tool_result", "tool_use_id": %q, "content": "Sunny"}]}
+			]
+		}`, id, id)
+	}
+	w = send(followUp(toolID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if sig := geminiReq.Contents[1].Parts[0].ThoughtSignature; sig != "c2lnbmF0dXJl" {
+		t.Errorf("expected signature to be restored from the tool_use ID, got %q", sig)
+	}
+
+	// Tampered IDs are rejected
+	tampered := toolID[:len(toolID)-2] + "xx"
+	if tampered == toolID {
+		tampered = toolID[:len(toolID)-2] + "yy"
+	}
+	w = send(followUp(tampered))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for tampered ID, got %d: %s", w.Code, w.Body.String())
+	}
+	var errResp types.AnthropicErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if errResp.Error.Type != types.ErrorTypeInvalidRequest {
+		t.Errorf("expected invalid_request_error, got %q", errResp.Error.Type)
+	}
+}
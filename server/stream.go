@@ -0,0 +1,235 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/savaki/twin-in-disguise/translator"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// streamContent handles a /v1/messages request with "stream": true.
+//
+// When the HTTP client is available, Gemini's streamGenerateContent endpoint is used and
+// each chunk is relayed to the client as it arrives. The genai SDK path doesn't support
+// thought signatures, so in that case the full response is generated and then replayed
+// as a stream of events.
+//
+// Errors that occur before the first event is written are reported as a regular JSON
+// error response. Once the stream has started, errors are reported as an error event.
+func (s *Server) streamContent(ctx context.Context, w http.ResponseWriter, modelID string, req *types.AnthropicRequest, body []byte) {
+	sse := newEventWriter(w)
+
+	// Streaming responses routinely outlive the server's write timeout
+	clearWriteDeadline(w)
+
+	var err error
+	if s.geminiHTTPClient != nil {
+		err = s.streamContentWithHTTP(ctx, sse, modelID, req)
+	} else {
+		var resp *types.AnthropicResponse
+		resp, err = s.generateContent(ctx, modelID, req)
+		if err == nil {
+			resp.Model = s.responseModelName(req.Model, modelID)
+			err = sse.send(translator.ToStreamEvents(resp)...)
+		}
+	}
+
+	if err != nil {
+		logGenerationError(err, body)
+		if !sse.started {
+			respondGenerationError(w, err)
+			return
+		}
+		sse.sendError(err)
+	}
+}
+
+func (s *Server) streamContentWithHTTP(ctx context.Context, sse *eventWriter, modelID string, req *types.AnthropicRequest) error {
+	// Inject cached thought signatures into the request
+	if err := s.injectThoughtSignatures(ctx, req); err != nil {
+		return err
+	}
+
+	_, err := s.streamWithHTTP(ctx, modelID, req, sse.send)
+	return err
+}
+
+// streamWithHTTP generates a response through Gemini's streamGenerateContent endpoint,
+// passing the Anthropic events to send as they are produced. Reading from Gemini stops as
+// soon as a stop sequence matches. The converter holding the complete response is
+// returned once message_stop has been sent.
+func (s *Server) streamWithHTTP(ctx context.Context, modelID string, req *types.AnthropicRequest, send func(...types.AnthropicStreamEvent) error) (*translator.StreamConverter, error) {
+	names := translator.NewToolNames(req)
+	geminiReq, cacheCreated, err := s.buildCachedHTTPRequest(ctx, modelID, names.Request(req))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.debug {
+		log.Printf("[DEBUG] Using HTTP client for streaming")
+		log.Printf("[DEBUG]   Model: %s", modelID)
+		log.Printf("[DEBUG]   Message count: %d", len(geminiReq.Contents))
+	}
+
+	// With the reprompt strategy, an attempt whose function calls are invalid is replaced
+	// by a corrected one. Until that can no longer happen, all of an attempt's events are
+	// held back, so that the client never sees content from a discarded attempt.
+	mayReprompt := s.toolArgsStrategy == translator.ToolArgsReprompt && len(req.Tools) > 0
+
+	var prior types.AnthropicUsage // Usage of the discarded attempt
+	for reprompted := false; ; reprompted = true {
+		converter := translator.NewStreamConverter(s.responseModelName(req.Model, modelID))
+		converter.SetStopSequences(translator.StopSequences(req.StopSequences))
+		converter.SetDisableParallelToolUse(translator.ParallelToolUseDisabled(req.ToolChoice))
+		converter.SetToolUseIDEncoder(s.toolUseIDEncoder())
+		converter.SetToolNames(names)
+		converter.SetToolValidator(translator.NewToolValidator(req.Tools, s.toolArgsStrategy))
+		converter.SetCacheCreated(cacheCreated && !reprompted) // A retry reads the cache the first attempt created
+		converter.SetPriorUsage(prior)
+
+		var held []types.AnthropicStreamEvent
+		emit := send
+		if mayReprompt && !reprompted {
+			emit = func(events ...types.AnthropicStreamEvent) error {
+				held = append(held, events...)
+				return nil
+			}
+		}
+
+		// The model's turn, kept in case it has to be re-prompted
+		model := &types.GeminiContent{Role: types.RoleModel}
+
+		err = s.geminiHTTPClient.StreamGenerateContent(ctx, modelID, geminiReq, func(chunk *translator.GenerateContentResponse) error {
+			if len(chunk.Candidates) > 0 && chunk.Candidates[0].Content != nil {
+				model.Parts = append(model.Parts, chunk.Candidates[0].Content.Parts...)
+			}
+			events, convertErr := converter.AddChunk(chunk)
+			if err := emit(events...); err != nil {
+				return err
+			}
+			if convertErr != nil {
+				return convertErr
+			}
+			if converter.Stopped() {
+				// An emulated stop sequence was matched; stop reading from Gemini
+				return errStopSequence
+			}
+			return nil
+		})
+		if errors.Is(err, errStopSequence) {
+			err = nil
+		}
+
+		switch {
+		case err != nil:
+			err = fmt.Errorf("gemini API error: %w", err)
+		case !converter.Stopped():
+			// Function calls are held back until the whole turn has been checked
+			var events []types.AnthropicStreamEvent
+			events, err = converter.ReleaseToolUse()
+			var argsErr *translator.InvalidToolArgsError
+			if errors.As(err, &argsErr) && mayReprompt && !reprompted {
+				log.Printf("Re-prompting Gemini to correct function calls: %v", argsErr)
+				geminiReq.Contents = append(geminiReq.Contents, translator.RepromptContents(model, argsErr)...)
+				prior = converter.Response().Usage
+				continue
+			}
+			if err == nil {
+				err = emit(events...)
+			}
+		}
+		if err == nil {
+			err = send(held...)
+		}
+
+		// Cache thought signatures from any tool_use blocks already sent to the client,
+		// even if the stream was cut short
+		s.cacheThoughtSignatures(context.WithoutCancel(ctx), converter.Response())
+
+		if err != nil {
+			return nil, err
+		}
+
+		return converter, send(converter.Finish()...)
+	}
+}
+
+// errStopSequence aborts an upstream stream once a stop sequence is matched
+var errStopSequence = errors.New("stop sequence matched")
+
+// eventWriter writes Anthropic server-sent events to an http.ResponseWriter
+type eventWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	started bool
+}
+
+func newEventWriter(w http.ResponseWriter) *eventWriter {
+	return &eventWriter{
+		w:  w,
+		rc: http.NewResponseController(w),
+	}
+}
+
+// send writes the events and flushes them to the client. Response headers are written
+// lazily on the first call so that errors before the stream starts can still be
+// reported with a proper status code.
+func (e *eventWriter) send(events ...types.AnthropicStreamEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", "text/event-stream")
+		e.w.Header().Set("Cache-Control", "no-cache")
+		e.w.Header().Set("Connection", "keep-alive")
+		e.w.WriteHeader(http.StatusOK)
+	}
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s event: %w", event.Type, err)
+		}
+		if _, err := fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+			return fmt.Errorf("failed to write %s event: %w", event.Type, err)
+		}
+	}
+
+	if err := e.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return fmt.Errorf("failed to flush events: %w", err)
+	}
+	return nil
+}
+
+// sendError writes an error event to an already-started stream
+func (e *eventWriter) sendError(err error) {
+	_, body := translator.ToAnthropicError(err)
+	event := types.AnthropicStreamEvent{
+		Type:  types.EventError,
+		Error: &body,
+	}
+	if err := e.send(event); err != nil {
+		log.Printf("Failed to send error event: %v", err)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/translator"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// parseStreamEvents parses an Anthropic SSE response body into events
+func parseStreamEvents(t *testing.T, body string) []types.AnthropicStreamEvent {
+	t.Helper()
+
+	var events []types.AnthropicStreamEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	var eventName string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventName = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var event types.AnthropicStreamEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("failed to parse event data %q: %v", line, err)
+			}
+			if event.Type != eventName {
+				t.Errorf("event name %q does not match data type %q", eventName, event.Type)
+			}
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestHandleMessages_Stream(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			t.Errorf("expected streaming endpoint, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "Checking"}]}}], "usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 1}}`+"\n\n")
+		fmt.Fprint(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"location": "Paris"}}, "thoughtSignature": "sig-xyz"}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 5}}`+"\n\n")
+	})
+
+	body := `{
+		"model": "gemini-3-pro-preview",
+		"stream": true,
+		"max_tokens": 100,
+		"messages": [{"role": "user", "content": "What's the weather in Paris?"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected Content-Type text/event-stream, got %q", ct)
+	}
+
+	events := parseStreamEvents(t, w.Body.String())
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Type)
+	}
+	expected := []string{
+		types.EventMessageStart,
+		types.EventContentBlockStart,
+		types.EventContentBlockDelta,
+		types.EventContentBlockStop,
+		types.EventContentBlockStart,
+		types.EventContentBlockDelta,
+		types.EventContentBlockStop,
+		types.EventMessageDelta,
+		types.EventMessageStop,
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected events %v, got %v", expected, got)
+	}
+
+	if events[0].Message == nil || events[0].Message.Usage.InputTokens != 12 {
+		t.Errorf("expected message_start usage with 12 input tokens, got %+v", events[0].Message)
+	}
+	if events[7].Usage == nil || events[7].Usage.OutputTokens != 5 {
+		t.Errorf("expected message_delta usage with 5 output tokens, got %+v", events[7].Usage)
+	}
+
+	// The streamed tool_use ID should have its thought signature cached
+	toolBlock, ok := events[4].ContentBlock.(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected tool_use content block, got %T", events[4].ContentBlock)
+	}
+	toolID, _ := toolBlock["id"].(string)
+
+	sig, _, _ := srv.thoughtSignatures.Get(context.Background(), toolID)
+	if sig != "sig-xyz" {
+		t.Errorf("expected thought signature 'sig-xyz' to be cached for %s, got %q", toolID, sig)
+	}
+}
+
+func TestHandleMessages_StreamNativeStopSequence(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "alpha ST"}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": "OP1 beta"}]}, "finishReason": "STOP"}]}`+"\n\n")
+	})
+
+	body := `{
+		"model": "gemini-3-pro-preview",
+		"stream": true,
+		"max_tokens": 100,
+		"stop_sequences": ["STOP1"],
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var text strings.Builder
+	var delta *types.AnthropicDelta
+	for _, event := range parseStreamEvents(t, w.Body.String()) {
+		switch event.Type {
+		case types.EventContentBlockDelta:
+			text.WriteString(event.Delta.Text)
+		case types.EventMessageDelta:
+			delta = event.Delta
+		}
+	}
+	if text.String() != "alpha " {
+		t.Errorf("expected streamed text 'alpha ', got %q", text.String())
+	}
+	if delta == nil || delta.StopReason != types.StopReasonStopSequence || delta.StopSequence != "STOP1" {
+		t.Errorf("expected message_delta with stop_sequence STOP1, got %+v", delta)
+	}
+}
+
+func TestHandleMessages_StreamUpstreamError(t *testing.T) {
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"code": 500, "message": "internal"}}`, http.StatusInternalServerError)
+	})
+
+	body := `{"model": "gemini-2.0-flash", "stream": true, "messages": [{"role": "user", "content": "Hi"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	// Errors before the first event should be reported as a regular JSON error
+	if w.Code == http.StatusOK {
+		t.Fatalf("expected error status, got 200: %s", w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %q", ct)
+	}
+}
+
+func TestHandleMessages_StreamToolArgsReprompt(t *testing.T) {
+	calls := 0
+	srv := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		text, args := "Let me check.", `{}`
+		if calls > 1 {
+			text, args = "Checking Paris.", `{"location": "Paris"}`
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"text": %q}]}}]}`+"\n\n", text)
+		fmt.Fprintf(w, `data: {"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": %s}}]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5}}`+"\n\n", args)
+	})
+	srv.SetToolArgsStrategy(translator.ToolArgsReprompt)
+
+	body := `{
+		"model": "gemini-2.5-flash",
+		"stream": true,
+		"tools": [{"name": "get_weather", "input_schema": {
+			"type": "object",
+			"properties": {"location": {"type": "string"}},
+			"required": ["location"]
+		}}],
+		"messages": [{"role": "user", "content": "Weather?"}]
+	}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	srv.HandleMessages(w, req)
+
+	if calls != 2 {
+		t.Fatalf("expected one re-prompt, got %d calls", calls)
+	}
+
+	// Only the corrected turn reaches the client, but both attempts are billed
+	var texts, toolUses []string
+	messageStarts := 0
+	for _, event := range parseStreamEvents(t, w.Body.String()) {
+		switch event.Type {
+		case types.EventMessageStart:
+			messageStarts++
+		case types.EventContentBlockDelta:
+			if event.Delta.Type == types.DeltaTypeText {
+				texts = append(texts, event.Delta.Text)
+			}
+			if event.Delta.Type == types.DeltaTypeInputJSON {
+				toolUses = append(toolUses, event.Delta.PartialJSON)
+			}
+		case types.EventMessageDelta:
+			if event.Delta.StopReason != types.StopReasonToolUse {
+				t.Errorf("expected stop_reason tool_use, got %q", event.Delta.StopReason)
+			}
+			if event.Usage == nil || event.Usage.InputTokens != 20 || event.Usage.OutputTokens != 10 {
+				t.Errorf("expected the usage of both attempts, got %+v", event.Usage)
+			}
+		}
+	}
+	if messageStarts != 1 {
+		t.Errorf("expected one message_start, got %d", messageStarts)
+	}
+	if len(texts) != 1 || texts[0] != "Checking Paris." {
+		t.Errorf("expected only the corrected text, got %v", texts)
+	}
+	if len(toolUses) != 1 || toolUses[0] != `{"location":"Paris"}` {
+		t.Errorf("expected only the corrected tool_use, got %v", toolUses)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultCompactionInterval is how often the on-disk store removes expired signatures
+const DefaultCompactionInterval = 10 * time.Minute
+
+// boltBucket holds signatures keyed by tool_use ID
+var boltBucket = []byte("thought_signatures")
+
+// BoltStore is a Store backed by an embedded bbolt database file, so signatures survive
+// proxy restarts.
+//
+// Each value is an 8-byte big-endian expiry (Unix nanoseconds, 0 for none) followed by
+// the signature. Expired signatures are reported as missing on lookup and deleted by a
+// background compaction pass that runs every CompactionInterval; bbolt reuses the freed
+// pages for new signatures. Only Config.TTL applies; MaxEntries and MaxBytes are limits
+// of the in-memory store.
+type BoltStore struct {
+	db                 *bolt.DB
+	ttl                time.Duration
+	compactionInterval time.Duration
+	now                func() time.Time
+
+	hits        atomic.Int64
+	misses      atomic.Int64
+	expirations atomic.Int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewBoltStore opens (or creates) the database file at path and starts background
+// compaction. A compactionInterval of zero uses DefaultCompactionInterval.
+func NewBoltStore(path string, config Config, compactionInterval time.Duration) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open signature store %s: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize signature store %s: %w", path, err)
+	}
+
+	if compactionInterval <= 0 {
+		compactionInterval = DefaultCompactionInterval
+	}
+
+	s := &BoltStore{
+		db:                 db,
+		ttl:                config.TTL,
+		compactionInterval: compactionInterval,
+		now:                time.Now,
+		done:               make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.compactLoop()
+
+	return s, nil
+}
+
+// Get implements Store
+func (s *BoltStore) Get(_ context.Context, id string) (string, bool, error) {
+	var (
+		signature string
+		found     bool
+		expired   bool
+	)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBucket).Get([]byte(id))
+		if value == nil {
+			return nil
+		}
+		expiresAt, sig, err := decodeBoltValue(value)
+		if err != nil {
+			return err
+		}
+		if s.expired(expiresAt) {
+			expired = true
+			return nil
+		}
+		signature, found = sig, true
+		return nil
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read signature for %s: %w", id, err)
+	}
+
+	if expired {
+		// Leave the deletion to compaction so lookups stay read-only
+		s.expirations.Add(1)
+	}
+	if !found {
+		s.misses.Add(1)
+		return "", false, nil
+	}
+	s.hits.Add(1)
+	return signature, true, nil
+}
+
+// Set implements Store
+func (s *BoltStore) Set(_ context.Context, id, signature string) error {
+	var expiresAt int64
+	if s.ttl > 0 {
+		expiresAt = s.now().Add(s.ttl).UnixNano()
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(id), encodeBoltValue(expiresAt, signature))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write signature for %s: %w", id, err)
+	}
+	return nil
+}
+
+// Stats implements Store. Evictions are always zero as the store is bounded by TTL only.
+func (s *BoltStore) Stats() Stats {
+	stats := Stats{
+		Hits:        s.hits.Load(),
+		Misses:      s.misses.Load(),
+		Expirations: s.expirations.Load(),
+	}
+	_ = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			stats.Entries++
+			stats.Bytes += int64(len(k) + len(v) - 8)
+			return nil
+		})
+	})
+	return stats
+}
+
+// Close stops compaction and closes the database file
+func (s *BoltStore) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return s.db.Close()
+}
+
+// Compact deletes all expired (and corrupt) signatures and returns how many were removed
+func (s *BoltStore) Compact() (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+
+		// Collect keys first; deleting while iterating a cursor can skip entries
+		var expired [][]byte
+		if err := bucket.ForEach(func(k, v []byte) error {
+			if expiresAt, _, err := decodeBoltValue(v); err != nil || s.expired(expiresAt) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to compact signature store: %w", err)
+	}
+	return removed, nil
+}
+
+func (s *BoltStore) compactLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.Compact(); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+	}
+}
+
+func (s *BoltStore) expired(expiresAt int64) bool {
+	return expiresAt != 0 && s.now().UnixNano() >= expiresAt
+}
+
+func encodeBoltValue(expiresAt int64, signature string) []byte {
+	value := make([]byte, 8+len(signature))
+	binary.BigEndian.PutUint64(value, uint64(expiresAt))
+	copy(value[8:], signature)
+	return value
+}
+
+func decodeBoltValue(value []byte) (int64, string, error) {
+	if len(value) < 8 {
+		return 0, "", fmt.Errorf("corrupt signature entry (%d bytes)", len(value))
+	}
+	return int64(binary.BigEndian.Uint64(value)), string(value[8:]), nil
+}
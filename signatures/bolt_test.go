@@ -0,0 +1,116 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestBoltStore(t *testing.T, path string, config Config) *BoltStore {
+	t.Helper()
+
+	store, err := NewBoltStore(path, config, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open bolt store: %v", err)
+	}
+	return store
+}
+
+func TestBoltStore_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "signatures.db")
+
+	store := openTestBoltStore(t, path, DefaultConfig())
+	if err := store.Set(ctx, "tool_1", "sig-1"); err != nil {
+		t.Fatalf("failed to set signature: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("failed to close store: %v", err)
+	}
+
+	store = openTestBoltStore(t, path, DefaultConfig())
+	defer store.Close()
+
+	sig, ok, err := store.Get(ctx, "tool_1")
+	if err != nil {
+		t.Fatalf("failed to get signature: %v", err)
+	}
+	if !ok || sig != "sig-1" {
+		t.Errorf("expected sig-1 after reopening, got %q (ok=%t)", sig, ok)
+	}
+
+	if _, ok, _ := store.Get(ctx, "missing"); ok {
+		t.Error("expected miss for unknown ID")
+	}
+
+	stats := store.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.Bytes != int64(len("tool_1")+len("sig-1")) {
+		t.Errorf("expected %d bytes, got %d", len("tool_1")+len("sig-1"), stats.Bytes)
+	}
+}
+
+func TestBoltStore_TTLCompaction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	store := openTestBoltStore(t, filepath.Join(t.TempDir(), "signatures.db"), Config{TTL: time.Hour})
+	defer store.Close()
+	store.now = func() time.Time { return now }
+
+	store.Set(ctx, "old", "1")
+	now = now.Add(30 * time.Minute)
+	store.Set(ctx, "new", "2")
+
+	now = now.Add(45 * time.Minute)
+	if _, ok, _ := store.Get(ctx, "old"); ok {
+		t.Error("expected old to have expired")
+	}
+	if _, ok, _ := store.Get(ctx, "new"); !ok {
+		t.Error("expected new to still be live")
+	}
+
+	removed, err := store.Compact()
+	if err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 signature to be compacted, got %d", removed)
+	}
+	if stats := store.Stats(); stats.Entries != 1 || stats.Expirations != 1 {
+		t.Errorf("expected 1 entry and 1 expiration, got %+v", stats)
+	}
+}
+
+func TestBoltStore_NoTTL(t *testing.T) {
+	ctx := context.Background()
+
+	store := openTestBoltStore(t, filepath.Join(t.TempDir(), "signatures.db"), Config{})
+	defer store.Close()
+	store.now = func() time.Time { return time.Now().Add(100 * 365 * 24 * time.Hour) }
+
+	store.Set(ctx, "tool_1", "sig-1")
+	if removed, _ := store.Compact(); removed != 0 {
+		t.Errorf("expected nothing to be compacted, got %d", removed)
+	}
+	if _, ok, _ := store.Get(ctx, "tool_1"); !ok {
+		t.Error("expected signature without TTL to never expire")
+	}
+}
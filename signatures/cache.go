@@ -0,0 +1,210 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package signatures caches Gemini thought signatures by tool_use ID so they can be
+// restored when a client sends the tool call back on a later turn.
+package signatures
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Default cache limits
+const (
+	DefaultMaxEntries = 10000
+	DefaultMaxBytes   = 64 * 1024 * 1024
+	DefaultTTL        = 24 * time.Hour
+)
+
+// Config bounds the size and lifetime of cached signatures. A zero value for any limit
+// disables that limit.
+type Config struct {
+	MaxEntries int           // Maximum number of cached signatures
+	MaxBytes   int64         // Maximum total size of cached IDs and signatures
+	TTL        time.Duration // How long a signature is kept after it was stored
+}
+
+// DefaultConfig returns the limits used unless configured otherwise
+func DefaultConfig() Config {
+	return Config{
+		MaxEntries: DefaultMaxEntries,
+		MaxBytes:   DefaultMaxBytes,
+		TTL:        DefaultTTL,
+	}
+}
+
+// Stats reports cache activity since the cache was created
+type Stats struct {
+	Entries     int   // Current number of entries
+	Bytes       int64 // Current size of all entries
+	Hits        int64 // Lookups that found a live signature
+	Misses      int64 // Lookups that found nothing or an expired signature
+	Evictions   int64 // Entries removed to stay within MaxEntries or MaxBytes
+	Expirations int64 // Entries removed because their TTL elapsed
+	Fallbacks   int64 // Operations served locally because a shared store was unreachable
+}
+
+// Cache is a least-recently-used cache of thought signatures keyed by tool_use ID.
+//
+// A lookup moves the entry to the front of the LRU list, so unlike a plain map every
+// operation, reads included, takes the exclusive lock. This keeps eviction safe when
+// requests inject and cache signatures concurrently.
+type Cache struct {
+	config Config
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Front is most recently used
+	bytes   int64
+	stats   Stats
+}
+
+type entry struct {
+	id        string
+	signature string
+	expiresAt time.Time // Zero if the entry never expires
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.id) + len(e.signature))
+}
+
+// NewCache creates an empty cache with the given limits
+func NewCache(config Config) *Cache {
+	return &Cache{
+		config:  config,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Get returns the signature cached for the tool_use ID
+func (c *Cache) Get(id string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		c.stats.Misses++
+		return "", false
+	}
+
+	e := elem.Value.(*entry)
+	if c.expired(e) {
+		c.remove(elem)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return "", false
+	}
+
+	c.lru.MoveToFront(elem)
+	c.stats.Hits++
+	return e.signature, true
+}
+
+// Set caches the signature for the tool_use ID, evicting the least recently used entries
+// as needed to stay within the configured limits. A signature larger than MaxBytes on its
+// own is not cached.
+func (c *Cache) Set(id, signature string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+
+	e := &entry{id: id, signature: signature}
+	if c.config.MaxBytes > 0 && e.size() > c.config.MaxBytes {
+		return
+	}
+	if c.config.TTL > 0 {
+		e.expiresAt = c.now().Add(c.config.TTL)
+	}
+
+	c.entries[id] = c.lru.PushFront(e)
+	c.bytes += e.size()
+
+	c.removeExpired()
+	for c.overLimit() {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Delete removes the signature cached for the tool_use ID
+func (c *Cache) Delete(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+}
+
+// Len returns the number of cached signatures, including any that have expired but have
+// not yet been removed
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+func (c *Cache) expired(e *entry) bool {
+	return !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt)
+}
+
+func (c *Cache) overLimit() bool {
+	if c.lru.Len() == 0 {
+		return false
+	}
+	if c.config.MaxEntries > 0 && c.lru.Len() > c.config.MaxEntries {
+		return true
+	}
+	return c.config.MaxBytes > 0 && c.bytes > c.config.MaxBytes
+}
+
+// removeExpired drops expired entries from the back of the LRU list. Entries all share
+// the same TTL, so the least recently used entries are the most likely to have expired;
+// the scan stops at the first live entry.
+func (c *Cache) removeExpired() {
+	for elem := c.lru.Back(); elem != nil; elem = c.lru.Back() {
+		if !c.expired(elem.Value.(*entry)) {
+			return
+		}
+		c.remove(elem)
+		c.stats.Expirations++
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*entry)
+	delete(c.entries, e.id)
+	c.bytes -= e.size()
+}
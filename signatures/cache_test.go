@@ -0,0 +1,155 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache_GetSet(t *testing.T) {
+	c := NewCache(DefaultConfig())
+
+	if _, ok := c.Get("missing"); ok {
+		t.Error("expected miss for unknown ID")
+	}
+
+	c.Set("tool_1", "sig-1")
+	sig, ok := c.Get("tool_1")
+	if !ok || sig != "sig-1" {
+		t.Errorf("expected sig-1, got %q (ok=%t)", sig, ok)
+	}
+
+	c.Set("tool_1", "sig-2")
+	if sig, _ := c.Get("tool_1"); sig != "sig-2" {
+		t.Errorf("expected overwritten signature sig-2, got %q", sig)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss, got %+v", stats)
+	}
+	if stats.Entries != 1 || stats.Bytes != int64(len("tool_1")+len("sig-2")) {
+		t.Errorf("unexpected size stats: %+v", stats)
+	}
+
+	c.Delete("tool_1")
+	if _, ok := c.Get("tool_1"); ok {
+		t.Error("expected deleted signature to be gone")
+	}
+}
+
+func TestCache_MaxEntries(t *testing.T) {
+	c := NewCache(Config{MaxEntries: 2})
+
+	c.Set("a", "1")
+	c.Set("b", "2")
+	c.Get("a") // a is now more recently used than b
+	c.Set("c", "3")
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("expected least recently used entry b to be evicted")
+	}
+	for _, id := range []string{"a", "c"} {
+		if _, ok := c.Get(id); !ok {
+			t.Errorf("expected %s to be cached", id)
+		}
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("expected 1 eviction and 2 entries, got %+v", stats)
+	}
+}
+
+func TestCache_MaxBytes(t *testing.T) {
+	c := NewCache(Config{MaxBytes: 20})
+
+	c.Set("a", "123456789") // 10 bytes
+	c.Set("b", "123456789") // 20 bytes total
+	c.Set("c", "123456789") // evicts a
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("expected a to be evicted")
+	}
+	if stats := c.Stats(); stats.Bytes != 20 || stats.Evictions != 1 {
+		t.Errorf("expected 20 bytes and 1 eviction, got %+v", stats)
+	}
+
+	// A signature that can never fit is not cached and doesn't flush the cache
+	c.Set("huge", "this signature is far larger than the limit")
+	if _, ok := c.Get("huge"); ok {
+		t.Error("expected oversized signature to be skipped")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries to remain, got %d", c.Len())
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCache(Config{TTL: time.Hour})
+	c.now = func() time.Time { return now }
+
+	c.Set("old", "1")
+	now = now.Add(30 * time.Minute)
+	c.Set("new", "2")
+
+	if _, ok := c.Get("old"); !ok {
+		t.Error("expected old to be live before its TTL")
+	}
+
+	now = now.Add(45 * time.Minute)
+	if _, ok := c.Get("old"); ok {
+		t.Error("expected old to have expired")
+	}
+	if _, ok := c.Get("new"); !ok {
+		t.Error("expected new to still be live")
+	}
+
+	// Expired entries are swept when new entries are added
+	now = now.Add(time.Hour)
+	c.Set("newest", "3")
+	if c.Len() != 1 {
+		t.Errorf("expected only the newest entry to remain, got %d", c.Len())
+	}
+	if stats := c.Stats(); stats.Expirations != 2 || stats.Evictions != 0 {
+		t.Errorf("expected 2 expirations and no evictions, got %+v", stats)
+	}
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := NewCache(Config{MaxEntries: 50, MaxBytes: 1024, TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				id := fmt.Sprintf("tool_%d_%d", g, i%100)
+				c.Set(id, "signature")
+				c.Get(id)
+				c.Get(fmt.Sprintf("tool_%d_%d", (g+1)%8, i%100))
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	stats := c.Stats()
+	if stats.Entries > 50 || stats.Bytes > 1024 {
+		t.Errorf("cache exceeded its limits: %+v", stats)
+	}
+}
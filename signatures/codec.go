@@ -0,0 +1,138 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ToolUseIDPrefix marks tool_use IDs that carry an encoded thought signature
+const ToolUseIDPrefix = "toolu_sig_"
+
+// MinKeyLength is the minimum HMAC key length accepted by NewCodec
+const MinKeyLength = 16
+
+// ErrInvalidToolUseID is returned when a tool_use ID looks like it carries a signature
+// but fails authentication, e.g. because it was modified or signed with another key
+var ErrInvalidToolUseID = errors.New("invalid tool_use ID: signature authentication failed")
+
+const (
+	macSize   = 16
+	nonceSize = 8
+
+	// Signature encodings
+	encodingBase64 byte = 1 // Signature was standard base64; the decoded bytes are stored
+	encodingText   byte = 2 // Signature stored as deflated text
+
+	// maxSignatureSize bounds the decompressed size of an encoded signature
+	maxSignatureSize = 1024 * 1024
+)
+
+// Codec embeds thought signatures in tool_use IDs so they can be recovered from the ID
+// the client echoes back, without any state on the proxy.
+//
+// An encoded ID has the form:
+//
+//	toolu_sig_ + base64url(mac || encoding || nonce || payload)
+//
+// where payload is the signature (base64-decoded when possible, otherwise deflated) and
+// mac is a truncated HMAC-SHA256 over everything after it. The random nonce keeps IDs
+// unique when the same signature is encoded twice. Every replica must share the key.
+type Codec struct {
+	key []byte
+}
+
+// NewCodec creates a codec that authenticates IDs with the given HMAC key
+func NewCodec(key []byte) (*Codec, error) {
+	if len(key) < MinKeyLength {
+		return nil, fmt.Errorf("signature HMAC key must be at least %d bytes", MinKeyLength)
+	}
+	return &Codec{key: append([]byte(nil), key...)}, nil
+}
+
+// EncodeToolUseID returns a new tool_use ID carrying the signature
+func (c *Codec) EncodeToolUseID(signature string) string {
+	var body bytes.Buffer
+
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce) // Never returns an error
+
+	if raw, err := base64.StdEncoding.DecodeString(signature); err == nil && base64.StdEncoding.EncodeToString(raw) == signature {
+		body.WriteByte(encodingBase64)
+		body.Write(nonce)
+		body.Write(raw)
+	} else {
+		body.WriteByte(encodingText)
+		body.Write(nonce)
+		// Writes to a bytes.Buffer can't fail
+		w, _ := flate.NewWriter(&body, flate.BestCompression)
+		io.WriteString(w, signature)
+		w.Close()
+	}
+
+	encoded := append(c.mac(body.Bytes()), body.Bytes()...)
+	return ToolUseIDPrefix + base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// DecodeToolUseID recovers the signature from an ID created by EncodeToolUseID. IDs
+// without the ToolUseIDPrefix are reported as not carrying a signature; IDs that have
+// the prefix but fail authentication return ErrInvalidToolUseID.
+func (c *Codec) DecodeToolUseID(id string) (string, bool, error) {
+	encodedID, ok := strings.CutPrefix(id, ToolUseIDPrefix)
+	if !ok {
+		return "", false, nil
+	}
+
+	encoded, err := base64.RawURLEncoding.DecodeString(encodedID)
+	if err != nil || len(encoded) < macSize+1+nonceSize {
+		return "", false, ErrInvalidToolUseID
+	}
+
+	mac, body := encoded[:macSize], encoded[macSize:]
+	if !hmac.Equal(mac, c.mac(body)) {
+		return "", false, ErrInvalidToolUseID
+	}
+
+	encoding, payload := body[0], body[1+nonceSize:]
+	switch encoding {
+	case encodingBase64:
+		return base64.StdEncoding.EncodeToString(payload), true, nil
+	case encodingText:
+		r := flate.NewReader(bytes.NewReader(payload))
+		defer r.Close()
+		signature, err := io.ReadAll(io.LimitReader(r, maxSignatureSize))
+		if err != nil {
+			return "", false, fmt.Errorf("failed to decompress signature: %w", err)
+		}
+		return string(signature), true, nil
+	default:
+		return "", false, fmt.Errorf("invalid tool_use ID: unknown signature encoding %d", encoding)
+	}
+}
+
+func (c *Codec) mac(body []byte) []byte {
+	h := hmac.New(sha256.New, c.key)
+	h.Write(body)
+	return h.Sum(nil)[:macSize]
+}
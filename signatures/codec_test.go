@@ -0,0 +1,132 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newTestCodec(t *testing.T, key string) *Codec {
+	t.Helper()
+
+	codec, err := NewCodec([]byte(key))
+	if err != nil {
+		t.Fatalf("failed to create codec: %v", err)
+	}
+	return codec
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	codec := newTestCodec(t, "0123456789abcdef0123456789abcdef")
+
+	raw := make([]byte, 512)
+	rand.Read(raw)
+
+	tests := []struct {
+		name      string
+		signature string
+	}{
+		{"base64 signature", base64.StdEncoding.EncodeToString(raw)},
+		{"opaque signature", "not base64! " + strings.Repeat("abc", 100)},
+		{"empty signature", ""},
+	}
+
+	// Anthropic tool_use IDs are limited to this alphabet
+	validID := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := codec.EncodeToolUseID(tt.signature)
+			if !strings.HasPrefix(id, ToolUseIDPrefix) {
+				t.Errorf("expected prefix %q, got %q", ToolUseIDPrefix, id)
+			}
+			if !validID.MatchString(id) {
+				t.Errorf("ID contains characters outside [a-zA-Z0-9_-]: %q", id)
+			}
+
+			sig, ok, err := codec.DecodeToolUseID(id)
+			if err != nil || !ok {
+				t.Fatalf("failed to decode ID: ok=%t err=%v", ok, err)
+			}
+			if sig != tt.signature {
+				t.Errorf("expected signature %q, got %q", tt.signature, sig)
+			}
+		})
+	}
+}
+
+func TestCodec_UniqueIDs(t *testing.T) {
+	codec := newTestCodec(t, "0123456789abcdef")
+
+	if codec.EncodeToolUseID("c2ln") == codec.EncodeToolUseID("c2ln") {
+		t.Error("expected encoding the same signature twice to produce distinct IDs")
+	}
+}
+
+func TestCodec_RejectsTamperedIDs(t *testing.T) {
+	codec := newTestCodec(t, "0123456789abcdef")
+	id := codec.EncodeToolUseID(base64.StdEncoding.EncodeToString([]byte("signature bytes")))
+
+	// Flip a character in the payload
+	body := []byte(id)
+	i := len(body) - 3
+	if body[i] == 'A' {
+		body[i] = 'B'
+	} else {
+		body[i] = 'A'
+	}
+
+	other := newTestCodec(t, "fedcba9876543210")
+
+	tests := []struct {
+		name  string
+		codec *Codec
+		id    string
+	}{
+		{"modified payload", codec, string(body)},
+		{"truncated", codec, id[:len(ToolUseIDPrefix)+10]},
+		{"not base64", codec, ToolUseIDPrefix + "!!!"},
+		{"different key", other, id},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok, err := tt.codec.DecodeToolUseID(tt.id)
+			if ok || !errors.Is(err, ErrInvalidToolUseID) {
+				t.Errorf("expected ErrInvalidToolUseID, got ok=%t err=%v", ok, err)
+			}
+		})
+	}
+}
+
+func TestCodec_PlainIDs(t *testing.T) {
+	codec := newTestCodec(t, "0123456789abcdef")
+
+	sig, ok, err := codec.DecodeToolUseID("5f8a7d62-1c55-4b0e-9a5e-1234567890ab")
+	if sig != "" || ok || err != nil {
+		t.Errorf("expected plain IDs to carry no signature, got %q ok=%t err=%v", sig, ok, err)
+	}
+}
+
+func TestNewCodec_ShortKey(t *testing.T) {
+	if _, err := NewCodec([]byte("short")); err == nil {
+		t.Error("expected error for short key")
+	}
+}
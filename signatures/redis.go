@@ -0,0 +1,196 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis store defaults
+const (
+	DefaultRedisURL       = "redis://localhost:6379/0"
+	DefaultRedisKeyPrefix = "twin-in-disguise:signature:"
+
+	// redisOperationTimeout bounds each Redis call so an unreachable server falls back to
+	// local memory quickly instead of stalling the request
+	redisOperationTimeout = time.Second
+
+	// redisRetryBackoff is how long the store uses local memory alone after a Redis call
+	// fails, before a single call probes Redis again
+	redisRetryBackoff = 5 * time.Second
+)
+
+// RedisStore is a Store backed by a Redis-protocol server, shared by every proxy
+// instance that points at it.
+//
+// Each signature is a plain string key with a Redis TTL of Config.TTL. Connections are
+// pooled by the client; pool size and timeouts can be tuned with the usual URL query
+// parameters (e.g. redis://host:6379/0?pool_size=20&dial_timeout=2s).
+//
+// When Redis is unreachable, signatures are written to and read from a local MemoryStore
+// instead, so a single instance keeps working while the shared store is down. Lookups that
+// miss in Redis also consult the local store, which covers signatures written during an
+// outage. After a failed call, Redis isn't tried again for redisRetryBackoff, so that an
+// unresponsive server doesn't cost every request the full operation timeout.
+type RedisStore struct {
+	client   *redis.Client
+	prefix   string
+	ttl      time.Duration
+	fallback *MemoryStore
+	now      func() time.Time
+
+	retryAt     atomic.Int64 // UnixNano before which Redis isn't called after a failure; 0 while it is healthy
+	hits        atomic.Int64
+	misses      atomic.Int64
+	fallbacks   atomic.Int64
+	unavailable atomic.Bool // Whether the last Redis call failed; used to log transitions once
+}
+
+// NewRedisStore connects to the Redis server at url. An unreachable server is not an
+// error; the store starts in fallback mode and switches back once Redis responds.
+func NewRedisStore(url string, config Config) (*RedisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return newRedisStore(options, config), nil
+}
+
+// newRedisStore creates a store using a Redis client with options
+func newRedisStore(options *redis.Options, config Config) *RedisStore {
+	s := &RedisStore{
+		client:   redis.NewClient(options),
+		prefix:   DefaultRedisKeyPrefix,
+		ttl:      config.TTL,
+		fallback: NewMemoryStore(config),
+		now:      time.Now,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisOperationTimeout)
+	defer cancel()
+	s.observe(s.client.Ping(ctx).Err())
+
+	return s
+}
+
+// Get implements Store
+func (s *RedisStore) Get(ctx context.Context, id string) (string, bool, error) {
+	if s.available() {
+		opCtx, cancel := context.WithTimeout(ctx, redisOperationTimeout)
+		defer cancel()
+
+		signature, err := s.client.Get(opCtx, s.prefix+id).Result()
+		switch {
+		case ctx.Err() != nil:
+			// The caller gave up; that says nothing about Redis
+			return "", false, ctx.Err()
+		case err == nil:
+			s.observe(nil)
+			s.hits.Add(1)
+			return signature, true, nil
+		case errors.Is(err, redis.Nil):
+			s.observe(nil)
+		default:
+			s.observe(err)
+			s.fallbacks.Add(1)
+		}
+	} else {
+		s.fallbacks.Add(1)
+	}
+
+	// Not in Redis (or Redis is down); the signature may have been stored locally
+	signature, ok, _ := s.fallback.Get(ctx, id)
+	if ok {
+		s.hits.Add(1)
+		return signature, true, nil
+	}
+	s.misses.Add(1)
+	return "", false, nil
+}
+
+// Set implements Store
+func (s *RedisStore) Set(ctx context.Context, id, signature string) error {
+	if s.available() {
+		opCtx, cancel := context.WithTimeout(ctx, redisOperationTimeout)
+		defer cancel()
+
+		err := s.client.Set(opCtx, s.prefix+id, signature, s.ttl).Err()
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !s.observe(err) {
+			return nil
+		}
+	}
+
+	s.fallbacks.Add(1)
+	return s.fallback.Set(ctx, id, signature)
+}
+
+// Stats implements Store. Entries, Bytes, Evictions and Expirations describe the local
+// fallback; Redis manages its own keys.
+func (s *RedisStore) Stats() Stats {
+	stats := s.fallback.Stats()
+	stats.Hits = s.hits.Load()
+	stats.Misses = s.misses.Load()
+	stats.Fallbacks = s.fallbacks.Load()
+	return stats
+}
+
+// Close closes the connection pool
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+// available reports whether Redis should be called. After a failure it isn't until the
+// back-off has passed, and then only by a single caller, which probes whether Redis has
+// recovered while the others keep using local memory.
+func (s *RedisStore) available() bool {
+	retryAt := s.retryAt.Load()
+	if retryAt == 0 {
+		return true
+	}
+	now := s.now()
+	if now.UnixNano() < retryAt {
+		return false
+	}
+	return s.retryAt.CompareAndSwap(retryAt, now.Add(redisRetryBackoff).UnixNano())
+}
+
+// observe records the outcome of a Redis call, logging when Redis becomes unreachable or
+// recovers. It reports whether the call failed.
+func (s *RedisStore) observe(err error) bool {
+	if err == nil {
+		s.retryAt.Store(0)
+		if s.unavailable.Swap(false) {
+			log.Printf("Redis signature store is reachable again")
+		}
+		return false
+	}
+
+	s.retryAt.Store(s.now().Add(redisRetryBackoff).UnixNano())
+
+	if !s.unavailable.Swap(true) {
+		log.Printf("Warning: Redis signature store unavailable, falling back to local memory: %v", err)
+	}
+	return true
+}
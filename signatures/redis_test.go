@@ -0,0 +1,200 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func openTestRedisStore(t *testing.T, mr *miniredis.Miniredis, config Config) *RedisStore {
+	t.Helper()
+
+	store, err := NewRedisStore("redis://"+mr.Addr()+"/0", config)
+	if err != nil {
+		t.Fatalf("failed to create Redis store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestRedisStore_SharedAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+
+	first := openTestRedisStore(t, mr, DefaultConfig())
+	second := openTestRedisStore(t, mr, DefaultConfig())
+
+	if err := first.Set(ctx, "tool_1", "sig-1"); err != nil {
+		t.Fatalf("failed to set signature: %v", err)
+	}
+
+	sig, ok, err := second.Get(ctx, "tool_1")
+	if err != nil || !ok || sig != "sig-1" {
+		t.Fatalf("expected second instance to see sig-1, got %q (ok=%t, err=%v)", sig, ok, err)
+	}
+	if _, ok, _ := second.Get(ctx, "missing"); ok {
+		t.Error("expected miss for unknown ID")
+	}
+
+	if got, _ := mr.Get(DefaultRedisKeyPrefix + "tool_1"); got != "sig-1" {
+		t.Errorf("expected signature under prefixed key, got %q", got)
+	}
+
+	stats := second.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Fallbacks != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestRedisStore_TTL(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	store := openTestRedisStore(t, mr, Config{TTL: time.Hour})
+
+	store.Set(ctx, "tool_1", "sig-1")
+	if ttl := mr.TTL(DefaultRedisKeyPrefix + "tool_1"); ttl != time.Hour {
+		t.Errorf("expected key TTL of 1h, got %s", ttl)
+	}
+
+	mr.FastForward(2 * time.Hour)
+	if _, ok, _ := store.Get(ctx, "tool_1"); ok {
+		t.Error("expected signature to expire with its key")
+	}
+}
+
+func TestRedisStore_FallbackToMemory(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	store := openTestRedisStore(t, mr, DefaultConfig())
+
+	store.Set(ctx, "before", "sig-before")
+
+	// Redis goes away: writes and reads are served locally
+	mr.Close()
+	if err := store.Set(ctx, "during", "sig-during"); err != nil {
+		t.Fatalf("expected fallback write to succeed, got %v", err)
+	}
+	sig, ok, err := store.Get(ctx, "during")
+	if err != nil || !ok || sig != "sig-during" {
+		t.Errorf("expected sig-during from local fallback, got %q (ok=%t, err=%v)", sig, ok, err)
+	}
+	if _, ok, _ := store.Get(ctx, "before"); ok {
+		t.Error("expected signatures that only exist in Redis to be unavailable")
+	}
+	if stats := store.Stats(); stats.Fallbacks != 3 || stats.Entries != 1 {
+		t.Errorf("expected 3 fallbacks and 1 local entry, got %+v", stats)
+	}
+
+	// Redis comes back: signatures written during the outage are still found locally
+	if err := mr.Restart(); err != nil {
+		t.Fatalf("failed to restart miniredis: %v", err)
+	}
+	// After repeated dial failures the connection pool only retries dialing in the
+	// background, so give it a moment to reconnect
+	deadline := time.Now().Add(5 * time.Second)
+	for store.client.Ping(ctx).Err() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Redis client did not reconnect")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	store.now = func() time.Time { return time.Now().Add(redisRetryBackoff) }
+	if sig, ok, _ := store.Get(ctx, "during"); !ok || sig != "sig-during" {
+		t.Errorf("expected sig-during after recovery, got %q (ok=%t)", sig, ok)
+	}
+	store.Set(ctx, "after", "sig-after")
+	if got, _ := mr.Get(DefaultRedisKeyPrefix + "after"); got != "sig-after" {
+		t.Errorf("expected writes to go to Redis after recovery, got %q", got)
+	}
+}
+
+func TestRedisStore_Unreachable(t *testing.T) {
+	ctx := context.Background()
+
+	// Nothing listens on this port; the store should still be usable
+	store, err := NewRedisStore("redis://127.0.0.1:1/0?dial_timeout=100ms", DefaultConfig())
+	if err != nil {
+		t.Fatalf("expected unreachable Redis not to be an error, got %v", err)
+	}
+	defer store.Close()
+
+	store.Set(ctx, "tool_1", "sig-1")
+	if sig, ok, _ := store.Get(ctx, "tool_1"); !ok || sig != "sig-1" {
+		t.Errorf("expected local fallback to serve sig-1, got %q (ok=%t)", sig, ok)
+	}
+}
+
+func TestRedisStore_Unresponsive(t *testing.T) {
+	ctx := context.Background()
+
+	// Connections never complete, as when packets to Redis are dropped
+	var dials atomic.Int32
+	release := make(chan struct{})
+	defer close(release)
+	store := newRedisStore(&redis.Options{
+		Addr: "redis.invalid:6379",
+		Dialer: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dials.Add(1)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-release:
+				return nil, errors.New("closed")
+			}
+		},
+		MaxRetries: -1,
+	}, DefaultConfig())
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	// The failed ping at startup opens the circuit: calls skip Redis entirely
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		store.Set(ctx, "tool_1", "sig-1")
+		if sig, ok, _ := store.Get(ctx, "tool_1"); !ok || sig != "sig-1" {
+			t.Fatalf("expected local fallback to serve sig-1, got %q (ok=%t)", sig, ok)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > redisOperationTimeout/2 {
+		t.Errorf("expected calls to skip unresponsive Redis, took %s", elapsed)
+	}
+	attempts := dials.Load()
+
+	// Once the back-off has passed, a single call probes Redis and the circuit opens again
+	now = now.Add(redisRetryBackoff)
+	store.Get(ctx, "tool_1")
+	store.Get(ctx, "tool_1")
+	if dials.Load() == attempts {
+		t.Error("expected Redis to be probed after the back-off")
+	}
+	if stats := store.Stats(); stats.Fallbacks != 22 {
+		t.Errorf("expected every call to fall back, got %+v", stats)
+	}
+}
+
+func TestNewRedisStore_InvalidURL(t *testing.T) {
+	if _, err := NewRedisStore("not-a-url://", DefaultConfig()); err == nil {
+		t.Error("expected error for invalid URL")
+	}
+}
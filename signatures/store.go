@@ -0,0 +1,77 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"context"
+)
+
+// Store kinds accepted by the --signature-store flag
+const (
+	StoreMemory    = "memory"
+	StoreBolt      = "bolt"
+	StoreRedis     = "redis"
+	StoreStateless = "stateless" // Signatures are carried in tool_use IDs; see Codec
+)
+
+// Store persists thought signatures by tool_use ID. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Get returns the signature stored for the tool_use ID. Expired signatures are
+	// reported as missing.
+	Get(ctx context.Context, id string) (string, bool, error)
+
+	// Set stores the signature for the tool_use ID
+	Set(ctx context.Context, id, signature string) error
+
+	// Stats returns a snapshot of the store counters
+	Stats() Stats
+
+	// Close releases any resources held by the store
+	Close() error
+}
+
+// MemoryStore is a Store backed by an in-memory Cache. Signatures are lost when the
+// proxy restarts.
+type MemoryStore struct {
+	cache *Cache
+}
+
+// NewMemoryStore creates an in-memory store with the given limits
+func NewMemoryStore(config Config) *MemoryStore {
+	return &MemoryStore{cache: NewCache(config)}
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(_ context.Context, id string) (string, bool, error) {
+	sig, ok := s.cache.Get(id)
+	return sig, ok, nil
+}
+
+// Set implements Store
+func (s *MemoryStore) Set(_ context.Context, id, signature string) error {
+	s.cache.Set(id, signature)
+	return nil
+}
+
+// Stats implements Store
+func (s *MemoryStore) Stats() Stats {
+	return s.cache.Stats()
+}
+
+// Close implements Store
+func (s *MemoryStore) Close() error {
+	return nil
+}
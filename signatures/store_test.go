@@ -0,0 +1,39 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package signatures
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	var store Store = NewMemoryStore(Config{MaxEntries: 1})
+	defer store.Close()
+
+	store.Set(ctx, "a", "1")
+	store.Set(ctx, "b", "2")
+
+	if _, ok, _ := store.Get(ctx, "a"); ok {
+		t.Error("expected a to be evicted")
+	}
+	if sig, ok, err := store.Get(ctx, "b"); err != nil || !ok || sig != "2" {
+		t.Errorf("expected b=2, got %q (ok=%t, err=%v)", sig, ok, err)
+	}
+	if stats := store.Stats(); stats.Evictions != 1 {
+		t.Errorf("expected 1 eviction, got %+v", stats)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+	"google.golang.org/api/googleapi"
+)
+
+// StatusOverloaded is the non-standard status code Anthropic uses for overloaded_error
+const StatusOverloaded = 529
+
+// errorReasonAPIKeyInvalid is the ErrorInfo reason Gemini reports, alongside a 400 status,
+// when the API key is missing or invalid
+const errorReasonAPIKeyInvalid = "API_KEY_INVALID"
+
+// APIError is a structured error returned by the Gemini REST API
+type APIError struct {
+	StatusCode int               // HTTP status code
+	Status     string            // Canonical status, e.g. RESOURCE_EXHAUSTED
+	Message    string            // Human readable message
+	Reason     string            // ErrorInfo reason, if any, e.g. API_KEY_INVALID
+	Details    []json.RawMessage // Raw error details (RetryInfo, ErrorInfo, ...)
+	Header     http.Header       // Response headers
+	Body       string            // Raw response body
+}
+
+func (e *APIError) Error() string {
+	status := e.Status
+	if status == "" {
+		status = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s (status %d): %s", status, e.StatusCode, e.Message)
+}
+
+// ClientError is an error caused by the client's request rather than by Gemini. It is
+// reported to the client with the given Anthropic status code and error type.
+type ClientError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *ClientError) Error() string {
+	return e.Message
+}
+
+// NewInvalidRequestError returns a ClientError reported as a 400 invalid_request_error
+func NewInvalidRequestError(format string, args ...interface{}) *ClientError {
+	return &ClientError{
+		StatusCode: http.StatusBadRequest,
+		Type:       types.ErrorTypeInvalidRequest,
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
+
+// newAPIError parses a Gemini error response of the form:
+//
+//	{"error": {"code": 429, "message": "...", "status": "RESOURCE_EXHAUSTED", "details": [...]}}
+//
+// If the body can't be parsed, the raw body is used as the message.
+func newAPIError(httpResp *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: httpResp.StatusCode,
+		Header:     httpResp.Header,
+		Body:       string(body),
+	}
+
+	var envelope struct {
+		Error struct {
+			Message string            `json:"message"`
+			Status  string            `json:"status"`
+			Details []json.RawMessage `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if apiErr.Message == "" {
+			apiErr.Message = httpResp.Status
+		}
+		return apiErr
+	}
+
+	apiErr.Message = envelope.Error.Message
+	apiErr.Status = envelope.Error.Status
+	apiErr.Details = envelope.Error.Details
+	for _, detail := range envelope.Error.Details {
+		var info struct {
+			Reason string `json:"reason"`
+		}
+		if json.Unmarshal(detail, &info) == nil && info.Reason != "" {
+			apiErr.Reason = info.Reason
+			break
+		}
+	}
+	return apiErr
+}
+
+// ToAnthropicError maps an error from either Gemini client onto an Anthropic HTTP status
+// code and error body. Gemini HTTP statuses are mapped as follows:
+//
+//	400 -> 400 invalid_request_error (401 authentication_error for an invalid API key)
+//	401 -> 401 authentication_error
+//	403 -> 403 permission_error
+//	404 -> 404 not_found_error
+//	413 -> 413 request_too_large
+//	429 -> 429 rate_limit_error
+//	503 -> 529 overloaded_error
+//	other 4xx -> 400 invalid_request_error
+//	anything else -> 500 api_error
+//
+// A ClientError keeps its own status code and type. For Gemini API errors the message is
+// Gemini's own message; otherwise it is the error text.
+func ToAnthropicError(err error) (int, types.AnthropicErrorBody) {
+	var clientErr *ClientError
+	if errors.As(err, &clientErr) {
+		return clientErr.StatusCode, types.AnthropicErrorBody{Type: clientErr.Type, Message: clientErr.Message}
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Reason == errorReasonAPIKeyInvalid {
+			return http.StatusUnauthorized, types.AnthropicErrorBody{
+				Type:    types.ErrorTypeAuthentication,
+				Message: apiErr.Message,
+			}
+		}
+		status, errorType := anthropicErrorForStatus(apiErr.StatusCode)
+		return status, types.AnthropicErrorBody{Type: errorType, Message: apiErr.Message}
+	}
+
+	// The genai SDK reports REST failures as googleapi errors
+	var sdkErr *googleapi.Error
+	if errors.As(err, &sdkErr) {
+		status, errorType := anthropicErrorForStatus(sdkErr.Code)
+		message := sdkErr.Message
+		if message == "" {
+			message = err.Error()
+		}
+		return status, types.AnthropicErrorBody{Type: errorType, Message: message}
+	}
+
+	return http.StatusInternalServerError, types.AnthropicErrorBody{
+		Type:    types.ErrorTypeAPI,
+		Message: err.Error(),
+	}
+}
+
+// anthropicErrorForStatus maps a Gemini HTTP status code onto an Anthropic status code
+// and error type
+func anthropicErrorForStatus(statusCode int) (int, string) {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return http.StatusBadRequest, types.ErrorTypeInvalidRequest
+	case http.StatusUnauthorized:
+		return http.StatusUnauthorized, types.ErrorTypeAuthentication
+	case http.StatusForbidden:
+		return http.StatusForbidden, types.ErrorTypePermission
+	case http.StatusNotFound:
+		return http.StatusNotFound, types.ErrorTypeNotFound
+	case http.StatusRequestEntityTooLarge:
+		return http.StatusRequestEntityTooLarge, types.ErrorTypeRequestTooLarge
+	case http.StatusTooManyRequests:
+		return http.StatusTooManyRequests, types.ErrorTypeRateLimit
+	case http.StatusServiceUnavailable:
+		return StatusOverloaded, types.ErrorTypeOverloaded
+	}
+	if statusCode >= 400 && statusCode < 500 {
+		return http.StatusBadRequest, types.ErrorTypeInvalidRequest
+	}
+	return http.StatusInternalServerError, types.ErrorTypeAPI
+}
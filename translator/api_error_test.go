@@ -0,0 +1,175 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/types"
+	"google.golang.org/api/googleapi"
+)
+
+func TestGeminiHTTPClient_GenerateContent_APIError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"code": 429, "message": "Quota exceeded", "status": "RESOURCE_EXHAUSTED", "details": [
+			{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "7s"}
+		]}}`)
+	}))
+	defer ts.Close()
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	_, err := client.GenerateContent(context.Background(), "gemini-2.0-flash", &GenerateContentRequest{})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status code 429, got %d", apiErr.StatusCode)
+	}
+	if apiErr.Status != "RESOURCE_EXHAUSTED" {
+		t.Errorf("expected status RESOURCE_EXHAUSTED, got %q", apiErr.Status)
+	}
+	if apiErr.Message != "Quota exceeded" {
+		t.Errorf("expected message 'Quota exceeded', got %q", apiErr.Message)
+	}
+	if len(apiErr.Details) != 1 {
+		t.Errorf("expected 1 detail, got %d", len(apiErr.Details))
+	}
+}
+
+func TestNewAPIError_UnparseableBody(t *testing.T) {
+	apiErr := newAPIError(&http.Response{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}, []byte("upstream connect error\n"))
+
+	if apiErr.Message != "upstream connect error" {
+		t.Errorf("expected raw body as message, got %q", apiErr.Message)
+	}
+	if apiErr.Error() != "Bad Gateway (status 502): upstream connect error" {
+		t.Errorf("unexpected error text: %q", apiErr.Error())
+	}
+}
+
+func TestToAnthropicError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectStatus int
+		expectType   string
+		expectMsg    string
+	}{
+		{
+			name:         "invalid argument",
+			err:          &APIError{StatusCode: 400, Status: "INVALID_ARGUMENT", Message: "bad schema"},
+			expectStatus: http.StatusBadRequest,
+			expectType:   types.ErrorTypeInvalidRequest,
+			expectMsg:    "bad schema",
+		},
+		{
+			name:         "invalid api key",
+			err:          &APIError{StatusCode: 400, Status: "INVALID_ARGUMENT", Message: "API key not valid", Reason: "API_KEY_INVALID"},
+			expectStatus: http.StatusUnauthorized,
+			expectType:   types.ErrorTypeAuthentication,
+			expectMsg:    "API key not valid",
+		},
+		{
+			name:         "unauthenticated",
+			err:          &APIError{StatusCode: 401, Message: "unauthenticated"},
+			expectStatus: http.StatusUnauthorized,
+			expectType:   types.ErrorTypeAuthentication,
+			expectMsg:    "unauthenticated",
+		},
+		{
+			name:         "permission denied",
+			err:          &APIError{StatusCode: 403, Message: "denied"},
+			expectStatus: http.StatusForbidden,
+			expectType:   types.ErrorTypePermission,
+			expectMsg:    "denied",
+		},
+		{
+			name:         "not found",
+			err:          fmt.Errorf("gemini API error: %w", &APIError{StatusCode: 404, Message: "no such model"}),
+			expectStatus: http.StatusNotFound,
+			expectType:   types.ErrorTypeNotFound,
+			expectMsg:    "no such model",
+		},
+		{
+			name:         "rate limited",
+			err:          &APIError{StatusCode: 429, Message: "quota"},
+			expectStatus: http.StatusTooManyRequests,
+			expectType:   types.ErrorTypeRateLimit,
+			expectMsg:    "quota",
+		},
+		{
+			name:         "overloaded",
+			err:          &APIError{StatusCode: 503, Message: "The model is overloaded"},
+			expectStatus: StatusOverloaded,
+			expectType:   types.ErrorTypeOverloaded,
+			expectMsg:    "The model is overloaded",
+		},
+		{
+			name:         "internal",
+			err:          &APIError{StatusCode: 500, Message: "internal"},
+			expectStatus: http.StatusInternalServerError,
+			expectType:   types.ErrorTypeAPI,
+			expectMsg:    "internal",
+		},
+		{
+			name:         "other client error",
+			err:          &APIError{StatusCode: 409, Message: "conflict"},
+			expectStatus: http.StatusBadRequest,
+			expectType:   types.ErrorTypeInvalidRequest,
+			expectMsg:    "conflict",
+		},
+		{
+			name:         "sdk error",
+			err:          fmt.Errorf("gemini API error: %w", &googleapi.Error{Code: 429, Message: "quota"}),
+			expectStatus: http.StatusTooManyRequests,
+			expectType:   types.ErrorTypeRateLimit,
+			expectMsg:    "quota",
+		},
+		{
+			name:         "other error",
+			err:          errors.New("failed to convert response"),
+			expectStatus: http.StatusInternalServerError,
+			expectType:   types.ErrorTypeAPI,
+			expectMsg:    "failed to convert response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := ToAnthropicError(tt.err)
+			if status != tt.expectStatus {
+				t.Errorf("expected status %d, got %d", tt.expectStatus, status)
+			}
+			if body.Type != tt.expectType {
+				t.Errorf("expected type %q, got %q", tt.expectType, body.Type)
+			}
+			if body.Message != tt.expectMsg {
+				t.Errorf("expected message %q, got %q", tt.expectMsg, body.Message)
+			}
+		})
+	}
+}
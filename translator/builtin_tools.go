@@ -0,0 +1,249 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// builtinTool is the definition of one of Anthropic's built-in client tools. Claude knows
+// these tools from training, so the request only names them; Gemini needs the full
+// declaration.
+type builtinTool struct {
+	name        string // Name the client expects in tool_use blocks
+	description string
+	schema      map[string]interface{} // JSON Schema of the tool's input; shared, so read-only
+}
+
+// mustParseToolSchema parses the JSON Schema of a built-in tool, panicking at startup if
+// it is invalid
+func mustParseToolSchema(schema string) map[string]interface{} {
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &parsed); err != nil {
+		panic(fmt.Sprintf("invalid built-in tool schema: %v", err))
+	}
+	return parsed
+}
+
+var bashSchema = mustParseToolSchema(`{
+	"type": "object",
+	"properties": {
+		"command": {
+			"type": "string",
+			"description": "The bash command to run. Required unless the tool is being restarted."
+		},
+		"restart": {
+			"type": "boolean",
+			"description": "Specifying true will restart this tool. Otherwise, leave this unspecified."
+		}
+	}
+}`)
+
+var bashTool = builtinTool{
+	name: "bash",
+	description: "Run commands in a bash shell.\n" +
+		"* When invoking this tool, the contents of the \"command\" parameter does NOT need to be XML-escaped.\n" +
+		"* The shell session is persistent: environment variables, the working directory and files created persist between calls.\n" +
+		"* Avoid commands that produce a very large amount of output, and run long-lived commands in the background.\n" +
+		"* Set \"restart\" to true to start a fresh shell session if the current one becomes unresponsive.",
+	schema: bashSchema,
+}
+
+// textEditorSchema returns the input schema of a text editor tool version
+func textEditorSchema(commands ...string) map[string]interface{} {
+	quoted := make([]string, len(commands))
+	for i, command := range commands {
+		quoted[i] = fmt.Sprintf("%q", command)
+	}
+	return mustParseToolSchema(`{
+	"type": "object",
+	"properties": {
+		"command": {
+			"type": "string",
+			"enum": [` + strings.Join(quoted, ", ") + `],
+			"description": "The command to run. Allowed options are: ` + strings.Join(commands, ", ") + `."
+		},
+		"path": {
+			"type": "string",
+			"description": "Absolute path to file or directory, e.g. /repo/file.py or /repo."
+		},
+		"file_text": {
+			"type": "string",
+			"description": "Required parameter of the create command, with the content of the file to be created."
+		},
+		"old_str": {
+			"type": "string",
+			"description": "Required parameter of the str_replace command containing the string in path to replace. It must match exactly one location in the file."
+		},
+		"new_str": {
+			"type": "string",
+			"description": "Optional parameter of the str_replace command containing the new string (if not given, no string will be added). Required parameter of the insert command containing the string to insert."
+		},
+		"insert_line": {
+			"type": "integer",
+			"description": "Required parameter of the insert command. The new_str will be inserted AFTER the line insert_line of path."
+		},
+		"view_range": {
+			"type": "array",
+			"items": {"type": "integer"},
+			"description": "Optional parameter of the view command when path points to a file. If none is given, the full file is shown. If provided, the file will be shown in the indicated line number range, e.g. [11, 12] will show lines 11 and 12. Indexing at 1 to start. Setting [start_line, -1] shows all lines from start_line to the end of the file."
+		}
+	},
+	"required": ["command", "path"]
+}`)
+}
+
+const textEditorDescription = "Custom editing tool for viewing, creating and editing files.\n" +
+	"* If path is a file, view displays the result of applying cat -n. If path is a directory, view lists non-hidden files and directories up to 2 levels deep.\n" +
+	"* The create command cannot be used if the specified path already exists as a file.\n" +
+	"* Long outputs will be truncated.\n" +
+	"* The old_str parameter of str_replace should match EXACTLY one or more consecutive lines from the original file. Be mindful of whitespace.\n" +
+	"* If old_str is not unique in the file, the replacement will not be performed. Include enough context in old_str to make it unique."
+
+var (
+	// Claude 3.x text editors support undo_edit
+	legacyTextEditorTool = builtinTool{
+		name:        "str_replace_editor",
+		description: textEditorDescription + "\n* The undo_edit command reverts the last edit made to the file at path.",
+		schema:      textEditorSchema("view", "create", "str_replace", "insert", "undo_edit"),
+	}
+	textEditorTool = builtinTool{
+		name:        "str_replace_based_edit_tool",
+		description: textEditorDescription,
+		schema:      textEditorSchema("view", "create", "str_replace", "insert"),
+	}
+)
+
+// computerSchema returns the input schema of a computer use tool version
+func computerSchema(actions ...string) map[string]interface{} {
+	quoted := make([]string, len(actions))
+	for i, action := range actions {
+		quoted[i] = fmt.Sprintf("%q", action)
+	}
+	return mustParseToolSchema(`{
+	"type": "object",
+	"properties": {
+		"action": {
+			"type": "string",
+			"enum": [` + strings.Join(quoted, ", ") + `],
+			"description": "The action to perform."
+		},
+		"coordinate": {
+			"type": "array",
+			"items": {"type": "integer"},
+			"description": "(x, y): The x (pixels from the left edge) and y (pixels from the top edge) coordinates to move the mouse to or click at."
+		},
+		"start_coordinate": {
+			"type": "array",
+			"items": {"type": "integer"},
+			"description": "(x, y): The coordinates to start a left_click_drag from."
+		},
+		"text": {
+			"type": "string",
+			"description": "Required for the type and key actions: the text to type or the key combination to press (xdotool syntax, e.g. \"ctrl+s\"). Optional for click and scroll actions: keys to hold down."
+		},
+		"scroll_direction": {
+			"type": "string",
+			"enum": ["up", "down", "left", "right"],
+			"description": "The direction to scroll the screen for the scroll action."
+		},
+		"scroll_amount": {
+			"type": "integer",
+			"description": "The number of mouse wheel clicks to scroll for the scroll action."
+		},
+		"duration": {
+			"type": "number",
+			"description": "The duration in seconds to hold a key down for hold_key, or to wait for wait."
+		}
+	},
+	"required": ["action"]
+}`)
+}
+
+const computerDescription = "Use a mouse and keyboard to interact with a computer, and take screenshots.\n" +
+	"* This is an interface to a desktop GUI. You do not have access to a terminal or applications menu; click on desktop icons to start applications.\n" +
+	"* Some applications may take time to start or process actions, so you may need to wait and take successive screenshots to see the results of your actions.\n" +
+	"* Whenever you intend to move the cursor to click on an element, consult a screenshot to determine the coordinates of the element first.\n" +
+	"* Make sure to click buttons, links and icons with the cursor tip in the center of the element."
+
+var (
+	legacyComputerTool = builtinTool{
+		name:        "computer",
+		description: computerDescription,
+		schema: computerSchema("key", "type", "mouse_move", "left_click", "left_click_drag",
+			"right_click", "middle_click", "double_click", "screenshot", "cursor_position"),
+	}
+	computerTool = builtinTool{
+		name:        "computer",
+		description: computerDescription,
+		schema: computerSchema("key", "hold_key", "type", "cursor_position", "mouse_move",
+			"left_mouse_down", "left_mouse_up", "left_click", "left_click_drag", "right_click",
+			"middle_click", "double_click", "triple_click", "scroll", "wait", "screenshot"),
+	}
+)
+
+// builtinTools maps each supported built-in tool type to its definition
+var builtinTools = map[string]builtinTool{
+	"bash_20241022":        bashTool,
+	"bash_20250124":        bashTool,
+	"text_editor_20241022": legacyTextEditorTool,
+	"text_editor_20250124": legacyTextEditorTool,
+	"text_editor_20250429": textEditorTool,
+	"text_editor_20250728": textEditorTool,
+	"computer_20241022":    legacyComputerTool,
+	"computer_20250124":    computerTool,
+}
+
+// ExpandBuiltinTools replaces built-in client tools (bash, text editor, computer use) with
+// full function declarations: the documented input schema and description. Gemini's calls
+// to these tools then come back as tool_use blocks with the name and arguments the client
+// expects. Custom tools are returned unchanged. Other typed tools, such as Anthropic's
+// server tools, can't be run by Gemini and are dropped with a warning.
+//
+// Expanded tools share the parsed input schemas, which must not be modified.
+func ExpandBuiltinTools(tools []types.AnthropicTool) []types.AnthropicTool {
+	var expanded []types.AnthropicTool
+	for _, tool := range tools {
+		if tool.Type == "" || tool.Type == types.ToolTypeCustom {
+			expanded = append(expanded, tool)
+			continue
+		}
+
+		builtin, ok := builtinTools[tool.Type]
+		if !ok {
+			log.Printf("Warning: dropping tool %s: unsupported tool type %q", tool.Name, tool.Type)
+			continue
+		}
+
+		if tool.Name == "" {
+			tool.Name = builtin.name
+		}
+		tool.Description = builtin.description
+		if tool.DisplayWidthPx > 0 && tool.DisplayHeightPx > 0 {
+			tool.Description += fmt.Sprintf("\n* The screen's resolution is %dx%d.", tool.DisplayWidthPx, tool.DisplayHeightPx)
+		}
+		if tool.DisplayNumber != nil {
+			tool.Description += fmt.Sprintf("\n* The display number is :%d.", *tool.DisplayNumber)
+		}
+		tool.InputSchema = builtin.schema
+		expanded = append(expanded, tool)
+	}
+	return expanded
+}
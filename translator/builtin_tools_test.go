@@ -0,0 +1,111 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestExpandBuiltinTools_Definitions(t *testing.T) {
+	for toolType, builtin := range builtinTools {
+		t.Run(toolType, func(t *testing.T) {
+			tools := ExpandBuiltinTools([]types.AnthropicTool{{Type: toolType, Name: builtin.name}})
+			if len(tools) != 1 {
+				t.Fatalf("expected one tool, got %d", len(tools))
+			}
+			tool := tools[0]
+			if tool.Description == "" {
+				t.Error("expected a description")
+			}
+			if tool.InputSchema[types.SchemaFieldType] != types.SchemaTypeObject {
+				t.Errorf("expected an object schema, got %v", tool.InputSchema)
+			}
+			// The schemas must survive the conversion to Gemini without loss
+			if _, issues := ConvertSchema(tool.InputSchema); len(issues) > 0 {
+				t.Errorf("unexpected schema issues: %v", issues)
+			}
+			// and are parsed once, not for every request
+			again := ExpandBuiltinTools([]types.AnthropicTool{{Type: toolType}})
+			if reflect.ValueOf(again[0].InputSchema).UnsafePointer() != reflect.ValueOf(tool.InputSchema).UnsafePointer() {
+				t.Error("expected expanded tools to share the parsed schema")
+			}
+		})
+	}
+}
+
+func TestExpandBuiltinTools(t *testing.T) {
+	display := 1
+	tools := ExpandBuiltinTools([]types.AnthropicTool{
+		{Name: "get_weather", Description: "Weather", InputSchema: map[string]interface{}{"type": "object"}},
+		{Type: types.ToolTypeCustom, Name: "search", InputSchema: map[string]interface{}{"type": "object"}},
+		{Type: "text_editor_20250728", Name: "str_replace_based_edit_tool"},
+		{Type: "computer_20250124", Name: "computer", DisplayWidthPx: 1024, DisplayHeightPx: 768, DisplayNumber: &display},
+		{Type: "web_search_20250305", Name: "web_search"},
+	})
+
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	if got := strings.Join(names, ","); got != "get_weather,search,str_replace_based_edit_tool,computer" {
+		t.Fatalf("unexpected tools %s", got)
+	}
+
+	if tools[0].Description != "Weather" || len(tools[0].InputSchema) != 1 {
+		t.Errorf("expected custom tool to be unchanged, got %+v", tools[0])
+	}
+
+	editor := tools[2].InputSchema
+	command := editor[types.SchemaFieldProperties].(map[string]interface{})["command"].(map[string]interface{})
+	if len(command[types.SchemaFieldEnum].([]interface{})) != 4 {
+		t.Errorf("expected 4 editor commands, got %v", command[types.SchemaFieldEnum])
+	}
+
+	if !strings.Contains(tools[3].Description, "1024x768") || !strings.Contains(tools[3].Description, ":1") {
+		t.Errorf("expected display details in the description, got %q", tools[3].Description)
+	}
+}
+
+func TestExpandBuiltinTools_ValidCalls(t *testing.T) {
+	tools := ExpandBuiltinTools([]types.AnthropicTool{
+		{Type: "bash_20250124", Name: "bash"},
+		{Type: "text_editor_20250124", Name: "str_replace_editor"},
+	})
+	validator := NewToolValidator(tools, ToolArgsError)
+
+	content := []types.AnthropicContentBlock{
+		{Type: types.ContentTypeToolUse, Name: "bash", Input: map[string]interface{}{"command": "ls"}},
+		{Type: types.ContentTypeToolUse, Name: "str_replace_editor", Input: map[string]interface{}{
+			"command": "view", "path": "/repo/main.go", "view_range": "[1, 10]",
+		}},
+	}
+	if err := validator.Check(content); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if viewRange, ok := content[1].Input["view_range"].([]interface{}); !ok || len(viewRange) != 2 {
+		t.Errorf("expected view_range to be repaired, got %v", content[1].Input["view_range"])
+	}
+
+	err := validator.Check([]types.AnthropicContentBlock{
+		{Type: types.ContentTypeToolUse, Name: "str_replace_editor", Input: map[string]interface{}{"command": "delete", "path": "/a"}},
+	})
+	if err == nil {
+		t.Error("expected an unknown command to be rejected")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// CachedContent represents a Gemini cachedContents resource: a prompt prefix (system
+// instruction, tools and leading contents) that generateContent requests can reference
+// instead of sending it again
+type CachedContent struct {
+	Name              string                `json:"name,omitempty"` // "cachedContents/{id}"
+	Model             string                `json:"model,omitempty"`
+	DisplayName       string                `json:"displayName,omitempty"`
+	Contents          []types.GeminiContent `json:"contents,omitempty"`
+	Tools             []GeminiToolWrapper   `json:"tools,omitempty"`
+	ToolConfig        *ToolConfig           `json:"toolConfig,omitempty"`
+	SystemInstruction *types.GeminiContent  `json:"systemInstruction,omitempty"`
+	TTL               string                `json:"ttl,omitempty"` // e.g. "300s"; only sent, never returned
+	ExpireTime        time.Time             `json:"expireTime,omitzero"`
+	UsageMetadata     *CachedContentUsage   `json:"usageMetadata,omitempty"`
+}
+
+// CachedContentUsage reports the size of a cachedContents resource
+type CachedContentUsage struct {
+	TotalTokenCount int32 `json:"totalTokenCount"`
+}
+
+// formatTTL formats a duration the way Gemini expects durations, e.g. "300s"
+func formatTTL(ttl time.Duration) string {
+	return strconv.FormatFloat(ttl.Seconds(), 'f', -1, 64) + "s"
+}
+
+// CreateCachedContent creates a cachedContents resource that lives for ttl
+func (c *GeminiHTTPClient) CreateCachedContent(ctx context.Context, cached *CachedContent, ttl time.Duration) (*CachedContent, error) {
+	body := *cached
+	body.TTL = formatTTL(ttl)
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cached content: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/cachedContents?key=%s", c.baseURL, c.apiKey)
+	return c.cachedContentRequest(ctx, http.MethodPost, url, jsonData)
+}
+
+// UpdateCachedContentTTL extends the life of a cachedContents resource to ttl from now
+func (c *GeminiHTTPClient) UpdateCachedContentTTL(ctx context.Context, name string, ttl time.Duration) (*CachedContent, error) {
+	jsonData, err := json.Marshal(CachedContent{TTL: formatTTL(ttl)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cached content: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/%s?updateMask=ttl&key=%s", c.baseURL, name, c.apiKey)
+	return c.cachedContentRequest(ctx, http.MethodPatch, url, jsonData)
+}
+
+// DeleteCachedContent deletes a cachedContents resource
+func (c *GeminiHTTPClient) DeleteCachedContent(ctx context.Context, name string) error {
+	url := fmt.Sprintf("%s/%s?key=%s", c.baseURL, name, c.apiKey)
+	return Retry(ctx, c.retry, func(ctx context.Context) error {
+		httpResp, err := c.send(ctx, http.MethodDelete, url, nil, nil)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, httpResp.Body)
+		return httpResp.Body.Close()
+	})
+}
+
+func (c *GeminiHTTPClient) cachedContentRequest(ctx context.Context, method, url string, jsonData []byte) (*CachedContent, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+
+	var cached CachedContent
+	err := Retry(ctx, c.retry, func(ctx context.Context) error {
+		httpResp, err := c.send(ctx, method, url, bytes.NewReader(jsonData), header)
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+
+		if err := json.NewDecoder(httpResp.Body).Decode(&cached); err != nil {
+			return fmt.Errorf("failed to unmarshal cached content: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cached, nil
+}
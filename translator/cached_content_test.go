@@ -0,0 +1,156 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// cachedContentsAPI is a stand-in for the Gemini cachedContents REST API. Prefixes with
+// fewer than minParts parts are refused as too small.
+type cachedContentsAPI struct {
+	t        *testing.T
+	now      func() time.Time
+	minParts int
+	status   int // If set, every request fails with this status
+
+	mu        sync.Mutex
+	resources map[string]*CachedContent
+	creates   int
+	updates   int
+	deletes   int
+}
+
+func newCachedContentsAPI(t *testing.T) (*cachedContentsAPI, *GeminiHTTPClient) {
+	t.Helper()
+	api := &cachedContentsAPI{t: t, now: time.Now, resources: map[string]*CachedContent{}}
+	ts := httptest.NewServer(api)
+	t.Cleanup(ts.Close)
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	return api, client
+}
+
+func (a *cachedContentsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.status != 0 {
+		w.WriteHeader(a.status)
+		fmt.Fprintf(w, `{"error": {"code": %d, "message": "failed", "status": "INTERNAL"}}`, a.status)
+		return
+	}
+
+	var body CachedContent
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	ttl, _ := time.ParseDuration(body.TTL)
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/cachedContents":
+		parts := 0
+		for _, content := range body.Contents {
+			parts += len(content.Parts)
+		}
+		if body.SystemInstruction != nil {
+			parts += len(body.SystemInstruction.Parts)
+		}
+		if parts < a.minParts {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": {"code": 400, "message": "Cached content is too small.", "status": "INVALID_ARGUMENT"}}`)
+			return
+		}
+		if ttl <= 0 || !strings.HasPrefix(body.Model, "models/") {
+			a.t.Errorf("unexpected cached content: %+v", body)
+		}
+		a.creates++
+		body.Name = fmt.Sprintf("cachedContents/c%d", a.creates)
+		body.TTL = ""
+		body.ExpireTime = a.now().Add(ttl)
+		a.resources[body.Name] = &body
+		json.NewEncoder(w).Encode(body)
+
+	case r.Method == http.MethodPatch && r.URL.Query().Get("updateMask") == "ttl":
+		resource, ok := a.resources[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": 404, "message": "not found", "status": "NOT_FOUND"}}`)
+			return
+		}
+		a.updates++
+		resource.ExpireTime = a.now().Add(ttl)
+		json.NewEncoder(w).Encode(resource)
+
+	case r.Method == http.MethodDelete:
+		a.deletes++
+		delete(a.resources, strings.TrimPrefix(r.URL.Path, "/"))
+		fmt.Fprint(w, "{}")
+
+	default:
+		a.t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func TestGeminiHTTPClient_CachedContent(t *testing.T) {
+	api, client := newCachedContentsAPI(t)
+	ctx := context.Background()
+
+	created, err := client.CreateCachedContent(ctx, &CachedContent{
+		Model:             "models/gemini-2.5-flash",
+		SystemInstruction: &types.GeminiContent{Parts: []types.GeminiPart{{Text: "You are helpful"}}},
+	}, 90*time.Second)
+	if err != nil {
+		t.Fatalf("CreateCachedContent failed: %v", err)
+	}
+	if created.Name != "cachedContents/c1" || created.ExpireTime.IsZero() {
+		t.Errorf("unexpected cached content: %+v", created)
+	}
+
+	updated, err := client.UpdateCachedContentTTL(ctx, created.Name, time.Hour)
+	if err != nil || time.Until(updated.ExpireTime) < 59*time.Minute {
+		t.Errorf("expected the TTL to be extended to an hour, got %+v (err=%v)", updated, err)
+	}
+
+	if err := client.DeleteCachedContent(ctx, created.Name); err != nil || len(api.resources) != 0 {
+		t.Errorf("expected the cached content to be deleted (err=%v)", err)
+	}
+}
+
+func TestFormatTTL(t *testing.T) {
+	tests := map[time.Duration]string{
+		5 * time.Minute:         "300s",
+		time.Hour:               "3600s",
+		1500 * time.Millisecond: "1.5s",
+	}
+	for ttl, want := range tests {
+		if got := formatTTL(ttl); got != want {
+			t.Errorf("formatTTL(%s) = %s, want %s", ttl, got, want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// DefaultCacheTTL is the lifetime of a cache_control breakpoint without a ttl, matching
+// Anthropic's default
+const DefaultCacheTTL = 5 * time.Minute
+
+// cacheExpiryMargin is how long before it expires that a cached prefix stops being used,
+// so that it can't expire while a request is in flight
+const cacheExpiryMargin = 30 * time.Second
+
+// CacheBreakpoint is a cache_control marker in a request
+type CacheBreakpoint struct {
+	Messages int           // Leading messages covered; 0 for tool and system breakpoints
+	TTL      time.Duration // Requested lifetime of the cached prefix
+}
+
+// CacheBreakpoints returns the cache_control breakpoints of req, shortest prefix first.
+// As with Anthropic, the prompt is ordered tools, system, then messages. A ttl that isn't
+// a valid duration is replaced by DefaultCacheTTL.
+func CacheBreakpoints(req *types.AnthropicRequest) []CacheBreakpoint {
+	var breakpoints []CacheBreakpoint
+	add := func(messages int, cacheControl *types.AnthropicCacheControl) {
+		if cacheControl == nil {
+			return
+		}
+		ttl := DefaultCacheTTL
+		if cacheControl.TTL != "" {
+			if d, err := time.ParseDuration(cacheControl.TTL); err == nil && d > 0 {
+				ttl = d
+			} else {
+				log.Printf("Warning: invalid cache_control ttl %q, using %s", cacheControl.TTL, DefaultCacheTTL)
+			}
+		}
+		breakpoints = append(breakpoints, CacheBreakpoint{Messages: messages, TTL: ttl})
+	}
+
+	for _, tool := range req.Tools {
+		add(0, tool.CacheControl)
+	}
+	if system, ok := req.System.([]interface{}); ok {
+		blocks, _ := contentBlocks(system)
+		for _, block := range blocks {
+			add(0, block.CacheControl)
+		}
+	}
+	for i, msg := range req.Messages {
+		for _, block := range msg.Content {
+			add(i+1, block.CacheControl)
+		}
+	}
+	return breakpoints
+}
+
+// ContextCache maps cache_control breakpoints to Gemini cachedContents resources.
+//
+// The prompt prefix up to a breakpoint (system instruction, tools, tool config and leading
+// contents) is hashed together with the model, and requests with the same prefix reference
+// the same resource instead of sending the prefix again. A resource lives for the
+// breakpoint's TTL, which is extended when it is reused, as Anthropic does.
+//
+// Gemini refuses to cache prefixes below a model-specific minimum size (1,024 tokens or
+// more). Such prefixes are remembered for their TTL and sent uncached. Failures to create
+// a cache never fail the request.
+type ContextCache struct {
+	client *GeminiHTTPClient
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry // By prefix hash
+}
+
+// cacheEntry is a cached prefix. Its fields may only be read once ready is closed, and
+// expireTime only while holding ContextCache.mu.
+type cacheEntry struct {
+	ready      chan struct{} // Closed once the resource has been created (or refused)
+	name       string        // "cachedContents/{id}", or empty if Gemini refused the prefix
+	expireTime time.Time
+}
+
+// NewContextCache creates a ContextCache managing resources through client
+func NewContextCache(client *GeminiHTTPClient) *ContextCache {
+	return &ContextCache{
+		client:  client,
+		now:     time.Now,
+		entries: map[string]*cacheEntry{},
+	}
+}
+
+// Apply moves the longest cached prefix of geminiReq into a cachedContents resource that
+// the request then references. req is the request geminiReq was built from. The prefix
+// of the last breakpoint is cached if it isn't already; shorter prefixes are only used if
+// they are already cached. At least one content is always left in the request. Apply
+// reports whether the referenced resource was created for this request.
+func (c *ContextCache) Apply(ctx context.Context, model string, req *types.AnthropicRequest, geminiReq *GenerateContentRequest) bool {
+	breakpoints := CacheBreakpoints(req)
+	if len(breakpoints) == 0 {
+		return false
+	}
+	c.prune()
+
+	longest := true
+	for i := len(breakpoints) - 1; i >= 0; i-- {
+		breakpoint := breakpoints[i]
+		prefix, err := ToCustomGeminiContents(req.Messages[:breakpoint.Messages])
+		if err != nil || len(prefix) >= len(geminiReq.Contents) {
+			continue
+		}
+
+		key, cached := c.prefix(model, geminiReq, len(prefix))
+		var (
+			name    string
+			created bool
+		)
+		if longest {
+			name, created = c.resource(ctx, key, breakpoint.TTL, cached)
+			longest = false
+		} else {
+			name, created = c.resource(ctx, key, breakpoint.TTL, nil)
+		}
+		if name != "" {
+			geminiReq.CachedContent = name
+			geminiReq.SystemInstruction = nil
+			geminiReq.Tools = nil
+			geminiReq.ToolConfig = nil
+			geminiReq.Contents = append([]types.GeminiContent{}, geminiReq.Contents[len(prefix):]...)
+			return created
+		}
+	}
+	return false
+}
+
+// prefix returns the hash of the first n contents of geminiReq along with everything
+// else that goes into a cachedContents resource, and the resource itself
+func (c *ContextCache) prefix(model string, geminiReq *GenerateContentRequest, n int) (string, *CachedContent) {
+	cached := &CachedContent{
+		Model:             "models/" + model,
+		Contents:          geminiReq.Contents[:n],
+		Tools:             geminiReq.Tools,
+		ToolConfig:        geminiReq.ToolConfig,
+		SystemInstruction: geminiReq.SystemInstruction,
+	}
+	data, _ := json.Marshal(cached)
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:])
+	cached.DisplayName = "twin-in-disguise " + key[:16]
+	return key, cached
+}
+
+// resource returns the name of the resource holding the prefix with the given key,
+// creating it from cached if it doesn't exist and cached isn't nil, and whether it was
+// just created. It returns "" if the prefix isn't cached.
+func (c *ContextCache) resource(ctx context.Context, key string, ttl time.Duration, cached *CachedContent) (string, bool) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		if cached == nil {
+			c.mu.Unlock()
+			return "", false
+		}
+		entry = &cacheEntry{ready: make(chan struct{})}
+		c.entries[key] = entry
+		c.mu.Unlock()
+
+		c.create(ctx, key, entry, cached, ttl)
+		return entry.name, entry.name != ""
+	}
+	c.mu.Unlock()
+
+	// Another request may still be creating the resource
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		return "", false
+	}
+	if entry.name == "" {
+		return "", false
+	}
+	return c.refresh(ctx, key, entry, ttl), false
+}
+
+// create creates the resource for a new entry. Prefixes that Gemini refuses to cache are
+// remembered until ttl passes; after other failures the entry is dropped so that a later
+// request tries again.
+func (c *ContextCache) create(ctx context.Context, key string, entry *cacheEntry, cached *CachedContent, ttl time.Duration) {
+	defer close(entry.ready)
+
+	created, err := c.client.CreateCachedContent(ctx, cached, ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		entry.name = created.Name
+		entry.expireTime = created.ExpireTime
+		if entry.expireTime.IsZero() {
+			entry.expireTime = c.now().Add(ttl)
+		}
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest:
+		log.Printf("Prompt prefix is not cached: %v", err)
+		entry.expireTime = c.now().Add(ttl)
+	default:
+		log.Printf("Warning: failed to create context cache: %v", err)
+		delete(c.entries, key)
+	}
+}
+
+// refresh extends the life of a resource once less than half of ttl is left, and returns
+// its name, or "" if it can no longer be used
+func (c *ContextCache) refresh(ctx context.Context, key string, entry *cacheEntry, ttl time.Duration) string {
+	c.mu.Lock()
+	expireTime := entry.expireTime
+	c.mu.Unlock()
+
+	now := c.now()
+	if !expireTime.After(now.Add(cacheExpiryMargin)) {
+		return ""
+	}
+	if expireTime.Sub(now) >= ttl/2 {
+		return entry.name
+	}
+
+	updated, err := c.client.UpdateCachedContentTTL(ctx, entry.name, ttl)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		entry.expireTime = updated.ExpireTime
+		if entry.expireTime.IsZero() {
+			entry.expireTime = now.Add(ttl)
+		}
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusForbidden):
+		// Deleted behind our back
+		delete(c.entries, key)
+		return ""
+	default:
+		// Still usable until it expires
+		log.Printf("Warning: failed to extend context cache %s: %v", entry.name, err)
+	}
+	return entry.name
+}
+
+// prune forgets entries that have expired; Gemini deletes the resources itself
+func (c *ContextCache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for key, entry := range c.entries {
+		select {
+		case <-entry.ready:
+			if !entry.expireTime.After(now) {
+				delete(c.entries, key)
+			}
+		default:
+			// Still being created
+		}
+	}
+}
+
+// Len returns the number of cached prefixes, including those Gemini refused to cache
+func (c *ContextCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Close deletes every resource that hasn't expired yet, so that none outlive the proxy
+func (c *ContextCache) Close(ctx context.Context) error {
+	c.mu.Lock()
+	var names []string
+	now := c.now()
+	for key, entry := range c.entries {
+		select {
+		case <-entry.ready:
+			if entry.name != "" && entry.expireTime.After(now) {
+				names = append(names, entry.name)
+			}
+			delete(c.entries, key)
+		default:
+			// Still being created; the request creating it will keep using it
+		}
+	}
+	c.mu.Unlock()
+
+	var errs []error
+	for _, name := range names {
+		if err := c.client.DeleteCachedContent(ctx, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
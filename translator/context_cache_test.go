@@ -0,0 +1,267 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// cacheRequest returns a request with cache_control on the system prompt and on the
+// given messages (1-based), along with the Gemini request built from it
+func cacheRequest(t *testing.T, turns int, marked ...int) (*types.AnthropicRequest, *GenerateContentRequest) {
+	t.Helper()
+	var req types.AnthropicRequest
+	err := json.Unmarshal([]byte(`{
+		"model": "gemini-2.5-flash",
+		"system": [{"type": "text", "text": "You are a coding agent", "cache_control": {"type": "ephemeral"}}],
+		"tools": [{"name": "read_file", "input_schema": {"type": "object"}}]
+	}`), &req)
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+	for i := 1; i <= turns; i++ {
+		role := types.RoleUser
+		if i%2 == 0 {
+			role = types.RoleAssistant
+		}
+		block := types.AnthropicContentBlock{Type: types.ContentTypeText, Text: "message"}
+		for _, m := range marked {
+			if m == i {
+				block.CacheControl = &types.AnthropicCacheControl{Type: types.CacheControlTypeEphemeral}
+			}
+		}
+		req.Messages = append(req.Messages, types.AnthropicMessage{Role: role, Content: []types.AnthropicContentBlock{block}})
+	}
+
+	contents, err := ToCustomGeminiContents(req.Messages)
+	if err != nil {
+		t.Fatalf("ToCustomGeminiContents failed: %v", err)
+	}
+	return &req, &GenerateContentRequest{
+		Contents:          contents,
+		SystemInstruction: &types.GeminiContent{Parts: []types.GeminiPart{{Text: "You are a coding agent"}}},
+		Tools:             []GeminiToolWrapper{{FunctionDeclarations: []FunctionDeclaration{{Name: "read_file"}}}},
+	}
+}
+
+func TestCacheBreakpoints(t *testing.T) {
+	var req types.AnthropicRequest
+	err := json.Unmarshal([]byte(`{
+		"system": [{"type": "text", "text": "System", "cache_control": {"type": "ephemeral", "ttl": "1h"}}],
+		"tools": [{"name": "a", "input_schema": {}}, {"name": "b", "input_schema": {}, "cache_control": {"type": "ephemeral"}}],
+		"messages": [
+			{"role": "user", "content": "Hi"},
+			{"role": "assistant", "content": "Hello"},
+			{"role": "user", "content": [{"type": "text", "text": "Bye", "cache_control": {"type": "ephemeral", "ttl": "forever"}}]}
+		]
+	}`), &req)
+	if err != nil {
+		t.Fatalf("invalid request: %v", err)
+	}
+
+	got := CacheBreakpoints(&req)
+	want := []CacheBreakpoint{
+		{Messages: 0, TTL: 5 * time.Minute},
+		{Messages: 0, TTL: time.Hour},
+		{Messages: 3, TTL: DefaultCacheTTL}, // Invalid TTL
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("breakpoint %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	if breakpoints := CacheBreakpoints(&types.AnthropicRequest{System: "Plain"}); len(breakpoints) != 0 {
+		t.Errorf("expected no breakpoints, got %v", breakpoints)
+	}
+}
+
+func TestContextCache_Apply(t *testing.T) {
+	api, client := newCachedContentsAPI(t)
+	cache := NewContextCache(client)
+	ctx := context.Background()
+
+	// The breakpoint on the last message would leave nothing to send, so the prefix up to
+	// the first message is cached
+	req, geminiReq := cacheRequest(t, 3, 1, 3)
+	if created := cache.Apply(ctx, "gemini-2.5-flash", req, geminiReq); !created {
+		t.Error("expected the cache to be reported as created")
+	}
+	if geminiReq.CachedContent != "cachedContents/c1" || len(geminiReq.Contents) != 2 {
+		t.Fatalf("expected the first message to be cached, got %q with %d contents", geminiReq.CachedContent, len(geminiReq.Contents))
+	}
+	if geminiReq.SystemInstruction != nil || geminiReq.Tools != nil {
+		t.Error("expected the system instruction and tools to move into the cache")
+	}
+	resource := api.resources["cachedContents/c1"]
+	if resource.Model != "models/gemini-2.5-flash" || len(resource.Contents) != 1 || resource.SystemInstruction == nil || len(resource.Tools) != 1 {
+		t.Errorf("unexpected cached content: %+v", resource)
+	}
+
+	// The same prefix is reused
+	req, geminiReq = cacheRequest(t, 3, 1, 3)
+	created := cache.Apply(ctx, "gemini-2.5-flash", req, geminiReq)
+	if geminiReq.CachedContent != "cachedContents/c1" || api.creates != 1 || created {
+		t.Errorf("expected the cache to be reused, got %q after %d creates (created %t)", geminiReq.CachedContent, api.creates, created)
+	}
+
+	// A later breakpoint caches a longer prefix
+	req, geminiReq = cacheRequest(t, 5, 3, 5)
+	cache.Apply(ctx, "gemini-2.5-flash", req, geminiReq)
+	if geminiReq.CachedContent != "cachedContents/c2" || len(geminiReq.Contents) != 2 {
+		t.Errorf("expected the first three messages to be cached, got %q with %d contents", geminiReq.CachedContent, len(geminiReq.Contents))
+	}
+
+	// A different model doesn't share the cache
+	req, geminiReq = cacheRequest(t, 3, 1, 3)
+	cache.Apply(ctx, "gemini-2.5-pro", req, geminiReq)
+	if geminiReq.CachedContent != "cachedContents/c3" {
+		t.Errorf("expected a new cache for another model, got %q", geminiReq.CachedContent)
+	}
+
+	// Without breakpoints nothing changes
+	req, geminiReq = cacheRequest(t, 3)
+	req.System = "You are a coding agent"
+	cache.Apply(ctx, "gemini-2.5-flash", req, geminiReq)
+	if geminiReq.CachedContent != "" || len(geminiReq.Contents) != 3 || geminiReq.SystemInstruction == nil {
+		t.Errorf("expected the request to be left alone, got %+v", geminiReq)
+	}
+
+	if err := cache.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(api.resources) != 0 || api.deletes != 3 || cache.Len() != 0 {
+		t.Errorf("expected every cache to be deleted on close, %d left after %d deletes", len(api.resources), api.deletes)
+	}
+}
+
+func TestContextCache_Expiry(t *testing.T) {
+	api, client := newCachedContentsAPI(t)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	api.now = func() time.Time { return now }
+	cache := NewContextCache(client)
+	cache.now = api.now
+	ctx := context.Background()
+
+	apply := func() string {
+		req, geminiReq := cacheRequest(t, 3, 1)
+		cache.Apply(ctx, "gemini-2.5-flash", req, geminiReq)
+		return geminiReq.CachedContent
+	}
+
+	if name := apply(); name != "cachedContents/c1" {
+		t.Fatalf("expected a new cache, got %q", name)
+	}
+
+	// Used again with less than half the TTL left, the cache is extended
+	now = now.Add(3 * time.Minute)
+	if name := apply(); name != "cachedContents/c1" || api.updates != 1 {
+		t.Errorf("expected the cache to be extended, got %q after %d updates", name, api.updates)
+	}
+	now = now.Add(time.Minute)
+	if name := apply(); name != "cachedContents/c1" || api.updates != 1 {
+		t.Errorf("expected the extended cache to be reused, got %q after %d updates", name, api.updates)
+	}
+
+	// Once expired, the prefix is cached again
+	now = now.Add(10 * time.Minute)
+	if name := apply(); name != "cachedContents/c2" {
+		t.Errorf("expected a new cache after expiry, got %q", name)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("expected the expired entry to be pruned, got %d entries", cache.Len())
+	}
+
+	// Expired caches are left to Gemini on close
+	now = now.Add(time.Hour)
+	if err := cache.Close(ctx); err != nil || api.deletes != 0 {
+		t.Errorf("expected nothing to be deleted, got %d deletes (err=%v)", api.deletes, err)
+	}
+}
+
+func TestContextCache_CreateFailures(t *testing.T) {
+	api, client := newCachedContentsAPI(t)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewContextCache(client)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	apply := func() *GenerateContentRequest {
+		req, geminiReq := cacheRequest(t, 3, 1)
+		cache.Apply(ctx, "gemini-2.5-flash", req, geminiReq)
+		return geminiReq
+	}
+
+	// Transient failures leave the request uncached and are retried by the next request
+	api.status = 500
+	if geminiReq := apply(); geminiReq.CachedContent != "" || len(geminiReq.Contents) != 3 || geminiReq.SystemInstruction == nil {
+		t.Errorf("expected the request to be sent uncached, got %+v", geminiReq)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("expected the failed entry to be dropped, got %d entries", cache.Len())
+	}
+
+	// Prefixes that are too small are not tried again until their TTL passes
+	api.status = 0
+	api.minParts = 100
+	apply()
+	apply()
+	if api.creates != 0 || cache.Len() != 1 {
+		t.Errorf("expected the small prefix to be remembered, got %d creates and %d entries", api.creates, cache.Len())
+	}
+
+	now = now.Add(DefaultCacheTTL)
+	api.minParts = 0
+	if geminiReq := apply(); geminiReq.CachedContent != "cachedContents/c1" {
+		t.Errorf("expected the prefix to be cached after the TTL, got %q", geminiReq.CachedContent)
+	}
+}
+
+func TestContextCache_ConcurrentRequests(t *testing.T) {
+	api, client := newCachedContentsAPI(t)
+	cache := NewContextCache(client)
+
+	var wg sync.WaitGroup
+	names := make([]string, 8)
+	for i := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, geminiReq := cacheRequest(t, 3, 1)
+			cache.Apply(context.Background(), "gemini-2.5-flash", req, geminiReq)
+			names[i] = geminiReq.CachedContent
+		}()
+	}
+	wg.Wait()
+
+	for _, name := range names {
+		if name != "cachedContents/c1" {
+			t.Errorf("expected every request to use the same cache, got %v", names)
+			break
+		}
+	}
+	if api.creates != 1 {
+		t.Errorf("expected a single cache to be created, got %d", api.creates)
+	}
+}
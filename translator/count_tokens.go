@@ -0,0 +1,160 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultTokenCountTTL is how long a token count is reused for an identical request
+const DefaultTokenCountTTL = time.Minute
+
+// maxTokenCounts bounds the number of token counts kept by a TokenCounter
+const maxTokenCounts = 1024
+
+// countTokensRequest is the body of a countTokens call. The request must name the model
+// even though the URL already does.
+type countTokensRequest struct {
+	GenerateContentRequest countTokensContent `json:"generateContentRequest"`
+}
+
+type countTokensContent struct {
+	Model string `json:"model"` // "models/{model}"
+	*GenerateContentRequest
+}
+
+// CountTokensResponse is the response of a countTokens call
+type CountTokensResponse struct {
+	TotalTokens             int32 `json:"totalTokens"`
+	CachedContentTokenCount int32 `json:"cachedContentTokenCount,omitempty"`
+}
+
+// CountTokens counts the prompt tokens of req, including its system instruction and tools.
+// Transient failures are retried according to the client's retry policy.
+func (c *GeminiHTTPClient) CountTokens(ctx context.Context, model string, req *GenerateContentRequest) (*CountTokensResponse, error) {
+	url := fmt.Sprintf("%s/models/%s:countTokens?key=%s", c.baseURL, model, c.apiKey)
+
+	jsonData, err := json.Marshal(countTokensRequest{
+		GenerateContentRequest: countTokensContent{Model: "models/" + model, GenerateContentRequest: req},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var count CountTokensResponse
+	err = Retry(ctx, c.retry, func(ctx context.Context) error {
+		httpResp, err := c.post(ctx, url, jsonData, "application/json")
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+
+		if err := json.NewDecoder(httpResp.Body).Decode(&count); err != nil {
+			return fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &count, nil
+}
+
+// TokenCounter counts prompt tokens with Gemini's countTokens, remembering each count for a
+// short while. Clients such as Claude Code count the same conversation repeatedly while
+// deciding when to compact it, so identical requests (by hash of the model and the
+// translated request) are answered without calling Gemini again.
+type TokenCounter struct {
+	client *GeminiHTTPClient
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	counts map[[sha256.Size]byte]tokenCount
+}
+
+type tokenCount struct {
+	tokens     int
+	expireTime time.Time
+}
+
+// NewTokenCounter creates a TokenCounter that reuses counts for ttl; 0 disables reuse
+func NewTokenCounter(client *GeminiHTTPClient, ttl time.Duration) *TokenCounter {
+	return &TokenCounter{
+		client: client,
+		ttl:    ttl,
+		now:    time.Now,
+		counts: map[[sha256.Size]byte]tokenCount{},
+	}
+}
+
+// Count returns the number of prompt tokens in req for model
+func (c *TokenCounter) Count(ctx context.Context, model string, req *GenerateContentRequest) (int, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	key := sha256.Sum256(append([]byte(model+"\x00"), data...))
+
+	c.mu.Lock()
+	count, ok := c.counts[key]
+	c.mu.Unlock()
+	if ok && count.expireTime.After(c.now()) {
+		return count.tokens, nil
+	}
+
+	resp, err := c.client.CountTokens(ctx, model, req)
+	if err != nil {
+		return 0, err
+	}
+	tokens := int(resp.TotalTokens)
+
+	if c.ttl > 0 {
+		c.mu.Lock()
+		c.prune()
+		c.counts[key] = tokenCount{tokens: tokens, expireTime: c.now().Add(c.ttl)}
+		c.mu.Unlock()
+	}
+	return tokens, nil
+}
+
+// prune forgets expired counts, and every count if there are still too many. The caller
+// must hold c.mu.
+func (c *TokenCounter) prune() {
+	if len(c.counts) < maxTokenCounts {
+		return
+	}
+	now := c.now()
+	for key, count := range c.counts {
+		if !count.expireTime.After(now) {
+			delete(c.counts, key)
+		}
+	}
+	if len(c.counts) >= maxTokenCounts {
+		clear(c.counts)
+	}
+}
+
+// Len returns the number of counts being remembered, including expired ones
+func (c *TokenCounter) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.counts)
+}
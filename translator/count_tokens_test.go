@@ -0,0 +1,167 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// newCountTokensAPI serves countTokens, counting one token per part, and returns the
+// number of calls made to it
+func newCountTokensAPI(t *testing.T) (*atomic.Int32, *GeminiHTTPClient) {
+	t.Helper()
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/models/gemini-2.5-flash:countTokens" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": 404, "message": "not found", "status": "NOT_FOUND"}}`)
+			return
+		}
+
+		var body struct {
+			GenerateContentRequest struct {
+				Model string `json:"model"`
+				GenerateContentRequest
+			} `json:"generateContentRequest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("invalid countTokens request: %v", err)
+		}
+		req := body.GenerateContentRequest
+		if req.Model != "models/gemini-2.5-flash" {
+			t.Errorf("expected the request to name the model, got %q", req.Model)
+		}
+
+		tokens := 0
+		if req.SystemInstruction != nil {
+			tokens += len(req.SystemInstruction.Parts)
+		}
+		for _, content := range req.Contents {
+			tokens += len(content.Parts)
+		}
+		fmt.Fprintf(w, `{"totalTokens": %d}`, tokens)
+	}))
+	t.Cleanup(ts.Close)
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	return &calls, client
+}
+
+func countRequest(texts ...string) *GenerateContentRequest {
+	req := &GenerateContentRequest{SystemInstruction: &types.GeminiContent{Parts: []types.GeminiPart{{Text: "Be brief"}}}}
+	for _, text := range texts {
+		req.Contents = append(req.Contents, types.GeminiContent{Role: types.RoleUser, Parts: []types.GeminiPart{{Text: text}}})
+	}
+	return req
+}
+
+func TestGeminiHTTPClient_CountTokens(t *testing.T) {
+	_, client := newCountTokensAPI(t)
+
+	resp, err := client.CountTokens(context.Background(), "gemini-2.5-flash", countRequest("Hello", "Again"))
+	if err != nil {
+		t.Fatalf("CountTokens failed: %v", err)
+	}
+	if resp.TotalTokens != 3 {
+		t.Errorf("expected 3 tokens, got %d", resp.TotalTokens)
+	}
+
+	_, err = client.CountTokens(context.Background(), "gemini-unknown", countRequest("Hello"))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
+
+func TestTokenCounter_Count(t *testing.T) {
+	calls, client := newCountTokensAPI(t)
+	counter := NewTokenCounter(client, time.Minute)
+	now := time.Now()
+	counter.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	count := func(texts ...string) int {
+		t.Helper()
+		tokens, err := counter.Count(ctx, "gemini-2.5-flash", countRequest(texts...))
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		return tokens
+	}
+
+	if tokens := count("Hello"); tokens != 2 {
+		t.Errorf("expected 2 tokens, got %d", tokens)
+	}
+	if tokens := count("Hello"); tokens != 2 || calls.Load() != 1 {
+		t.Errorf("expected the count to be reused, got %d tokens after %d calls", tokens, calls.Load())
+	}
+	if tokens := count("Hello", "Again"); tokens != 3 || calls.Load() != 2 {
+		t.Errorf("expected a different request to be counted, got %d tokens after %d calls", tokens, calls.Load())
+	}
+
+	// Counts expire
+	now = now.Add(time.Minute)
+	count("Hello")
+	if calls.Load() != 3 {
+		t.Errorf("expected an expired count to be refreshed, got %d calls", calls.Load())
+	}
+
+	// Failures aren't remembered
+	if _, err := counter.Count(ctx, "gemini-unknown", countRequest("Hello")); err == nil {
+		t.Error("expected an error for an unknown model")
+	}
+	if counter.Len() != 2 {
+		t.Errorf("expected 2 counts, got %d", counter.Len())
+	}
+}
+
+func TestTokenCounter_Prune(t *testing.T) {
+	_, client := newCountTokensAPI(t)
+	counter := NewTokenCounter(client, time.Minute)
+	for i := 0; i < maxTokenCounts+10; i++ {
+		if _, err := counter.Count(context.Background(), "gemini-2.5-flash", countRequest(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+	}
+	if n := counter.Len(); n > maxTokenCounts {
+		t.Errorf("expected at most %d counts, got %d", maxTokenCounts, n)
+	}
+}
+
+func TestTokenCounter_NoReuse(t *testing.T) {
+	calls, client := newCountTokensAPI(t)
+	counter := NewTokenCounter(client, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := counter.Count(context.Background(), "gemini-2.5-flash", countRequest("Hello")); err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+	}
+	if calls.Load() != 2 || counter.Len() != 0 {
+		t.Errorf("expected every request to be counted, got %d calls and %d counts", calls.Load(), counter.Len())
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// convertDocument converts a document block to Gemini parts:
+//   - base64 sources (PDFs) become inline data
+//   - URL sources of Gemini File API files become file data; other URLs must have been
+//     fetched by URLFetcher.ResolveURLSources, and file sources resolved by
+//     ResolveFileSources
+//   - text sources become a text part
+//   - content sources become a text part with the text blocks, followed by any images
+//
+// The title and context are kept in a text part ahead of the document. Gemini has no
+// equivalent of Anthropic's citations, so a document with citations enabled is accepted,
+// but the response never contains citations.
+func convertDocument(block types.AnthropicContentBlock) ([]types.GeminiPart, error) {
+	source := block.Source
+	if source == nil {
+		return nil, NewInvalidRequestError("document block has no source")
+	}
+
+	var header []string
+	if block.Title != "" {
+		header = append(header, "Document title: "+block.Title)
+	}
+	if block.Context != "" {
+		header = append(header, "Document context: "+block.Context)
+	}
+
+	// withHeader prepends the title and context to the document's text
+	withHeader := func(text string) types.GeminiPart {
+		if len(header) == 0 {
+			return types.GeminiPart{Text: text}
+		}
+		return types.GeminiPart{Text: strings.Join(header, "\n") + "\n\n" + text}
+	}
+
+	// afterHeader puts the title and context in a text part ahead of a binary document
+	afterHeader := func(part types.GeminiPart) []types.GeminiPart {
+		if len(header) == 0 {
+			return []types.GeminiPart{part}
+		}
+		return []types.GeminiPart{{Text: strings.Join(header, "\n")}, part}
+	}
+
+	switch source.Type {
+	case types.SourceTypeBase64:
+		if source.Data == "" {
+			return nil, NewInvalidRequestError("document block has no data")
+		}
+		mediaType := source.MediaType
+		if mediaType == "" {
+			mediaType = types.MediaTypePDF
+		}
+		return afterHeader(types.GeminiPart{
+			InlineData: &types.GeminiBlob{MimeType: mediaType, Data: source.Data},
+		}), nil
+
+	case types.SourceTypeText:
+		return []types.GeminiPart{withHeader(source.Data)}, nil
+
+	case types.SourceTypeURL, types.SourceTypeFile:
+		// Only Gemini File API URLs are left by ResolveURLSources
+		part, err := fileSourcePart(source)
+		if err != nil {
+			return nil, err
+		}
+		return afterHeader(part), nil
+
+	case types.SourceTypeContent:
+		blocks, err := contentBlocks(source.Content)
+		if err != nil {
+			return nil, NewInvalidRequestError("invalid document content: %v", err)
+		}
+
+		var (
+			texts  []string
+			images []types.GeminiPart
+		)
+		for _, b := range blocks {
+			switch b.Type {
+			case types.ContentTypeText, "":
+				texts = append(texts, b.Text)
+			case types.ContentTypeImage:
+				if b.Source == nil || b.Source.Data == "" {
+					return nil, NewInvalidRequestError("image in document content has no data")
+				}
+				images = append(images, types.GeminiPart{
+					InlineData: &types.GeminiBlob{MimeType: b.Source.MediaType, Data: b.Source.Data},
+				})
+			default:
+				return nil, NewInvalidRequestError("unsupported block type %q in document content", b.Type)
+			}
+		}
+		return append([]types.GeminiPart{withHeader(strings.Join(texts, "\n\n"))}, images...), nil
+
+	default:
+		return nil, NewInvalidRequestError("unsupported document source type %q", source.Type)
+	}
+}
+
+// contentBlocks returns content that is either a string or an array of content blocks as
+// content blocks
+func contentBlocks(content interface{}) ([]types.AnthropicContentBlock, error) {
+	switch content := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []types.AnthropicContentBlock{{Type: types.ContentTypeText, Text: content}}, nil
+	case []types.AnthropicContentBlock:
+		return content, nil
+	default:
+		// Decoded from JSON as []interface{}; convert it the same way message content is
+		data, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		var blocks []types.AnthropicContentBlock
+		if err := json.Unmarshal(data, &blocks); err != nil {
+			return nil, err
+		}
+		return blocks, nil
+	}
+}
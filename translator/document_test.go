@@ -0,0 +1,174 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// documentMessages returns a single user message with the given document block and a
+// question about it
+func documentMessages(t *testing.T, document string) []types.AnthropicMessage {
+	t.Helper()
+	var messages []types.AnthropicMessage
+	err := json.Unmarshal([]byte(`[{"role": "user", "content": [`+document+`, {"type": "text", "text": "Summarize this"}]}]`), &messages)
+	if err != nil {
+		t.Fatalf("invalid messages: %v", err)
+	}
+	return messages
+}
+
+func TestConvertDocument(t *testing.T) {
+	const pdf = "JVBERi0xLjQK"
+
+	tests := []struct {
+		name     string
+		document string
+		expected []types.GeminiPart // Parts for the document, before the question
+	}{
+		{
+			name:     "base64 PDF",
+			document: `{"type": "document", "source": {"type": "base64", "media_type": "application/pdf", "data": "` + pdf + `"}}`,
+			expected: []types.GeminiPart{{InlineData: &types.GeminiBlob{MimeType: "application/pdf", Data: pdf}}},
+		},
+		{
+			name: "PDF with title, context and citations",
+			document: `{"type": "document", "source": {"type": "base64", "media_type": "application/pdf", "data": "` + pdf + `"},
+				"title": "Q3 Report", "context": "Internal draft", "citations": {"enabled": true}}`,
+			expected: []types.GeminiPart{
+				{Text: "Document title: Q3 Report\nDocument context: Internal draft"},
+				{InlineData: &types.GeminiBlob{MimeType: "application/pdf", Data: pdf}},
+			},
+		},
+		{
+			name:     "plain text",
+			document: `{"type": "document", "source": {"type": "text", "media_type": "text/plain", "data": "The grass is green."}}`,
+			expected: []types.GeminiPart{{Text: "The grass is green."}},
+		},
+		{
+			name:     "plain text with title",
+			document: `{"type": "document", "source": {"type": "text", "media_type": "text/plain", "data": "The grass is green."}, "title": "Facts"}`,
+			expected: []types.GeminiPart{{Text: "Document title: Facts\n\nThe grass is green."}},
+		},
+		{
+			name: "content blocks",
+			document: `{"type": "document", "title": "Notes", "source": {"type": "content", "content": [
+				{"type": "text", "text": "First chunk"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}},
+				{"type": "text", "text": "Second chunk"}
+			]}}`,
+			expected: []types.GeminiPart{
+				{Text: "Document title: Notes\n\nFirst chunk\n\nSecond chunk"},
+				{InlineData: &types.GeminiBlob{MimeType: "image/png", Data: "iVBORw0KGgo="}},
+			},
+		},
+		{
+			name:     "string content",
+			document: `{"type": "document", "source": {"type": "content", "content": "Just text"}}`,
+			expected: []types.GeminiPart{{Text: "Just text"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contents, err := ToCustomGeminiContents(documentMessages(t, tt.document))
+			if err != nil {
+				t.Fatalf("ToCustomGeminiContents failed: %v", err)
+			}
+
+			parts := contents[0].Parts
+			if len(parts) != len(tt.expected)+1 || parts[len(parts)-1].Text != "Summarize this" {
+				t.Fatalf("expected document parts followed by the question, got %+v", parts)
+			}
+			got, _ := json.Marshal(parts[:len(tt.expected)])
+			want, _ := json.Marshal(tt.expected)
+			if string(got) != string(want) {
+				t.Errorf("document parts mismatch\nGot:  %s\nWant: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestConvertDocument_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		message  string
+	}{
+		{name: "no source", document: `{"type": "document"}`, message: "has no source"},
+		{name: "no data", document: `{"type": "document", "source": {"type": "base64", "media_type": "application/pdf"}}`, message: "has no data"},
+		{name: "unknown source", document: `{"type": "document", "source": {"type": "s3", "url": "s3://bucket/a.pdf"}}`, message: `unsupported document source type "s3"`},
+		{name: "unknown content block", document: `{"type": "document", "source": {"type": "content", "content": [{"type": "audio"}]}}`, message: `unsupported block type "audio"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ToCustomGeminiContents(documentMessages(t, tt.document))
+			var clientErr *ClientError
+			if !errors.As(err, &clientErr) || clientErr.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected an invalid request error, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected error containing %q, got %q", tt.message, err.Error())
+			}
+		})
+	}
+}
+
+func TestToGeminiContents_Document(t *testing.T) {
+	contents, err := ToGeminiContents(documentMessages(t,
+		`{"type": "document", "title": "Report", "source": {"type": "base64", "media_type": "application/pdf", "data": "JVBERi0xLjQK"}}`))
+	if err != nil {
+		t.Fatalf("ToGeminiContents failed: %v", err)
+	}
+
+	parts := contents[0].Parts
+	if len(parts) != 3 {
+		t.Fatalf("expected title, document and question, got %d parts", len(parts))
+	}
+	if parts[0] != genai.Text("Document title: Report") {
+		t.Errorf("unexpected title part: %#v", parts[0])
+	}
+	blob, ok := parts[1].(genai.Blob)
+	if !ok || blob.MIMEType != "application/pdf" || string(blob.Data) != "%PDF-1.4\n" {
+		t.Errorf("expected decoded PDF data, got %#v", parts[1])
+	}
+}
+
+func TestConvertToolResult_Document(t *testing.T) {
+	contents, err := ToCustomGeminiContents(toolResultMessages(t, `"content": [
+		{"type": "text", "text": "Downloaded"},
+		{"type": "document", "source": {"type": "base64", "media_type": "application/pdf", "data": "JVBERi0xLjQK"}},
+		{"type": "document", "source": {"type": "text", "media_type": "text/plain", "data": "README"}}
+	]`))
+	if err != nil {
+		t.Fatalf("ToCustomGeminiContents failed: %v", err)
+	}
+
+	response := contents[2].Parts[0].FunctionResponse
+	if response.Response["result"] != "Downloaded\nREADME" {
+		t.Errorf("unexpected payload: %v", response.Response)
+	}
+	if len(response.Parts) != 1 || response.Parts[0].InlineData.MimeType != "application/pdf" {
+		t.Errorf("expected the PDF as a function response part, got %+v", response.Parts)
+	}
+}
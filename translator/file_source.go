@@ -0,0 +1,72 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// FileLookup returns the URI and media type of the Gemini file holding an uploaded file. It
+// returns a *ClientError if the file doesn't exist.
+type FileLookup func(ctx context.Context, fileID string) (uri, mediaType string, err error)
+
+// ResolveFileSources sets the GeminiFileURI of the file sources of image and document blocks
+// in messages, including those inside tool results, to the Gemini file that holds the
+// upload. These are sent to Gemini as file data.
+func ResolveFileSources(ctx context.Context, messages []types.AnthropicMessage, lookup FileLookup) error {
+	return forEachSourceBlock(messages, func(block *types.AnthropicContentBlock) error {
+		source := block.Source
+		if source == nil || source.Type != types.SourceTypeFile {
+			return nil
+		}
+		if source.FileID == "" {
+			return NewInvalidRequestError("%s block has a file source without a file_id", block.Type)
+		}
+
+		uri, mediaType, err := lookup(ctx, source.FileID)
+		if err != nil {
+			return err
+		}
+		if block.Type == types.ContentTypeImage && !strings.HasPrefix(mediaType, "image/") {
+			return NewInvalidRequestError("file %s has media type %s and can't be used as an image", source.FileID, mediaType)
+		}
+
+		block.Source = &types.AnthropicSource{
+			Type:          types.SourceTypeFile,
+			FileID:        source.FileID,
+			MediaType:     mediaType,
+			GeminiFileURI: uri,
+		}
+		return nil
+	})
+}
+
+// fileSourcePart converts a file source to file data referencing its Gemini file, which must
+// have been looked up beforehand; see ResolveFileSources. URL sources are always inlined by
+// ResolveURLSources, so one that is left is an error.
+func fileSourcePart(source *types.AnthropicSource) (types.GeminiPart, error) {
+	if source.Type != types.SourceTypeFile {
+		return types.GeminiPart{}, NewInvalidRequestError("URL source %s was not fetched", source.URL)
+	}
+	if source.GeminiFileURI == "" {
+		return types.GeminiPart{}, NewInvalidRequestError("file source %s was not resolved", source.FileID)
+	}
+	return types.GeminiPart{
+		FileData: &types.GeminiFileData{MimeType: source.MediaType, FileURI: source.GeminiFileURI},
+	}, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// testFiles resolves a few uploaded files for ResolveFileSources
+func testFiles(_ context.Context, fileID string) (string, string, error) {
+	switch fileID {
+	case "file_pdf":
+		return "https://generativelanguage.googleapis.com/v1beta/files/pdf", types.MediaTypePDF, nil
+	case "file_png":
+		return "https://generativelanguage.googleapis.com/v1beta/files/png", "image/png", nil
+	default:
+		return "", "", NewInvalidRequestError("file %s not found", fileID)
+	}
+}
+
+func TestResolveFileSources(t *testing.T) {
+	messages := documentMessages(t, `{"type": "document", "title": "Report", "source": {"type": "file", "file_id": "file_pdf"}}`)
+	messages = append(messages, toolResultMessages(t, `"content": [{"type": "image", "source": {"type": "file", "file_id": "file_png"}}]`)...)
+
+	if err := ResolveFileSources(context.Background(), messages, testFiles); err != nil {
+		t.Fatalf("ResolveFileSources failed: %v", err)
+	}
+
+	contents, err := ToCustomGeminiContents(messages)
+	if err != nil {
+		t.Fatalf("ToCustomGeminiContents failed: %v", err)
+	}
+	parts := contents[0].Parts
+	if len(parts) != 3 || parts[0].Text != "Document title: Report" {
+		t.Fatalf("expected title, document and question, got %+v", parts)
+	}
+	if fileData := parts[1].FileData; fileData == nil || fileData.MimeType != types.MediaTypePDF ||
+		fileData.FileURI != "https://generativelanguage.googleapis.com/v1beta/files/pdf" {
+		t.Errorf("expected file data for the PDF, got %+v", parts[1])
+	}
+
+	response := contents[3].Parts[0].FunctionResponse
+	if len(response.Parts) != 1 || response.Parts[0].FileData == nil || response.Parts[0].FileData.MimeType != "image/png" {
+		t.Fatalf("expected file data in the function response, got %+v", response)
+	}
+
+	// Models without multimodal function responses get the file after the response
+	FlattenFunctionResponses(contents)
+	if parts := contents[3].Parts; len(parts) != 3 || parts[2].FileData == nil {
+		t.Errorf("expected the file to be moved after the function response, got %+v", parts)
+	}
+
+	sdkContents, err := ToGeminiContents(messages)
+	if err != nil {
+		t.Fatalf("ToGeminiContents failed: %v", err)
+	}
+	if fileData, ok := sdkContents[0].Parts[1].(genai.FileData); !ok || fileData.URI != "https://generativelanguage.googleapis.com/v1beta/files/pdf" {
+		t.Errorf("expected SDK file data, got %#v", sdkContents[0].Parts[1])
+	}
+}
+
+func TestResolveFileSources_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   string
+		message string
+	}{
+		{name: "unknown file", block: `{"type": "document", "source": {"type": "file", "file_id": "file_missing"}}`, message: "file_missing not found"},
+		{name: "no file_id", block: `{"type": "document", "source": {"type": "file"}}`, message: "without a file_id"},
+		{name: "PDF as image", block: `{"type": "image", "source": {"type": "file", "file_id": "file_pdf"}}`, message: "can't be used as an image"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ResolveFileSources(context.Background(), documentMessages(t, tt.block), testFiles)
+			var clientErr *ClientError
+			if !errors.As(err, &clientErr) || clientErr.StatusCode != http.StatusBadRequest {
+				t.Fatalf("expected an invalid request error, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("expected error containing %q, got %q", tt.message, err.Error())
+			}
+		})
+	}
+}
+
+func TestConvertDocument_UnresolvedFile(t *testing.T) {
+	// The Gemini file can't be supplied by the client
+	for _, block := range []string{
+		`{"type": "document", "source": {"type": "file", "file_id": "file_pdf"}}`,
+		`{"type": "document", "source": {"type": "file", "file_id": "file_pdf", "url": "https://generativelanguage.googleapis.com/v1beta/files/pdf", "GeminiFileURI": "https://generativelanguage.googleapis.com/v1beta/files/pdf"}}`,
+	} {
+		_, err := ToCustomGeminiContents(documentMessages(t, block))
+		if err == nil || !strings.Contains(err.Error(), "file source file_pdf was not resolved") {
+			t.Errorf("expected an error for an unresolved file source, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// fileStatePollInterval is how often UploadFile checks on a file Gemini is still
+// processing. It is a variable so that tests can shorten it.
+var fileStatePollInterval = time.Second
+
+// UploadFile uploads data to the Gemini File API using the resumable upload protocol and
+// waits until Gemini has finished processing it. Transient failures restart the upload
+// according to the client's retry policy.
+func (c *GeminiHTTPClient) UploadFile(ctx context.Context, displayName, mimeType string, data []byte) (*types.GeminiFile, error) {
+	startURL, err := c.uploadURL()
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := json.Marshal(map[string]interface{}{
+		"file": map[string]string{"display_name": displayName},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal file metadata: %w", err)
+	}
+
+	var file types.GeminiFile
+	err = Retry(ctx, c.retry, func(ctx context.Context) error {
+		// Start an upload session
+		header := http.Header{}
+		header.Set("Content-Type", "application/json")
+		header.Set("X-Goog-Upload-Protocol", "resumable")
+		header.Set("X-Goog-Upload-Command", "start")
+		header.Set("X-Goog-Upload-Header-Content-Length", strconv.Itoa(len(data)))
+		header.Set("X-Goog-Upload-Header-Content-Type", mimeType)
+		httpResp, err := c.send(ctx, http.MethodPost, startURL, bytes.NewReader(metadata), header)
+		if err != nil {
+			return err
+		}
+		httpResp.Body.Close()
+
+		sessionURL := httpResp.Header.Get("X-Goog-Upload-URL")
+		if sessionURL == "" {
+			return errors.New("gemini did not return an upload URL")
+		}
+
+		// Send the content in a single chunk and finalize the upload
+		header = http.Header{}
+		header.Set("X-Goog-Upload-Command", "upload, finalize")
+		header.Set("X-Goog-Upload-Offset", "0")
+		httpResp, err = c.send(ctx, http.MethodPost, sessionURL, bytes.NewReader(data), header)
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+
+		var result struct {
+			File types.GeminiFile `json:"file"`
+		}
+		if err := json.NewDecoder(httpResp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed to unmarshal upload response: %w", err)
+		}
+		file = result.File
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.waitForFile(ctx, &file)
+}
+
+// GetFile returns the metadata of a file in the Gemini File API. name has the form
+// "files/{id}".
+func (c *GeminiHTTPClient) GetFile(ctx context.Context, name string) (*types.GeminiFile, error) {
+	var file types.GeminiFile
+	err := Retry(ctx, c.retry, func(ctx context.Context) error {
+		httpResp, err := c.send(ctx, http.MethodGet, c.fileURL(name), nil, nil)
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+
+		if err := json.NewDecoder(httpResp.Body).Decode(&file); err != nil {
+			return fmt.Errorf("failed to unmarshal file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// DeleteFile deletes a file from the Gemini File API
+func (c *GeminiHTTPClient) DeleteFile(ctx context.Context, name string) error {
+	return Retry(ctx, c.retry, func(ctx context.Context) error {
+		httpResp, err := c.send(ctx, http.MethodDelete, c.fileURL(name), nil, nil)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, httpResp.Body)
+		return httpResp.Body.Close()
+	})
+}
+
+// waitForFile polls a file until Gemini has finished processing it. Images and documents
+// are usually active as soon as the upload completes.
+func (c *GeminiHTTPClient) waitForFile(ctx context.Context, file *types.GeminiFile) (*types.GeminiFile, error) {
+	for file.State == types.FileStateProcessing {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(fileStatePollInterval):
+		}
+
+		var err error
+		if file, err = c.GetFile(ctx, file.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	if file.State == types.FileStateFailed {
+		message := "unknown error"
+		if file.Error != nil {
+			message = file.Error.Message
+		}
+		return nil, NewInvalidRequestError("gemini failed to process file: %s", message)
+	}
+	return file, nil
+}
+
+// uploadURL returns the endpoint that starts resumable uploads. It lives under /upload on
+// the same host as the rest of the API, e.g. https://generativelanguage.googleapis.com/upload/v1beta/files.
+func (c *GeminiHTTPClient) uploadURL() (string, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	u.Path = "/upload" + strings.TrimSuffix(u.Path, "/") + "/files"
+	u.RawQuery = url.Values{"key": {c.apiKey}}.Encode()
+	return u.String(), nil
+}
+
+func (c *GeminiHTTPClient) fileURL(name string) string {
+	return fmt.Sprintf("%s/%s?key=%s", c.baseURL, name, c.apiKey)
+}
@@ -0,0 +1,211 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// filesAPI is a stand-in for the Gemini File API. Uploads are reported as PROCESSING for
+// the first processingLookups lookups and finalState afterwards.
+type filesAPI struct {
+	t                 *testing.T
+	processingLookups int
+	finalState        string
+
+	mu       sync.Mutex
+	sessions map[string]string // Upload ID to the declared content type
+	files    map[string]*types.GeminiFile
+	content  map[string]string
+	lookups  map[string]int
+}
+
+func newFilesAPI(t *testing.T) (*filesAPI, *GeminiHTTPClient) {
+	t.Helper()
+	api := &filesAPI{
+		t:          t,
+		finalState: types.FileStateActive,
+		sessions:   map[string]string{},
+		files:      map[string]*types.GeminiFile{},
+		content:    map[string]string{},
+		lookups:    map[string]int{},
+	}
+	ts := httptest.NewServer(api)
+	t.Cleanup(ts.Close)
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL + "/v1beta")
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	return api, client
+}
+
+func (a *filesAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/upload/v1beta/files" && r.Header.Get("X-Goog-Upload-Command") == "start":
+		if r.URL.Query().Get("key") != "test-key" || r.Header.Get("X-Goog-Upload-Protocol") != "resumable" {
+			a.t.Errorf("unexpected upload start: %s %v", r.URL, r.Header)
+		}
+		var metadata struct {
+			File struct {
+				DisplayName string `json:"display_name"`
+			} `json:"file"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&metadata); err != nil || metadata.File.DisplayName == "" {
+			a.t.Errorf("expected file metadata with a display name (err=%v)", err)
+		}
+		id := fmt.Sprintf("upload-%d", len(a.sessions)+1)
+		a.sessions[id] = r.Header.Get("X-Goog-Upload-Header-Content-Type")
+		w.Header().Set("X-Goog-Upload-URL", "http://"+r.Host+"/upload/v1beta/files?upload_id="+id)
+
+	case r.URL.Path == "/upload/v1beta/files" && r.Header.Get("X-Goog-Upload-Command") == "upload, finalize":
+		mimeType, ok := a.sessions[r.URL.Query().Get("upload_id")]
+		if !ok || r.Header.Get("X-Goog-Upload-Offset") != "0" {
+			http.Error(w, "unknown upload session", http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		name := fmt.Sprintf("files/f%d", len(a.files)+1)
+		file := &types.GeminiFile{
+			Name:           name,
+			MimeType:       mimeType,
+			SizeBytes:      int64(len(data)),
+			ExpirationTime: time.Now().Add(48 * time.Hour).UTC(),
+			URI:            "https://generativelanguage.googleapis.com/v1beta/" + name,
+			State:          types.FileStateProcessing,
+		}
+		if a.processingLookups == 0 {
+			file.State = a.finalState
+		}
+		a.files[name] = file
+		a.content[name] = string(data)
+		json.NewEncoder(w).Encode(map[string]interface{}{"file": file})
+
+	case strings.HasPrefix(r.URL.Path, "/v1beta/files/"):
+		name := strings.TrimPrefix(r.URL.Path, "/v1beta/")
+		file, ok := a.files[name]
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"error": {"code": 403, "message": "You do not have permission to access the File %s or it may not exist.", "status": "PERMISSION_DENIED"}}`, name)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(a.files, name)
+			fmt.Fprint(w, "{}")
+			return
+		}
+		if a.lookups[name]++; a.lookups[name] >= a.processingLookups {
+			file.State = a.finalState
+			if a.finalState == types.FileStateFailed {
+				file.Error = &types.GeminiFileError{Code: 400, Message: "unsupported file"}
+			}
+		}
+		json.NewEncoder(w).Encode(file)
+
+	default:
+		a.t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		http.NotFound(w, r)
+	}
+}
+
+func TestGeminiHTTPClient_UploadFile(t *testing.T) {
+	api, client := newFilesAPI(t)
+
+	file, err := client.UploadFile(context.Background(), "report.pdf", "application/pdf", []byte("%PDF-1.4\n"))
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if file.Name != "files/f1" || file.State != types.FileStateActive || file.MimeType != "application/pdf" || file.SizeBytes != 9 {
+		t.Errorf("unexpected file: %+v", file)
+	}
+	if !IsGeminiFileURI(file.URI) {
+		t.Errorf("expected a Gemini file URI, got %q", file.URI)
+	}
+	if api.content["files/f1"] != "%PDF-1.4\n" {
+		t.Errorf("unexpected uploaded content: %q", api.content["files/f1"])
+	}
+}
+
+func TestGeminiHTTPClient_UploadFileWaitsForProcessing(t *testing.T) {
+	defer func(interval time.Duration) { fileStatePollInterval = interval }(fileStatePollInterval)
+	fileStatePollInterval = time.Millisecond
+
+	api, client := newFilesAPI(t)
+	api.processingLookups = 2
+
+	file, err := client.UploadFile(context.Background(), "clip.mp4", "video/mp4", []byte("video"))
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if file.State != types.FileStateActive || api.lookups[file.Name] != 2 {
+		t.Errorf("expected the file to be active after 2 lookups, got %s after %d", file.State, api.lookups[file.Name])
+	}
+
+	api.finalState = types.FileStateFailed
+	_, err = client.UploadFile(context.Background(), "broken.mp4", "video/mp4", []byte("video"))
+	var clientErr *ClientError
+	if !errors.As(err, &clientErr) || !strings.Contains(err.Error(), "unsupported file") {
+		t.Errorf("expected an invalid request error for a failed file, got %v", err)
+	}
+}
+
+func TestGeminiHTTPClient_GetAndDeleteFile(t *testing.T) {
+	_, client := newFilesAPI(t)
+	ctx := context.Background()
+
+	uploaded, err := client.UploadFile(ctx, "notes.txt", "text/plain", []byte("notes"))
+	if err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+
+	file, err := client.GetFile(ctx, uploaded.Name)
+	if err != nil || file.URI != uploaded.URI {
+		t.Fatalf("expected %s, got %+v (err=%v)", uploaded.URI, file, err)
+	}
+
+	if err := client.DeleteFile(ctx, uploaded.Name); err != nil {
+		t.Fatalf("DeleteFile failed: %v", err)
+	}
+	_, err = client.GetFile(ctx, uploaded.Name)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the deleted file to be gone, got %v", err)
+	}
+}
+
+func TestGeminiHTTPClient_UploadURL(t *testing.T) {
+	client := NewGeminiHTTPClient("test-key")
+	got, err := client.uploadURL()
+	if err != nil {
+		t.Fatalf("uploadURL failed: %v", err)
+	}
+	if want := "https://generativelanguage.googleapis.com/upload/v1beta/files?key=test-key"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
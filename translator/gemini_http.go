@@ -15,6 +15,7 @@
 package translator
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -29,6 +30,7 @@ import (
 type GeminiHTTPClient struct {
 	apiKey  string
 	baseURL string
+	retry   RetryPolicy
 }
 
 // NewGeminiHTTPClient creates a new HTTP client for the Gemini API
@@ -36,15 +38,28 @@ func NewGeminiHTTPClient(apiKey string) *GeminiHTTPClient {
 	return &GeminiHTTPClient{
 		apiKey:  apiKey,
 		baseURL: "https://generativelanguage.googleapis.com/v1beta",
+		retry:   DefaultRetryPolicy(),
 	}
 }
 
+// SetBaseURL overrides the Gemini API base URL (useful for testing against a local server)
+func (c *GeminiHTTPClient) SetBaseURL(baseURL string) {
+	c.baseURL = baseURL
+}
+
+// SetRetryPolicy configures how transient Gemini failures are retried
+func (c *GeminiHTTPClient) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
 // GenerateContentRequest represents a request to the Gemini API
 type GenerateContentRequest struct {
 	Contents          []types.GeminiContent `json:"contents"`
 	Tools             []GeminiToolWrapper   `json:"tools,omitempty"`
+	ToolConfig        *ToolConfig           `json:"toolConfig,omitempty"`
 	SystemInstruction *types.GeminiContent  `json:"systemInstruction,omitempty"`
 	GenerationConfig  *GenerationConfig     `json:"generationConfig,omitempty"`
+	CachedContent     string                `json:"cachedContent,omitempty"` // "cachedContents/{id}" holding the prompt prefix
 }
 
 // GeminiToolWrapper wraps function declarations
@@ -61,14 +76,26 @@ type FunctionDeclaration struct {
 
 // GenerationConfig represents generation configuration
 type GenerationConfig struct {
-	MaxOutputTokens *int32   `json:"maxOutputTokens,omitempty"`
-	Temperature     *float32 `json:"temperature,omitempty"`
+	MaxOutputTokens *int32          `json:"maxOutputTokens,omitempty"`
+	Temperature     *float32        `json:"temperature,omitempty"`
+	TopP            *float32        `json:"topP,omitempty"`
+	TopK            *int32          `json:"topK,omitempty"`
+	ThinkingConfig  *ThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+// ThinkingConfig controls Gemini's thinking (reasoning) behavior. Gemini 2.5 models
+// take a token budget; Gemini 3 models take a thinking level.
+type ThinkingConfig struct {
+	IncludeThoughts bool   `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int32 `json:"thinkingBudget,omitempty"`
+	ThinkingLevel   string `json:"thinkingLevel,omitempty"`
 }
 
 // GenerateContentResponse represents a response from the Gemini API
 type GenerateContentResponse struct {
-	Candidates    []Candidate    `json:"candidates,omitempty"`
-	UsageMetadata *UsageMetadata `json:"usageMetadata,omitempty"`
+	Candidates     []Candidate     `json:"candidates,omitempty"`
+	PromptFeedback *PromptFeedback `json:"promptFeedback,omitempty"`
+	UsageMetadata  *UsageMetadata  `json:"usageMetadata,omitempty"`
 }
 
 // Candidate represents a response candidate
@@ -77,14 +104,19 @@ type Candidate struct {
 	FinishReason string               `json:"finishReason,omitempty"`
 }
 
-// UsageMetadata represents usage statistics
+// UsageMetadata represents usage statistics; see ToAnthropicUsage for how they are
+// reported to Anthropic clients
 type UsageMetadata struct {
-	PromptTokenCount     int32 `json:"promptTokenCount"`
-	CandidatesTokenCount int32 `json:"candidatesTokenCount"`
-	TotalTokenCount      int32 `json:"totalTokenCount"`
+	PromptTokenCount        int32 `json:"promptTokenCount"`                  // Includes cached content
+	CachedContentTokenCount int32 `json:"cachedContentTokenCount,omitempty"` // Read from a context cache
+	CandidatesTokenCount    int32 `json:"candidatesTokenCount"`
+	ToolUsePromptTokenCount int32 `json:"toolUsePromptTokenCount,omitempty"` // Results of built-in tools
+	ThoughtsTokenCount      int32 `json:"thoughtsTokenCount,omitempty"`
+	TotalTokenCount         int32 `json:"totalTokenCount"`
 }
 
-// GenerateContent makes a generateContent API call with thought signature support
+// GenerateContent makes a generateContent API call with thought signature support.
+// Transient failures are retried according to the client's retry policy.
 func (c *GeminiHTTPClient) GenerateContent(ctx context.Context, model string, req *GenerateContentRequest) (*GenerateContentResponse, error) {
 	url := fmt.Sprintf("%s/models/%s:generateContent?key=%s", c.baseURL, model, c.apiKey)
 
@@ -94,37 +126,150 @@ func (c *GeminiHTTPClient) GenerateContent(ctx context.Context, model string, re
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+	var respBody []byte
+	err = Retry(ctx, c.retry, func(ctx context.Context) error {
+		httpResp, err := c.post(ctx, url, jsonData, "application/json")
+		if err != nil {
+			return err
+		}
+		defer httpResp.Body.Close()
+
+		// Read response
+		respBody, err = io.ReadAll(httpResp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
+	// Unmarshal response
+	var geminiResp GenerateContentResponse
+	if err := json.Unmarshal(respBody, &geminiResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
 
-	// Make request
-	httpResp, err := http.DefaultClient.Do(httpReq)
+	return &geminiResp, nil
+}
+
+// StreamGenerateContent makes a streamGenerateContent API call using server-sent events.
+// The callback is invoked once for each chunk received from Gemini; returning an error
+// from the callback aborts the stream and returns that error.
+//
+// Failures to open the stream are retried according to the client's retry policy. Once
+// chunks have been delivered to the callback, errors are returned as-is.
+func (c *GeminiHTTPClient) StreamGenerateContent(ctx context.Context, model string, req *GenerateContentRequest, fn func(*GenerateContentResponse) error) error {
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse&key=%s", c.baseURL, model, c.apiKey)
+
+	// Marshal request
+	jsonData, err := json.Marshal(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var httpResp *http.Response
+	err = Retry(ctx, c.retry, func(ctx context.Context) error {
+		httpResp, err = c.post(ctx, url, jsonData, "text/event-stream")
+		return err
+	})
+	if err != nil {
+		return err
 	}
 	defer httpResp.Body.Close()
 
-	// Read response
-	respBody, err := io.ReadAll(httpResp.Body)
+	return readServerSentEvents(httpResp.Body, func(data []byte) error {
+		var chunk GenerateContentResponse
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("failed to unmarshal stream chunk: %w", err)
+		}
+		return fn(&chunk)
+	})
+}
+
+// post sends a JSON request body to Gemini. Non-200 responses are returned as an *APIError;
+// on success the caller is responsible for closing the response body.
+func (c *GeminiHTTPClient) post(ctx context.Context, url string, jsonData []byte, accept string) (*http.Response, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", accept)
+	return c.send(ctx, http.MethodPost, url, bytes.NewReader(jsonData), header)
+}
+
+// send makes a request to Gemini. Non-200 responses are returned as an *APIError; on
+// success the caller is responsible for closing the response body.
+func (c *GeminiHTTPClient) send(ctx context.Context, method, url string, body io.Reader, header http.Header) (*http.Response, error) {
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		httpReq.Header[key] = values
+	}
+
+	// Make request
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 
 	// Check for errors
 	if httpResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("gemini API error: %s (status %d): %s", httpResp.Status, httpResp.StatusCode, string(respBody))
+		defer httpResp.Body.Close()
+		respBody, _ := io.ReadAll(httpResp.Body)
+		return nil, newAPIError(httpResp, respBody)
 	}
 
-	// Unmarshal response
-	var geminiResp GenerateContentResponse
-	if err := json.Unmarshal(respBody, &geminiResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	return httpResp, nil
+}
+
+// maxEventSize bounds the size of a single server-sent event line. Chunks carrying
+// thought signatures or large function call arguments can exceed bufio's default.
+const maxEventSize = 16 * 1024 * 1024
+
+// readServerSentEvents parses a text/event-stream body and invokes fn with the data
+// payload of each event. Multi-line data fields are joined with newlines per the
+// SSE specification.
+func readServerSentEvents(r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+
+	var data []byte
+	dispatch := func() error {
+		if len(data) == 0 {
+			return nil
+		}
+		payload := data
+		data = nil
+		return fn(payload)
 	}
 
-	return &geminiResp, nil
+	for scanner.Scan() {
+		line := bytes.TrimRight(scanner.Bytes(), "\r")
+		if len(line) == 0 {
+			if err := dispatch(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			// Ignore comments and other fields (event, id, retry)
+			continue
+		}
+		value = bytes.TrimPrefix(value, []byte(" "))
+		if len(data) > 0 {
+			data = append(data, '\n')
+		}
+		data = append(data, value...)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	// Dispatch any trailing event that wasn't terminated by a blank line
+	return dispatch()
 }
@@ -16,6 +16,10 @@ package translator
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/savaki/twin-in-disguise/types"
@@ -38,6 +42,7 @@ func TestNewGeminiHTTPClient(t *testing.T) {
 
 func TestGeminiHTTPClient_GenerateContent_InvalidAPIKey(t *testing.T) {
 	client := NewGeminiHTTPClient("invalid-key")
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
 
 	req := &GenerateContentRequest{
 		Contents: []types.GeminiContent{
@@ -58,3 +63,80 @@ func TestGeminiHTTPClient_GenerateContent_InvalidAPIKey(t *testing.T) {
 		t.Error("expected error for invalid API key")
 	}
 }
+
+func TestGeminiHTTPClient_StreamGenerateContent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("expected alt=sse, got %q", r.URL.Query().Get("alt"))
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"Hel\"}]}}]}\r\n\r\n")
+		fmt.Fprint(w, "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"lo\"}]}, \"finishReason\": \"STOP\"}]}\r\n\r\n")
+	}))
+	defer ts.Close()
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+
+	var texts []string
+	err := client.StreamGenerateContent(context.Background(), "gemini-2.0-flash", &GenerateContentRequest{}, func(chunk *GenerateContentResponse) error {
+		texts = append(texts, chunk.Candidates[0].Content.Parts[0].Text)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamGenerateContent failed: %v", err)
+	}
+
+	if strings.Join(texts, "") != "Hello" {
+		t.Errorf("expected chunks to spell 'Hello', got %v", texts)
+	}
+}
+
+func TestGeminiHTTPClient_StreamGenerateContent_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": {"code": 400, "message": "bad request"}}`, http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+
+	called := false
+	err := client.StreamGenerateContent(context.Background(), "gemini-2.0-flash", &GenerateContentRequest{}, func(chunk *GenerateContentResponse) error {
+		called = true
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected error for non-200 response")
+	}
+	if called {
+		t.Error("expected callback not to be invoked")
+	}
+}
+
+func TestReadServerSentEvents(t *testing.T) {
+	input := ": comment\nevent: message\ndata: first\n\ndata: multi\ndata: line\n\ndata: trailing"
+
+	var got []string
+	err := readServerSentEvents(strings.NewReader(input), func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("readServerSentEvents failed: %v", err)
+	}
+
+	expected := []string{"first", "multi\nline", "trailing"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("event %d: expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ResponseModel decides which model name responses carry when a request's model is
+// mapped to another one
+type ResponseModel string
+
+// Response model names
+const (
+	ResponseModelRequested ResponseModel = "requested" // The model named in the request
+	ResponseModelResolved  ResponseModel = "resolved"  // The Gemini model that served it
+)
+
+// ParseResponseModel validates a response model name
+func ParseResponseModel(name string) (ResponseModel, error) {
+	switch mode := ResponseModel(name); mode {
+	case ResponseModelRequested, ResponseModelResolved:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown response model %q (expected %s or %s)", name, ResponseModelRequested, ResponseModelResolved)
+}
+
+// ModelRoute maps model names matching Pattern to the Gemini model Model. Pattern is
+// either an exact name or a glob as understood by path.Match, e.g. "claude-*-haiku-*".
+type ModelRoute struct {
+	Pattern string
+	Model   string
+}
+
+// IsGlob reports whether the route matches more than one name
+func (r ModelRoute) IsGlob() bool {
+	return strings.ContainsAny(r.Pattern, `*?[\`)
+}
+
+// ModelMap routes requested model names to Gemini models, so that clients hard-coding
+// Claude model names can be served without reconfiguration. An exact name takes
+// precedence over globs, and globs are tried in the order they were added. Names that
+// match no route are used as they are.
+type ModelMap struct {
+	routes []ModelRoute
+}
+
+// NewModelMap creates a ModelMap with the given routes
+func NewModelMap(routes ...ModelRoute) (*ModelMap, error) {
+	m := &ModelMap{}
+	for _, route := range routes {
+		if err := m.Add(route); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Add appends a route
+func (m *ModelMap) Add(route ModelRoute) error {
+	if route.Pattern == "" || route.Model == "" {
+		return fmt.Errorf("model route %q -> %q needs both a pattern and a model", route.Pattern, route.Model)
+	}
+	if _, err := path.Match(route.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid model pattern %q: %w", route.Pattern, err)
+	}
+	m.routes = append(m.routes, route)
+	return nil
+}
+
+// ParseModelRoutes parses routes written as "pattern=model", separated by commas or
+// newlines. Blank entries and lines starting with # are ignored, so the same syntax
+// serves both the --model-map flag and a model map file.
+func ParseModelRoutes(r io.Reader) ([]ModelRoute, error) {
+	var routes []ModelRoute
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		for _, entry := range strings.Split(text, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			pattern, model, ok := strings.Cut(entry, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected pattern=model, got %q", line, entry)
+			}
+			routes = append(routes, ModelRoute{Pattern: strings.TrimSpace(pattern), Model: strings.TrimSpace(model)})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// Resolve returns the Gemini model for a requested model name, and whether a route
+// matched
+func (m *ModelMap) Resolve(name string) (string, bool) {
+	if m == nil {
+		return name, false
+	}
+	for _, route := range m.routes {
+		if !route.IsGlob() && route.Pattern == name {
+			return route.Model, true
+		}
+	}
+	for _, route := range m.routes {
+		if matched, _ := path.Match(route.Pattern, name); matched && route.IsGlob() {
+			return route.Model, true
+		}
+	}
+	return name, false
+}
+
+// Aliases returns the routes for exact names, in the order they were added. Glob routes
+// are left out since they can't be listed, as are names repeated by later routes, which
+// never take effect.
+func (m *ModelMap) Aliases() []ModelRoute {
+	if m == nil {
+		return nil
+	}
+	var aliases []ModelRoute
+	seen := map[string]bool{}
+	for _, route := range m.routes {
+		if !route.IsGlob() && !seen[route.Pattern] {
+			seen[route.Pattern] = true
+			aliases = append(aliases, route)
+		}
+	}
+	return aliases
+}
+
+// Len returns the number of routes
+func (m *ModelMap) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.routes)
+}
@@ -0,0 +1,106 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseModelRoutes(t *testing.T) {
+	routes, err := ParseModelRoutes(strings.NewReader(`
+# Claude names used by Claude Code
+claude-*-haiku-* = gemini-2.5-flash, claude-sonnet-4-5=gemini-3-pro-preview
+
+claude-opus-* = gemini-3-pro-preview
+`))
+	if err != nil {
+		t.Fatalf("ParseModelRoutes failed: %v", err)
+	}
+	expected := []ModelRoute{
+		{Pattern: "claude-*-haiku-*", Model: "gemini-2.5-flash"},
+		{Pattern: "claude-sonnet-4-5", Model: "gemini-3-pro-preview"},
+		{Pattern: "claude-opus-*", Model: "gemini-3-pro-preview"},
+	}
+	if !slices.Equal(routes, expected) {
+		t.Errorf("got %+v, want %+v", routes, expected)
+	}
+
+	if _, err := ParseModelRoutes(strings.NewReader("a=b\nclaude-sonnet")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
+
+func TestModelMap_Resolve(t *testing.T) {
+	m, err := NewModelMap(
+		ModelRoute{Pattern: "claude-*", Model: "gemini-2.5-pro"},
+		ModelRoute{Pattern: "claude-*-haiku-*", Model: "gemini-2.5-flash"},
+		ModelRoute{Pattern: "claude-sonnet-4-5", Model: "gemini-3-pro-preview"},
+		ModelRoute{Pattern: "claude-sonnet-4-5", Model: "gemini-2.0-flash"},
+	)
+	if err != nil {
+		t.Fatalf("NewModelMap failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+		routed   bool
+	}{
+		{name: "claude-sonnet-4-5", expected: "gemini-3-pro-preview", routed: true}, // Exact names win over globs
+		{name: "claude-3-5-haiku-latest", expected: "gemini-2.5-pro", routed: true}, // The first matching glob wins
+		{name: "claude-opus-4-1", expected: "gemini-2.5-pro", routed: true},
+		{name: "gemini-2.5-flash", expected: "gemini-2.5-flash"},
+	}
+	for _, tt := range tests {
+		if got, routed := m.Resolve(tt.name); got != tt.expected || routed != tt.routed {
+			t.Errorf("Resolve(%s) = %s, %t, want %s, %t", tt.name, got, routed, tt.expected, tt.routed)
+		}
+	}
+
+	aliases := m.Aliases()
+	if len(aliases) != 1 || aliases[0].Model != "gemini-3-pro-preview" {
+		t.Errorf("expected the first exact route as the only alias, got %+v", aliases)
+	}
+
+	var empty *ModelMap
+	if got, routed := empty.Resolve("claude-sonnet-4-5"); got != "claude-sonnet-4-5" || routed || empty.Len() != 0 {
+		t.Errorf("expected a nil map to route nothing, got %s, %t", got, routed)
+	}
+}
+
+func TestNewModelMap_Invalid(t *testing.T) {
+	for _, route := range []ModelRoute{
+		{Pattern: "claude-[", Model: "gemini-2.5-flash"},
+		{Pattern: "claude-*", Model: ""},
+		{Pattern: "", Model: "gemini-2.5-flash"},
+	} {
+		if _, err := NewModelMap(route); err == nil {
+			t.Errorf("expected %+v to be rejected", route)
+		}
+	}
+}
+
+func TestParseResponseModel(t *testing.T) {
+	for _, name := range []string{"requested", "resolved"} {
+		if mode, err := ParseResponseModel(name); err != nil || string(mode) != name {
+			t.Errorf("ParseResponseModel(%s) = %s, %v", name, mode, err)
+		}
+	}
+	if _, err := ParseResponseModel("gemini"); err == nil {
+		t.Error("expected an unknown response model to be rejected")
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultModelListTTL is how long the list of Gemini models is reused before it is
+// fetched again
+const DefaultModelListTTL = time.Hour
+
+// modelListPageSize is the number of models requested per models.list call
+const modelListPageSize = 1000
+
+// modelListTimeout bounds a refresh of the model list, including retries
+const modelListTimeout = 10 * time.Second
+
+// modelListRetryBackoff is how long after a failed refresh the model list is fetched again
+const modelListRetryBackoff = time.Minute
+
+// listModelsResponse is a page of models.list
+type listModelsResponse struct {
+	Models        []types.GeminiModel `json:"models"`
+	NextPageToken string              `json:"nextPageToken,omitempty"`
+}
+
+// ListModels returns every model available to the API key, following pagination.
+// Transient failures are retried according to the client's retry policy.
+func (c *GeminiHTTPClient) ListModels(ctx context.Context) ([]types.GeminiModel, error) {
+	var (
+		models    []types.GeminiModel
+		pageToken string
+	)
+	for {
+		query := url.Values{"key": {c.apiKey}, "pageSize": {fmt.Sprint(modelListPageSize)}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		requestURL := c.baseURL + "/models?" + query.Encode()
+
+		var page listModelsResponse
+		err := Retry(ctx, c.retry, func(ctx context.Context) error {
+			httpResp, err := c.send(ctx, http.MethodGet, requestURL, nil, nil)
+			if err != nil {
+				return err
+			}
+			defer httpResp.Body.Close()
+
+			page = listModelsResponse{}
+			if err := json.NewDecoder(httpResp.Body).Decode(&page); err != nil {
+				return fmt.Errorf("failed to unmarshal models: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		models = append(models, page.Models...)
+		if page.NextPageToken == "" {
+			return models, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// ModelID returns the ID of a Gemini model, i.e. its name without the "models/" prefix
+func ModelID(model types.GeminiModel) string {
+	return strings.TrimPrefix(model.Name, "models/")
+}
+
+// ModelCatalog keeps the list of Gemini models that support generateContent, fetching it
+// from models.list at most once per TTL. Once the list has been fetched, a stale list is
+// served while a single background refresh runs, so requests never wait on models.list. If
+// a refresh fails, the previous list is kept and the next attempt waits for a back-off.
+type ModelCatalog struct {
+	client  *GeminiHTTPClient
+	ttl     time.Duration
+	now     func() time.Time
+	refresh singleflight.Group
+
+	mu        sync.Mutex
+	models    []types.GeminiModel
+	fetchedAt time.Time
+	retryAt   time.Time // No fetch is attempted before this time after a failure
+	err       error     // Failure of the last fetch
+}
+
+// NewModelCatalog creates a ModelCatalog listing models through client
+func NewModelCatalog(client *GeminiHTTPClient, ttl time.Duration) *ModelCatalog {
+	return &ModelCatalog{
+		client: client,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Models returns the Gemini models that support generateContent, in the order Gemini
+// lists them. Only the first call, before any list has been fetched, waits for models.list.
+func (c *ModelCatalog) Models(ctx context.Context) ([]types.GeminiModel, error) {
+	c.mu.Lock()
+	now := c.now()
+	models, err := c.models, c.err
+	stale := models == nil || !now.Before(c.fetchedAt.Add(c.ttl))
+	backingOff := now.Before(c.retryAt)
+	c.mu.Unlock()
+
+	switch {
+	case !stale:
+		return models, nil
+	case backingOff && models == nil:
+		return nil, err
+	case backingOff:
+		return models, nil
+	case models != nil:
+		c.refresh.DoChan("", c.fetch) // The result channel is buffered, so it can be dropped
+		return models, nil
+	}
+
+	select {
+	case result := <-c.refresh.DoChan("", c.fetch):
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]types.GeminiModel), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch lists the models and records the outcome. It isn't tied to the request that
+// triggered it, since other requests share the result.
+func (c *ModelCatalog) fetch() (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), modelListTimeout)
+	defer cancel()
+	all, err := c.client.ListModels(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		if c.models != nil {
+			log.Printf("Warning: failed to refresh the model list, keeping the previous one: %v", err)
+		}
+		c.retryAt, c.err = c.now().Add(modelListRetryBackoff), err
+		return nil, err
+	}
+
+	models := []types.GeminiModel{}
+	for _, model := range all {
+		if slices.Contains(model.SupportedGenerationMethods, types.GenerationMethodGenerateContent) {
+			models = append(models, model)
+		}
+	}
+	c.models, c.fetchedAt, c.retryAt, c.err = models, c.now(), time.Time{}, nil
+	return models, nil
+}
+
+// Model returns the model with the given ID, which may also be given as "models/{id}".
+// ok is false if there is no such model.
+func (c *ModelCatalog) Model(ctx context.Context, id string) (model types.GeminiModel, ok bool, err error) {
+	models, err := c.Models(ctx)
+	if err != nil {
+		return types.GeminiModel{}, false, err
+	}
+	id = strings.TrimPrefix(id, "models/")
+	for _, model := range models {
+		if ModelID(model) == id {
+			return model, true, nil
+		}
+	}
+	return types.GeminiModel{}, false, nil
+}
+
+// SimilarModelIDs returns up to n of ids that are close to id: first those that contain
+// it or are contained in it, then those within a small edit distance, closest first
+func SimilarModelIDs(id string, ids []string, n int) []string {
+	type match struct {
+		id       string
+		contains bool
+		distance int
+	}
+	var matches []match
+	for _, candidate := range ids {
+		contains := strings.Contains(candidate, id) || strings.Contains(id, candidate)
+		distance := editDistance(id, candidate)
+		if contains || distance <= max(len(id)/4, 2) {
+			matches = append(matches, match{candidate, contains, distance})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int {
+		if a.contains != b.contains {
+			if a.contains {
+				return -1
+			}
+			return 1
+		}
+		return a.distance - b.distance
+	})
+
+	var similar []string
+	for _, m := range matches[:min(n, len(matches))] {
+		similar = append(similar, m.id)
+	}
+	return similar
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,226 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// modelPages are the pages served by newModelsAPI
+var modelPages = []string{
+	`{"models": [
+		{"name": "models/gemini-2.5-flash", "displayName": "Gemini 2.5 Flash", "supportedGenerationMethods": ["generateContent", "countTokens"]},
+		{"name": "models/text-embedding-004", "supportedGenerationMethods": ["embedContent"]}
+	], "nextPageToken": "page2"}`,
+	`{"models": [
+		{"name": "models/gemini-2.5-pro", "displayName": "Gemini 2.5 Pro", "supportedGenerationMethods": ["generateContent"]},
+		{"name": "models/gemini-3-pro-preview", "supportedGenerationMethods": ["generateContent"]}
+	]}`,
+}
+
+// newModelsAPI serves models.list in two pages and returns the number of calls made to
+// it; once failing is set, every call fails
+func newModelsAPI(t *testing.T) (calls *atomic.Int32, failing *atomic.Bool, client *GeminiHTTPClient) {
+	t.Helper()
+	calls, failing = &atomic.Int32{}, &atomic.Bool{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error": {"code": 500, "message": "failed", "status": "INTERNAL"}}`)
+			return
+		}
+		if r.URL.Path != "/models" || r.URL.Query().Get("key") != "test-key" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if r.URL.Query().Get("pageToken") == "page2" {
+			fmt.Fprint(w, modelPages[1])
+		} else {
+			fmt.Fprint(w, modelPages[0])
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	client = NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	return calls, failing, client
+}
+
+func TestGeminiHTTPClient_ListModels(t *testing.T) {
+	_, _, client := newModelsAPI(t)
+	models, err := client.ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels failed: %v", err)
+	}
+	if len(models) != 4 || models[3].Name != "models/gemini-3-pro-preview" {
+		t.Errorf("expected models from both pages, got %+v", models)
+	}
+}
+
+func TestModelCatalog(t *testing.T) {
+	calls, failing, client := newModelsAPI(t)
+	catalog := NewModelCatalog(client, time.Hour)
+	now := time.Now()
+	catalog.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	models, err := catalog.Models(ctx)
+	if err != nil {
+		t.Fatalf("Models failed: %v", err)
+	}
+	var ids []string
+	for _, model := range models {
+		ids = append(ids, ModelID(model))
+	}
+	if want := []string{"gemini-2.5-flash", "gemini-2.5-pro", "gemini-3-pro-preview"}; !slices.Equal(ids, want) {
+		t.Errorf("expected generateContent models %v, got %v", want, ids)
+	}
+
+	for _, id := range []string{"gemini-2.5-pro", "models/gemini-2.5-pro"} {
+		if model, ok, err := catalog.Model(ctx, id); err != nil || !ok || model.DisplayName != "Gemini 2.5 Pro" {
+			t.Errorf("Model(%s) = %+v, %t, %v", id, model, ok, err)
+		}
+	}
+	if _, ok, _ := catalog.Model(ctx, "text-embedding-004"); ok {
+		t.Error("expected a model without generateContent not to be found")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("expected the list to be fetched once (2 pages), got %d calls", calls.Load())
+	}
+
+	// A stale list is served while it is refreshed in the background, and a failed
+	// refresh keeps it
+	now = now.Add(time.Hour)
+	failing.Store(true)
+	if models, err := catalog.Models(ctx); err != nil || len(models) != 3 {
+		t.Errorf("expected the previous list while refreshing, got %d models and %v", len(models), err)
+	}
+	awaitRefresh(catalog)
+	if calls.Load() != 3 {
+		t.Errorf("expected one refresh attempt, got %d calls", calls.Load()-2)
+	}
+
+	// No refresh is attempted until the back-off has passed
+	if models, err := catalog.Models(ctx); err != nil || len(models) != 3 {
+		t.Errorf("expected the previous list after a failed refresh, got %d models and %v", len(models), err)
+	}
+	awaitRefresh(catalog)
+	if calls.Load() != 3 {
+		t.Errorf("expected no refresh during the back-off, got %d calls", calls.Load())
+	}
+
+	failing.Store(false)
+	now = now.Add(modelListRetryBackoff)
+	catalog.Models(ctx)
+	awaitRefresh(catalog)
+	if calls.Load() != 5 {
+		t.Errorf("expected a refresh once the back-off passed, got %d calls", calls.Load())
+	}
+
+	// Without a previous list the failure is returned, and isn't retried until the
+	// back-off has passed
+	failing.Store(true)
+	empty := NewModelCatalog(client, time.Hour)
+	if _, err := empty.Models(ctx); err == nil {
+		t.Error("expected an error when the list can't be fetched")
+	}
+	if _, err := empty.Models(ctx); err == nil || calls.Load() != 6 {
+		t.Errorf("expected the failure to be remembered, got %v after %d calls", err, calls.Load())
+	}
+}
+
+// awaitRefresh waits for a background refresh of catalog, if one is running
+func awaitRefresh(catalog *ModelCatalog) {
+	catalog.refresh.Do("", func() (interface{}, error) { return nil, nil })
+}
+
+func TestModelCatalog_ServesStaleListWithoutWaiting(t *testing.T) {
+	_, _, client := newModelsAPI(t)
+	catalog := NewModelCatalog(client, time.Hour)
+	now := time.Now()
+	catalog.now = func() time.Time { return now }
+	ctx := context.Background()
+	if _, err := catalog.Models(ctx); err != nil {
+		t.Fatalf("Models failed: %v", err)
+	}
+
+	// Hold a refresh open and check that readers aren't blocked by it
+	started, release := make(chan struct{}), make(chan struct{})
+	go catalog.refresh.Do("", func() (interface{}, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	defer close(release)
+	<-started
+
+	now = now.Add(time.Hour)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if models, err := catalog.Models(ctx); err != nil || len(models) != 3 {
+			t.Errorf("expected the stale list, got %d models and %v", len(models), err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Models waited for the refresh in progress")
+	}
+}
+
+func TestSimilarModelIDs(t *testing.T) {
+	ids := []string{"gemini-2.5-flash", "gemini-2.5-flash-lite", "gemini-2.5-pro", "gemini-3-pro-preview", "gemma-3-27b-it"}
+
+	tests := []struct {
+		id       string
+		expected []string
+	}{
+		{id: "gemini-2.5-flsh", expected: []string{"gemini-2.5-flash"}},
+		{id: "gemini-3-pro", expected: []string{"gemini-3-pro-preview", "gemini-2.5-pro"}},
+		{id: "gemini-2.5", expected: []string{"gemini-2.5-pro", "gemini-2.5-flash", "gemini-2.5-flash-lite"}},
+		{id: "claude-sonnet-4-5", expected: nil},
+	}
+	for _, tt := range tests {
+		if got := SimilarModelIDs(tt.id, ids, 3); !slices.Equal(got, tt.expected) {
+			t.Errorf("SimilarModelIDs(%s) = %v, want %v", tt.id, got, tt.expected)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"flash", "", 5},
+		{"flash", "flsh", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.distance {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.distance)
+		}
+	}
+}
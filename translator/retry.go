@@ -0,0 +1,192 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Default retry settings
+const (
+	DefaultRetryMaxAttempts    = 4
+	DefaultRetryInitialBackoff = 1 * time.Second
+	DefaultRetryMaxBackoff     = 30 * time.Second
+	DefaultRetryDeadline       = 2 * time.Minute
+)
+
+// retryInfoType identifies the google.rpc.RetryInfo entry in Gemini error details
+const retryInfoType = "type.googleapis.com/google.rpc.RetryInfo"
+
+// RetryPolicy controls how transient Gemini failures (429, 500, 502, 503, 504 and
+// connection errors) are retried.
+//
+// Retries use exponential backoff with full jitter, starting at InitialBackoff and capped
+// at MaxBackoff. When Gemini says how long to wait, via RetryInfo.retryDelay in the error
+// details or a Retry-After header, that delay is used instead. No retry is attempted if
+// the wait would end after Deadline, measured from the first attempt.
+type RetryPolicy struct {
+	MaxAttempts    int           // Total attempts, including the first; 1 disables retries
+	InitialBackoff time.Duration // Backoff before the first retry
+	MaxBackoff     time.Duration // Upper bound for a single computed backoff
+	Deadline       time.Duration // Overall time budget for all attempts; 0 means no limit
+}
+
+// DefaultRetryPolicy returns the retry policy used unless configured otherwise
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    DefaultRetryMaxAttempts,
+		InitialBackoff: DefaultRetryInitialBackoff,
+		MaxBackoff:     DefaultRetryMaxBackoff,
+		Deadline:       DefaultRetryDeadline,
+	}
+}
+
+// Retry calls fn until it succeeds, returns an error that isn't retryable, or the policy
+// is exhausted. Waiting stops as soon as ctx is done (e.g., the client disconnected), in
+// which case the context's error is returned.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= policy.MaxAttempts || !IsRetryable(ctx, err) {
+			return err
+		}
+
+		delay, ok := RetryDelay(err)
+		if !ok {
+			delay = policy.backoff(attempt)
+		}
+		if policy.Deadline > 0 && time.Since(start)+delay > policy.Deadline {
+			return err
+		}
+
+		log.Printf("Gemini request failed (attempt %d/%d), retrying in %s: %v", attempt, policy.MaxAttempts, delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns a jittered exponential backoff for the given (1-based) attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	limit := p.InitialBackoff
+	for i := 1; i < attempt && limit < p.MaxBackoff; i++ {
+		limit *= 2
+	}
+	if p.MaxBackoff > 0 && limit > p.MaxBackoff {
+		limit = p.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit) + 1
+}
+
+// IsRetryable reports whether err is a transient Gemini failure worth retrying. Errors
+// caused by ctx being done are never retryable.
+func IsRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return isRetryableStatus(apiErr.StatusCode)
+	}
+
+	var sdkErr *googleapi.Error
+	if errors.As(err, &sdkErr) {
+		return isRetryableStatus(sdkErr.Code)
+	}
+
+	// Connection failures (resets, refused connections, ...)
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RetryDelay returns the delay Gemini asked for, taken from RetryInfo.retryDelay in the
+// error details or, failing that, the Retry-After header
+func RetryDelay(err error) (time.Duration, bool) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return retryDelay(apiErr.Details, apiErr.Header)
+	}
+
+	var sdkErr *googleapi.Error
+	if errors.As(err, &sdkErr) {
+		parsed := newAPIError(&http.Response{StatusCode: sdkErr.Code}, []byte(sdkErr.Body))
+		return retryDelay(parsed.Details, sdkErr.Header)
+	}
+
+	return 0, false
+}
+
+func retryDelay(details []json.RawMessage, header http.Header) (time.Duration, bool) {
+	for _, detail := range details {
+		var info struct {
+			Type       string `json:"@type"`
+			RetryDelay string `json:"retryDelay"`
+		}
+		if json.Unmarshal(detail, &info) != nil || info.Type != retryInfoType {
+			continue
+		}
+		if delay, err := time.ParseDuration(info.RetryDelay); err == nil && delay >= 0 {
+			return delay, true
+		}
+	}
+
+	if header == nil {
+		return 0, false
+	}
+	retryAfter := strings.TrimSpace(header.Get("Retry-After"))
+	if retryAfter == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(retryAfter); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
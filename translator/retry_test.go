@@ -0,0 +1,295 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// fastRetryPolicy retries quickly so tests don't wait on real backoff
+var fastRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     time.Millisecond,
+}
+
+func TestGeminiHTTPClient_GenerateContent_RetriesTransientErrors(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(w, `{"error": {"code": 503, "message": "overloaded", "status": "UNAVAILABLE"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}]}, "finishReason": "STOP"}]}`)
+	}))
+	defer ts.Close()
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+	client.SetRetryPolicy(fastRetryPolicy)
+
+	resp, err := client.GenerateContent(context.Background(), "gemini-2.0-flash", &GenerateContentRequest{})
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 calls, got %d", calls.Load())
+	}
+	if resp.Candidates[0].Content.Parts[0].Text != "Hello" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestGeminiHTTPClient_GenerateContent_GivesUp(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"code": 429, "message": "quota", "status": "RESOURCE_EXHAUSTED"}}`)
+	}))
+	defer ts.Close()
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+	client.SetRetryPolicy(fastRetryPolicy)
+
+	_, err := client.GenerateContent(context.Background(), "gemini-2.0-flash", &GenerateContentRequest{})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the last 429 error, got %v", err)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 calls, got %d", calls.Load())
+	}
+}
+
+func TestGeminiHTTPClient_GenerateContent_NoRetryOnClientError(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": {"code": 400, "message": "bad request", "status": "INVALID_ARGUMENT"}}`)
+	}))
+	defer ts.Close()
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+	client.SetRetryPolicy(fastRetryPolicy)
+
+	if _, err := client.GenerateContent(context.Background(), "gemini-2.0-flash", &GenerateContentRequest{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestGeminiHTTPClient_StreamGenerateContent_RetriesOpen(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": {"code": 429, "message": "quota", "status": "RESOURCE_EXHAUSTED"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"Hi\"}]}}]}\n\n")
+	}))
+	defer ts.Close()
+
+	client := NewGeminiHTTPClient("test-key")
+	client.SetBaseURL(ts.URL)
+	client.SetRetryPolicy(fastRetryPolicy)
+
+	chunks := 0
+	err := client.StreamGenerateContent(context.Background(), "gemini-2.0-flash", &GenerateContentRequest{}, func(chunk *GenerateContentResponse) error {
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected success after retry, got %v", err)
+	}
+	if calls.Load() != 2 || chunks != 1 {
+		t.Errorf("expected 2 calls and 1 chunk, got %d calls and %d chunks", calls.Load(), chunks)
+	}
+}
+
+func TestRetry_StopsWhenContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+
+	calls := 0
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	err := Retry(ctx, policy, func(ctx context.Context) error {
+		calls++
+		return &APIError{StatusCode: http.StatusServiceUnavailable}
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected retry to stop promptly, took %s", elapsed)
+	}
+}
+
+func TestRetry_Deadline(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Deadline: time.Second}
+
+	calls := 0
+	start := time.Now()
+	err := Retry(context.Background(), policy, func(ctx context.Context) error {
+		calls++
+		// Gemini asks for a delay that would exceed the overall deadline
+		return &APIError{
+			StatusCode: http.StatusTooManyRequests,
+			Details:    []json.RawMessage{json.RawMessage(`{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "30s"}`)},
+		}
+	})
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Errorf("expected *APIError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected no wait, took %s", elapsed)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name        string
+		err         error
+		expectOK    bool
+		expectDelay time.Duration
+	}{
+		{
+			name: "retry info",
+			err: &APIError{StatusCode: 429, Details: []json.RawMessage{
+				json.RawMessage(`{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "RATE_LIMIT_EXCEEDED"}`),
+				json.RawMessage(`{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1.5s"}`),
+			}},
+			expectOK:    true,
+			expectDelay: 1500 * time.Millisecond,
+		},
+		{
+			name:        "retry-after seconds",
+			err:         &APIError{StatusCode: 503, Header: http.Header{"Retry-After": []string{"3"}}},
+			expectOK:    true,
+			expectDelay: 3 * time.Second,
+		},
+		{
+			name:     "retry-after date",
+			err:      &APIError{StatusCode: 503, Header: http.Header{"Retry-After": []string{future}}},
+			expectOK: true,
+		},
+		{
+			name:        "sdk error body",
+			err:         fmt.Errorf("gemini API error: %w", &googleapi.Error{Code: 429, Body: `{"error": {"code": 429, "message": "quota", "details": [{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "2s"}]}}`}),
+			expectOK:    true,
+			expectDelay: 2 * time.Second,
+		},
+		{
+			name: "no hint",
+			err:  &APIError{StatusCode: 503},
+		},
+		{
+			name: "other error",
+			err:  errors.New("boom"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := RetryDelay(tt.err)
+			if ok != tt.expectOK {
+				t.Fatalf("expected ok=%t, got %t", tt.expectOK, ok)
+			}
+			if tt.expectDelay != 0 && delay != tt.expectDelay {
+				t.Errorf("expected delay %s, got %s", tt.expectDelay, delay)
+			}
+			if tt.expectOK && delay <= 0 {
+				t.Errorf("expected positive delay, got %s", delay)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		limit := 100 * time.Millisecond << (attempt - 1)
+		if limit > policy.MaxBackoff {
+			limit = policy.MaxBackoff
+		}
+		for i := 0; i < 50; i++ {
+			if delay := policy.backoff(attempt); delay <= 0 || delay > limit {
+				t.Fatalf("attempt %d: backoff %s outside (0, %s]", attempt, delay, limit)
+			}
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		err    error
+		expect bool
+	}{
+		{"rate limited", context.Background(), &APIError{StatusCode: 429}, true},
+		{"unavailable", context.Background(), &APIError{StatusCode: 503}, true},
+		{"internal", context.Background(), &APIError{StatusCode: 500}, true},
+		{"bad request", context.Background(), &APIError{StatusCode: 400}, false},
+		{"sdk unavailable", context.Background(), &googleapi.Error{Code: 503}, true},
+		{"sdk not found", context.Background(), &googleapi.Error{Code: 404}, false},
+		{"client disconnected", canceled, &APIError{StatusCode: 503}, false},
+		{"other", context.Background(), errors.New("failed to convert"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.ctx, tt.err); got != tt.expect {
+				t.Errorf("expected %t, got %t", tt.expect, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,758 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// geminiFormats lists the formats Gemini accepts for each type; other formats are rejected
+var geminiFormats = map[string][]string{
+	types.SchemaTypeString:  {"enum", "date-time"},
+	types.SchemaTypeNumber:  {"float", "double"},
+	types.SchemaTypeInteger: {"int32", "int64"},
+}
+
+// schemaTypes lists the JSON Schema types Gemini has an equivalent for
+var schemaTypes = []string{
+	types.SchemaTypeString, types.SchemaTypeNumber, types.SchemaTypeInteger,
+	types.SchemaTypeBoolean, types.SchemaTypeArray, types.SchemaTypeObject,
+}
+
+// ignoredSchemaFields are dropped without being reported, since losing them doesn't change
+// what the model may send
+var ignoredSchemaFields = map[string]bool{
+	types.SchemaFieldDollarSchema: true,
+	types.SchemaFieldDefs:         true,
+	types.SchemaFieldDefinitions:  true,
+	types.SchemaFieldTitle:        true,
+	"$id":                         true,
+	"$anchor":                     true,
+	"$comment":                    true,
+}
+
+// SchemaIssue describes a part of a JSON Schema that couldn't be represented exactly in
+// the schema subset Gemini accepts
+type SchemaIssue struct {
+	Path    string // JSON pointer to the schema, e.g. #/properties/name
+	Message string
+}
+
+// String implements fmt.Stringer
+func (i SchemaIssue) String() string {
+	return i.Path + ": " + i.Message
+}
+
+// ConvertSchema converts a JSON Schema to the OpenAPI subset Gemini accepts for function
+// parameters, returning the converted schema along with the lossy conversions it made.
+//
+// Local $refs are inlined (recursive references are truncated to a plain object),
+// allOf is flattened, anyOf/oneOf are collapsed into a single schema, type unions with
+// "null" become nullable, const becomes a single-value enum and empty properties are
+// removed. Keywords Gemini rejects, such as default, examples and pattern, are dropped.
+func ConvertSchema(schema map[string]interface{}) (map[string]interface{}, []SchemaIssue) {
+	if schema == nil {
+		return nil, nil
+	}
+
+	c := &schemaConverter{
+		root:      schema,
+		resolving: map[string]bool{},
+	}
+	converted := c.translate(schema, "#")
+	c.ensureType(converted, types.SchemaTypeObject, "#")
+	return converted, c.issues
+}
+
+// schemaConverter holds the state of a single ConvertSchema call
+type schemaConverter struct {
+	root      map[string]interface{} // Document $refs are resolved against
+	resolving map[string]bool        // $refs being expanded, for cycle detection
+	issues    []SchemaIssue
+}
+
+func (c *schemaConverter) report(path, format string, args ...interface{}) {
+	c.issues = append(c.issues, SchemaIssue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// convert converts a nested schema, making sure it ends up with a type
+func (c *schemaConverter) convert(schema map[string]interface{}, path string) map[string]interface{} {
+	converted := c.translate(schema, path)
+	c.ensureType(converted, types.SchemaTypeString, path)
+	return converted
+}
+
+// translate converts schema without inferring a type for it, so that the result can
+// still be combined with the other members of an allOf or anyOf
+func (c *schemaConverter) translate(schema map[string]interface{}, path string) map[string]interface{} {
+	if ref, ok := schema[types.SchemaFieldRef].(string); ok {
+		return c.translateRef(ref, schema, path)
+	}
+
+	if members, ok := schema[types.SchemaFieldAllOf].([]interface{}); ok {
+		parts := []map[string]interface{}{c.translate(without(schema, types.SchemaFieldAllOf), path)}
+		for i, member := range members {
+			if m, ok := asSchema(member); ok {
+				parts = append(parts, c.translate(m, fmt.Sprintf("%s/%s/%d", path, types.SchemaFieldAllOf, i)))
+			}
+		}
+		return c.intersect(parts, path)
+	}
+
+	for _, keyword := range []string{types.SchemaFieldAnyOf, types.SchemaFieldOneOf} {
+		if variants, ok := schema[keyword].([]interface{}); ok {
+			base := c.translate(without(schema, keyword), path)
+			return c.intersect([]map[string]interface{}{base, c.union(variants, path+"/"+keyword)}, path)
+		}
+	}
+
+	result := map[string]interface{}{}
+	for _, key := range sortedKeys(schema) {
+		value := schema[key]
+		switch key {
+		case types.SchemaFieldType:
+			c.translateType(value, result, path)
+
+		case types.SchemaFieldDescription:
+			if description, ok := value.(string); ok && description != "" {
+				if note, ok := result[types.SchemaFieldDescription].(string); ok {
+					description += "\n" + note // From a const sorted before it
+				}
+				result[types.SchemaFieldDescription] = description
+			}
+
+		case types.SchemaFieldNullable:
+			if nullable, ok := value.(bool); ok && nullable {
+				result[types.SchemaFieldNullable] = true
+			}
+
+		case types.SchemaFieldConst:
+			c.translateEnum([]interface{}{value}, result, path)
+
+		case types.SchemaFieldEnum:
+			if values, ok := value.([]interface{}); ok {
+				c.translateEnum(values, result, path)
+			}
+
+		case types.SchemaFieldProperties:
+			properties, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			converted := map[string]interface{}{}
+			for _, name := range sortedKeys(properties) {
+				propertyPath := path + "/" + types.SchemaFieldProperties + "/" + escapePointer(name)
+				property, ok := asSchema(properties[name])
+				if !ok {
+					c.report(propertyPath, "property that can never be valid dropped")
+					continue
+				}
+				converted[name] = c.convert(property, propertyPath)
+			}
+			if len(converted) > 0 {
+				result[types.SchemaFieldProperties] = converted
+			}
+
+		case types.SchemaFieldRequired:
+			if required, ok := value.([]interface{}); ok && len(required) > 0 {
+				result[types.SchemaFieldRequired] = required
+			}
+
+		case types.SchemaFieldItems:
+			switch items := value.(type) {
+			case []interface{}:
+				// Tuple validation; Gemini arrays have a single item schema
+				if len(items) > 0 {
+					if m, ok := asSchema(items[0]); ok {
+						c.report(path, "tuple items narrowed to the first item schema")
+						result[types.SchemaFieldItems] = c.convert(m, path+"/"+types.SchemaFieldItems+"/0")
+					}
+				}
+			default:
+				if m, ok := asSchema(items); ok {
+					result[types.SchemaFieldItems] = c.convert(m, path+"/"+types.SchemaFieldItems)
+				}
+			}
+
+		case types.SchemaFieldFormat:
+			if format, ok := value.(string); ok {
+				result[types.SchemaFieldFormat] = format // Checked once the type is known
+			}
+
+		case types.SchemaFieldAdditionalProperties:
+			// Gemini objects are closed, so only a schema for extra properties loses anything
+			if _, ok := value.(map[string]interface{}); ok {
+				c.report(path, "dropped unsupported keyword %q", key)
+			}
+
+		default:
+			if !ignoredSchemaFields[key] {
+				c.report(path, "dropped unsupported keyword %q", key)
+			}
+		}
+	}
+
+	c.checkFormat(result, path)
+	c.checkRequired(result, path)
+	return result
+}
+
+// translateRef inlines a local $ref. Keywords next to the $ref take precedence over the
+// referenced schema.
+func (c *schemaConverter) translateRef(ref string, schema map[string]interface{}, path string) map[string]interface{} {
+	if c.resolving[ref] {
+		c.report(path, "recursive $ref %q truncated to an object", ref)
+		result := map[string]interface{}{types.SchemaFieldType: types.SchemaTypeObject}
+		if description, ok := schema[types.SchemaFieldDescription].(string); ok && description != "" {
+			result[types.SchemaFieldDescription] = description
+		}
+		return result
+	}
+
+	target, ok := resolveRef(c.root, ref)
+	if !ok {
+		c.report(path, "unresolvable $ref %q dropped", ref)
+		target = map[string]interface{}{}
+	}
+
+	merged := make(map[string]interface{}, len(target)+len(schema))
+	for key, value := range target {
+		merged[key] = value
+	}
+	for key, value := range schema {
+		if key != types.SchemaFieldRef {
+			merged[key] = value
+		}
+	}
+
+	c.resolving[ref] = true
+	defer delete(c.resolving, ref)
+	return c.translate(merged, path)
+}
+
+// resolveRef looks up a JSON pointer reference, such as #/$defs/address, in the root schema
+func resolveRef(root map[string]interface{}, ref string) (map[string]interface{}, bool) {
+	pointer, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, false // Remote references aren't fetched
+	}
+	if unescaped, err := url.PathUnescape(pointer); err == nil {
+		pointer = unescaped
+	}
+
+	var current interface{} = root
+	if pointer != "" {
+		for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			switch v := current.(type) {
+			case map[string]interface{}:
+				current, ok = v[token]
+			case []interface{}:
+				index, err := strconv.Atoi(token)
+				ok = err == nil && index >= 0 && index < len(v)
+				if ok {
+					current = v[index]
+				}
+			default:
+				ok = false
+			}
+			if !ok {
+				return nil, false
+			}
+		}
+	}
+	return asSchema(current)
+}
+
+// translateType handles both a single type and a list of types. "null" in a list makes the
+// schema nullable; a schema whose only type is "null" keeps it as a marker for union.
+func (c *schemaConverter) translateType(value interface{}, result map[string]interface{}, path string) {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		names = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if name, ok := item.(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var nonNull []string
+	for _, name := range names {
+		name = strings.ToLower(name)
+		switch {
+		case name == types.SchemaTypeNull:
+			result[types.SchemaFieldNullable] = true
+		case !slices.Contains(schemaTypes, name):
+			c.report(path, "unknown type %q dropped", name)
+		case !slices.Contains(nonNull, name):
+			nonNull = append(nonNull, name)
+		}
+	}
+
+	switch {
+	case len(nonNull) == 0 && len(names) > 0:
+		result[types.SchemaFieldType] = types.SchemaTypeNull
+	case len(nonNull) == 1:
+		result[types.SchemaFieldType] = nonNull[0]
+	case len(nonNull) > 1:
+		result[types.SchemaFieldType] = c.narrowTypes(nonNull, path)
+	}
+}
+
+// narrowTypes picks a single type for a union of types
+func (c *schemaConverter) narrowTypes(names []string, path string) string {
+	if len(names) == 2 && slices.Contains(names, types.SchemaTypeInteger) && slices.Contains(names, types.SchemaTypeNumber) {
+		return types.SchemaTypeNumber
+	}
+	c.report(path, "union of types %s narrowed to %s", strings.Join(names, ", "), names[0])
+	return names[0]
+}
+
+// translateEnum converts enum and const. Gemini only supports string enums, so other
+// values are described instead of enforced.
+func (c *schemaConverter) translateEnum(values []interface{}, result map[string]interface{}, path string) {
+	var strs []interface{}
+	var others []string
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			strs = append(strs, v)
+		case nil:
+			result[types.SchemaFieldNullable] = true
+		default:
+			others = append(others, fmt.Sprint(v))
+		}
+	}
+
+	if len(others) == 0 && len(strs) > 0 {
+		if existing, ok := result[types.SchemaFieldEnum].([]interface{}); ok {
+			strs = intersectValues(existing, strs) // enum and const together
+		}
+		result[types.SchemaFieldEnum] = strs
+		return
+	}
+	if len(others) > 0 {
+		for _, s := range strs {
+			others = append(others, fmt.Sprint(s))
+		}
+		c.report(path, "non-string enum values moved to the description")
+		appendDescription(result, "Allowed values: "+strings.Join(others, ", "))
+	}
+}
+
+// checkFormat drops formats Gemini doesn't accept for the schema's type
+func (c *schemaConverter) checkFormat(result map[string]interface{}, path string) {
+	typeName, _ := result[types.SchemaFieldType].(string)
+	if format, ok := result[types.SchemaFieldFormat].(string); ok && typeName != "" {
+		if !slices.Contains(geminiFormats[typeName], format) {
+			c.report(path, "unsupported format %q dropped", format)
+			delete(result, types.SchemaFieldFormat)
+		}
+	}
+}
+
+// checkRequired drops required properties that aren't declared, which Gemini rejects
+func (c *schemaConverter) checkRequired(result map[string]interface{}, path string) {
+	required, ok := result[types.SchemaFieldRequired].([]interface{})
+	if !ok {
+		return
+	}
+	properties, _ := result[types.SchemaFieldProperties].(map[string]interface{})
+	if typeName, _ := result[types.SchemaFieldType].(string); typeName != "" && typeName != types.SchemaTypeObject {
+		delete(result, types.SchemaFieldRequired)
+		return
+	}
+	if properties == nil {
+		return // May be combined with the properties of an allOf member
+	}
+
+	var kept []interface{}
+	for _, name := range required {
+		if s, ok := name.(string); ok {
+			if _, declared := properties[s]; declared {
+				kept = append(kept, s)
+				continue
+			}
+			c.report(path, "required property %q is not declared and was dropped", s)
+		}
+	}
+	if len(kept) == 0 {
+		delete(result, types.SchemaFieldRequired)
+	} else {
+		result[types.SchemaFieldRequired] = kept
+	}
+}
+
+// ensureType infers a missing type from the schema's other keywords, falling back to
+// fallback. Schemas that only allow null become nullable strings.
+func (c *schemaConverter) ensureType(result map[string]interface{}, fallback, path string) {
+	typeName, _ := result[types.SchemaFieldType].(string)
+	switch {
+	case typeName == types.SchemaTypeNull:
+		c.report(path, "null type converted to a nullable %s", types.SchemaTypeString)
+		result[types.SchemaFieldType] = types.SchemaTypeString
+		result[types.SchemaFieldNullable] = true
+	case typeName != "":
+	case result[types.SchemaFieldProperties] != nil || result[types.SchemaFieldRequired] != nil:
+		result[types.SchemaFieldType] = types.SchemaTypeObject
+	case result[types.SchemaFieldItems] != nil:
+		result[types.SchemaFieldType] = types.SchemaTypeArray
+	case result[types.SchemaFieldEnum] != nil:
+		result[types.SchemaFieldType] = types.SchemaTypeString
+	default:
+		if fallback != types.SchemaTypeObject {
+			c.report(path, "schema without a type treated as %s", fallback)
+		}
+		result[types.SchemaFieldType] = fallback
+	}
+
+	if result[types.SchemaFieldType] == types.SchemaTypeArray && result[types.SchemaFieldItems] == nil {
+		c.report(path, "array without items treated as an array of %s", types.SchemaTypeString)
+		result[types.SchemaFieldItems] = map[string]interface{}{types.SchemaFieldType: types.SchemaTypeString}
+	}
+	if _, ok := result[types.SchemaFieldProperties]; !ok && result[types.SchemaFieldRequired] != nil {
+		c.report(path, "required properties without declared properties dropped")
+		delete(result, types.SchemaFieldRequired)
+	}
+	c.checkFormat(result, path)
+	c.checkRequired(result, path)
+}
+
+// intersect combines schemas that must all hold (allOf, or keywords next to anyOf)
+func (c *schemaConverter) intersect(parts []map[string]interface{}, path string) map[string]interface{} {
+	result := map[string]interface{}{}
+	nullable := true
+	typed := false
+
+	for _, part := range parts {
+		for _, key := range sortedKeys(part) {
+			value := part[key]
+			existing, exists := result[key]
+			switch key {
+			case types.SchemaFieldType:
+				typed = true
+				if !exists {
+					result[key] = value
+				} else if existing != value {
+					result[key] = c.narrowTypes([]string{existing.(string), value.(string)}, path)
+				}
+				if part[types.SchemaFieldNullable] != true {
+					nullable = false
+				}
+			case types.SchemaFieldNullable:
+				// Combined below
+			case types.SchemaFieldEnum:
+				if exists {
+					result[key] = intersectValues(existing.([]interface{}), value.([]interface{}))
+				} else {
+					result[key] = value
+				}
+			case types.SchemaFieldRequired:
+				if exists {
+					result[key] = unionValues(existing.([]interface{}), value.([]interface{}))
+				} else {
+					result[key] = value
+				}
+			case types.SchemaFieldProperties:
+				merged := map[string]interface{}{}
+				if exists {
+					for name, property := range existing.(map[string]interface{}) {
+						merged[name] = property
+					}
+				}
+				for name, property := range value.(map[string]interface{}) {
+					if previous, ok := merged[name]; ok {
+						property = c.intersect([]map[string]interface{}{
+							previous.(map[string]interface{}), property.(map[string]interface{}),
+						}, path+"/"+types.SchemaFieldProperties+"/"+escapePointer(name))
+					}
+					merged[name] = property
+				}
+				result[key] = merged
+			case types.SchemaFieldItems:
+				if exists {
+					result[key] = c.intersect([]map[string]interface{}{
+						existing.(map[string]interface{}), value.(map[string]interface{}),
+					}, path+"/"+types.SchemaFieldItems)
+				} else {
+					result[key] = value
+				}
+			default:
+				// description, format: the first (outermost) one wins
+				if !exists {
+					result[key] = value
+				}
+			}
+		}
+	}
+
+	if typed && nullable {
+		result[types.SchemaFieldNullable] = true
+	} else if !typed {
+		// No member constrains the type, so any member may allow null
+		for _, part := range parts {
+			if part[types.SchemaFieldNullable] == true {
+				result[types.SchemaFieldNullable] = true
+			}
+		}
+	}
+	c.checkFormat(result, path)
+	c.checkRequired(result, path)
+	return result
+}
+
+// union collapses the variants of an anyOf or oneOf into a single schema
+func (c *schemaConverter) union(variants []interface{}, path string) map[string]interface{} {
+	var candidates []map[string]interface{}
+	var typeNames []string
+	nullable := false
+
+	for i, variant := range variants {
+		m, ok := asSchema(variant)
+		if !ok {
+			continue
+		}
+		converted := c.translate(m, fmt.Sprintf("%s/%d", path, i))
+		if converted[types.SchemaFieldNullable] == true {
+			nullable = true
+		}
+		typeName, _ := converted[types.SchemaFieldType].(string)
+		if typeName == types.SchemaTypeNull {
+			nullable = true
+			continue
+		}
+		if typeName != "" && !slices.Contains(typeNames, typeName) {
+			typeNames = append(typeNames, typeName)
+		}
+		candidates = append(candidates, converted)
+	}
+
+	var result map[string]interface{}
+	switch {
+	case len(candidates) == 0:
+		result = map[string]interface{}{}
+	case len(candidates) == 1:
+		result = candidates[0]
+	case len(typeNames) > 1 && c.narrowTypes(typeNames, path) != types.SchemaTypeNumber:
+		// Different kinds of values; keep the first variant
+		for _, candidate := range candidates {
+			if candidate[types.SchemaFieldType] == typeNames[0] {
+				result = candidate
+				break
+			}
+		}
+	default:
+		result = c.unite(candidates, path)
+	}
+
+	if nullable {
+		result[types.SchemaFieldNullable] = true
+	}
+	return result
+}
+
+// unite merges variants of compatible types into one schema that accepts all of them.
+// Only merging string enums is lossless.
+func (c *schemaConverter) unite(candidates []map[string]interface{}, path string) map[string]interface{} {
+	result := map[string]interface{}{}
+	allEnums := true
+	var enum []interface{}
+	var required []interface{}
+	properties := map[string]interface{}{}
+
+	for i, candidate := range candidates {
+		for _, key := range sortedKeys(candidate) {
+			value := candidate[key]
+			switch key {
+			case types.SchemaFieldType:
+				if existing, ok := result[key]; ok && existing != value {
+					result[key] = types.SchemaTypeNumber // Only integer and number get here
+				} else {
+					result[key] = value
+				}
+			case types.SchemaFieldEnum:
+				enum = unionValues(enum, value.([]interface{}))
+			case types.SchemaFieldRequired:
+				if i == 0 {
+					required = value.([]interface{})
+				}
+			case types.SchemaFieldProperties:
+				for name, property := range value.(map[string]interface{}) {
+					if _, ok := properties[name]; !ok {
+						properties[name] = property
+					}
+				}
+			default:
+				if _, ok := result[key]; !ok {
+					result[key] = value
+				}
+			}
+		}
+		if _, ok := candidate[types.SchemaFieldEnum]; !ok {
+			allEnums = false
+		}
+		if i > 0 {
+			// Only properties every variant requires stay required
+			theirs, _ := candidate[types.SchemaFieldRequired].([]interface{})
+			required = intersectValues(required, theirs)
+		}
+	}
+
+	if allEnums {
+		result[types.SchemaFieldEnum] = enum
+	} else {
+		c.report(path, "%d variants merged into a single schema", len(candidates))
+	}
+	if len(properties) > 0 {
+		result[types.SchemaFieldProperties] = properties
+	}
+	if len(required) > 0 {
+		result[types.SchemaFieldRequired] = required
+	}
+	return result
+}
+
+// toGeminiSchema converts a schema produced by ConvertSchema to the SDK's schema type
+func toGeminiSchema(schema map[string]interface{}) *genai.Schema {
+	result := &genai.Schema{}
+	if schema == nil {
+		return result
+	}
+
+	switch schema[types.SchemaFieldType] {
+	case types.SchemaTypeString:
+		result.Type = genai.TypeString
+	case types.SchemaTypeNumber:
+		result.Type = genai.TypeNumber
+	case types.SchemaTypeInteger:
+		result.Type = genai.TypeInteger
+	case types.SchemaTypeBoolean:
+		result.Type = genai.TypeBoolean
+	case types.SchemaTypeArray:
+		result.Type = genai.TypeArray
+	case types.SchemaTypeObject:
+		result.Type = genai.TypeObject
+	}
+
+	result.Format, _ = schema[types.SchemaFieldFormat].(string)
+	result.Description, _ = schema[types.SchemaFieldDescription].(string)
+	result.Nullable, _ = schema[types.SchemaFieldNullable].(bool)
+
+	if enum, ok := schema[types.SchemaFieldEnum].([]interface{}); ok {
+		for _, e := range enum {
+			if s, ok := e.(string); ok {
+				result.Enum = append(result.Enum, s)
+			}
+		}
+	}
+
+	if items, ok := schema[types.SchemaFieldItems].(map[string]interface{}); ok {
+		result.Items = toGeminiSchema(items)
+	}
+
+	if props, ok := schema[types.SchemaFieldProperties].(map[string]interface{}); ok {
+		result.Properties = make(map[string]*genai.Schema)
+		for propName, propVal := range props {
+			if propMap, ok := propVal.(map[string]interface{}); ok {
+				result.Properties[propName] = toGeminiSchema(propMap)
+			}
+		}
+	}
+
+	if required, ok := schema[types.SchemaFieldRequired].([]interface{}); ok {
+		for _, r := range required {
+			if s, ok := r.(string); ok {
+				result.Required = append(result.Required, s)
+			}
+		}
+	}
+
+	return result
+}
+
+// asSchema returns v as a schema object. The boolean schema true accepts anything and is
+// treated as an empty schema; false accepts nothing.
+func asSchema(v interface{}) (map[string]interface{}, bool) {
+	switch s := v.(type) {
+	case map[string]interface{}:
+		return s, true
+	case bool:
+		if s {
+			return map[string]interface{}{}, true
+		}
+	}
+	return nil, false
+}
+
+func without(schema map[string]interface{}, key string) map[string]interface{} {
+	result := make(map[string]interface{}, len(schema))
+	for k, v := range schema {
+		if k != key {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func appendDescription(schema map[string]interface{}, text string) {
+	if description, ok := schema[types.SchemaFieldDescription].(string); ok && description != "" {
+		text = description + "\n" + text
+	}
+	schema[types.SchemaFieldDescription] = text
+}
+
+func intersectValues(a, b []interface{}) []interface{} {
+	var result []interface{}
+	for _, v := range a {
+		if slices.Contains(b, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func unionValues(a, b []interface{}) []interface{} {
+	result := append([]interface{}(nil), a...)
+	for _, v := range b {
+		if !slices.Contains(result, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,237 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func mustParseSchema(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &schema); err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+	return schema
+}
+
+func TestConvertSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		issues   []string // Substrings expected in the report, in order
+	}{
+		{
+			name:     "plain schema is unchanged",
+			input:    `{"type":"object","properties":{"name":{"type":"string","description":"Name"}},"required":["name"]}`,
+			expected: `{"properties":{"name":{"description":"Name","type":"string"}},"required":["name"],"type":"object"}`,
+		},
+		{
+			name: "inlines $ref from $defs",
+			input: `{"type":"object","$defs":{"address":{"type":"object","properties":{"city":{"type":"string"}}}},
+				"properties":{"home":{"$ref":"#/$defs/address","description":"Home address"}}}`,
+			expected: `{"properties":{"home":{"description":"Home address","properties":{"city":{"type":"string"}},"type":"object"}},"type":"object"}`,
+		},
+		{
+			name:     "inlines $ref from definitions with escaped pointer",
+			input:    `{"type":"object","definitions":{"a/b":{"type":"integer"}},"properties":{"n":{"$ref":"#/definitions/a~1b"}}}`,
+			expected: `{"properties":{"n":{"type":"integer"}},"type":"object"}`,
+		},
+		{
+			name: "truncates recursive $ref",
+			input: `{"type":"object","$defs":{"node":{"type":"object","properties":{"value":{"type":"string"},"children":{"type":"array","items":{"$ref":"#/$defs/node"}}}}},
+				"properties":{"root":{"$ref":"#/$defs/node"}}}`,
+			expected: `{"properties":{"root":{"properties":{"children":{"items":{"type":"object"},"type":"array"},"value":{"type":"string"}},"type":"object"}},"type":"object"}`,
+			issues:   []string{`#/properties/root/properties/children/items: recursive $ref "#/$defs/node" truncated`},
+		},
+		{
+			name:     "unresolvable $ref",
+			input:    `{"type":"object","properties":{"x":{"$ref":"https://example.com/schema.json"}}}`,
+			expected: `{"properties":{"x":{"type":"string"}},"type":"object"}`,
+			issues:   []string{`unresolvable $ref "https://example.com/schema.json"`, "without a type treated as string"},
+		},
+		{
+			name:     "nullable type union",
+			input:    `{"type":"object","properties":{"x":{"type":["string","null"]}}}`,
+			expected: `{"properties":{"x":{"nullable":true,"type":"string"}},"type":"object"}`,
+		},
+		{
+			name:     "nullable anyOf",
+			input:    `{"type":"object","properties":{"x":{"description":"Maybe","anyOf":[{"type":"integer"},{"type":"null"}]}}}`,
+			expected: `{"properties":{"x":{"description":"Maybe","nullable":true,"type":"integer"}},"type":"object"}`,
+		},
+		{
+			name:     "integer or number becomes number",
+			input:    `{"type":"object","properties":{"x":{"type":["integer","number"]}}}`,
+			expected: `{"properties":{"x":{"type":"number"}},"type":"object"}`,
+		},
+		{
+			name:     "type union narrowed",
+			input:    `{"type":"object","properties":{"x":{"type":["string","boolean"]}}}`,
+			expected: `{"properties":{"x":{"type":"string"}},"type":"object"}`,
+			issues:   []string{"#/properties/x: union of types string, boolean narrowed to string"},
+		},
+		{
+			name:     "const becomes a single-value enum",
+			input:    `{"type":"object","properties":{"kind":{"const":"circle"}}}`,
+			expected: `{"properties":{"kind":{"enum":["circle"],"type":"string"}},"type":"object"}`,
+		},
+		{
+			name:     "string enums in oneOf are merged",
+			input:    `{"type":"object","properties":{"x":{"oneOf":[{"const":"a"},{"const":"b"},{"enum":["c"]}]}}}`,
+			expected: `{"properties":{"x":{"enum":["a","b","c"],"type":"string"}},"type":"object"}`,
+		},
+		{
+			name:     "non-string enum moved to the description",
+			input:    `{"type":"object","properties":{"level":{"type":"integer","description":"Level","enum":[1,2,3]}}}`,
+			expected: `{"properties":{"level":{"description":"Level\nAllowed values: 1, 2, 3","type":"integer"}},"type":"object"}`,
+			issues:   []string{"non-string enum values"},
+		},
+		{
+			name: "flattens allOf",
+			input: `{"$defs":{"base":{"type":"object","properties":{"id":{"type":"string"}},"required":["id"]}},
+				"allOf":[{"$ref":"#/$defs/base"},{"properties":{"name":{"type":"string"}},"required":["name"]}]}`,
+			expected: `{"properties":{"id":{"type":"string"},"name":{"type":"string"}},"required":["id","name"],"type":"object"}`,
+		},
+		{
+			name: "merges object variants of anyOf",
+			input: `{"type":"object","properties":{"shape":{"anyOf":[
+				{"type":"object","properties":{"radius":{"type":"number"},"kind":{"type":"string"}},"required":["kind","radius"]},
+				{"type":"object","properties":{"side":{"type":"number"},"kind":{"type":"string"}},"required":["kind","side"]}]}}}`,
+			expected: `{"properties":{"shape":{"properties":{"kind":{"type":"string"},"radius":{"type":"number"},"side":{"type":"number"}},"required":["kind"],"type":"object"}},"type":"object"}`,
+			issues:   []string{"#/properties/shape/anyOf: 2 variants merged"},
+		},
+		{
+			name:     "keeps the first variant of unrelated types",
+			input:    `{"type":"object","properties":{"x":{"anyOf":[{"type":"string"},{"type":"array","items":{"type":"string"}}]}}}`,
+			expected: `{"properties":{"x":{"type":"string"}},"type":"object"}`,
+			issues:   []string{"union of types string, array narrowed to string"},
+		},
+		{
+			name:     "drops unsupported formats",
+			input:    `{"type":"object","properties":{"url":{"type":"string","format":"uri"},"at":{"type":"string","format":"date-time"}}}`,
+			expected: `{"properties":{"at":{"format":"date-time","type":"string"},"url":{"type":"string"}},"type":"object"}`,
+			issues:   []string{`#/properties/url: unsupported format "uri" dropped`},
+		},
+		{
+			name:     "drops default, examples and pattern",
+			input:    `{"type":"object","properties":{"x":{"type":"string","default":"a","examples":["a"],"pattern":"^a$"}}}`,
+			expected: `{"properties":{"x":{"type":"string"}},"type":"object"}`,
+			issues: []string{
+				`#/properties/x: dropped unsupported keyword "default"`,
+				`#/properties/x: dropped unsupported keyword "examples"`,
+				`#/properties/x: dropped unsupported keyword "pattern"`,
+			},
+		},
+		{
+			name:     "removes empty properties",
+			input:    `{"type":"object","properties":{"options":{"type":"object","properties":{}}}}`,
+			expected: `{"properties":{"options":{"type":"object"}},"type":"object"}`,
+		},
+		{
+			name:     "empty root schema becomes an object",
+			input:    `{"properties":{}}`,
+			expected: `{"type":"object"}`,
+		},
+		{
+			name:     "drops undeclared required properties",
+			input:    `{"type":"object","properties":{"a":{"type":"string"}},"required":["a","b"]}`,
+			expected: `{"properties":{"a":{"type":"string"}},"required":["a"],"type":"object"}`,
+			issues:   []string{`required property "b" is not declared`},
+		},
+		{
+			name:     "array without items",
+			input:    `{"type":"object","properties":{"tags":{"type":"array"}}}`,
+			expected: `{"properties":{"tags":{"items":{"type":"string"},"type":"array"}},"type":"object"}`,
+			issues:   []string{"array without items"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, issues := ConvertSchema(mustParseSchema(t, tt.input))
+
+			got, err := json.Marshal(result)
+			if err != nil {
+				t.Fatalf("failed to marshal result: %v", err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("ConvertSchema() mismatch\nGot:  %s\nWant: %s", got, tt.expected)
+			}
+
+			var report []string
+			for _, issue := range issues {
+				report = append(report, issue.String())
+			}
+			if tt.issues == nil && len(report) > 0 {
+				t.Errorf("expected a lossless conversion, got issues: %v", report)
+			}
+			i := 0
+			for _, line := range report {
+				if i < len(tt.issues) && strings.Contains(line, tt.issues[i]) {
+					i++
+				}
+			}
+			if i < len(tt.issues) {
+				t.Errorf("expected issue %q in report %v", tt.issues[i], report)
+			}
+		})
+	}
+}
+
+func TestConvertSchema_Nil(t *testing.T) {
+	result, issues := ConvertSchema(nil)
+	if result != nil || issues != nil {
+		t.Errorf("expected nil result and issues, got %v, %v", result, issues)
+	}
+}
+
+func TestConvertJSONSchemaToGemini_SharesConverter(t *testing.T) {
+	schema := mustParseSchema(t, `{
+		"type": "object",
+		"$defs": {"color": {"type": ["string", "null"], "enum": ["red", "green", null]}},
+		"properties": {
+			"color": {"$ref": "#/$defs/color"},
+			"count": {"type": "integer", "format": "int32", "minimum": 0}
+		},
+		"required": ["color", "missing"]
+	}`)
+
+	result := convertJSONSchemaToGemini(schema)
+	if result.Type != genai.TypeObject {
+		t.Errorf("expected object type, got %v", result.Type)
+	}
+	if len(result.Required) != 1 || result.Required[0] != "color" {
+		t.Errorf("expected required [color], got %v", result.Required)
+	}
+
+	color := result.Properties["color"]
+	if color == nil || color.Type != genai.TypeString || !color.Nullable {
+		t.Fatalf("expected nullable string color, got %+v", color)
+	}
+	if strings.Join(color.Enum, ",") != "red,green" {
+		t.Errorf("expected enum [red green], got %v", color.Enum)
+	}
+
+	count := result.Properties["count"]
+	if count == nil || count.Type != genai.TypeInteger || count.Format != "int32" {
+		t.Errorf("expected int32 integer count, got %+v", count)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"fmt"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// Gemini finish reasons
+const (
+	FinishReasonUnspecified           = "FINISH_REASON_UNSPECIFIED"
+	FinishReasonStop                  = "STOP"
+	FinishReasonMaxTokens             = "MAX_TOKENS"
+	FinishReasonSafety                = "SAFETY"
+	FinishReasonRecitation            = "RECITATION"
+	FinishReasonLanguage              = "LANGUAGE"
+	FinishReasonOther                 = "OTHER"
+	FinishReasonBlocklist             = "BLOCKLIST"
+	FinishReasonProhibitedContent     = "PROHIBITED_CONTENT"
+	FinishReasonSPII                  = "SPII"
+	FinishReasonImageSafety           = "IMAGE_SAFETY"
+	FinishReasonMalformedFunctionCall = "MALFORMED_FUNCTION_CALL"
+	FinishReasonUnexpectedToolCall    = "UNEXPECTED_TOOL_CALL"
+	FinishReasonTooManyToolCalls      = "TOO_MANY_TOOL_CALLS"
+)
+
+// FinishReasonError is returned when Gemini finishes for a reason that has no Anthropic
+// stop_reason equivalent, such as a malformed function call
+type FinishReasonError struct {
+	FinishReason string
+	Message      string
+}
+
+func (e *FinishReasonError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("gemini finished with %s: %s", e.FinishReason, e.Message)
+	}
+	return fmt.Sprintf("gemini finished with %s", e.FinishReason)
+}
+
+// ToStopReason maps a Gemini finishReason onto an Anthropic stop_reason:
+//
+//	STOP                                   -> end_turn, or tool_use if the response calls tools
+//	MAX_TOKENS                             -> max_tokens
+//	SAFETY, RECITATION, BLOCKLIST,
+//	PROHIBITED_CONTENT, SPII, IMAGE_SAFETY -> refusal
+//	MALFORMED_FUNCTION_CALL,
+//	UNEXPECTED_TOOL_CALL,
+//	TOO_MANY_TOOL_CALLS                    -> error
+//	LANGUAGE, OTHER, unknown values        -> end_turn
+//
+// An empty finish reason (e.g., an intermediate stream chunk) maps to an empty stop_reason.
+func ToStopReason(finishReason string, hasToolUse bool) (string, error) {
+	switch finishReason {
+	case "":
+		return "", nil
+	case FinishReasonStop:
+		if hasToolUse {
+			return types.StopReasonToolUse, nil
+		}
+		return types.StopReasonEndTurn, nil
+	case FinishReasonMaxTokens:
+		return types.StopReasonMaxTokens, nil
+	case FinishReasonSafety, FinishReasonRecitation, FinishReasonBlocklist,
+		FinishReasonProhibitedContent, FinishReasonSPII, FinishReasonImageSafety:
+		return types.StopReasonRefusal, nil
+	case FinishReasonMalformedFunctionCall:
+		return "", &FinishReasonError{FinishReason: finishReason, Message: "the model generated an invalid function call"}
+	case FinishReasonUnexpectedToolCall:
+		return "", &FinishReasonError{FinishReason: finishReason, Message: "the model called a tool that was not declared"}
+	case FinishReasonTooManyToolCalls:
+		return "", &FinishReasonError{FinishReason: finishReason, Message: "the model made too many tool calls"}
+	default:
+		if hasToolUse {
+			return types.StopReasonToolUse, nil
+		}
+		return types.StopReasonEndTurn, nil
+	}
+}
+
+// PromptFeedback reports why Gemini refused to process a prompt
+type PromptFeedback struct {
+	BlockReason        string `json:"blockReason,omitempty"`
+	BlockReasonMessage string `json:"blockReasonMessage,omitempty"`
+}
+
+// toSDKFinishReason converts a genai SDK finish reason to its REST API name
+func toSDKFinishReason(reason genai.FinishReason) string {
+	switch reason {
+	case genai.FinishReasonUnspecified:
+		return ""
+	case genai.FinishReasonStop:
+		return FinishReasonStop
+	case genai.FinishReasonMaxTokens:
+		return FinishReasonMaxTokens
+	case genai.FinishReasonSafety:
+		return FinishReasonSafety
+	case genai.FinishReasonRecitation:
+		return FinishReasonRecitation
+	default:
+		return FinishReasonOther
+	}
+}
+
+// hasToolUse reports whether any of the blocks is a tool_use block
+func hasToolUse(blocks []types.AnthropicContentBlock) bool {
+	for _, block := range blocks {
+		if block.Type == types.ContentTypeToolUse {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestToStopReason(t *testing.T) {
+	tests := []struct {
+		finishReason string
+		hasToolUse   bool
+		expected     string
+		wantErr      bool
+	}{
+		{finishReason: "", expected: ""},
+		{finishReason: FinishReasonStop, expected: types.StopReasonEndTurn},
+		{finishReason: FinishReasonStop, hasToolUse: true, expected: types.StopReasonToolUse},
+		{finishReason: FinishReasonMaxTokens, expected: types.StopReasonMaxTokens},
+		{finishReason: FinishReasonMaxTokens, hasToolUse: true, expected: types.StopReasonMaxTokens},
+		{finishReason: FinishReasonSafety, expected: types.StopReasonRefusal},
+		{finishReason: FinishReasonRecitation, expected: types.StopReasonRefusal},
+		{finishReason: FinishReasonBlocklist, expected: types.StopReasonRefusal},
+		{finishReason: FinishReasonProhibitedContent, expected: types.StopReasonRefusal},
+		{finishReason: FinishReasonSPII, expected: types.StopReasonRefusal},
+		{finishReason: FinishReasonImageSafety, expected: types.StopReasonRefusal},
+		{finishReason: FinishReasonLanguage, expected: types.StopReasonEndTurn},
+		{finishReason: FinishReasonOther, expected: types.StopReasonEndTurn},
+		{finishReason: FinishReasonUnspecified, expected: types.StopReasonEndTurn},
+		{finishReason: FinishReasonMalformedFunctionCall, wantErr: true},
+		{finishReason: FinishReasonUnexpectedToolCall, wantErr: true},
+		{finishReason: FinishReasonTooManyToolCalls, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		name := tt.finishReason
+		if tt.hasToolUse {
+			name += " with tool use"
+		}
+		t.Run(name, func(t *testing.T) {
+			got, err := ToStopReason(tt.finishReason, tt.hasToolUse)
+			if tt.wantErr {
+				var finishErr *FinishReasonError
+				if !errors.As(err, &finishErr) {
+					t.Fatalf("expected FinishReasonError, got %v", err)
+				}
+				if finishErr.FinishReason != tt.finishReason {
+					t.Errorf("expected finish reason %q, got %q", tt.finishReason, finishErr.FinishReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("ToStopReason(%q, %v) = %q, want %q", tt.finishReason, tt.hasToolUse, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestToAnthropicResponseFromCustom_StopReasons(t *testing.T) {
+	functionCall := types.GeminiPart{
+		FunctionCall: &types.GeminiFunctionCall{Name: "search", Args: map[string]interface{}{"q": "x"}},
+	}
+
+	tests := []struct {
+		name     string
+		resp     *GenerateContentResponse
+		expected string
+		wantErr  bool
+	}{
+		{
+			name: "function call maps to tool_use",
+			resp: &GenerateContentResponse{Candidates: []Candidate{{
+				Content:      &types.GeminiContent{Parts: []types.GeminiPart{functionCall}},
+				FinishReason: FinishReasonStop,
+			}}},
+			expected: types.StopReasonToolUse,
+		},
+		{
+			name: "truncation maps to max_tokens",
+			resp: &GenerateContentResponse{Candidates: []Candidate{{
+				Content:      &types.GeminiContent{Parts: []types.GeminiPart{{Text: "partial"}}},
+				FinishReason: FinishReasonMaxTokens,
+			}}},
+			expected: types.StopReasonMaxTokens,
+		},
+		{
+			name:     "safety maps to refusal",
+			resp:     &GenerateContentResponse{Candidates: []Candidate{{FinishReason: FinishReasonSafety}}},
+			expected: types.StopReasonRefusal,
+		},
+		{
+			name:     "blocked prompt maps to refusal",
+			resp:     &GenerateContentResponse{PromptFeedback: &PromptFeedback{BlockReason: "PROHIBITED_CONTENT"}},
+			expected: types.StopReasonRefusal,
+		},
+		{
+			name:    "malformed function call is an error",
+			resp:    &GenerateContentResponse{Candidates: []Candidate{{FinishReason: FinishReasonMalformedFunctionCall}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ToAnthropicResponseFromCustom(tt.resp, "gemini-3-pro-preview", ResponseOptions{})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StopReason != tt.expected {
+				t.Errorf("expected stop reason %q, got %q", tt.expected, resp.StopReason)
+			}
+			if resp.Content == nil {
+				t.Error("expected content to be an empty array, not nil")
+			}
+		})
+	}
+}
+
+func TestToAnthropicResponse_StopReasons(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     *genai.GenerateContentResponse
+		expected string
+	}{
+		{
+			name: "function call maps to tool_use",
+			resp: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+				Content:      &genai.Content{Parts: []genai.Part{genai.FunctionCall{Name: "search"}}},
+				FinishReason: genai.FinishReasonStop,
+			}}},
+			expected: types.StopReasonToolUse,
+		},
+		{
+			name: "truncation maps to max_tokens",
+			resp: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+				FinishReason: genai.FinishReasonMaxTokens,
+			}}},
+			expected: types.StopReasonMaxTokens,
+		},
+		{
+			name: "recitation maps to refusal",
+			resp: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+				FinishReason: genai.FinishReasonRecitation,
+			}}},
+			expected: types.StopReasonRefusal,
+		},
+		{
+			name: "other maps to end_turn",
+			resp: &genai.GenerateContentResponse{Candidates: []*genai.Candidate{{
+				FinishReason: genai.FinishReasonOther,
+			}}},
+			expected: types.StopReasonEndTurn,
+		},
+		{
+			name:     "blocked prompt maps to refusal",
+			resp:     &genai.GenerateContentResponse{PromptFeedback: &genai.PromptFeedback{BlockReason: genai.BlockReasonSafety}},
+			expected: types.StopReasonRefusal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := ToAnthropicResponse(tt.resp, "gemini-2.0-flash")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StopReason != tt.expected {
+				t.Errorf("expected stop reason %q, got %q", tt.expected, resp.StopReason)
+			}
+		})
+	}
+}
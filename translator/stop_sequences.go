@@ -0,0 +1,118 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// StopSequences returns the stop sequences of a request that the proxy emulates, which is
+// all of them but empty ones. None are sent to Gemini: Gemini strips a stop sequence it
+// matches and reports an ordinary STOP, so the proxy couldn't tell which sequence ended
+// the output, or that one did at all. Streaming requests stop reading from Gemini as soon
+// as a sequence matches, so little is generated past it.
+func StopSequences(sequences []string) []string {
+	var emulated []string
+	for _, seq := range sequences {
+		if seq != "" {
+			emulated = append(emulated, seq)
+		}
+	}
+	return emulated
+}
+
+// ApplyStopSequences emulates stop sequences on a complete response. The response is
+// truncated at the earliest occurrence of any sequence in its text blocks, later blocks
+// are dropped, and the stop reason is set to stop_sequence.
+func ApplyStopSequences(resp *types.AnthropicResponse, sequences []string) {
+	if len(sequences) == 0 {
+		return
+	}
+
+	for i, block := range resp.Content {
+		if block.Type != types.ContentTypeText {
+			continue
+		}
+
+		index, seq := findStopSequence(block.Text, sequences)
+		if index < 0 {
+			continue
+		}
+
+		resp.Content[i].Text = block.Text[:index]
+		resp.Content = resp.Content[:i+1]
+		if resp.Content[i].Text == "" {
+			resp.Content = resp.Content[:i]
+		}
+		resp.StopReason = types.StopReasonStopSequence
+		resp.StopSequence = seq
+		return
+	}
+}
+
+// findStopSequence returns the index and value of the earliest stop sequence in text,
+// or -1 if none is present
+func findStopSequence(text string, sequences []string) (int, string) {
+	index, match := -1, ""
+	for _, seq := range sequences {
+		if i := strings.Index(text, seq); i >= 0 && (index < 0 || i < index) {
+			index, match = i, seq
+		}
+	}
+	return index, match
+}
+
+// stopSequenceMatcher detects stop sequences in streamed text. Text that could be the
+// beginning of a stop sequence is held back until enough text has arrived to decide.
+type stopSequenceMatcher struct {
+	sequences []string
+	pending   string
+}
+
+// add appends streamed text and returns the portion that is safe to emit. If a stop
+// sequence is found, matched is set and any text after it is discarded.
+func (m *stopSequenceMatcher) add(text string) (emit string, matched string) {
+	m.pending += text
+
+	if index, seq := findStopSequence(m.pending, m.sequences); index >= 0 {
+		emit = m.pending[:index]
+		m.pending = ""
+		return emit, seq
+	}
+
+	// Hold back the longest suffix that is a prefix of some stop sequence
+	hold := 0
+	for _, seq := range m.sequences {
+		for n := min(len(seq)-1, len(m.pending)); n > hold; n-- {
+			if strings.HasSuffix(m.pending, seq[:n]) {
+				hold = n
+				break
+			}
+		}
+	}
+
+	emit = m.pending[:len(m.pending)-hold]
+	m.pending = m.pending[len(m.pending)-hold:]
+	return emit, ""
+}
+
+// flush returns and clears any held back text
+func (m *stopSequenceMatcher) flush() string {
+	pending := m.pending
+	m.pending = ""
+	return pending
+}
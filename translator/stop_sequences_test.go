@@ -0,0 +1,143 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestStopSequences(t *testing.T) {
+	emulated := StopSequences([]string{"a", "b", "", "c", "d", "e", "f", "g"})
+	if strings.Join(emulated, ",") != "a,b,c,d,e,f,g" {
+		t.Errorf("expected every non-empty sequence to be emulated, got %v", emulated)
+	}
+}
+
+func TestApplyStopSequences(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      []types.AnthropicContentBlock
+		sequences    []string
+		expectText   []string
+		expectReason string
+		expectSeq    string
+	}{
+		{
+			name:         "no match",
+			content:      []types.AnthropicContentBlock{{Type: "text", Text: "Hello world"}},
+			sequences:    []string{"STOP"},
+			expectText:   []string{"Hello world"},
+			expectReason: types.StopReasonEndTurn,
+		},
+		{
+			name:         "truncates at earliest match",
+			content:      []types.AnthropicContentBlock{{Type: "text", Text: "one END two HALT three"}},
+			sequences:    []string{"HALT", "END"},
+			expectText:   []string{"one "},
+			expectReason: types.StopReasonStopSequence,
+			expectSeq:    "END",
+		},
+		{
+			name: "drops blocks after the match",
+			content: []types.AnthropicContentBlock{
+				{Type: "text", Text: "before"},
+				{Type: "text", Text: "middle</answer>after"},
+				{Type: "tool_use", Name: "search"},
+			},
+			sequences:    []string{"</answer>"},
+			expectText:   []string{"before", "middle"},
+			expectReason: types.StopReasonStopSequence,
+			expectSeq:    "</answer>",
+		},
+		{
+			name: "drops block that becomes empty",
+			content: []types.AnthropicContentBlock{
+				{Type: "text", Text: "before"},
+				{Type: "text", Text: "###rest"},
+			},
+			sequences:    []string{"###"},
+			expectText:   []string{"before"},
+			expectReason: types.StopReasonStopSequence,
+			expectSeq:    "###",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &types.AnthropicResponse{
+				Content:    tt.content,
+				StopReason: types.StopReasonEndTurn,
+			}
+
+			ApplyStopSequences(resp, tt.sequences)
+
+			var texts []string
+			for _, block := range resp.Content {
+				texts = append(texts, block.Text)
+			}
+			if strings.Join(texts, "|") != strings.Join(tt.expectText, "|") {
+				t.Errorf("expected content %v, got %v", tt.expectText, texts)
+			}
+			if resp.StopReason != tt.expectReason {
+				t.Errorf("expected stop reason %q, got %q", tt.expectReason, resp.StopReason)
+			}
+			if resp.StopSequence != tt.expectSeq {
+				t.Errorf("expected stop sequence %q, got %q", tt.expectSeq, resp.StopSequence)
+			}
+		})
+	}
+}
+
+func TestStopSequenceMatcher(t *testing.T) {
+	m := &stopSequenceMatcher{sequences: []string{"<END>"}}
+
+	var emitted string
+	for _, chunk := range []string{"Hello <", "E", "ND", "> ignored"} {
+		text, matched := m.add(chunk)
+		emitted += text
+		if matched != "" {
+			if matched != "<END>" {
+				t.Errorf("expected match <END>, got %q", matched)
+			}
+			break
+		}
+	}
+
+	if emitted != "Hello " {
+		t.Errorf("expected emitted text 'Hello ', got %q", emitted)
+	}
+}
+
+func TestStopSequenceMatcher_FalseStart(t *testing.T) {
+	m := &stopSequenceMatcher{sequences: []string{"<END>"}}
+
+	text, matched := m.add("a <E")
+	if matched != "" || text != "a " {
+		t.Fatalf("expected to hold back '<E', got text=%q matched=%q", text, matched)
+	}
+
+	text, matched = m.add("X>")
+	if matched != "" || text != "<EX>" {
+		t.Fatalf("expected held text to be released, got text=%q matched=%q", text, matched)
+	}
+
+	m.add("tail <")
+	if rest := m.flush(); rest != "<" {
+		t.Errorf("expected flush to return held text '<', got %q", rest)
+	}
+}
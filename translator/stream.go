@@ -0,0 +1,417 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// StreamConverter translates Gemini streamGenerateContent chunks into the Anthropic
+// server-sent event sequence:
+//
+//	message_start
+//	content_block_start / content_block_delta... / content_block_stop (per block)
+//	message_delta (stop_reason and usage)
+//	message_stop
+//
+// Consecutive text parts are merged into a single text block, and consecutive thought
+// parts into a single thinking block whose signature is sent as a signature_delta just
+// before the block closes. Function calls arrive complete from Gemini, so each one is
+// emitted as a tool_use block carrying a single input_json_delta.
+//
+// Stop sequences that Gemini can't handle natively are emulated on the text deltas; once
+// one is matched, Stopped reports true and all further content is discarded.
+//
+// With a ToolValidator, function call arguments are repaired before they are emitted.
+// Unless the strategy is passthrough, tool_use blocks are also held back until
+// ReleaseToolUse, so that a response with invalid calls can be rejected (or re-prompted)
+// before any of its calls reach the client.
+//
+// The converter also accumulates the full response so callers can inspect it once the
+// stream completes (e.g., to cache thought signatures).
+type StreamConverter struct {
+	resp      *types.AnthropicResponse
+	started   bool
+	stopped   bool
+	matcher   *stopSequenceMatcher
+	singleUse bool // Only the first tool_use block is emitted
+	opts      ResponseOptions
+	pending   []types.AnthropicContentBlock // tool_use blocks held back for validation
+	openIndex int                           // Index of the currently open content block, or -1
+	openType  string                        // Type of the currently open content block
+	prior     types.AnthropicUsage          // Usage of discarded attempts, added to the reported usage
+}
+
+// NewStreamConverter creates a converter for a single streaming response
+func NewStreamConverter(model string) *StreamConverter {
+	return &StreamConverter{
+		resp: &types.AnthropicResponse{
+			ID:      uuid.New().String(),
+			Type:    types.ResponseTypeMessage,
+			Role:    types.RoleAssistant,
+			Model:   model,
+			Content: []types.AnthropicContentBlock{},
+		},
+		openIndex: -1,
+	}
+}
+
+// SetStopSequences configures stop sequences to emulate on streamed text
+func (c *StreamConverter) SetStopSequences(sequences []string) {
+	if len(sequences) == 0 {
+		c.matcher = nil
+		return
+	}
+	c.matcher = &stopSequenceMatcher{sequences: sequences}
+}
+
+// SetDisableParallelToolUse limits the stream to a single tool_use block
+func (c *StreamConverter) SetDisableParallelToolUse(disable bool) {
+	c.singleUse = disable
+}
+
+// SetToolUseIDEncoder embeds thought signatures in the IDs of streamed tool_use blocks
+func (c *StreamConverter) SetToolUseIDEncoder(ids ToolUseIDEncoder) {
+	c.opts.ToolUseIDs = ids
+}
+
+// SetToolNames maps streamed function names back to the request's tool names
+func (c *StreamConverter) SetToolNames(names *ToolNames) {
+	c.opts.ToolNames = names
+}
+
+// SetToolValidator checks the arguments of streamed function calls
+func (c *StreamConverter) SetToolValidator(tools *ToolValidator) {
+	c.opts.Tools = tools
+}
+
+// SetCacheCreated reports cached tokens as cache creation; see ResponseOptions.CacheCreated
+func (c *StreamConverter) SetCacheCreated(created bool) {
+	c.opts.CacheCreated = created
+}
+
+// SetPriorUsage adds the usage of earlier, discarded attempts at the response (e.g. one
+// that was re-prompted) to the usage reported by the stream
+func (c *StreamConverter) SetPriorUsage(usage types.AnthropicUsage) {
+	c.prior = usage
+	c.resp.Usage = AddUsage(c.resp.Usage, usage)
+}
+
+// ReleaseToolUse validates the tool_use blocks held back since the last call and emits
+// them. If any of them is invalid, none are emitted and an *InvalidToolArgsError is returned;
+// the stream can then be continued with the model's corrected response.
+func (c *StreamConverter) ReleaseToolUse() ([]types.AnthropicStreamEvent, error) {
+	pending := c.pending
+	c.pending = nil
+	if err := c.opts.Tools.Check(pending); err != nil {
+		return nil, err
+	}
+
+	var events []types.AnthropicStreamEvent
+	for _, block := range pending {
+		events = append(events, c.AddBlock(block)...)
+	}
+	return events, nil
+}
+
+// Stopped reports whether an emulated stop sequence has been matched. Callers should
+// stop consuming the upstream stream and call Finish.
+func (c *StreamConverter) Stopped() bool {
+	return c.stopped
+}
+
+// Start returns the message_start event. It is emitted at most once; subsequent calls
+// return nil.
+func (c *StreamConverter) Start() []types.AnthropicStreamEvent {
+	if c.started {
+		return nil
+	}
+	c.started = true
+
+	message := *c.resp
+	message.Content = []types.AnthropicContentBlock{}
+	return []types.AnthropicStreamEvent{{
+		Type:    types.EventMessageStart,
+		Message: &message,
+	}}
+}
+
+// AddChunk converts a single Gemini stream chunk into Anthropic events. An error is
+// returned if Gemini finished for a reason that can't be expressed as a stop_reason;
+// the returned events should still be sent.
+func (c *StreamConverter) AddChunk(chunk *GenerateContentResponse) ([]types.AnthropicStreamEvent, error) {
+	// Usage metadata is cumulative; the last chunk carries the final counts. It is recorded
+	// before message_start is emitted, so that the first chunk's prompt token count is sent
+	// as the message's input tokens.
+	if chunk.UsageMetadata != nil {
+		c.resp.Usage = AddUsage(ToAnthropicUsage(chunk.UsageMetadata, c.opts.CacheCreated), c.prior)
+	}
+
+	events := c.Start()
+
+	if len(chunk.Candidates) > 0 {
+		candidate := chunk.Candidates[0]
+		if candidate.Content != nil {
+			for _, part := range candidate.Content.Parts {
+				block := convertCustomGeminiPart(part, c.opts)
+				switch {
+				case block == nil:
+				case block.Type == types.ContentTypeToolUse && c.opts.Tools != nil && c.opts.Tools.strategy != ToolArgsPassthrough:
+					c.pending = append(c.pending, *block)
+				case block.Type == types.ContentTypeToolUse:
+					blocks := []types.AnthropicContentBlock{*block}
+					c.opts.Tools.Check(blocks) // Passthrough only logs
+					events = append(events, c.AddBlock(blocks[0])...)
+				default:
+					events = append(events, c.AddBlock(*block)...)
+				}
+			}
+		}
+
+		// Map stop reason
+		if candidate.FinishReason != "" && !c.stopped {
+			stopReason, err := ToStopReason(candidate.FinishReason, hasToolUse(c.resp.Content) || len(c.pending) > 0)
+			if err != nil {
+				return events, err
+			}
+			c.resp.StopReason = stopReason
+		}
+	} else if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" {
+		// The prompt itself was blocked
+		c.resp.StopReason = types.StopReasonRefusal
+	}
+
+	return events, nil
+}
+
+// AddBlock appends a complete Anthropic content block to the stream. Text and thinking
+// blocks are merged with a preceding open block of the same type.
+func (c *StreamConverter) AddBlock(block types.AnthropicContentBlock) []types.AnthropicStreamEvent {
+	events := c.Start()
+	if c.stopped {
+		return events
+	}
+
+	if block.Type != types.ContentTypeText {
+		events = append(events, c.flushText()...)
+	}
+
+	switch block.Type {
+	case types.ContentTypeThinking:
+		if c.openType != types.ContentTypeThinking {
+			events = append(events, c.closeBlock()...)
+			events = append(events, c.openBlock(types.ContentTypeThinking, map[string]interface{}{
+				types.SchemaFieldType: types.ContentTypeThinking,
+				"thinking":            "",
+				"signature":           "",
+			}, block)...)
+		} else {
+			c.resp.Content[c.openIndex].Thinking += block.Thinking
+			if block.Signature != "" {
+				c.resp.Content[c.openIndex].Signature = block.Signature
+			}
+		}
+		if block.Thinking != "" {
+			events = append(events, types.AnthropicStreamEvent{
+				Type:  types.EventContentBlockDelta,
+				Index: intPtr(c.openIndex),
+				Delta: &types.AnthropicDelta{
+					Type:     types.DeltaTypeThinking,
+					Thinking: block.Thinking,
+				},
+			})
+		}
+
+	case types.ContentTypeText:
+		text := block.Text
+		if c.matcher != nil {
+			var matched string
+			text, matched = c.matcher.add(block.Text)
+			if matched != "" {
+				c.stopped = true
+				c.resp.StopReason = types.StopReasonStopSequence
+				c.resp.StopSequence = matched
+			}
+		}
+		events = append(events, c.emitText(text)...)
+
+	case types.ContentTypeToolUse:
+		if c.singleUse && hasToolUse(c.resp.Content) {
+			// Parallel tool use is disabled; drop additional function calls
+			break
+		}
+		events = append(events, c.closeBlock()...)
+		events = append(events, c.openBlock(types.ContentTypeToolUse, map[string]interface{}{
+			types.SchemaFieldType: types.ContentTypeToolUse,
+			"id":                  block.ID,
+			"name":                block.Name,
+			"input":               map[string]interface{}{},
+		}, block)...)
+
+		input := block.Input
+		if input == nil {
+			input = map[string]interface{}{}
+		}
+		partialJSON, err := json.Marshal(input)
+		if err != nil {
+			partialJSON = []byte("{}")
+		}
+		events = append(events, types.AnthropicStreamEvent{
+			Type:  types.EventContentBlockDelta,
+			Index: intPtr(c.openIndex),
+			Delta: &types.AnthropicDelta{
+				Type:        types.DeltaTypeInputJSON,
+				PartialJSON: string(partialJSON),
+			},
+		})
+		events = append(events, c.closeBlock()...)
+	}
+
+	return events
+}
+
+// Finish closes any open content block and returns the message_delta and message_stop
+// events. tool_use blocks still held back are emitted without further validation.
+func (c *StreamConverter) Finish() []types.AnthropicStreamEvent {
+	events := c.Start()
+	for _, block := range c.pending {
+		events = append(events, c.AddBlock(block)...)
+	}
+	c.pending = nil
+	events = append(events, c.flushText()...)
+	events = append(events, c.closeBlock()...)
+
+	if c.resp.StopReason == "" {
+		c.resp.StopReason = types.StopReasonEndTurn
+	}
+	usage := c.resp.Usage
+
+	events = append(events,
+		types.AnthropicStreamEvent{
+			Type: types.EventMessageDelta,
+			Delta: &types.AnthropicDelta{
+				StopReason:   c.resp.StopReason,
+				StopSequence: c.resp.StopSequence,
+			},
+			Usage: &usage,
+		},
+		types.AnthropicStreamEvent{
+			Type: types.EventMessageStop,
+		},
+	)
+	return events
+}
+
+// Response returns the response accumulated from all chunks seen so far
+func (c *StreamConverter) Response() *types.AnthropicResponse {
+	return c.resp
+}
+
+// ToStreamEvents replays a complete Anthropic response as a stream of events. This is
+// used when the upstream call could not be streamed (e.g., the SDK path).
+func ToStreamEvents(resp *types.AnthropicResponse) []types.AnthropicStreamEvent {
+	c := NewStreamConverter(resp.Model)
+	c.resp.ID = resp.ID
+
+	events := c.Start()
+	for _, block := range resp.Content {
+		events = append(events, c.AddBlock(block)...)
+	}
+	c.resp.StopReason = resp.StopReason
+	c.resp.StopSequence = resp.StopSequence
+	c.resp.Usage = resp.Usage
+	return append(events, c.Finish()...)
+}
+
+// emitText appends text to the open text block, opening one if necessary
+func (c *StreamConverter) emitText(text string) []types.AnthropicStreamEvent {
+	if text == "" {
+		return nil
+	}
+
+	var events []types.AnthropicStreamEvent
+	if c.openType != types.ContentTypeText {
+		events = append(events, c.closeBlock()...)
+		events = append(events, c.openBlock(types.ContentTypeText, map[string]interface{}{
+			types.SchemaFieldType: types.ContentTypeText,
+			"text":                "",
+		}, types.AnthropicContentBlock{Type: types.ContentTypeText, Text: text})...)
+	} else {
+		c.resp.Content[c.openIndex].Text += text
+	}
+
+	return append(events, types.AnthropicStreamEvent{
+		Type:  types.EventContentBlockDelta,
+		Index: intPtr(c.openIndex),
+		Delta: &types.AnthropicDelta{
+			Type: types.DeltaTypeText,
+			Text: text,
+		},
+	})
+}
+
+// flushText emits any text held back by the stop sequence matcher
+func (c *StreamConverter) flushText() []types.AnthropicStreamEvent {
+	if c.matcher == nil {
+		return nil
+	}
+	return c.emitText(c.matcher.flush())
+}
+
+func (c *StreamConverter) openBlock(blockType string, start map[string]interface{}, block types.AnthropicContentBlock) []types.AnthropicStreamEvent {
+	c.resp.Content = append(c.resp.Content, block)
+	c.openIndex = len(c.resp.Content) - 1
+	c.openType = blockType
+
+	return []types.AnthropicStreamEvent{{
+		Type:         types.EventContentBlockStart,
+		Index:        intPtr(c.openIndex),
+		ContentBlock: start,
+	}}
+}
+
+func (c *StreamConverter) closeBlock() []types.AnthropicStreamEvent {
+	if c.openIndex < 0 {
+		return nil
+	}
+	var events []types.AnthropicStreamEvent
+	index := c.openIndex
+	if c.openType == types.ContentTypeThinking {
+		if sig := c.resp.Content[index].Signature; sig != "" {
+			events = append(events, types.AnthropicStreamEvent{
+				Type:  types.EventContentBlockDelta,
+				Index: intPtr(index),
+				Delta: &types.AnthropicDelta{
+					Type:      types.DeltaTypeSignature,
+					Signature: sig,
+				},
+			})
+		}
+	}
+	c.openIndex = -1
+	c.openType = ""
+
+	return append(events, types.AnthropicStreamEvent{
+		Type:  types.EventContentBlockStop,
+		Index: intPtr(index),
+	})
+}
+
+func intPtr(v int) *int {
+	return &v
+}
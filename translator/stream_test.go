@@ -0,0 +1,451 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func eventTypes(events []types.AnthropicStreamEvent) []string {
+	var result []string
+	for _, e := range events {
+		result = append(result, e.Type)
+	}
+	return result
+}
+
+func mustAddChunk(t *testing.T, c *StreamConverter, chunk *GenerateContentResponse) []types.AnthropicStreamEvent {
+	t.Helper()
+	events, err := c.AddChunk(chunk)
+	if err != nil {
+		t.Fatalf("AddChunk failed: %v", err)
+	}
+	return events
+}
+
+func assertEventTypes(t *testing.T, events []types.AnthropicStreamEvent, expected []string) {
+	t.Helper()
+	got := eventTypes(events)
+	if len(got) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected events %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestStreamConverter_TextChunks(t *testing.T) {
+	c := NewStreamConverter("gemini-3-pro-preview")
+
+	var events []types.AnthropicStreamEvent
+	events = append(events, mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{{Text: "Hello"}}},
+		}},
+	})...)
+	events = append(events, mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content:      &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{{Text: ", world"}}},
+			FinishReason: "STOP",
+		}},
+		UsageMetadata: &UsageMetadata{PromptTokenCount: 10, CandidatesTokenCount: 4},
+	})...)
+	events = append(events, c.Finish()...)
+
+	assertEventTypes(t, events, []string{
+		types.EventMessageStart,
+		types.EventContentBlockStart,
+		types.EventContentBlockDelta,
+		types.EventContentBlockDelta,
+		types.EventContentBlockStop,
+		types.EventMessageDelta,
+		types.EventMessageStop,
+	})
+
+	if events[0].Message == nil || events[0].Message.Model != "gemini-3-pro-preview" {
+		t.Errorf("expected message_start to carry the model, got %+v", events[0].Message)
+	}
+	if events[2].Delta.Type != types.DeltaTypeText || events[2].Delta.Text != "Hello" {
+		t.Errorf("unexpected first delta: %+v", events[2].Delta)
+	}
+	if *events[3].Index != 0 {
+		t.Errorf("expected second delta to target block 0, got %d", *events[3].Index)
+	}
+
+	messageDelta := events[5]
+	if messageDelta.Delta.StopReason != types.StopReasonEndTurn {
+		t.Errorf("expected stop_reason end_turn, got %q", messageDelta.Delta.StopReason)
+	}
+	if messageDelta.Usage.InputTokens != 10 || messageDelta.Usage.OutputTokens != 4 {
+		t.Errorf("unexpected usage: %+v", messageDelta.Usage)
+	}
+
+	resp := c.Response()
+	if len(resp.Content) != 1 || resp.Content[0].Text != "Hello, world" {
+		t.Errorf("expected accumulated text 'Hello, world', got %+v", resp.Content)
+	}
+}
+
+func TestStreamConverter_MessageStartUsage(t *testing.T) {
+	c := NewStreamConverter("gemini-3-pro-preview")
+
+	events := mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{{Text: "Hello"}}},
+		}},
+		UsageMetadata: &UsageMetadata{PromptTokenCount: 25, CandidatesTokenCount: 1},
+	})
+
+	if events[0].Type != types.EventMessageStart || events[0].Message.Usage.InputTokens != 25 {
+		t.Errorf("expected message_start with 25 input tokens, got %+v", events[0].Message)
+	}
+}
+
+func TestStreamConverter_FunctionCall(t *testing.T) {
+	c := NewStreamConverter("gemini-3-pro-preview")
+
+	var events []types.AnthropicStreamEvent
+	events = append(events, mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{
+				{Text: "Let me check."},
+				{
+					FunctionCall: &types.GeminiFunctionCall{
+						Name: "get_weather",
+						Args: map[string]interface{}{"location": "Paris"},
+					},
+					ThoughtSignature: "sig-abc",
+				},
+			}},
+			FinishReason: "STOP",
+		}},
+	})...)
+	events = append(events, c.Finish()...)
+
+	assertEventTypes(t, events, []string{
+		types.EventMessageStart,
+		types.EventContentBlockStart,
+		types.EventContentBlockDelta,
+		types.EventContentBlockStop,
+		types.EventContentBlockStart,
+		types.EventContentBlockDelta,
+		types.EventContentBlockStop,
+		types.EventMessageDelta,
+		types.EventMessageStop,
+	})
+
+	start, ok := events[4].ContentBlock.(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected content_block to be a map, got %T", events[4].ContentBlock)
+	}
+	if start["type"] != types.ContentTypeToolUse || start["name"] != "get_weather" {
+		t.Errorf("unexpected tool_use start block: %+v", start)
+	}
+	if *events[4].Index != 1 {
+		t.Errorf("expected tool_use block at index 1, got %d", *events[4].Index)
+	}
+	if events[5].Delta.Type != types.DeltaTypeInputJSON || events[5].Delta.PartialJSON != `{"location":"Paris"}` {
+		t.Errorf("unexpected input_json_delta: %+v", events[5].Delta)
+	}
+	if events[7].Delta.StopReason != types.StopReasonToolUse {
+		t.Errorf("expected stop_reason tool_use, got %q", events[7].Delta.StopReason)
+	}
+
+	resp := c.Response()
+	if len(resp.Content) != 2 {
+		t.Fatalf("expected 2 accumulated blocks, got %d", len(resp.Content))
+	}
+	if resp.Content[1].ThoughtSignature != "sig-abc" {
+		t.Errorf("expected thought signature to be preserved, got %q", resp.Content[1].ThoughtSignature)
+	}
+	if resp.Content[1].ID != start["id"] {
+		t.Errorf("expected accumulated tool_use ID %q to match streamed ID %v", resp.Content[1].ID, start["id"])
+	}
+}
+
+func TestToStreamEvents(t *testing.T) {
+	resp := &types.AnthropicResponse{
+		ID:    "msg_123",
+		Model: "gemini-2.0-flash",
+		Content: []types.AnthropicContentBlock{
+			{Type: types.ContentTypeText, Text: "Hi"},
+		},
+		StopReason: types.StopReasonEndTurn,
+		Usage:      types.AnthropicUsage{InputTokens: 3, OutputTokens: 1},
+	}
+
+	events := ToStreamEvents(resp)
+	assertEventTypes(t, events, []string{
+		types.EventMessageStart,
+		types.EventContentBlockStart,
+		types.EventContentBlockDelta,
+		types.EventContentBlockStop,
+		types.EventMessageDelta,
+		types.EventMessageStop,
+	})
+
+	if events[0].Message.ID != "msg_123" {
+		t.Errorf("expected message ID to be preserved, got %q", events[0].Message.ID)
+	}
+	if events[4].Usage.OutputTokens != 1 {
+		t.Errorf("expected output tokens 1, got %d", events[4].Usage.OutputTokens)
+	}
+}
+
+func TestStreamConverter_Thinking(t *testing.T) {
+	c := NewStreamConverter("gemini-3-pro-preview")
+
+	var events []types.AnthropicStreamEvent
+	events = append(events, mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{{Text: "Thinking about", Thought: true}}},
+		}},
+	})...)
+	events = append(events, mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{
+				{Text: " it", Thought: true, ThoughtSignature: "sig-think"},
+				{Text: "Answer"},
+			}},
+			FinishReason: "STOP",
+		}},
+	})...)
+	events = append(events, c.Finish()...)
+
+	assertEventTypes(t, events, []string{
+		types.EventMessageStart,
+		types.EventContentBlockStart,
+		types.EventContentBlockDelta, // thinking_delta
+		types.EventContentBlockDelta, // thinking_delta
+		types.EventContentBlockDelta, // signature_delta
+		types.EventContentBlockStop,
+		types.EventContentBlockStart,
+		types.EventContentBlockDelta,
+		types.EventContentBlockStop,
+		types.EventMessageDelta,
+		types.EventMessageStop,
+	})
+
+	start := events[1].ContentBlock.(map[string]interface{})
+	if start["type"] != types.ContentTypeThinking {
+		t.Errorf("expected thinking block start, got %+v", start)
+	}
+	if events[2].Delta.Type != types.DeltaTypeThinking || events[2].Delta.Thinking != "Thinking about" {
+		t.Errorf("unexpected thinking delta: %+v", events[2].Delta)
+	}
+	if events[4].Delta.Type != types.DeltaTypeSignature || events[4].Delta.Signature != "sig-think" {
+		t.Errorf("unexpected signature delta: %+v", events[4].Delta)
+	}
+
+	resp := c.Response()
+	if resp.Content[0].Thinking != "Thinking about it" || resp.Content[0].Signature != "sig-think" {
+		t.Errorf("unexpected accumulated thinking block: %+v", resp.Content[0])
+	}
+}
+
+func TestStreamConverter_StopSequence(t *testing.T) {
+	c := NewStreamConverter("gemini-2.0-flash")
+	c.SetStopSequences([]string{"<END>"})
+
+	var events []types.AnthropicStreamEvent
+	for _, text := range []string{"Answer: 42 <E", "ND> more text"} {
+		events = append(events, mustAddChunk(t, c, &GenerateContentResponse{
+			Candidates: []Candidate{{
+				Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{{Text: text}}},
+			}},
+		})...)
+	}
+	if !c.Stopped() {
+		t.Fatal("expected converter to report stopped")
+	}
+
+	// Content after the stop sequence is discarded
+	events = append(events, mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{{Text: "ignored"}}},
+		}},
+	})...)
+	events = append(events, c.Finish()...)
+
+	var text string
+	for _, e := range events {
+		if e.Type == types.EventContentBlockDelta {
+			text += e.Delta.Text
+		}
+	}
+	if text != "Answer: 42 " {
+		t.Errorf("expected streamed text 'Answer: 42 ', got %q", text)
+	}
+
+	messageDelta := events[len(events)-2]
+	if messageDelta.Delta.StopReason != types.StopReasonStopSequence || messageDelta.Delta.StopSequence != "<END>" {
+		t.Errorf("unexpected message_delta: %+v", messageDelta.Delta)
+	}
+}
+
+func TestStreamConverter_MalformedFunctionCall(t *testing.T) {
+	c := NewStreamConverter("gemini-3-pro-preview")
+
+	_, err := c.AddChunk(&GenerateContentResponse{
+		Candidates: []Candidate{{FinishReason: FinishReasonMalformedFunctionCall}},
+	})
+
+	var finishErr *FinishReasonError
+	if !errors.As(err, &finishErr) {
+		t.Fatalf("expected FinishReasonError, got %v", err)
+	}
+}
+
+func TestStreamConverter_PromptBlocked(t *testing.T) {
+	c := NewStreamConverter("gemini-3-pro-preview")
+
+	events := mustAddChunk(t, c, &GenerateContentResponse{
+		PromptFeedback: &PromptFeedback{BlockReason: "SAFETY"},
+	})
+	events = append(events, c.Finish()...)
+
+	messageDelta := events[len(events)-2]
+	if messageDelta.Delta.StopReason != types.StopReasonRefusal {
+		t.Errorf("expected stop_reason refusal, got %q", messageDelta.Delta.StopReason)
+	}
+}
+
+func TestStreamConverter_DisableParallelToolUse(t *testing.T) {
+	c := NewStreamConverter("gemini-3-pro-preview")
+	c.SetDisableParallelToolUse(true)
+
+	events := mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{
+				{FunctionCall: &types.GeminiFunctionCall{Name: "first"}},
+				{FunctionCall: &types.GeminiFunctionCall{Name: "second"}},
+			}},
+			FinishReason: "STOP",
+		}},
+	})
+	events = append(events, c.Finish()...)
+
+	starts := 0
+	for _, e := range events {
+		if e.Type == types.EventContentBlockStart {
+			starts++
+		}
+	}
+	if starts != 1 {
+		t.Errorf("expected 1 content block, got %d", starts)
+	}
+	if len(c.Response().Content) != 1 || c.Response().Content[0].Name != "first" {
+		t.Errorf("expected only the first tool_use, got %+v", c.Response().Content)
+	}
+}
+
+func TestStreamConverter_ToolValidator(t *testing.T) {
+	tools := []types.AnthropicTool{{
+		Name: "get_weather",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"days": map[string]interface{}{"type": "integer"}},
+			"required":   []interface{}{"days"},
+		},
+	}}
+	chunk := func(args map[string]interface{}) *GenerateContentResponse {
+		return &GenerateContentResponse{Candidates: []Candidate{{
+			Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{
+				{FunctionCall: &types.GeminiFunctionCall{Name: "get_weather", Args: args}},
+			}},
+			FinishReason: "STOP",
+		}}}
+	}
+
+	t.Run("held back until released", func(t *testing.T) {
+		c := NewStreamConverter("gemini-2.5-flash")
+		c.SetToolValidator(NewToolValidator(tools, ToolArgsReprompt))
+
+		events := mustAddChunk(t, c, chunk(map[string]interface{}{"days": "2"}))
+		assertEventTypes(t, events, []string{types.EventMessageStart})
+
+		events, err := c.ReleaseToolUse()
+		if err != nil {
+			t.Fatalf("ReleaseToolUse failed: %v", err)
+		}
+		assertEventTypes(t, events, []string{
+			types.EventContentBlockStart,
+			types.EventContentBlockDelta,
+			types.EventContentBlockStop,
+		})
+		if events[1].Delta.PartialJSON != `{"days":2}` {
+			t.Errorf("expected repaired arguments, got %s", events[1].Delta.PartialJSON)
+		}
+		if c.Response().StopReason != types.StopReasonToolUse {
+			t.Errorf("expected stop_reason tool_use, got %q", c.Response().StopReason)
+		}
+	})
+
+	t.Run("invalid calls are dropped", func(t *testing.T) {
+		c := NewStreamConverter("gemini-2.5-flash")
+		c.SetToolValidator(NewToolValidator(tools, ToolArgsError))
+
+		mustAddChunk(t, c, chunk(map[string]interface{}{}))
+		events, err := c.ReleaseToolUse()
+		var argsErr *InvalidToolArgsError
+		if !errors.As(err, &argsErr) {
+			t.Fatalf("expected InvalidToolArgsError, got %v", err)
+		}
+		if len(events) != 0 || len(c.Response().Content) != 0 {
+			t.Errorf("expected no tool_use to be emitted, got %v", eventTypes(events))
+		}
+	})
+
+	t.Run("passthrough streams immediately", func(t *testing.T) {
+		c := NewStreamConverter("gemini-2.5-flash")
+		c.SetToolValidator(NewToolValidator(tools, ToolArgsPassthrough))
+
+		events := mustAddChunk(t, c, chunk(map[string]interface{}{"days": "2"}))
+		assertEventTypes(t, events, []string{
+			types.EventMessageStart,
+			types.EventContentBlockStart,
+			types.EventContentBlockDelta,
+			types.EventContentBlockStop,
+		})
+		if events[2].Delta.PartialJSON != `{"days":2}` {
+			t.Errorf("expected repaired arguments, got %s", events[2].Delta.PartialJSON)
+		}
+	})
+}
+
+func TestStreamConverter_CacheCreatedUsage(t *testing.T) {
+	c := NewStreamConverter("gemini-2.5-flash")
+	c.SetCacheCreated(true)
+
+	mustAddChunk(t, c, &GenerateContentResponse{
+		Candidates: []Candidate{{
+			Content:      &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{{Text: "Done"}}},
+			FinishReason: "STOP",
+		}},
+		UsageMetadata: &UsageMetadata{PromptTokenCount: 3000, CachedContentTokenCount: 2048, CandidatesTokenCount: 2, ThoughtsTokenCount: 100},
+	})
+	events := c.Finish()
+
+	usage := events[len(events)-2].Usage
+	expected := types.AnthropicUsage{InputTokens: 952, CacheCreationInputTokens: 2048, OutputTokens: 102}
+	if usage == nil || *usage != expected {
+		t.Errorf("got usage %+v, want %+v", usage, expected)
+	}
+}
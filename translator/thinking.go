@@ -0,0 +1,138 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// Gemini 3 thinking levels
+const (
+	ThinkingLevelLow  = "low"
+	ThinkingLevelHigh = "high"
+)
+
+// thinkingLevelHighThreshold is the smallest Anthropic budget_tokens value mapped to
+// the high thinking level on Gemini 3. Claude Code's "think" keyword requests ~4k
+// tokens, while "think hard" and "ultrathink" request 10k and 32k respectively.
+const thinkingLevelHighThreshold = 8192
+
+// thinkingBudgetRange describes the thinkingBudget values a Gemini 2.5 model accepts
+type thinkingBudgetRange struct {
+	min        int32
+	max        int32
+	canDisable bool // Whether a budget of 0 turns thinking off
+}
+
+// thinkingBudgetRanges lists budget ranges by model prefix. More specific prefixes
+// must come first.
+var thinkingBudgetRanges = []struct {
+	prefix string
+	budget thinkingBudgetRange
+}{
+	{prefix: "gemini-2.5-pro", budget: thinkingBudgetRange{min: 128, max: 32768, canDisable: false}},
+	{prefix: "gemini-2.5-flash-lite", budget: thinkingBudgetRange{min: 512, max: 24576, canDisable: true}},
+	{prefix: "gemini-2.5-flash", budget: thinkingBudgetRange{min: 1, max: 24576, canDisable: true}},
+}
+
+// SupportsThinking reports whether the Gemini model accepts a thinkingConfig.
+// Gemini 2.5 and Gemini 3 models think; earlier models reject the field.
+func SupportsThinking(model string) bool {
+	model = strings.TrimPrefix(model, "models/")
+	return strings.HasPrefix(model, "gemini-2.5") || strings.HasPrefix(model, "gemini-3")
+}
+
+// ToThinkingConfig maps an Anthropic thinking configuration onto a Gemini thinkingConfig
+// for the given model:
+//
+//   - No thinking parameter: thoughts are included and the model's dynamic default applies
+//   - Gemini 3: budget_tokens selects the low or high thinking level
+//   - Gemini 2.5: budget_tokens becomes thinkingBudget, clamped to the model's range
+//   - Disabled: thinking is turned off where the model allows it, otherwise minimized
+//
+// A non-empty warning is returned when the request couldn't be honored exactly (e.g.,
+// the budget was clamped). The returned config is nil for models without thinking.
+func ToThinkingConfig(model string, thinking *types.AnthropicThinking) (*ThinkingConfig, string) {
+	if !SupportsThinking(model) {
+		if thinking != nil && thinking.Type == types.ThinkingTypeEnabled {
+			return nil, fmt.Sprintf("model %s does not support thinking; ignoring thinking budget", model)
+		}
+		return nil, ""
+	}
+
+	if thinking == nil || (thinking.Type != types.ThinkingTypeEnabled && thinking.Type != types.ThinkingTypeDisabled) {
+		return &ThinkingConfig{IncludeThoughts: true}, ""
+	}
+	disabled := thinking.Type == types.ThinkingTypeDisabled
+
+	model = strings.TrimPrefix(model, "models/")
+	if strings.HasPrefix(model, "gemini-3") {
+		// Gemini 3 can't turn thinking off entirely; use the lowest level instead
+		if disabled {
+			return &ThinkingConfig{ThinkingLevel: ThinkingLevelLow}, ""
+		}
+		level := ThinkingLevelLow
+		if thinking.BudgetTokens >= thinkingLevelHighThreshold {
+			level = ThinkingLevelHigh
+		}
+		return &ThinkingConfig{IncludeThoughts: true, ThinkingLevel: level}, ""
+	}
+
+	budgetRange, ok := lookupThinkingBudgetRange(model)
+	if !ok {
+		// Unknown 2.5 variant; pass the budget through and let Gemini validate it
+		if disabled {
+			return &ThinkingConfig{ThinkingBudget: int32Ptr(0)}, ""
+		}
+		return &ThinkingConfig{IncludeThoughts: true, ThinkingBudget: int32Ptr(int32(thinking.BudgetTokens))}, ""
+	}
+
+	if disabled {
+		if budgetRange.canDisable {
+			return &ThinkingConfig{ThinkingBudget: int32Ptr(0)}, ""
+		}
+		return &ThinkingConfig{ThinkingBudget: int32Ptr(budgetRange.min)},
+			fmt.Sprintf("model %s cannot disable thinking; using minimum budget of %d tokens", model, budgetRange.min)
+	}
+
+	budget := int32(thinking.BudgetTokens)
+	var warning string
+	switch {
+	case budget < budgetRange.min:
+		warning = fmt.Sprintf("thinking budget %d is below the minimum for %s; clamping to %d", budget, model, budgetRange.min)
+		budget = budgetRange.min
+	case budget > budgetRange.max:
+		warning = fmt.Sprintf("thinking budget %d exceeds the maximum for %s; clamping to %d", budget, model, budgetRange.max)
+		budget = budgetRange.max
+	}
+
+	return &ThinkingConfig{IncludeThoughts: true, ThinkingBudget: int32Ptr(budget)}, warning
+}
+
+func lookupThinkingBudgetRange(model string) (thinkingBudgetRange, bool) {
+	for _, r := range thinkingBudgetRanges {
+		if strings.HasPrefix(model, r.prefix) {
+			return r.budget, true
+		}
+	}
+	return thinkingBudgetRange{}, false
+}
+
+func int32Ptr(v int32) *int32 {
+	return &v
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestSupportsThinking(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected bool
+	}{
+		{model: "gemini-3-pro-preview", expected: true},
+		{model: "gemini-2.5-pro", expected: true},
+		{model: "gemini-2.5-flash", expected: true},
+		{model: "models/gemini-2.5-flash-lite", expected: true},
+		{model: "gemini-2.0-flash", expected: false},
+		{model: "gemini-1.5-pro", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := SupportsThinking(tt.model); got != tt.expected {
+				t.Errorf("SupportsThinking(%q) = %v, want %v", tt.model, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestToThinkingConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		model       string
+		thinking    *types.AnthropicThinking
+		expectNil   bool
+		include     bool
+		budget      *int32
+		level       string
+		wantWarning bool
+	}{
+		{
+			name:      "non-thinking model without thinking",
+			model:     "gemini-2.0-flash",
+			expectNil: true,
+		},
+		{
+			name:        "non-thinking model with thinking enabled",
+			model:       "gemini-2.0-flash",
+			thinking:    &types.AnthropicThinking{Type: "enabled", BudgetTokens: 4000},
+			expectNil:   true,
+			wantWarning: true,
+		},
+		{
+			name:    "no thinking parameter uses dynamic default",
+			model:   "gemini-2.5-pro",
+			include: true,
+		},
+		{
+			name:     "gemini 3 small budget maps to low",
+			model:    "gemini-3-pro-preview",
+			thinking: &types.AnthropicThinking{Type: "enabled", BudgetTokens: 4000},
+			include:  true,
+			level:    ThinkingLevelLow,
+		},
+		{
+			name:     "gemini 3 ultrathink maps to high",
+			model:    "gemini-3-pro-preview",
+			thinking: &types.AnthropicThinking{Type: "enabled", BudgetTokens: 31999},
+			include:  true,
+			level:    ThinkingLevelHigh,
+		},
+		{
+			name:     "gemini 3 disabled uses low level without thoughts",
+			model:    "gemini-3-pro-preview",
+			thinking: &types.AnthropicThinking{Type: "disabled"},
+			level:    ThinkingLevelLow,
+		},
+		{
+			name:     "gemini 2.5 flash budget within range",
+			model:    "gemini-2.5-flash",
+			thinking: &types.AnthropicThinking{Type: "enabled", BudgetTokens: 10000},
+			include:  true,
+			budget:   int32Ptr(10000),
+		},
+		{
+			name:        "gemini 2.5 flash budget clamped to maximum",
+			model:       "gemini-2.5-flash",
+			thinking:    &types.AnthropicThinking{Type: "enabled", BudgetTokens: 31999},
+			include:     true,
+			budget:      int32Ptr(24576),
+			wantWarning: true,
+		},
+		{
+			name:     "gemini 2.5 flash disabled",
+			model:    "gemini-2.5-flash",
+			thinking: &types.AnthropicThinking{Type: "disabled"},
+			budget:   int32Ptr(0),
+		},
+		{
+			name:        "gemini 2.5 pro cannot disable",
+			model:       "gemini-2.5-pro",
+			thinking:    &types.AnthropicThinking{Type: "disabled"},
+			budget:      int32Ptr(128),
+			wantWarning: true,
+		},
+		{
+			name:        "gemini 2.5 flash lite budget clamped to minimum",
+			model:       "gemini-2.5-flash-lite",
+			thinking:    &types.AnthropicThinking{Type: "enabled", BudgetTokens: 100},
+			include:     true,
+			budget:      int32Ptr(512),
+			wantWarning: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, warning := ToThinkingConfig(tt.model, tt.thinking)
+
+			if (warning != "") != tt.wantWarning {
+				t.Errorf("expected warning=%v, got %q", tt.wantWarning, warning)
+			}
+			if tt.expectNil {
+				if config != nil {
+					t.Errorf("expected nil config, got %+v", config)
+				}
+				return
+			}
+			if config == nil {
+				t.Fatal("expected config, got nil")
+			}
+			if config.IncludeThoughts != tt.include {
+				t.Errorf("expected IncludeThoughts=%v, got %v", tt.include, config.IncludeThoughts)
+			}
+			if config.ThinkingLevel != tt.level {
+				t.Errorf("expected ThinkingLevel %q, got %q", tt.level, config.ThinkingLevel)
+			}
+			switch {
+			case tt.budget == nil && config.ThinkingBudget != nil:
+				t.Errorf("expected no ThinkingBudget, got %d", *config.ThinkingBudget)
+			case tt.budget != nil && config.ThinkingBudget == nil:
+				t.Errorf("expected ThinkingBudget %d, got nil", *tt.budget)
+			case tt.budget != nil && *config.ThinkingBudget != *tt.budget:
+				t.Errorf("expected ThinkingBudget %d, got %d", *tt.budget, *config.ThinkingBudget)
+			}
+		})
+	}
+}
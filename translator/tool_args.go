@@ -0,0 +1,623 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// ToolArgsStrategy decides what happens to a function call whose arguments don't match
+// the tool's input schema even after safe coercions
+type ToolArgsStrategy string
+
+// Tool argument strategies
+const (
+	ToolArgsPassthrough ToolArgsStrategy = "passthrough" // Log a warning and send the arguments as they are
+	ToolArgsError       ToolArgsStrategy = "error"       // Fail the request
+	ToolArgsReprompt    ToolArgsStrategy = "reprompt"    // Ask the model to correct the call once, then fail
+)
+
+// ParseToolArgsStrategy validates a strategy name
+func ParseToolArgsStrategy(name string) (ToolArgsStrategy, error) {
+	switch strategy := ToolArgsStrategy(name); strategy {
+	case ToolArgsPassthrough, ToolArgsError, ToolArgsReprompt:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown tool argument strategy %q (expected %s, %s or %s)", name,
+		ToolArgsPassthrough, ToolArgsError, ToolArgsReprompt)
+}
+
+// maxSchemaDepth bounds recursion through (possibly recursive) $refs while validating
+const maxSchemaDepth = 64
+
+// ToolValidator checks function call arguments against the input schemas of the tools in
+// a request, repairing what can be repaired safely:
+//
+//   - strings holding numbers or booleans are converted ("42" -> 42, "true" -> true)
+//   - numbers and booleans are converted to strings where a string is expected
+//   - strings holding JSON objects or arrays are parsed where one is expected
+//   - a single value is wrapped in an array where an array is expected
+//   - enum values in the wrong case are replaced by the declared value
+//   - null optional properties and properties not allowed by additionalProperties: false
+//     are removed
+//   - missing required properties with a default are filled in
+type ToolValidator struct {
+	schemas  map[string]map[string]interface{}
+	strategy ToolArgsStrategy
+}
+
+// NewToolValidator creates a validator for the given tools, or returns nil if there are none
+func NewToolValidator(tools []types.AnthropicTool, strategy ToolArgsStrategy) *ToolValidator {
+	if len(tools) == 0 {
+		return nil
+	}
+	v := &ToolValidator{
+		schemas:  make(map[string]map[string]interface{}, len(tools)),
+		strategy: strategy,
+	}
+	for _, tool := range tools {
+		v.schemas[tool.Name] = tool.InputSchema
+	}
+	return v
+}
+
+// InvalidToolCall describes a function call whose arguments couldn't be repaired
+type InvalidToolCall struct {
+	Index    int // Position among the function calls of the response
+	Name     string
+	Problems []string
+}
+
+// InvalidToolArgsError reports function calls whose arguments don't match the tool's input schema
+type InvalidToolArgsError struct {
+	Calls []InvalidToolCall
+}
+
+// Error implements error
+func (e *InvalidToolArgsError) Error() string {
+	var calls []string
+	for _, call := range e.Calls {
+		calls = append(calls, fmt.Sprintf("%s: %s", call.Name, strings.Join(call.Problems, "; ")))
+	}
+	return "invalid function call arguments from Gemini: " + strings.Join(calls, ", ")
+}
+
+// Check repairs the arguments of the tool_use blocks in content in place. Calls that
+// can't be repaired are returned as an *InvalidToolArgsError, unless the strategy is passthrough,
+// in which case they are only logged. A nil validator accepts everything.
+func (v *ToolValidator) Check(content []types.AnthropicContentBlock) error {
+	if v == nil {
+		return nil
+	}
+
+	var invalid []InvalidToolCall
+	index := 0
+	for i := range content {
+		block := &content[i]
+		if block.Type != types.ContentTypeToolUse {
+			continue
+		}
+		if problems := v.repair(block); len(problems) > 0 {
+			invalid = append(invalid, InvalidToolCall{Index: index, Name: block.Name, Problems: problems})
+		}
+		index++
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+
+	err := &InvalidToolArgsError{Calls: invalid}
+	if v.strategy == ToolArgsPassthrough {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	return err
+}
+
+// repair checks a single tool_use block, returning the problems it couldn't repair
+func (v *ToolValidator) repair(block *types.AnthropicContentBlock) []string {
+	schema, ok := v.schemas[block.Name]
+	if !ok {
+		return []string{fmt.Sprintf("tool %q is not defined in the request", block.Name)}
+	}
+	if schema == nil {
+		return nil
+	}
+
+	var input interface{} = block.Input
+	if block.Input == nil {
+		input = map[string]interface{}{}
+	}
+
+	c := &argsChecker{root: schema}
+	repaired, _ := c.check(input, schema, "", 0)
+	if m, ok := repaired.(map[string]interface{}); ok {
+		block.Input = m
+	}
+	for _, repair := range c.repairs {
+		log.Printf("Repaired %s arguments: %s", block.Name, repair)
+	}
+	return c.problems
+}
+
+// RepromptContents returns the contents to append to a request so the model can correct
+// the calls err reports as invalid: the model's own turn, followed by a function response
+// for each of its function calls. Valid calls from the same turn weren't sent to the
+// client either, so the model is asked to repeat them.
+func RepromptContents(content *types.GeminiContent, err *InvalidToolArgsError) []types.GeminiContent {
+	if content == nil {
+		return nil
+	}
+
+	problems := map[int][]string{}
+	for _, call := range err.Calls {
+		problems[call.Index] = call.Problems
+	}
+
+	model := types.GeminiContent{Role: types.RoleModel, Parts: content.Parts}
+	reply := types.GeminiContent{Role: types.RoleUser}
+	index := 0
+	for _, part := range content.Parts {
+		if part.FunctionCall == nil {
+			continue
+		}
+		message := "Not executed because another function call in this turn had invalid arguments. Call it again if it is still needed."
+		if p, ok := problems[index]; ok {
+			message = "Invalid arguments: " + strings.Join(p, "; ") + ". Call the function again with arguments that match its parameter schema."
+		}
+		reply.Parts = append(reply.Parts, types.GeminiPart{
+			FunctionResponse: &types.GeminiFunctionResponse{
+				Name:     part.FunctionCall.Name,
+				Response: map[string]interface{}{types.ResponseFieldError: message},
+			},
+		})
+		index++
+	}
+
+	return []types.GeminiContent{model, reply}
+}
+
+// argsChecker validates a value against a JSON Schema, collecting the repairs it made and
+// the problems it couldn't repair
+type argsChecker struct {
+	root     map[string]interface{} // Document $refs are resolved against
+	repairs  []string
+	problems []string
+}
+
+func (c *argsChecker) fail(path, format string, args ...interface{}) {
+	c.problems = append(c.problems, describePath(path)+" "+fmt.Sprintf(format, args...))
+}
+
+func (c *argsChecker) repaired(path, format string, args ...interface{}) {
+	c.repairs = append(c.repairs, describePath(path)+" "+fmt.Sprintf(format, args...))
+}
+
+// check validates value against schema, returning the repaired value and whether it is
+// valid. Problems are only recorded in c when it isn't.
+func (c *argsChecker) check(value interface{}, schema map[string]interface{}, path string, depth int) (interface{}, bool) {
+	if depth > maxSchemaDepth {
+		return value, true
+	}
+
+	if ref, ok := schema[types.SchemaFieldRef].(string); ok {
+		target, found := resolveRef(c.root, ref)
+		if !found {
+			return value, true // Can't check against a schema we don't have
+		}
+		value, ok := c.check(value, target, path, depth+1)
+		if !ok {
+			return value, false
+		}
+		return c.check(value, without(schema, types.SchemaFieldRef), path, depth+1)
+	}
+
+	if members, ok := schema[types.SchemaFieldAllOf].([]interface{}); ok {
+		valid := true
+		for _, member := range members {
+			if m, ok := asSchema(member); ok {
+				var memberValid bool
+				value, memberValid = c.check(value, m, path, depth+1)
+				valid = valid && memberValid
+			}
+		}
+		value, ok := c.check(value, without(schema, types.SchemaFieldAllOf), path, depth+1)
+		return value, valid && ok
+	}
+
+	for _, keyword := range []string{types.SchemaFieldAnyOf, types.SchemaFieldOneOf} {
+		if variants, ok := schema[keyword].([]interface{}); ok {
+			value, ok := c.checkVariants(value, variants, path, depth)
+			if !ok {
+				return value, false
+			}
+			return c.check(value, without(schema, keyword), path, depth+1)
+		}
+	}
+
+	if value == nil {
+		if allowsNull(schema) {
+			return nil, true
+		}
+		c.fail(path, "must not be null")
+		return nil, false
+	}
+
+	value, ok := c.checkType(value, schema, path)
+	if !ok {
+		return value, false
+	}
+
+	value, ok = c.checkEnum(value, schema, path)
+	if !ok {
+		return value, false
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return c.checkObject(v, schema, path, depth)
+	case []interface{}:
+		return c.checkArray(v, schema, path, depth)
+	}
+	return value, true
+}
+
+// checkVariants accepts value if it matches any variant, preferring the one needing the
+// fewest repairs
+func (c *argsChecker) checkVariants(value interface{}, variants []interface{}, path string, depth int) (interface{}, bool) {
+	var best *argsChecker
+	var bestValue interface{}
+	var firstFailure *argsChecker
+
+	for _, variant := range variants {
+		m, ok := asSchema(variant)
+		if !ok {
+			continue
+		}
+		trial := &argsChecker{root: c.root}
+		repaired, valid := trial.check(value, m, path, depth+1)
+		if !valid {
+			if firstFailure == nil {
+				firstFailure = trial
+			}
+			continue
+		}
+		if best == nil || len(trial.repairs) < len(best.repairs) {
+			best, bestValue = trial, repaired
+		}
+	}
+
+	if best != nil {
+		c.repairs = append(c.repairs, best.repairs...)
+		return bestValue, true
+	}
+	if firstFailure != nil && len(variants) == 1 {
+		c.problems = append(c.problems, firstFailure.problems...)
+	} else {
+		c.fail(path, "does not match any of the allowed schemas")
+	}
+	return value, false
+}
+
+// checkType checks value against the schema's type, coercing it when that is safe
+func (c *argsChecker) checkType(value interface{}, schema map[string]interface{}, path string) (interface{}, bool) {
+	var typeNames []string
+	switch t := schema[types.SchemaFieldType].(type) {
+	case string:
+		typeNames = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok && name != types.SchemaTypeNull {
+				typeNames = append(typeNames, name)
+			}
+		}
+	}
+	if len(typeNames) == 0 {
+		return value, true
+	}
+
+	for _, name := range typeNames {
+		if hasType(value, name) {
+			return value, true
+		}
+	}
+	for _, name := range typeNames {
+		if coerced, ok := coerce(value, name); ok {
+			c.repaired(path, "converted %s to %s", jsonTypeName(value), name)
+			return coerced, true
+		}
+	}
+
+	c.fail(path, "must be %s, got %s", strings.Join(typeNames, " or "), jsonTypeName(value))
+	return value, false
+}
+
+// checkEnum checks value against enum and const, fixing the case of string values
+func (c *argsChecker) checkEnum(value interface{}, schema map[string]interface{}, path string) (interface{}, bool) {
+	allowed, hasEnum := schema[types.SchemaFieldEnum].([]interface{})
+	if constValue, ok := schema[types.SchemaFieldConst]; ok {
+		allowed, hasEnum = []interface{}{constValue}, true
+	}
+	if !hasEnum {
+		return value, true
+	}
+
+	for _, candidate := range allowed {
+		if reflect.DeepEqual(value, candidate) {
+			return value, true
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		var match interface{}
+		matches := 0
+		for _, candidate := range allowed {
+			if cs, ok := candidate.(string); ok && strings.EqualFold(cs, s) {
+				match = cs
+				matches++
+			}
+		}
+		if matches == 1 {
+			c.repaired(path, "replaced %q with %q", s, match)
+			return match, true
+		}
+	}
+
+	var names []string
+	for _, candidate := range allowed {
+		encoded, _ := json.Marshal(candidate)
+		names = append(names, string(encoded))
+	}
+	c.fail(path, "must be one of %s", strings.Join(names, ", "))
+	return value, false
+}
+
+func (c *argsChecker) checkObject(value map[string]interface{}, schema map[string]interface{}, path string, depth int) (interface{}, bool) {
+	properties, _ := schema[types.SchemaFieldProperties].(map[string]interface{})
+	required := map[string]bool{}
+	if names, ok := schema[types.SchemaFieldRequired].([]interface{}); ok {
+		for _, name := range names {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+
+	result := make(map[string]interface{}, len(value))
+	valid := true
+	for _, name := range sortedKeys(value) {
+		property := value[name]
+		propertyPath := joinPath(path, name)
+
+		propertySchema, declared := asSchema(properties[name])
+		if !declared {
+			switch additional := schema[types.SchemaFieldAdditionalProperties].(type) {
+			case bool:
+				if !additional {
+					c.repaired(propertyPath, "removed; the tool doesn't accept it")
+					continue
+				}
+			case map[string]interface{}:
+				propertySchema, declared = additional, true
+			}
+		}
+		if !declared {
+			result[name] = property
+			continue
+		}
+
+		if property == nil && !required[name] && !allowsNull(propertySchema) {
+			c.repaired(propertyPath, "removed null value of optional property")
+			continue
+		}
+
+		repaired, ok := c.check(property, propertySchema, propertyPath, depth+1)
+		valid = valid && ok
+		result[name] = repaired
+	}
+
+	for _, name := range sortedKeys(properties) {
+		if !required[name] {
+			continue
+		}
+		if _, ok := result[name]; ok {
+			continue
+		}
+		if propertySchema, ok := properties[name].(map[string]interface{}); ok {
+			if defaultValue, ok := propertySchema["default"]; ok {
+				c.repaired(joinPath(path, name), "missing; filled in the default")
+				result[name] = defaultValue
+				continue
+			}
+		}
+		c.fail(joinPath(path, name), "is required")
+		valid = false
+	}
+	for name := range required {
+		if _, declared := properties[name]; !declared {
+			if _, ok := result[name]; !ok {
+				c.fail(joinPath(path, name), "is required")
+				valid = false
+			}
+		}
+	}
+
+	return result, valid
+}
+
+func (c *argsChecker) checkArray(value []interface{}, schema map[string]interface{}, path string, depth int) (interface{}, bool) {
+	items, ok := asSchema(schema[types.SchemaFieldItems])
+	if !ok {
+		return value, true
+	}
+
+	result := make([]interface{}, len(value))
+	valid := true
+	for i, item := range value {
+		repaired, ok := c.check(item, items, fmt.Sprintf("%s[%d]", path, i), depth+1)
+		valid = valid && ok
+		result[i] = repaired
+	}
+	return result, valid
+}
+
+// allowsNull reports whether a schema accepts null
+func allowsNull(schema map[string]interface{}) bool {
+	if nullable, ok := schema[types.SchemaFieldNullable].(bool); ok && nullable {
+		return true
+	}
+	switch t := schema[types.SchemaFieldType].(type) {
+	case string:
+		return t == types.SchemaTypeNull
+	case []interface{}:
+		for _, item := range t {
+			if item == types.SchemaTypeNull {
+				return true
+			}
+		}
+		return false
+	}
+	if enum, ok := schema[types.SchemaFieldEnum].([]interface{}); ok {
+		for _, value := range enum {
+			if value == nil {
+				return true
+			}
+		}
+		return false
+	}
+	// No type constraint here; combinators are checked by the caller
+	for _, keyword := range []string{types.SchemaFieldAnyOf, types.SchemaFieldOneOf} {
+		if variants, ok := schema[keyword].([]interface{}); ok {
+			for _, variant := range variants {
+				if m, ok := asSchema(variant); ok && allowsNull(m) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return schema[types.SchemaFieldRef] == nil && schema[types.SchemaFieldAllOf] == nil
+}
+
+// hasType reports whether a decoded JSON value has the given JSON Schema type
+func hasType(value interface{}, name string) bool {
+	switch v := value.(type) {
+	case string:
+		return name == types.SchemaTypeString
+	case bool:
+		return name == types.SchemaTypeBoolean
+	case float64:
+		return name == types.SchemaTypeNumber || (name == types.SchemaTypeInteger && v == math.Trunc(v))
+	case map[string]interface{}:
+		return name == types.SchemaTypeObject
+	case []interface{}:
+		return name == types.SchemaTypeArray
+	}
+	return false
+}
+
+// coerce converts value to the given type when that can be done without guessing
+func coerce(value interface{}, name string) (interface{}, bool) {
+	switch name {
+	case types.SchemaTypeNumber, types.SchemaTypeInteger:
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsInf(n, 0) || math.IsNaN(n) || (name == types.SchemaTypeInteger && n != math.Trunc(n)) {
+			return nil, false
+		}
+		return n, true
+
+	case types.SchemaTypeBoolean:
+		s, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+
+	case types.SchemaTypeString:
+		switch v := value.(type) {
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+
+	case types.SchemaTypeObject:
+		if s, ok := value.(string); ok {
+			var m map[string]interface{}
+			if json.Unmarshal([]byte(s), &m) == nil && m != nil {
+				return m, true
+			}
+		}
+
+	case types.SchemaTypeArray:
+		if s, ok := value.(string); ok {
+			var a []interface{}
+			if json.Unmarshal([]byte(s), &a) == nil && a != nil {
+				return a, true
+			}
+		}
+		return []interface{}{value}, true
+	}
+	return nil, false
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return types.SchemaTypeNull
+	case string:
+		return types.SchemaTypeString
+	case bool:
+		return types.SchemaTypeBoolean
+	case float64:
+		return types.SchemaTypeNumber
+	case map[string]interface{}:
+		return types.SchemaTypeObject
+	case []interface{}:
+		return types.SchemaTypeArray
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "arguments"
+	}
+	return fmt.Sprintf("%q", path)
+}
@@ -0,0 +1,265 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestParseToolArgsStrategy(t *testing.T) {
+	for _, name := range []string{"passthrough", "error", "reprompt"} {
+		strategy, err := ParseToolArgsStrategy(name)
+		if err != nil || string(strategy) != name {
+			t.Errorf("ParseToolArgsStrategy(%q) = %q, %v", name, strategy, err)
+		}
+	}
+	if _, err := ParseToolArgsStrategy("ignore"); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestToolValidator_Check(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"$defs": {"unit": {"type": "string", "enum": ["celsius", "fahrenheit"]}},
+		"properties": {
+			"location": {"type": "string"},
+			"days": {"type": "integer"},
+			"threshold": {"type": "number"},
+			"detailed": {"type": "boolean"},
+			"unit": {"$ref": "#/$defs/unit"},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"filter": {"type": "object", "properties": {"max": {"type": "integer"}}, "additionalProperties": false},
+			"zip": {"type": "string"},
+			"limit": {"anyOf": [{"type": "integer"}, {"type": "null"}]},
+			"mode": {"type": "string", "default": "fast"}
+		},
+		"required": ["location", "mode"]
+	}`
+
+	tests := []struct {
+		name     string
+		args     string
+		expected string // Repaired arguments
+		problems []string
+	}{
+		{
+			name:     "valid arguments are unchanged",
+			args:     `{"location": "Paris", "days": 3, "unit": "celsius", "mode": "slow"}`,
+			expected: `{"days":3,"location":"Paris","mode":"slow","unit":"celsius"}`,
+		},
+		{
+			name:     "numbers and booleans as strings",
+			args:     `{"location": "Paris", "days": "3", "threshold": " 1.5", "detailed": "TRUE", "mode": "slow"}`,
+			expected: `{"days":3,"detailed":true,"location":"Paris","mode":"slow","threshold":1.5}`,
+		},
+		{
+			name:     "number as string",
+			args:     `{"location": "Paris", "zip": 75001, "mode": "slow"}`,
+			expected: `{"location":"Paris","mode":"slow","zip":"75001"}`,
+		},
+		{
+			name:     "single value to array",
+			args:     `{"location": "Paris", "tags": "weather", "mode": "slow"}`,
+			expected: `{"location":"Paris","mode":"slow","tags":["weather"]}`,
+		},
+		{
+			name:     "JSON encoded array and object",
+			args:     `{"location": "Paris", "tags": "[\"a\", \"b\"]", "filter": "{\"max\": \"5\"}", "mode": "slow"}`,
+			expected: `{"filter":{"max":5},"location":"Paris","mode":"slow","tags":["a","b"]}`,
+		},
+		{
+			name:     "enum in the wrong case",
+			args:     `{"location": "Paris", "unit": "Celsius", "mode": "slow"}`,
+			expected: `{"location":"Paris","mode":"slow","unit":"celsius"}`,
+		},
+		{
+			name:     "extra and null properties removed",
+			args:     `{"location": "Paris", "filter": {"max": 5, "min": 1}, "days": null, "mode": "slow"}`,
+			expected: `{"filter":{"max":5},"location":"Paris","mode":"slow"}`,
+		},
+		{
+			name:     "nullable property keeps null",
+			args:     `{"location": "Paris", "limit": null, "mode": "slow"}`,
+			expected: `{"limit":null,"location":"Paris","mode":"slow"}`,
+		},
+		{
+			name:     "anyOf variant coerced",
+			args:     `{"location": "Paris", "limit": "10", "mode": "slow"}`,
+			expected: `{"limit":10,"location":"Paris","mode":"slow"}`,
+		},
+		{
+			name:     "missing required property with a default",
+			args:     `{"location": "Paris"}`,
+			expected: `{"location":"Paris","mode":"fast"}`,
+		},
+		{
+			name:     "missing required property",
+			args:     `{"days": 3}`,
+			problems: []string{`"location" is required`},
+		},
+		{
+			name:     "fractional integer",
+			args:     `{"location": "Paris", "days": 1.5, "mode": "slow"}`,
+			problems: []string{`"days" must be integer, got number`},
+		},
+		{
+			name:     "unknown enum value",
+			args:     `{"location": "Paris", "unit": "kelvin", "mode": "slow"}`,
+			problems: []string{`"unit" must be one of "celsius", "fahrenheit"`},
+		},
+		{
+			name:     "invalid array item",
+			args:     `{"location": "Paris", "tags": ["a", {"b": 1}], "mode": "slow"}`,
+			problems: []string{`"tags[1]" must be string, got object`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewToolValidator([]types.AnthropicTool{{
+				Name:        "get_weather",
+				InputSchema: mustParseSchema(t, schema),
+			}}, ToolArgsError)
+
+			content := []types.AnthropicContentBlock{
+				{Type: types.ContentTypeText, Text: "Checking"},
+				{Type: types.ContentTypeToolUse, Name: "get_weather", Input: mustParseSchema(t, tt.args)},
+			}
+			err := validator.Check(content)
+
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got, _ := json.Marshal(content[1].Input)
+				if string(got) != tt.expected {
+					t.Errorf("repaired arguments mismatch\nGot:  %s\nWant: %s", got, tt.expected)
+				}
+				return
+			}
+
+			var argsErr *InvalidToolArgsError
+			if !errors.As(err, &argsErr) {
+				t.Fatalf("expected InvalidToolArgsError, got %v", err)
+			}
+			if len(argsErr.Calls) != 1 || argsErr.Calls[0].Index != 0 || argsErr.Calls[0].Name != "get_weather" {
+				t.Fatalf("unexpected invalid calls: %+v", argsErr.Calls)
+			}
+			if got := strings.Join(argsErr.Calls[0].Problems, "; "); got != strings.Join(tt.problems, "; ") {
+				t.Errorf("problems mismatch\nGot:  %s\nWant: %s", got, strings.Join(tt.problems, "; "))
+			}
+		})
+	}
+}
+
+func TestToolValidator_UnknownTool(t *testing.T) {
+	validator := NewToolValidator([]types.AnthropicTool{{Name: "a", InputSchema: map[string]interface{}{"type": "object"}}}, ToolArgsError)
+	err := validator.Check([]types.AnthropicContentBlock{{Type: types.ContentTypeToolUse, Name: "b"}})
+	if err == nil || !strings.Contains(err.Error(), `tool "b" is not defined`) {
+		t.Errorf("expected unknown tool error, got %v", err)
+	}
+}
+
+func TestToolValidator_Passthrough(t *testing.T) {
+	validator := NewToolValidator([]types.AnthropicTool{{
+		Name:        "read",
+		InputSchema: map[string]interface{}{"type": "object", "required": []interface{}{"path"}},
+	}}, ToolArgsPassthrough)
+
+	content := []types.AnthropicContentBlock{{Type: types.ContentTypeToolUse, Name: "read", Input: map[string]interface{}{"file": "a"}}}
+	if err := validator.Check(content); err != nil {
+		t.Errorf("passthrough should not fail, got %v", err)
+	}
+	if content[0].Input["file"] != "a" {
+		t.Errorf("expected arguments to pass through, got %v", content[0].Input)
+	}
+}
+
+func TestToolValidator_Nil(t *testing.T) {
+	if NewToolValidator(nil, ToolArgsError) != nil {
+		t.Error("expected a nil validator without tools")
+	}
+	var validator *ToolValidator
+	if err := validator.Check([]types.AnthropicContentBlock{{Type: types.ContentTypeToolUse, Name: "x"}}); err != nil {
+		t.Errorf("nil validator should accept everything, got %v", err)
+	}
+}
+
+func TestToAnthropicResponseFromCustom_InvalidToolArgs(t *testing.T) {
+	tools := NewToolValidator([]types.AnthropicTool{{
+		Name:        "get_weather",
+		InputSchema: mustParseSchema(t, `{"type":"object","properties":{"days":{"type":"integer"}},"required":["days"]}`),
+	}}, ToolArgsError)
+
+	resp := &GenerateContentResponse{Candidates: []Candidate{{
+		Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{
+			{FunctionCall: &types.GeminiFunctionCall{Name: "get_weather", Args: map[string]interface{}{"days": "2"}}},
+		}},
+		FinishReason: "STOP",
+	}}}
+	anthropicResp, err := ToAnthropicResponseFromCustom(resp, "gemini-2.5-flash", ResponseOptions{Tools: tools})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if anthropicResp.Content[0].Input["days"] != float64(2) {
+		t.Errorf("expected days to be repaired, got %v", anthropicResp.Content[0].Input)
+	}
+	// The Gemini part is left as it was, so it can be sent back verbatim
+	if resp.Candidates[0].Content.Parts[0].FunctionCall.Args["days"] != "2" {
+		t.Error("expected the original function call to be unchanged")
+	}
+
+	resp.Candidates[0].Content.Parts[0].FunctionCall.Args = map[string]interface{}{}
+	_, err = ToAnthropicResponseFromCustom(resp, "gemini-2.5-flash", ResponseOptions{Tools: tools})
+	var argsErr *InvalidToolArgsError
+	if !errors.As(err, &argsErr) {
+		t.Fatalf("expected InvalidToolArgsError, got %v", err)
+	}
+}
+
+func TestRepromptContents(t *testing.T) {
+	content := &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{
+		{Text: "Let me check"},
+		{FunctionCall: &types.GeminiFunctionCall{Name: "a"}, ThoughtSignature: "sig"},
+		{FunctionCall: &types.GeminiFunctionCall{Name: "b"}},
+	}}
+	err := &InvalidToolArgsError{Calls: []InvalidToolCall{{Index: 1, Name: "b", Problems: []string{`"x" is required`}}}}
+
+	contents := RepromptContents(content, err)
+	if len(contents) != 2 {
+		t.Fatalf("expected model and user contents, got %d", len(contents))
+	}
+	if contents[0].Role != types.RoleModel || len(contents[0].Parts) != 3 || contents[0].Parts[1].ThoughtSignature != "sig" {
+		t.Errorf("expected the model turn to be sent back verbatim, got %+v", contents[0])
+	}
+
+	reply := contents[1]
+	if reply.Role != types.RoleUser || len(reply.Parts) != 2 {
+		t.Fatalf("expected a function response per call, got %+v", reply)
+	}
+	first, second := reply.Parts[0].FunctionResponse, reply.Parts[1].FunctionResponse
+	if first.Name != "a" || !strings.Contains(first.Response["error"].(string), "Not executed") {
+		t.Errorf("unexpected response for the valid call: %+v", first)
+	}
+	if second.Name != "b" || !strings.Contains(second.Response["error"].(string), `"x" is required`) {
+		t.Errorf("unexpected response for the invalid call: %+v", second)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// Gemini function calling modes
+const (
+	FunctionCallingModeAuto = "AUTO"
+	FunctionCallingModeAny  = "ANY"
+	FunctionCallingModeNone = "NONE"
+)
+
+// ToolConfig represents Gemini's tool configuration
+type ToolConfig struct {
+	FunctionCallingConfig *FunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+// FunctionCallingConfig controls how Gemini calls functions
+type FunctionCallingConfig struct {
+	Mode                 string   `json:"mode,omitempty"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+// ToToolConfig maps an Anthropic tool_choice onto a Gemini toolConfig:
+//
+//	auto -> AUTO
+//	any  -> ANY
+//	tool -> ANY restricted to the named function
+//	none -> NONE
+//
+// A nil or unrecognized tool_choice returns nil, leaving Gemini's default (AUTO).
+func ToToolConfig(choice *types.AnthropicToolChoice) *ToolConfig {
+	if choice == nil {
+		return nil
+	}
+
+	var config FunctionCallingConfig
+	switch choice.Type {
+	case types.ToolChoiceAuto:
+		config.Mode = FunctionCallingModeAuto
+	case types.ToolChoiceAny:
+		config.Mode = FunctionCallingModeAny
+	case types.ToolChoiceTool:
+		config.Mode = FunctionCallingModeAny
+		if choice.Name != "" {
+			config.AllowedFunctionNames = []string{choice.Name}
+		}
+	case types.ToolChoiceNone:
+		config.Mode = FunctionCallingModeNone
+	default:
+		return nil
+	}
+
+	return &ToolConfig{FunctionCallingConfig: &config}
+}
+
+// ToGeminiToolConfig is the genai SDK equivalent of ToToolConfig
+func ToGeminiToolConfig(choice *types.AnthropicToolChoice) *genai.ToolConfig {
+	config := ToToolConfig(choice)
+	if config == nil {
+		return nil
+	}
+
+	var mode genai.FunctionCallingMode
+	switch config.FunctionCallingConfig.Mode {
+	case FunctionCallingModeAuto:
+		mode = genai.FunctionCallingAuto
+	case FunctionCallingModeAny:
+		mode = genai.FunctionCallingAny
+	case FunctionCallingModeNone:
+		mode = genai.FunctionCallingNone
+	}
+
+	return &genai.ToolConfig{
+		FunctionCallingConfig: &genai.FunctionCallingConfig{
+			Mode:                 mode,
+			AllowedFunctionNames: config.FunctionCallingConfig.AllowedFunctionNames,
+		},
+	}
+}
+
+// ParallelToolUseDisabled reports whether the client asked for at most one tool call
+func ParallelToolUseDisabled(choice *types.AnthropicToolChoice) bool {
+	return choice != nil && choice.DisableParallelToolUse
+}
+
+// LimitToolUse keeps only the first tool_use block in the response. Gemini has no
+// equivalent of disable_parallel_tool_use, so extra function calls are dropped.
+func LimitToolUse(resp *types.AnthropicResponse) {
+	seen := false
+	content := resp.Content[:0]
+	for _, block := range resp.Content {
+		if block.Type == types.ContentTypeToolUse {
+			if seen {
+				continue
+			}
+			seen = true
+		}
+		content = append(content, block)
+	}
+	resp.Content = content
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestToToolConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		choice       *types.AnthropicToolChoice
+		expectNil    bool
+		mode         string
+		sdkMode      genai.FunctionCallingMode
+		allowedNames []string
+	}{
+		{
+			name:      "nil",
+			expectNil: true,
+		},
+		{
+			name:    "auto",
+			choice:  &types.AnthropicToolChoice{Type: "auto"},
+			mode:    FunctionCallingModeAuto,
+			sdkMode: genai.FunctionCallingAuto,
+		},
+		{
+			name:    "any",
+			choice:  &types.AnthropicToolChoice{Type: "any"},
+			mode:    FunctionCallingModeAny,
+			sdkMode: genai.FunctionCallingAny,
+		},
+		{
+			name:         "named tool",
+			choice:       &types.AnthropicToolChoice{Type: "tool", Name: "get_weather"},
+			mode:         FunctionCallingModeAny,
+			sdkMode:      genai.FunctionCallingAny,
+			allowedNames: []string{"get_weather"},
+		},
+		{
+			name:    "none",
+			choice:  &types.AnthropicToolChoice{Type: "none"},
+			mode:    FunctionCallingModeNone,
+			sdkMode: genai.FunctionCallingNone,
+		},
+		{
+			name:      "unknown",
+			choice:    &types.AnthropicToolChoice{Type: "bogus"},
+			expectNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := ToToolConfig(tt.choice)
+			sdkConfig := ToGeminiToolConfig(tt.choice)
+			if tt.expectNil {
+				if config != nil || sdkConfig != nil {
+					t.Errorf("expected nil configs, got %+v and %+v", config, sdkConfig)
+				}
+				return
+			}
+
+			if config.FunctionCallingConfig.Mode != tt.mode {
+				t.Errorf("expected mode %q, got %q", tt.mode, config.FunctionCallingConfig.Mode)
+			}
+			if strings.Join(config.FunctionCallingConfig.AllowedFunctionNames, ",") != strings.Join(tt.allowedNames, ",") {
+				t.Errorf("expected allowed names %v, got %v", tt.allowedNames, config.FunctionCallingConfig.AllowedFunctionNames)
+			}
+			if sdkConfig.FunctionCallingConfig.Mode != tt.sdkMode {
+				t.Errorf("expected SDK mode %v, got %v", tt.sdkMode, sdkConfig.FunctionCallingConfig.Mode)
+			}
+			if strings.Join(sdkConfig.FunctionCallingConfig.AllowedFunctionNames, ",") != strings.Join(tt.allowedNames, ",") {
+				t.Errorf("expected SDK allowed names %v, got %v", tt.allowedNames, sdkConfig.FunctionCallingConfig.AllowedFunctionNames)
+			}
+		})
+	}
+}
+
+func TestLimitToolUse(t *testing.T) {
+	resp := &types.AnthropicResponse{
+		Content: []types.AnthropicContentBlock{
+			{Type: "text", Text: "Calling tools"},
+			{Type: "tool_use", ID: "1", Name: "first"},
+			{Type: "tool_use", ID: "2", Name: "second"},
+			{Type: "text", Text: "after"},
+		},
+	}
+
+	LimitToolUse(resp)
+
+	if len(resp.Content) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(resp.Content))
+	}
+	if resp.Content[1].ID != "1" || resp.Content[2].Type != "text" {
+		t.Errorf("expected only the first tool_use to remain, got %+v", resp.Content)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// MaxFunctionNameLength is the longest function name Gemini accepts
+const MaxFunctionNameLength = 64
+
+// functionNameHashLength is the number of hex digits of the original name's hash appended
+// to a sanitized name
+const functionNameHashLength = 8
+
+var validFunctionName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]{0,63}$`)
+
+// SanitizeFunctionName returns a name Gemini accepts as a function name. Valid names are
+// returned unchanged. Otherwise unsupported characters are replaced with underscores, the
+// name is shortened if needed, and a hash of the original name is appended so distinct
+// names stay distinct. The result is deterministic, so the same tool gets the same name
+// on every turn of a conversation.
+func SanitizeFunctionName(name string) string {
+	if validFunctionName.MatchString(name) {
+		return name
+	}
+
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+		case r == '.' || r == '-' || r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+		default:
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+
+	sanitized := b.String()
+	if sanitized == "" {
+		sanitized = "_"
+	}
+	if limit := MaxFunctionNameLength - functionNameHashLength - 1; len(sanitized) > limit {
+		sanitized = sanitized[:limit]
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	return sanitized + "_" + hex.EncodeToString(sum[:])[:functionNameHashLength]
+}
+
+// ToolNames maps the tool names of a single request to function names Gemini accepts and
+// back. A nil *ToolNames is valid and leaves every name unchanged.
+type ToolNames struct {
+	toGemini    map[string]string
+	toAnthropic map[string]string
+}
+
+// NewToolNames collects the tool names used by req: declared tools, the forced tool_choice,
+// and tool_use blocks in the conversation history. It returns nil if all of them are
+// already valid Gemini function names.
+func NewToolNames(req *types.AnthropicRequest) *ToolNames {
+	n := &ToolNames{
+		toGemini:    map[string]string{},
+		toAnthropic: map[string]string{},
+	}
+	add := func(name string) {
+		if sanitized := SanitizeFunctionName(name); sanitized != name {
+			n.toGemini[name] = sanitized
+			n.toAnthropic[sanitized] = name
+		}
+	}
+
+	for _, tool := range req.Tools {
+		add(tool.Name)
+	}
+	if req.ToolChoice != nil && req.ToolChoice.Name != "" {
+		add(req.ToolChoice.Name)
+	}
+	for _, msg := range req.Messages {
+		for _, block := range msg.Content {
+			if block.Type == types.ContentTypeToolUse {
+				add(block.Name)
+			}
+		}
+	}
+
+	if len(n.toGemini) == 0 {
+		return nil
+	}
+	return n
+}
+
+// ToGemini returns the Gemini function name for an Anthropic tool name
+func (n *ToolNames) ToGemini(name string) string {
+	if n == nil {
+		return name
+	}
+	if sanitized, ok := n.toGemini[name]; ok {
+		return sanitized
+	}
+	return name
+}
+
+// ToAnthropic returns the Anthropic tool name for a Gemini function name. Names that
+// weren't renamed are returned unchanged.
+func (n *ToolNames) ToAnthropic(name string) string {
+	if n == nil {
+		return name
+	}
+	if original, ok := n.toAnthropic[name]; ok {
+		return original
+	}
+	return name
+}
+
+// Request returns a copy of req with tool names replaced by their Gemini function names.
+// req itself is not modified. If n is nil, req is returned as is.
+func (n *ToolNames) Request(req *types.AnthropicRequest) *types.AnthropicRequest {
+	if n == nil {
+		return req
+	}
+
+	renamed := *req
+	renamed.Tools = make([]types.AnthropicTool, len(req.Tools))
+	for i, tool := range req.Tools {
+		tool.Name = n.ToGemini(tool.Name)
+		renamed.Tools[i] = tool
+	}
+
+	if req.ToolChoice != nil {
+		toolChoice := *req.ToolChoice
+		toolChoice.Name = n.ToGemini(toolChoice.Name)
+		renamed.ToolChoice = &toolChoice
+	}
+
+	renamed.Messages = make([]types.AnthropicMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		msg.Content = append([]types.AnthropicContentBlock(nil), msg.Content...)
+		for j, block := range msg.Content {
+			if block.Type == types.ContentTypeToolUse {
+				msg.Content[j].Name = n.ToGemini(block.Name)
+			}
+		}
+		renamed.Messages[i] = msg
+	}
+
+	return &renamed
+}
+
+// RestoreToolUse replaces Gemini function names in tool_use blocks with the original
+// Anthropic tool names
+func (n *ToolNames) RestoreToolUse(content []types.AnthropicContentBlock) {
+	for i, block := range content {
+		if block.Type == types.ContentTypeToolUse {
+			content[i].Name = n.ToAnthropic(block.Name)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestSanitizeFunctionName(t *testing.T) {
+	long := "mcp__github__" + strings.Repeat("create_pull_request_review_comment_", 3)
+
+	tests := []struct {
+		name   string
+		input  string
+		prefix string // Expected sanitized name before the hash suffix, or "" if unchanged
+	}{
+		{name: "valid name", input: "get_weather"},
+		{name: "valid with dots and dashes", input: "mcp__server__read-file.v2"},
+		{name: "exactly 64 characters", input: strings.Repeat("a", 64)},
+		{name: "invalid characters", input: "mcp__my server__read/file", prefix: "mcp__my_server__read_file_"},
+		{name: "leading digit", input: "1password", prefix: "_1password_"},
+		{name: "leading dash", input: "-x y", prefix: "_-x_y_"},
+		{name: "non-ASCII", input: "café", prefix: "caf__"},
+		{name: "too long", input: long, prefix: long[:55] + "_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SanitizeFunctionName(tt.input)
+			if !validFunctionName.MatchString(got) {
+				t.Errorf("SanitizeFunctionName(%q) = %q is not a valid Gemini function name", tt.input, got)
+			}
+			if tt.prefix == "" {
+				if got != tt.input {
+					t.Errorf("expected valid name to be unchanged, got %q", got)
+				}
+				return
+			}
+			if !strings.HasPrefix(got, tt.prefix) || len(got) != len(tt.prefix)+functionNameHashLength {
+				t.Errorf("SanitizeFunctionName(%q) = %q, want %q followed by a hash", tt.input, got, tt.prefix)
+			}
+			if again := SanitizeFunctionName(tt.input); again != got {
+				t.Errorf("expected a deterministic name, got %q and %q", got, again)
+			}
+		})
+	}
+
+	if SanitizeFunctionName("a b") == SanitizeFunctionName("a/b") {
+		t.Error("expected distinct names to stay distinct")
+	}
+}
+
+func TestToolNames(t *testing.T) {
+	long := "mcp__server__" + strings.Repeat("very_long_tool_name_", 4)
+	req := &types.AnthropicRequest{
+		Tools: []types.AnthropicTool{
+			{Name: "get_weather"},
+			{Name: long},
+			{Name: "read file"},
+		},
+		ToolChoice: &types.AnthropicToolChoice{Type: types.ToolChoiceTool, Name: long},
+		Messages: []types.AnthropicMessage{
+			{Role: types.RoleUser, Content: []types.AnthropicContentBlock{{Type: types.ContentTypeText, Text: "Hi"}}},
+			{Role: types.RoleAssistant, Content: []types.AnthropicContentBlock{
+				{Type: types.ContentTypeToolUse, ID: "a", Name: "read file"},
+				{Type: types.ContentTypeToolUse, ID: "b", Name: "removed tool!"},
+			}},
+		},
+	}
+
+	names := NewToolNames(req)
+	if names == nil {
+		t.Fatal("expected a name mapping")
+	}
+	renamed := names.Request(req)
+
+	for i, tool := range renamed.Tools {
+		if !validFunctionName.MatchString(tool.Name) {
+			t.Errorf("tool %d has invalid name %q", i, tool.Name)
+		}
+		if got := names.ToAnthropic(tool.Name); got != req.Tools[i].Name {
+			t.Errorf("expected %q to map back to %q, got %q", tool.Name, req.Tools[i].Name, got)
+		}
+	}
+	if renamed.Tools[0].Name != "get_weather" {
+		t.Errorf("expected valid name to be unchanged, got %q", renamed.Tools[0].Name)
+	}
+	if renamed.ToolChoice.Name != renamed.Tools[1].Name {
+		t.Errorf("expected tool_choice to use the sanitized name, got %q", renamed.ToolChoice.Name)
+	}
+
+	history := renamed.Messages[1].Content
+	if history[0].Name != renamed.Tools[2].Name {
+		t.Errorf("expected tool_use in history to use the sanitized name, got %q", history[0].Name)
+	}
+	if history[1].Name != SanitizeFunctionName("removed tool!") {
+		t.Errorf("expected tool_use of an undeclared tool to be sanitized, got %q", history[1].Name)
+	}
+
+	// The original request is left untouched
+	if req.Tools[1].Name != long || req.ToolChoice.Name != long || req.Messages[1].Content[0].Name != "read file" {
+		t.Error("expected the original request to be unchanged")
+	}
+
+	content := []types.AnthropicContentBlock{
+		{Type: types.ContentTypeText, Text: renamed.Tools[2].Name},
+		{Type: types.ContentTypeToolUse, Name: renamed.Tools[2].Name},
+		{Type: types.ContentTypeToolUse, Name: "unknown"},
+	}
+	names.RestoreToolUse(content)
+	if content[0].Text != renamed.Tools[2].Name || content[1].Name != "read file" || content[2].Name != "unknown" {
+		t.Errorf("unexpected restored content: %+v", content)
+	}
+}
+
+func TestToolNames_Nil(t *testing.T) {
+	req := &types.AnthropicRequest{Tools: []types.AnthropicTool{{Name: "get_weather"}}}
+	names := NewToolNames(req)
+	if names != nil {
+		t.Fatal("expected no mapping when all names are valid")
+	}
+	if names.Request(req) != req {
+		t.Error("expected the request to be returned as is")
+	}
+	if names.ToGemini("a b") != "a b" || names.ToAnthropic("a_b") != "a_b" {
+		t.Error("expected a nil mapping to leave names unchanged")
+	}
+}
+
+func TestToAnthropicResponseFromCustom_ToolNames(t *testing.T) {
+	req := &types.AnthropicRequest{
+		Tools: []types.AnthropicTool{{
+			Name:        "mcp__files__read file",
+			InputSchema: mustParseSchema(t, `{"type":"object","properties":{"path":{"type":"string"}},"required":["path"]}`),
+		}},
+	}
+	names := NewToolNames(req)
+
+	resp := &GenerateContentResponse{Candidates: []Candidate{{
+		Content: &types.GeminiContent{Role: "model", Parts: []types.GeminiPart{
+			{FunctionCall: &types.GeminiFunctionCall{Name: names.ToGemini("mcp__files__read file"), Args: map[string]interface{}{"path": "a"}}},
+		}},
+		FinishReason: "STOP",
+	}}}
+	anthropicResp, err := ToAnthropicResponseFromCustom(resp, "gemini-2.5-flash", ResponseOptions{
+		ToolNames: names,
+		Tools:     NewToolValidator(req.Tools, ToolArgsError),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if anthropicResp.Content[0].Name != "mcp__files__read file" {
+		t.Errorf("expected the original tool name, got %q", anthropicResp.Content[0].Name)
+	}
+}
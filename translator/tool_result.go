@@ -0,0 +1,131 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// SupportsMultimodalFunctionResponse reports whether the model, given by ID or as
+// "models/{id}", accepts media inside a function response. Other models get the media as
+// separate parts; see FlattenFunctionResponses.
+func SupportsMultimodalFunctionResponse(model string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(model, "models/"), "gemini-3")
+}
+
+// convertToolResult converts a tool_result block into a Gemini function response for the
+// named function. The content, either a string or an array of blocks, is split up: text
+// blocks are joined into the response payload, images and PDF documents are attached as
+// inline data (or file data, for files in the Gemini File API) parts of the response, and
+// other blocks are included in the payload as JSON. The payload is
+// {"result": text}, or {"error": text} if the tool reported an error with is_error.
+func convertToolResult(block types.AnthropicContentBlock, name string) (*types.GeminiPart, error) {
+	blocks, err := contentBlocks(block.Content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content in tool_result %s: %w", block.ToolUseID, err)
+	}
+	if block.Content == nil && block.Text != "" {
+		blocks = []types.AnthropicContentBlock{{Type: types.ContentTypeText, Text: block.Text}}
+	}
+
+	var (
+		texts []string
+		parts []types.GeminiFunctionResponsePart
+	)
+	for _, b := range blocks {
+		switch b.Type {
+		case types.ContentTypeText, "":
+			if b.Text != "" {
+				texts = append(texts, b.Text)
+			}
+		case types.ContentTypeImage:
+			if b.Source != nil && (b.Source.Type == types.SourceTypeURL || b.Source.Type == types.SourceTypeFile) {
+				part, err := fileSourcePart(b.Source)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, types.GeminiFunctionResponsePart{FileData: part.FileData})
+				continue
+			}
+			if b.Source == nil || b.Source.Data == "" {
+				return nil, fmt.Errorf("image in tool_result %s has no data", block.ToolUseID)
+			}
+			parts = append(parts, types.GeminiFunctionResponsePart{
+				InlineData: &types.GeminiBlob{
+					MimeType: b.Source.MediaType,
+					Data:     b.Source.Data,
+				},
+			})
+		case types.ContentTypeDocument:
+			documentParts, err := convertDocument(b)
+			if err != nil {
+				return nil, err
+			}
+			for _, part := range documentParts {
+				if part.InlineData != nil || part.FileData != nil {
+					parts = append(parts, types.GeminiFunctionResponsePart{InlineData: part.InlineData, FileData: part.FileData})
+				} else {
+					texts = append(texts, part.Text)
+				}
+			}
+		default:
+			// Pass other blocks on as JSON rather than dropping them
+			data, err := json.Marshal(b)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s block in tool_result %s: %w", b.Type, block.ToolUseID, err)
+			}
+			texts = append(texts, string(data))
+		}
+	}
+
+	key := types.ResponseFieldResult
+	if block.IsError {
+		key = types.ResponseFieldError
+	}
+
+	return &types.GeminiPart{
+		FunctionResponse: &types.GeminiFunctionResponse{
+			Name:     name,
+			Response: map[string]interface{}{key: strings.Join(texts, "\n")},
+			Parts:    parts,
+		},
+	}, nil
+}
+
+// FlattenFunctionResponses moves media out of function responses, for models that don't
+// accept it there. The media is appended to the same content after the function
+// responses, each group introduced by a text part naming the function it came from.
+func FlattenFunctionResponses(contents []types.GeminiContent) {
+	for i := range contents {
+		var media []types.GeminiPart
+		for j := range contents[i].Parts {
+			response := contents[i].Parts[j].FunctionResponse
+			if response == nil || len(response.Parts) == 0 {
+				continue
+			}
+
+			media = append(media, types.GeminiPart{Text: fmt.Sprintf("Output of %s:", response.Name)})
+			for _, part := range response.Parts {
+				media = append(media, types.GeminiPart{InlineData: part.InlineData, FileData: part.FileData})
+			}
+			response.Parts = nil
+		}
+		contents[i].Parts = append(contents[i].Parts, media...)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// toolResultMessages returns a conversation ending in a tool_result with the given JSON
+// fields
+func toolResultMessages(t *testing.T, toolResult string) []types.AnthropicMessage {
+	t.Helper()
+	var messages []types.AnthropicMessage
+	err := json.Unmarshal([]byte(`[
+		{"role": "user", "content": "Take a screenshot"},
+		{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "computer", "input": {"action": "screenshot"}}]},
+		{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", `+toolResult+`}]}
+	]`), &messages)
+	if err != nil {
+		t.Fatalf("invalid messages: %v", err)
+	}
+	return messages
+}
+
+func TestConvertToolResult(t *testing.T) {
+	const image = `{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}`
+
+	tests := []struct {
+		name     string
+		fields   string
+		expected string // Function response payload
+		images   int
+	}{
+		{
+			name:     "string content",
+			fields:   `"content": "72 degrees"`,
+			expected: `{"result":"72 degrees"}`,
+		},
+		{
+			name:     "text blocks are joined",
+			fields:   `"content": [{"type": "text", "text": "line 1"}, {"type": "text", "text": "line 2"}]`,
+			expected: `{"result":"line 1\nline 2"}`,
+		},
+		{
+			name:     "text and image",
+			fields:   `"content": [{"type": "text", "text": "Screenshot taken"}, ` + image + `]`,
+			expected: `{"result":"Screenshot taken"}`,
+			images:   1,
+		},
+		{
+			name:     "images only",
+			fields:   `"content": [` + image + `, ` + image + `]`,
+			expected: `{"result":""}`,
+			images:   2,
+		},
+		{
+			name:     "empty content",
+			fields:   `"content": []`,
+			expected: `{"result":""}`,
+		},
+		{
+			name:     "error string",
+			fields:   `"content": "command not found", "is_error": true`,
+			expected: `{"error":"command not found"}`,
+		},
+		{
+			name:     "error blocks",
+			fields:   `"is_error": true, "content": [{"type": "text", "text": "Timed out"}, {"type": "text", "text": "after 30s"}]`,
+			expected: `{"error":"Timed out\nafter 30s"}`,
+		},
+		{
+			name:     "error with image",
+			fields:   `"is_error": true, "content": [{"type": "text", "text": "Dialog blocked the click"}, ` + image + `]`,
+			expected: `{"error":"Dialog blocked the click"}`,
+			images:   1,
+		},
+		{
+			name:     "is_error false",
+			fields:   `"is_error": false, "content": "ok"`,
+			expected: `{"result":"ok"}`,
+		},
+		{
+			name:     "other blocks as JSON",
+			fields:   `"content": [{"type": "search_result", "text": "hit"}]`,
+			expected: `{"result":"{\"type\":\"search_result\",\"text\":\"hit\"}"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contents, err := ToCustomGeminiContents(toolResultMessages(t, tt.fields))
+			if err != nil {
+				t.Fatalf("ToCustomGeminiContents failed: %v", err)
+			}
+
+			parts := contents[2].Parts
+			if len(parts) != 1 || parts[0].FunctionResponse == nil {
+				t.Fatalf("expected a single function response, got %+v", parts)
+			}
+			response := parts[0].FunctionResponse
+			if response.Name != "computer" {
+				t.Errorf("expected function name computer, got %q", response.Name)
+			}
+			if got, _ := json.Marshal(response.Response); string(got) != tt.expected {
+				t.Errorf("payload mismatch\nGot:  %s\nWant: %s", got, tt.expected)
+			}
+			if len(response.Parts) != tt.images {
+				t.Fatalf("expected %d image parts, got %d", tt.images, len(response.Parts))
+			}
+			for _, part := range response.Parts {
+				if part.InlineData == nil || part.InlineData.MimeType != "image/png" || part.InlineData.Data != "iVBORw0KGgo=" {
+					t.Errorf("unexpected image part: %+v", part)
+				}
+			}
+		})
+	}
+}
+
+func TestConvertToolResult_InvalidImage(t *testing.T) {
+	_, err := ToCustomGeminiContents(toolResultMessages(t, `"content": [{"type": "image", "source": {"type": "base64", "media_type": "image/png"}}]`))
+	if err == nil || !strings.Contains(err.Error(), "has no data") {
+		t.Errorf("expected an error for an image without data, got %v", err)
+	}
+}
+
+func TestSupportsMultimodalFunctionResponse(t *testing.T) {
+	tests := map[string]bool{
+		"gemini-3-pro-preview":        true,
+		"models/gemini-3-pro-preview": true,
+		"gemini-2.5-flash":            false,
+		"models/gemini-2.5-flash":     false,
+	}
+	for model, want := range tests {
+		if got := SupportsMultimodalFunctionResponse(model); got != want {
+			t.Errorf("SupportsMultimodalFunctionResponse(%q) = %t, want %t", model, got, want)
+		}
+	}
+}
+
+func TestFlattenFunctionResponses(t *testing.T) {
+	image := &types.GeminiBlob{MimeType: "image/png", Data: "a"}
+	contents := []types.GeminiContent{{Role: types.RoleUser, Parts: []types.GeminiPart{
+		{FunctionResponse: &types.GeminiFunctionResponse{Name: "screenshot", Response: map[string]interface{}{"result": ""},
+			Parts: []types.GeminiFunctionResponsePart{{InlineData: image}}}},
+		{FunctionResponse: &types.GeminiFunctionResponse{Name: "bash", Response: map[string]interface{}{"result": "ok"}}},
+	}}}
+
+	FlattenFunctionResponses(contents)
+
+	parts := contents[0].Parts
+	if len(parts) != 4 {
+		t.Fatalf("expected 2 function responses, a label and an image, got %+v", parts)
+	}
+	if parts[0].FunctionResponse.Parts != nil || parts[1].FunctionResponse == nil {
+		t.Errorf("expected function responses first without media, got %+v", parts[:2])
+	}
+	if parts[2].Text != "Output of screenshot:" || parts[3].InlineData != image {
+		t.Errorf("expected labelled image after the responses, got %+v", parts[2:])
+	}
+}
+
+func TestToGeminiContents_ToolResultImage(t *testing.T) {
+	contents, err := ToGeminiContents(toolResultMessages(t, `"content": [
+		{"type": "text", "text": "Screenshot taken"},
+		{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+	]`))
+	if err != nil {
+		t.Fatalf("ToGeminiContents failed: %v", err)
+	}
+
+	parts := contents[2].Parts
+	if len(parts) != 3 {
+		t.Fatalf("expected function response, label and image, got %d parts", len(parts))
+	}
+	if response, ok := parts[0].(genai.FunctionResponse); !ok || response.Response["result"] != "Screenshot taken" {
+		t.Errorf("unexpected function response: %#v", parts[0])
+	}
+	if _, ok := parts[2].(genai.Blob); !ok {
+		t.Errorf("expected image data, got %T", parts[2])
+	}
+}
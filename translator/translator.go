@@ -17,7 +17,6 @@ package translator
 import (
 	"encoding/base64"
 	"fmt"
-	"strings"
 
 	"github.com/google/generative-ai-go/genai"
 	"github.com/google/uuid"
@@ -30,6 +29,8 @@ func ToGeminiContents(messages []types.AnthropicMessage) ([]*genai.Content, erro
 	if err != nil {
 		return nil, err
 	}
+	// The SDK can't attach media to function responses
+	FlattenFunctionResponses(customContents)
 
 	// Convert custom contents to genai.Content
 	// Note: This will lose thought signatures, but they're preserved in the custom version
@@ -40,6 +41,11 @@ func ToGeminiContents(messages []types.AnthropicMessage) ([]*genai.Content, erro
 		}
 
 		for _, part := range cc.Parts {
+			if part.Thought {
+				// The SDK has no notion of thought parts; sending them as plain text
+				// would leak the model's reasoning back into the conversation
+				continue
+			}
 			if part.Text != "" {
 				content.Parts = append(content.Parts, genai.Text(part.Text))
 			} else if part.FunctionCall != nil {
@@ -52,14 +58,19 @@ func ToGeminiContents(messages []types.AnthropicMessage) ([]*genai.Content, erro
 					Name:     part.FunctionResponse.Name,
 					Response: part.FunctionResponse.Response,
 				})
+			} else if part.FileData != nil {
+				content.Parts = append(content.Parts, genai.FileData{
+					MIMEType: part.FileData.MimeType,
+					URI:      part.FileData.FileURI,
+				})
 			} else if part.InlineData != nil {
-				// Add inline data (images) to the content
+				// Add inline data (images and documents) to the content
 				// Data is base64 encoded, decode it
 				data, err := base64.StdEncoding.DecodeString(part.InlineData.Data)
 				if err != nil {
-					return nil, fmt.Errorf("failed to decode base64 image data: %w", err)
+					return nil, fmt.Errorf("failed to decode base64 inline data: %w", err)
 				}
-				content.Parts = append(content.Parts, genai.ImageData(part.InlineData.MimeType, data))
+				content.Parts = append(content.Parts, genai.Blob{MIMEType: part.InlineData.MimeType, Data: data})
 			}
 		}
 
@@ -94,13 +105,11 @@ func ToCustomGeminiContents(messages []types.AnthropicMessage) ([]types.GeminiCo
 
 		var parts []types.GeminiPart
 		for _, block := range msg.Content {
-			part, err := convertContentBlockToCustom(block, toolMap)
+			blockParts, err := convertContentBlockToCustom(block, toolMap)
 			if err != nil {
 				return nil, err
 			}
-			if part != nil {
-				parts = append(parts, *part)
-			}
+			parts = append(parts, blockParts...)
 		}
 
 		if len(parts) > 0 {
@@ -114,29 +123,56 @@ func ToCustomGeminiContents(messages []types.AnthropicMessage) ([]types.GeminiCo
 	return contents, nil
 }
 
-func convertContentBlockToCustom(block types.AnthropicContentBlock, toolMap map[string]string) (*types.GeminiPart, error) {
+func convertContentBlockToCustom(block types.AnthropicContentBlock, toolMap map[string]string) ([]types.GeminiPart, error) {
 	switch block.Type {
 	case types.ContentTypeText, "":
 		if block.Text != "" {
-			return &types.GeminiPart{
+			return []types.GeminiPart{{
 				Text: block.Text,
-			}, nil
+			}}, nil
 		}
 
+	case types.ContentTypeThinking:
+		// Thinking from a previous assistant turn becomes a Gemini thought part
+		if block.Thinking != "" || block.Signature != "" {
+			return []types.GeminiPart{{
+				Text:             block.Thinking,
+				Thought:          true,
+				ThoughtSignature: block.Signature,
+			}}, nil
+		}
+	case types.ContentTypeRedactedThinking:
+		// Redacted thinking only carries the opaque signature
+		if block.Data != "" {
+			return []types.GeminiPart{{
+				Thought:          true,
+				ThoughtSignature: block.Data,
+			}}, nil
+		}
 	case types.ContentTypeImage:
+		if block.Source != nil && (block.Source.Type == types.SourceTypeURL || block.Source.Type == types.SourceTypeFile) {
+			part, err := fileSourcePart(block.Source)
+			if err != nil {
+				return nil, err
+			}
+			return []types.GeminiPart{part}, nil
+		}
 		if block.Source != nil && block.Source.Data != "" {
-			return &types.GeminiPart{
+			return []types.GeminiPart{{
 				InlineData: &types.GeminiBlob{
 					MimeType: block.Source.MediaType,
 					Data:     block.Source.Data,
 				},
-			}, nil
+			}}, nil
 		}
 
+	case types.ContentTypeDocument:
+		return convertDocument(block)
+
 	case types.ContentTypeToolUse:
 		// Function call from assistant
 		if block.Name != "" {
-			part := &types.GeminiPart{
+			part := types.GeminiPart{
 				FunctionCall: &types.GeminiFunctionCall{
 					Name: block.Name,
 					Args: block.Input,
@@ -146,7 +182,7 @@ func convertContentBlockToCustom(block types.AnthropicContentBlock, toolMap map[
 			if block.ThoughtSignature != "" {
 				part.ThoughtSignature = block.ThoughtSignature
 			}
-			return part, nil
+			return []types.GeminiPart{part}, nil
 		}
 
 	case types.ContentTypeToolResult:
@@ -158,31 +194,47 @@ func convertContentBlockToCustom(block types.AnthropicContentBlock, toolMap map[
 				return nil, fmt.Errorf("tool_result references unknown tool_use_id: %s", block.ToolUseID)
 			}
 
-			// Extract the content from the tool_result block
-			var content interface{}
-			if block.Content != nil {
-				content = block.Content
-			} else if block.Text != "" {
-				content = block.Text
-			}
-
-			// Gemini expects the response to be a map[string]interface{}
-			response := map[string]interface{}{
-				types.ResponseFieldResult: content,
+			part, err := convertToolResult(block, toolName)
+			if err != nil {
+				return nil, err
 			}
-
-			return &types.GeminiPart{
-				FunctionResponse: &types.GeminiFunctionResponse{
-					Name:     toolName,
-					Response: response,
-				},
-			}, nil
+			return []types.GeminiPart{*part}, nil
 		}
 	}
 
 	return nil, nil
 }
 
+// DefaultMaxOutputTokens is used when the request doesn't specify max_tokens
+const DefaultMaxOutputTokens = 65536
+
+// ToGenerationConfig converts the Anthropic sampling parameters (max_tokens, temperature,
+// top_p and top_k) to a Gemini generation config. Stop sequences are emulated by the proxy
+// instead; see StopSequences.
+func ToGenerationConfig(req *types.AnthropicRequest) *GenerationConfig {
+	maxOutputTokens := int32(DefaultMaxOutputTokens)
+	if req.MaxTokens > 0 {
+		maxOutputTokens = int32(req.MaxTokens)
+	}
+
+	config := &GenerationConfig{
+		MaxOutputTokens: &maxOutputTokens,
+	}
+	if req.Temperature != nil {
+		temperature := float32(*req.Temperature)
+		config.Temperature = &temperature
+	}
+	if req.TopP != nil {
+		topP := float32(*req.TopP)
+		config.TopP = &topP
+	}
+	if req.TopK != nil {
+		topK := int32(*req.TopK)
+		config.TopK = &topK
+	}
+	return config
+}
+
 // ToGeminiTools converts Anthropic tools to Gemini tools
 func ToGeminiTools(tools []types.AnthropicTool) ([]*genai.Tool, error) {
 	if len(tools) == 0 {
@@ -192,29 +244,8 @@ func ToGeminiTools(tools []types.AnthropicTool) ([]*genai.Tool, error) {
 	var functionDecls []*genai.FunctionDeclaration
 
 	for _, tool := range tools {
-		schema := &genai.Schema{
-			Type: genai.TypeObject,
-		}
-
-		// Extract properties
-		if props, ok := tool.InputSchema[types.SchemaFieldProperties].(map[string]interface{}); ok {
-			schema.Properties = make(map[string]*genai.Schema)
-			for propName, propVal := range props {
-				if propMap, ok := propVal.(map[string]interface{}); ok {
-					schema.Properties[propName] = convertJSONSchemaToGemini(propMap)
-				}
-			}
-		}
-
-		// Extract required fields
-		if required, ok := tool.InputSchema[types.SchemaFieldRequired].([]interface{}); ok {
-			schema.Required = make([]string, len(required))
-			for i, r := range required {
-				if s, ok := r.(string); ok {
-					schema.Required[i] = s
-				}
-			}
-		}
+		schema := convertJSONSchemaToGemini(tool.InputSchema)
+		schema.Type = genai.TypeObject
 
 		functionDecls = append(functionDecls, &genai.FunctionDeclaration{
 			Name:        tool.Name,
@@ -226,119 +257,28 @@ func ToGeminiTools(tools []types.AnthropicTool) ([]*genai.Tool, error) {
 	return []*genai.Tool{{FunctionDeclarations: functionDecls}}, nil
 }
 
-// CleanSchemaForGemini removes fields that Gemini doesn't support from a JSON schema
+// CleanSchemaForGemini converts a JSON schema to the subset Gemini supports; see
+// ConvertSchema for the details and for a report of what was lost
 func CleanSchemaForGemini(schema map[string]interface{}) map[string]interface{} {
-	if schema == nil {
-		return nil
-	}
-
-	cleaned := make(map[string]interface{})
-
-	for key, value := range schema {
-		// Skip fields that Gemini doesn't support
-		if key == types.SchemaFieldDollarSchema || key == types.SchemaFieldAdditionalProperties {
-			continue
-		}
-
-		// Recursively clean nested objects
-		switch v := value.(type) {
-		case map[string]interface{}:
-			cleaned[key] = CleanSchemaForGemini(v)
-
-		case []interface{}:
-			// Clean array elements if they're objects
-			cleanedArray := make([]interface{}, len(v))
-			for i, item := range v {
-				if itemMap, ok := item.(map[string]interface{}); ok {
-					cleanedArray[i] = CleanSchemaForGemini(itemMap)
-				} else {
-					cleanedArray[i] = item
-				}
-			}
-			cleaned[key] = cleanedArray
-
-		default:
-			cleaned[key] = value
-		}
-	}
-
+	cleaned, _ := ConvertSchema(schema)
 	return cleaned
 }
 
+// convertJSONSchemaToGemini converts a JSON schema to the SDK's schema type, using the same
+// conversion as CleanSchemaForGemini
 func convertJSONSchemaToGemini(schema map[string]interface{}) *genai.Schema {
-	result := &genai.Schema{}
-
-	// Map type
-	if typeStr, ok := schema[types.SchemaFieldType].(string); ok {
-		switch strings.ToLower(typeStr) {
-		case types.SchemaTypeString:
-			result.Type = genai.TypeString
-		case types.SchemaTypeNumber:
-			result.Type = genai.TypeNumber
-		case types.SchemaTypeInteger:
-			result.Type = genai.TypeInteger
-		case types.SchemaTypeBoolean:
-			result.Type = genai.TypeBoolean
-		case types.SchemaTypeArray:
-			result.Type = genai.TypeArray
-		case types.SchemaTypeObject:
-			result.Type = genai.TypeObject
-		}
-	}
-
-	// Map description
-	if desc, ok := schema[types.SchemaFieldDescription].(string); ok {
-		result.Description = desc
-	}
-
-	// Map enum
-	if enum, ok := schema[types.SchemaFieldEnum].([]interface{}); ok {
-		result.Enum = make([]string, len(enum))
-		for i, e := range enum {
-			if s, ok := e.(string); ok {
-				result.Enum[i] = s
-			}
-		}
-	}
-
-	// Map items (for arrays)
-	if items, ok := schema[types.SchemaFieldItems].(map[string]interface{}); ok {
-		result.Items = convertJSONSchemaToGemini(items)
-	}
-
-	// Map nested properties (for objects)
-	if props, ok := schema[types.SchemaFieldProperties].(map[string]interface{}); ok {
-		result.Properties = make(map[string]*genai.Schema)
-		for propName, propVal := range props {
-			if propMap, ok := propVal.(map[string]interface{}); ok {
-				result.Properties[propName] = convertJSONSchemaToGemini(propMap)
-			}
-		}
-	}
-
-	// Map required fields (for objects)
-	if required, ok := schema[types.SchemaFieldRequired].([]interface{}); ok {
-		result.Required = make([]string, len(required))
-		for i, r := range required {
-			if s, ok := r.(string); ok {
-				result.Required[i] = s
-			}
-		}
-	}
-
-	// NOTE: We explicitly do NOT map "$schema" or "additionalProperties" as Gemini doesn't support them
-	// and will return a 400 error if they are present
-
-	return result
+	cleaned, _ := ConvertSchema(schema)
+	return toGeminiSchema(cleaned)
 }
 
 // ToAnthropicResponse converts a Gemini response to Anthropic format
 func ToAnthropicResponse(resp *genai.GenerateContentResponse, model string) (*types.AnthropicResponse, error) {
 	anthropicResp := &types.AnthropicResponse{
-		ID:    uuid.New().String(),
-		Type:  types.ResponseTypeMessage,
-		Role:  types.RoleAssistant,
-		Model: model,
+		ID:      uuid.New().String(),
+		Type:    types.ResponseTypeMessage,
+		Role:    types.RoleAssistant,
+		Model:   model,
+		Content: []types.AnthropicContentBlock{},
 	}
 
 	// Extract content from first candidate
@@ -354,17 +294,19 @@ func ToAnthropicResponse(resp *genai.GenerateContentResponse, model string) (*ty
 		}
 
 		// Map stop reason
-		if candidate.FinishReason != 0 {
-			anthropicResp.StopReason = types.StopReasonEndTurn
+		stopReason, err := ToStopReason(toSDKFinishReason(candidate.FinishReason), hasToolUse(anthropicResp.Content))
+		if err != nil {
+			return nil, err
 		}
+		anthropicResp.StopReason = stopReason
+	} else if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != genai.BlockReasonUnspecified {
+		// The prompt itself was blocked
+		anthropicResp.StopReason = types.StopReasonRefusal
 	}
 
 	// Map usage metadata
 	if resp.UsageMetadata != nil {
-		anthropicResp.Usage = types.AnthropicUsage{
-			InputTokens:  int(resp.UsageMetadata.PromptTokenCount),
-			OutputTokens: int(resp.UsageMetadata.CandidatesTokenCount),
-		}
+		anthropicResp.Usage = ToAnthropicUsage(toUsageMetadata(resp.UsageMetadata), false)
 	}
 
 	return anthropicResp, nil
@@ -390,8 +332,45 @@ func convertGeminiPart(part genai.Part) *types.AnthropicContentBlock {
 	return nil
 }
 
-// convertCustomGeminiPart converts a custom Gemini part (with thought signature support) to Anthropic format
-func convertCustomGeminiPart(part types.GeminiPart) *types.AnthropicContentBlock {
+// ToolUseIDEncoder creates tool_use IDs that carry the function call's thought signature,
+// so the signature can be recovered from the ID the client echoes back on a later turn
+type ToolUseIDEncoder interface {
+	EncodeToolUseID(signature string) string
+}
+
+// ResponseOptions controls how a custom Gemini response is converted to Anthropic format.
+// The zero value converts the response as is.
+type ResponseOptions struct {
+	// ToolUseIDs, if non-nil, creates the IDs of function calls with a thought signature
+	// instead of a random UUID
+	ToolUseIDs ToolUseIDEncoder
+
+	// ToolNames maps Gemini function names back to the request's tool names
+	ToolNames *ToolNames
+
+	// Tools, if non-nil, checks function call arguments against the tools' input schemas;
+	// see ToolValidator.Check
+	Tools *ToolValidator
+
+	// CacheCreated reports that the request's context cache was created for it, so that
+	// cached tokens count as cache creation rather than a cache read
+	CacheCreated bool
+}
+
+// convertCustomGeminiPart converts a custom Gemini part (with thought signature support) to
+// Anthropic format
+func convertCustomGeminiPart(part types.GeminiPart, opts ResponseOptions) *types.AnthropicContentBlock {
+	if part.Thought {
+		// Thought summary parts become thinking blocks
+		if part.Text == "" && part.ThoughtSignature == "" {
+			return nil
+		}
+		return &types.AnthropicContentBlock{
+			Type:      types.ContentTypeThinking,
+			Thinking:  part.Text,
+			Signature: part.ThoughtSignature,
+		}
+	}
 	if part.Text != "" {
 		return &types.AnthropicContentBlock{
 			Type: types.ContentTypeText,
@@ -403,12 +382,15 @@ func convertCustomGeminiPart(part types.GeminiPart) *types.AnthropicContentBlock
 		block := &types.AnthropicContentBlock{
 			Type:  types.ContentTypeToolUse,
 			ID:    uuid.New().String(),
-			Name:  part.FunctionCall.Name,
+			Name:  opts.ToolNames.ToAnthropic(part.FunctionCall.Name),
 			Input: part.FunctionCall.Args,
 		}
 		// Preserve thought signature
 		if part.ThoughtSignature != "" {
 			block.ThoughtSignature = part.ThoughtSignature
+			if opts.ToolUseIDs != nil {
+				block.ID = opts.ToolUseIDs.EncodeToolUseID(part.ThoughtSignature)
+			}
 		}
 		return block
 	}
@@ -417,12 +399,13 @@ func convertCustomGeminiPart(part types.GeminiPart) *types.AnthropicContentBlock
 }
 
 // ToAnthropicResponseFromCustom converts a custom Gemini response to Anthropic format
-func ToAnthropicResponseFromCustom(resp *GenerateContentResponse, model string) (*types.AnthropicResponse, error) {
+func ToAnthropicResponseFromCustom(resp *GenerateContentResponse, model string, opts ResponseOptions) (*types.AnthropicResponse, error) {
 	anthropicResp := &types.AnthropicResponse{
-		ID:    uuid.New().String(),
-		Type:  types.ResponseTypeMessage,
-		Role:  types.RoleAssistant,
-		Model: model,
+		ID:      uuid.New().String(),
+		Type:    types.ResponseTypeMessage,
+		Role:    types.RoleAssistant,
+		Model:   model,
+		Content: []types.AnthropicContentBlock{},
 	}
 
 	// Extract content from first candidate
@@ -430,25 +413,31 @@ func ToAnthropicResponseFromCustom(resp *GenerateContentResponse, model string)
 		candidate := resp.Candidates[0]
 		if candidate.Content != nil {
 			for _, part := range candidate.Content.Parts {
-				block := convertCustomGeminiPart(part)
+				block := convertCustomGeminiPart(part, opts)
 				if block != nil {
 					anthropicResp.Content = append(anthropicResp.Content, *block)
 				}
 			}
 		}
 
+		if err := opts.Tools.Check(anthropicResp.Content); err != nil {
+			return nil, err
+		}
+
 		// Map stop reason
-		if candidate.FinishReason != "" {
-			anthropicResp.StopReason = types.StopReasonEndTurn
+		stopReason, err := ToStopReason(candidate.FinishReason, hasToolUse(anthropicResp.Content))
+		if err != nil {
+			return nil, err
 		}
+		anthropicResp.StopReason = stopReason
+	} else if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		// The prompt itself was blocked
+		anthropicResp.StopReason = types.StopReasonRefusal
 	}
 
 	// Map usage metadata
 	if resp.UsageMetadata != nil {
-		anthropicResp.Usage = types.AnthropicUsage{
-			InputTokens:  int(resp.UsageMetadata.PromptTokenCount),
-			OutputTokens: int(resp.UsageMetadata.CandidatesTokenCount),
-		}
+		anthropicResp.Usage = ToAnthropicUsage(resp.UsageMetadata, opts.CacheCreated)
 	}
 
 	return anthropicResp, nil
@@ -17,6 +17,7 @@ package translator
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/google/generative-ai-go/genai"
@@ -124,7 +125,7 @@ func TestToGeminiContents_WithImage(t *testing.T) {
 				{Type: "text", Text: "What's in this image?"},
 				{
 					Type: "image",
-					Source: &types.AnthropicImageSource{
+					Source: &types.AnthropicSource{
 						Type:      "base64",
 						MediaType: "image/png",
 						Data:      "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==",
@@ -426,14 +427,9 @@ func TestToGeminiContents_ToolResultWithComplexContent(t *testing.T) {
 		t.Errorf("expected function response name 'read_file', got '%s'", functionResponse.Name)
 	}
 
-	// Content should be the array wrapped in result key
-	contentArray, ok := functionResponse.Response["result"].([]interface{})
-	if !ok {
-		t.Errorf("expected array content in result, got %T", functionResponse.Response["result"])
-	}
-
-	if len(contentArray) != 1 {
-		t.Errorf("expected 1 content block, got %d", len(contentArray))
+	// Text blocks are joined into the result
+	if result := functionResponse.Response["result"]; result != "File contents here" {
+		t.Errorf("expected result 'File contents here', got %v", result)
 	}
 }
 
@@ -758,11 +754,45 @@ func TestToAnthropicResponseFromCustom(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "thought summary becomes thinking block",
+			resp: &GenerateContentResponse{
+				Candidates: []Candidate{
+					{
+						Content: &types.GeminiContent{
+							Parts: []types.GeminiPart{
+								{Text: "Considering the question", Thought: true, ThoughtSignature: "sig-1"},
+								{Text: "The answer is 42."},
+							},
+						},
+						FinishReason: "STOP",
+					},
+				},
+			},
+			model: "gemini-3-pro-preview",
+			validate: func(t *testing.T, resp *types.AnthropicResponse) {
+				if len(resp.Content) != 2 {
+					t.Fatalf("expected 2 content blocks, got %d", len(resp.Content))
+				}
+				if resp.Content[0].Type != "thinking" {
+					t.Errorf("expected content type 'thinking', got '%s'", resp.Content[0].Type)
+				}
+				if resp.Content[0].Thinking != "Considering the question" {
+					t.Errorf("expected thinking text, got '%s'", resp.Content[0].Thinking)
+				}
+				if resp.Content[0].Signature != "sig-1" {
+					t.Errorf("expected signature 'sig-1', got '%s'", resp.Content[0].Signature)
+				}
+				if resp.Content[1].Type != "text" || resp.Content[1].Text != "The answer is 42." {
+					t.Errorf("expected text block, got %+v", resp.Content[1])
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			resp, err := ToAnthropicResponseFromCustom(tt.resp, tt.model)
+			resp, err := ToAnthropicResponseFromCustom(tt.resp, tt.model, ResponseOptions{})
 			if err != nil {
 				t.Fatalf("ToAnthropicResponseFromCustom failed: %v", err)
 			}
@@ -778,7 +808,7 @@ func TestToGeminiContents_InvalidBase64Image(t *testing.T) {
 			Content: []types.AnthropicContentBlock{
 				{
 					Type: "image",
-					Source: &types.AnthropicImageSource{
+					Source: &types.AnthropicSource{
 						Type:      "base64",
 						MediaType: "image/png",
 						Data:      "!!!invalid base64!!!",
@@ -931,7 +961,7 @@ func TestConvertCustomGeminiPart_NoPart(t *testing.T) {
 	// Test with empty GeminiPart
 	part := types.GeminiPart{}
 
-	result := convertCustomGeminiPart(part)
+	result := convertCustomGeminiPart(part, ResponseOptions{})
 	if result != nil {
 		t.Errorf("expected nil for empty part, got %+v", result)
 	}
@@ -1004,7 +1034,7 @@ func TestToAnthropicResponseFromCustom_NoFinishReason(t *testing.T) {
 		},
 	}
 
-	anthropicResp, err := ToAnthropicResponseFromCustom(resp, "test-model")
+	anthropicResp, err := ToAnthropicResponseFromCustom(resp, "test-model", ResponseOptions{})
 	if err != nil {
 		t.Fatalf("ToAnthropicResponseFromCustom failed: %v", err)
 	}
@@ -1034,3 +1064,129 @@ func TestCleanSchemaForGemini_NilSchema(t *testing.T) {
 		t.Errorf("expected nil result for nil input, got %v", result)
 	}
 }
+
+func TestToCustomGeminiContents_WithThinking(t *testing.T) {
+	messages := []types.AnthropicMessage{
+		{
+			Role: "assistant",
+			Content: []types.AnthropicContentBlock{
+				{Type: "thinking", Thinking: "Let me think", Signature: "sig-thinking"},
+				{Type: "redacted_thinking", Data: "sig-redacted"},
+				{Type: "text", Text: "Done"},
+			},
+		},
+	}
+
+	contents, err := ToCustomGeminiContents(messages)
+	if err != nil {
+		t.Fatalf("ToCustomGeminiContents failed: %v", err)
+	}
+
+	if len(contents) != 1 || len(contents[0].Parts) != 3 {
+		t.Fatalf("expected 1 content with 3 parts, got %+v", contents)
+	}
+
+	thinking := contents[0].Parts[0]
+	if !thinking.Thought || thinking.Text != "Let me think" || thinking.ThoughtSignature != "sig-thinking" {
+		t.Errorf("unexpected thinking part: %+v", thinking)
+	}
+
+	redacted := contents[0].Parts[1]
+	if !redacted.Thought || redacted.Text != "" || redacted.ThoughtSignature != "sig-redacted" {
+		t.Errorf("unexpected redacted thinking part: %+v", redacted)
+	}
+
+	if contents[0].Parts[2].Thought {
+		t.Error("expected text part not to be marked as a thought")
+	}
+}
+
+func TestToGeminiContents_SkipsThinking(t *testing.T) {
+	messages := []types.AnthropicMessage{
+		{
+			Role: "assistant",
+			Content: []types.AnthropicContentBlock{
+				{Type: "thinking", Thinking: "Private reasoning", Signature: "sig"},
+				{Type: "text", Text: "Public answer"},
+			},
+		},
+	}
+
+	contents, err := ToGeminiContents(messages)
+	if err != nil {
+		t.Fatalf("ToGeminiContents failed: %v", err)
+	}
+
+	if len(contents) != 1 || len(contents[0].Parts) != 1 {
+		t.Fatalf("expected 1 content with 1 part, got %+v", contents)
+	}
+	if text, ok := contents[0].Parts[0].(genai.Text); !ok || string(text) != "Public answer" {
+		t.Errorf("expected only the text part, got %+v", contents[0].Parts[0])
+	}
+}
+
+func TestToGenerationConfig(t *testing.T) {
+	temperature := 0.5
+	topP := 0.9
+	topK := 40
+	req := &types.AnthropicRequest{
+		MaxTokens:   1024,
+		Temperature: &temperature,
+		TopP:        &topP,
+		TopK:        &topK,
+	}
+
+	config := ToGenerationConfig(req)
+
+	if config.MaxOutputTokens == nil || *config.MaxOutputTokens != 1024 {
+		t.Errorf("expected max output tokens 1024, got %v", config.MaxOutputTokens)
+	}
+	if config.Temperature == nil || *config.Temperature != 0.5 {
+		t.Errorf("expected temperature 0.5, got %v", config.Temperature)
+	}
+	if config.TopP == nil || *config.TopP != float32(0.9) {
+		t.Errorf("expected top_p 0.9, got %v", config.TopP)
+	}
+	if config.TopK == nil || *config.TopK != 40 {
+		t.Errorf("expected top_k 40, got %v", config.TopK)
+	}
+}
+
+func TestToGenerationConfig_Defaults(t *testing.T) {
+	config := ToGenerationConfig(&types.AnthropicRequest{})
+
+	if config.MaxOutputTokens == nil || *config.MaxOutputTokens != DefaultMaxOutputTokens {
+		t.Errorf("expected default max output tokens %d, got %v", DefaultMaxOutputTokens, config.MaxOutputTokens)
+	}
+	if config.Temperature != nil || config.TopP != nil || config.TopK != nil {
+		t.Errorf("expected unset sampling parameters, got %+v", config)
+	}
+}
+
+// prefixEncoder is a ToolUseIDEncoder that embeds the signature verbatim
+type prefixEncoder struct{}
+
+func (prefixEncoder) EncodeToolUseID(signature string) string {
+	return "encoded_" + signature
+}
+
+func TestConvertCustomGeminiPart_ToolUseIDEncoder(t *testing.T) {
+	withSignature := convertCustomGeminiPart(types.GeminiPart{
+		FunctionCall:     &types.GeminiFunctionCall{Name: "get_weather"},
+		ThoughtSignature: "sig",
+	}, ResponseOptions{ToolUseIDs: prefixEncoder{}})
+	if withSignature.ID != "encoded_sig" {
+		t.Errorf("expected encoded ID, got %q", withSignature.ID)
+	}
+	if withSignature.ThoughtSignature != "sig" {
+		t.Errorf("expected thought signature to be preserved, got %q", withSignature.ThoughtSignature)
+	}
+
+	// Function calls without a signature keep a random ID
+	withoutSignature := convertCustomGeminiPart(types.GeminiPart{
+		FunctionCall: &types.GeminiFunctionCall{Name: "get_weather"},
+	}, ResponseOptions{ToolUseIDs: prefixEncoder{}})
+	if strings.HasPrefix(withoutSignature.ID, "encoded_") || withoutSignature.ID == "" {
+		t.Errorf("expected random ID, got %q", withoutSignature.ID)
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// Default URL fetch settings
+const (
+	DefaultURLFetchMaxBytes        = 10 << 20 // Gemini limits inline data to 20MB per request
+	DefaultURLFetchMaxRequestBytes = 20 << 20
+	DefaultURLFetchMaxSources      = 20
+	DefaultURLFetchTimeout         = 15 * time.Second
+	DefaultURLFetchCacheTTL        = 5 * time.Minute
+)
+
+// maxURLFetchRedirects is the number of redirects followed when fetching a URL source
+const maxURLFetchRedirects = 5
+
+// maxURLCacheBytes bounds the total size of the fetched content URLFetcher keeps for reuse
+const maxURLCacheBytes = 64 << 20
+
+// Media types that URL sources may resolve to
+var (
+	fetchableImageTypes    = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+	fetchableDocumentTypes = []string{types.MediaTypePDF, types.MediaTypePlainText}
+)
+
+// blockedNetworks are ranges that aren't covered by the net.IP predicates but must not be
+// reachable from a URL source either
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "This" network
+	"100.64.0.0/10", // Carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // Benchmarking
+	"240.0.0.0/4",   // Reserved
+	"64:ff9b::/96",  // NAT64, which can reach any IPv4 address
+)
+
+// errBlockedAddress is returned when a URL source resolves to a private address
+var errBlockedAddress = errors.New("address is not publicly routable")
+
+// URLFetchPolicy limits what is downloaded for image and document URL sources.
+//
+// Only http and https URLs are fetched. Unless AllowPrivateNetworks is set, connections to
+// loopback, private, link-local and other non-public addresses are refused; the check is
+// made on the resolved address of every connection, including redirects. Responses must
+// have one of the allowed media types (JPEG, PNG, GIF and WebP images; PDF and plain text
+// documents).
+//
+// Clients send the whole conversation with every request, so fetched content is reused
+// for CacheTTL rather than downloaded again on every turn.
+type URLFetchPolicy struct {
+	MaxBytes             int64         // Largest response body accepted
+	MaxRequestBytes      int64         // Total size of the URL sources of a request; 0 for no limit
+	MaxSources           int           // Number of URL sources in a request; 0 for no limit
+	Timeout              time.Duration // Time limit for a single fetch, including redirects
+	CacheTTL             time.Duration // How long fetched content is reused; 0 disables caching
+	AllowPrivateNetworks bool          // Allow URLs that resolve to non-public addresses
+}
+
+// DefaultURLFetchPolicy returns the URL fetch policy used unless configured otherwise
+func DefaultURLFetchPolicy() URLFetchPolicy {
+	return URLFetchPolicy{
+		MaxBytes:        DefaultURLFetchMaxBytes,
+		MaxRequestBytes: DefaultURLFetchMaxRequestBytes,
+		MaxSources:      DefaultURLFetchMaxSources,
+		Timeout:         DefaultURLFetchTimeout,
+		CacheTTL:        DefaultURLFetchCacheTTL,
+	}
+}
+
+// URLFetcher downloads URL sources of image and document blocks so they can be sent to
+// Gemini as inline data
+type URLFetcher struct {
+	policy URLFetchPolicy
+	client *http.Client
+	now    func() time.Time
+
+	mu         sync.Mutex
+	cache      map[string]fetchedURL // Fetched content by URL
+	cacheBytes int64
+}
+
+// fetchedURL is content fetched for a URL, kept until expireTime
+type fetchedURL struct {
+	mediaType  string
+	data       []byte
+	expireTime time.Time
+}
+
+// NewURLFetcher creates a URLFetcher enforcing policy
+func NewURLFetcher(policy URLFetchPolicy) *URLFetcher {
+	dialer := &net.Dialer{
+		Timeout: policy.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if policy.AllowPrivateNetworks {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isBlockedIP(ip) {
+				return fmt.Errorf("%s: %w", host, errBlockedAddress)
+			}
+			return nil
+		},
+	}
+
+	return &URLFetcher{
+		policy: policy,
+		now:    time.Now,
+		cache:  map[string]fetchedURL{},
+		client: &http.Client{
+			Timeout: policy.Timeout,
+			Transport: &http.Transport{
+				// No proxy: the address check must apply to the server itself
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: policy.Timeout,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxURLFetchRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxURLFetchRedirects)
+				}
+				return checkFetchURL(req.URL)
+			},
+		},
+	}
+}
+
+// isBlockedIP reports whether ip is an address a URL source must not reach
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// checkFetchURL rejects URLs that aren't plain http or https
+func checkFetchURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return errors.New("URL has no host")
+	}
+	return nil
+}
+
+// IsGeminiFileURI reports whether rawURL refers to a file uploaded to the Gemini File API.
+// Clients can't reference such files directly, since they would be read with the proxy's
+// API key; uploads go through the Files API instead.
+func IsGeminiFileURI(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && u.Host == "generativelanguage.googleapis.com" &&
+		strings.Contains(u.Path, "/files/")
+}
+
+// Fetch downloads rawURL and returns its media type and content. The media type must be
+// one of allowed; when the server doesn't send a usable Content-Type it is detected from
+// the content.
+func (f *URLFetcher) Fetch(ctx context.Context, rawURL string, allowed []string) (string, []byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if err := checkFetchURL(u); err != nil {
+		return "", nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > f.policy.MaxBytes {
+		return "", nil, fmt.Errorf("content is larger than %d bytes", f.policy.MaxBytes)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.policy.MaxBytes+1))
+	if err != nil {
+		return "", nil, err
+	}
+	if int64(len(data)) > f.policy.MaxBytes {
+		return "", nil, fmt.Errorf("content is larger than %d bytes", f.policy.MaxBytes)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if err := checkMediaType(mediaType, allowed); err != nil {
+		return "", nil, err
+	}
+	return mediaType, data, nil
+}
+
+// checkMediaType rejects content whose media type isn't one of allowed
+func checkMediaType(mediaType string, allowed []string) error {
+	if !slices.Contains(allowed, mediaType) {
+		return fmt.Errorf("content type %q is not allowed (expected one of %s)", mediaType, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// fetchCached is Fetch, reusing content fetched for the same URL within the cache TTL
+func (f *URLFetcher) fetchCached(ctx context.Context, rawURL string, allowed []string) (string, []byte, error) {
+	f.mu.Lock()
+	cached, ok := f.cache[rawURL]
+	f.mu.Unlock()
+	if ok && cached.expireTime.After(f.now()) {
+		if err := checkMediaType(cached.mediaType, allowed); err != nil {
+			return "", nil, err
+		}
+		return cached.mediaType, cached.data, nil
+	}
+
+	mediaType, data, err := f.Fetch(ctx, rawURL, allowed)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if f.policy.CacheTTL > 0 && len(data) <= maxURLCacheBytes {
+		f.mu.Lock()
+		f.prune(int64(len(data)))
+		f.cache[rawURL] = fetchedURL{mediaType: mediaType, data: data, expireTime: f.now().Add(f.policy.CacheTTL)}
+		f.cacheBytes += int64(len(data))
+		f.mu.Unlock()
+	}
+	return mediaType, data, nil
+}
+
+// prune forgets expired content, and all content if there still isn't room for size more
+// bytes. The caller must hold f.mu.
+func (f *URLFetcher) prune(size int64) {
+	now := f.now()
+	for rawURL, cached := range f.cache {
+		if !cached.expireTime.After(now) {
+			delete(f.cache, rawURL)
+			f.cacheBytes -= int64(len(cached.data))
+		}
+	}
+	if f.cacheBytes+size > maxURLCacheBytes {
+		clear(f.cache)
+		f.cacheBytes = 0
+	}
+}
+
+// ResolveURLSources fetches the URL sources of image and document blocks in messages,
+// including those inside tool results, and replaces them with the fetched content. URLs
+// of files in the Gemini File API, a URL that can't be fetched, or URL sources exceeding
+// the policy's per-request limits fail the request with an invalid_request_error.
+func (f *URLFetcher) ResolveURLSources(ctx context.Context, messages []types.AnthropicMessage) error {
+	var totals urlSourceTotals
+	return forEachSourceBlock(messages, func(block *types.AnthropicContentBlock) error {
+		return f.resolveSource(ctx, block, &totals)
+	})
+}
+
+// urlSourceTotals counts the URL sources resolved for a request
+type urlSourceTotals struct {
+	sources int
+	bytes   int64
+}
+
+// forEachSourceBlock calls fn with each image and document block in messages, including
+// those inside tool results. Tool result content is normalized to an array of blocks so
+// that fn can modify them.
+func forEachSourceBlock(messages []types.AnthropicMessage, fn func(*types.AnthropicContentBlock) error) error {
+	for i := range messages {
+		if err := forEachSourceBlockIn(messages[i].Content, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forEachSourceBlockIn(blocks []types.AnthropicContentBlock, fn func(*types.AnthropicContentBlock) error) error {
+	for i := range blocks {
+		block := &blocks[i]
+		switch block.Type {
+		case types.ContentTypeImage, types.ContentTypeDocument:
+			if err := fn(block); err != nil {
+				return err
+			}
+		case types.ContentTypeToolResult:
+			if _, ok := block.Content.(string); ok || block.Content == nil {
+				continue
+			}
+			content, err := contentBlocks(block.Content)
+			if err != nil {
+				return NewInvalidRequestError("invalid content in tool_result %s: %v", block.ToolUseID, err)
+			}
+			if err := forEachSourceBlockIn(content, fn); err != nil {
+				return err
+			}
+			block.Content = content
+		}
+	}
+	return nil
+}
+
+func (f *URLFetcher) resolveSource(ctx context.Context, block *types.AnthropicContentBlock, totals *urlSourceTotals) error {
+	source := block.Source
+	if source == nil || source.Type != types.SourceTypeURL {
+		return nil
+	}
+	if IsGeminiFileURI(source.URL) {
+		return NewInvalidRequestError("%s source %s refers to a Gemini file; upload it through the Files API instead", block.Type, source.URL)
+	}
+
+	totals.sources++
+	if f.policy.MaxSources > 0 && totals.sources > f.policy.MaxSources {
+		return NewInvalidRequestError("too many URL sources: at most %d are fetched per request", f.policy.MaxSources)
+	}
+
+	allowed := fetchableImageTypes
+	if block.Type == types.ContentTypeDocument {
+		allowed = fetchableDocumentTypes
+	}
+	mediaType, data, err := f.fetchCached(ctx, source.URL, allowed)
+	if err != nil {
+		return NewInvalidRequestError("failed to fetch %s source %s: %v", block.Type, source.URL, err)
+	}
+
+	totals.bytes += int64(len(data))
+	if f.policy.MaxRequestBytes > 0 && totals.bytes > f.policy.MaxRequestBytes {
+		return NewInvalidRequestError("URL sources exceed %d bytes in total", f.policy.MaxRequestBytes)
+	}
+
+	if mediaType == types.MediaTypePlainText {
+		block.Source = &types.AnthropicSource{Type: types.SourceTypeText, MediaType: mediaType, Data: string(data)}
+	} else {
+		block.Source = &types.AnthropicSource{
+			Type:      types.SourceTypeBase64,
+			MediaType: mediaType,
+			Data:      base64.StdEncoding.EncodeToString(data),
+		}
+	}
+	return nil
+}
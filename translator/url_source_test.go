@@ -0,0 +1,346 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// pngHeader is enough of a PNG file for content type detection
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// newFileServer serves a few test files and returns a fetcher allowed to reach it
+func newFileServer(t *testing.T) (*httptest.Server, *URLFetcher) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngHeader)
+	})
+	mux.HandleFunc("/untyped", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(pngHeader)
+	})
+	mux.HandleFunc("/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4\n"))
+	})
+	mux.HandleFunc("/notes.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Meeting notes"))
+	})
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, 2048))
+	})
+	mux.HandleFunc("/large-chunked", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		for i := 0; i < 4; i++ {
+			w.Write(make([]byte, 512))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	fetcher := NewURLFetcher(URLFetchPolicy{
+		MaxBytes:             1024,
+		Timeout:              200 * time.Millisecond,
+		AllowPrivateNetworks: true,
+	})
+	return ts, fetcher
+}
+
+func TestIsBlockedIP(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true, // Cloud metadata
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"224.0.0.1":        true,
+		"::1":              true,
+		"fd00::1":          true,
+		"fe80::1":          true,
+		"::ffff:127.0.0.1": true,
+		"64:ff9b::a00:1":   true,
+		"8.8.8.8":          false,
+		"142.250.72.14":    false,
+		"2606:4700::1111":  false,
+	}
+	for address, blocked := range tests {
+		if got := isBlockedIP(net.ParseIP(address)); got != blocked {
+			t.Errorf("isBlockedIP(%s) = %t, want %t", address, got, blocked)
+		}
+	}
+}
+
+func TestURLFetcher_Fetch(t *testing.T) {
+	ts, fetcher := newFileServer(t)
+
+	tests := []struct {
+		path      string
+		allowed   []string
+		mediaType string
+		err       string
+	}{
+		{path: "/image.png", allowed: fetchableImageTypes, mediaType: "image/png"},
+		{path: "/untyped", allowed: fetchableImageTypes, mediaType: "image/png"},
+		{path: "/report.pdf", allowed: fetchableDocumentTypes, mediaType: "application/pdf"},
+		{path: "/notes.txt", allowed: fetchableDocumentTypes, mediaType: "text/plain"},
+		{path: "/report.pdf", allowed: fetchableImageTypes, err: `content type "application/pdf" is not allowed`},
+		{path: "/page.html", allowed: fetchableDocumentTypes, err: `content type "text/html" is not allowed`},
+		{path: "/large", allowed: fetchableImageTypes, err: "larger than 1024 bytes"},
+		{path: "/large-chunked", allowed: fetchableImageTypes, err: "larger than 1024 bytes"},
+		{path: "/slow", allowed: fetchableImageTypes, err: "Timeout"},
+		{path: "/missing", allowed: fetchableImageTypes, err: "unexpected status 404"},
+		{path: "/loop", allowed: fetchableImageTypes, err: "stopped after 5 redirects"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			mediaType, data, err := fetcher.Fetch(context.Background(), ts.URL+tt.path, tt.allowed)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mediaType != tt.mediaType || len(data) == 0 {
+				t.Errorf("got %q with %d bytes, want %q", mediaType, len(data), tt.mediaType)
+			}
+		})
+	}
+}
+
+func TestURLFetcher_BlocksPrivateNetworks(t *testing.T) {
+	ts, _ := newFileServer(t)
+	fetcher := NewURLFetcher(DefaultURLFetchPolicy())
+
+	for _, rawURL := range []string{
+		ts.URL + "/image.png",
+		strings.Replace(ts.URL, "127.0.0.1", "localhost", 1) + "/image.png",
+	} {
+		_, _, err := fetcher.Fetch(context.Background(), rawURL, fetchableImageTypes)
+		if !errors.Is(err, errBlockedAddress) {
+			t.Errorf("expected %s to be blocked, got %v", rawURL, err)
+		}
+	}
+
+	for _, rawURL := range []string{"file:///etc/passwd", "ftp://example.com/a.png", "http:///a.png"} {
+		if _, _, err := fetcher.Fetch(context.Background(), rawURL, fetchableImageTypes); err == nil {
+			t.Errorf("expected %s to be rejected", rawURL)
+		}
+	}
+}
+
+func TestURLFetcher_ResolveURLSources(t *testing.T) {
+	ts, fetcher := newFileServer(t)
+	messages := []types.AnthropicMessage{
+		{Role: types.RoleUser, Content: []types.AnthropicContentBlock{
+			{Type: types.ContentTypeImage, Source: &types.AnthropicSource{Type: types.SourceTypeURL, URL: ts.URL + "/image.png"}},
+			{Type: types.ContentTypeDocument, Source: &types.AnthropicSource{Type: types.SourceTypeURL, URL: ts.URL + "/report.pdf"}},
+			{Type: types.ContentTypeDocument, Title: "Notes", Source: &types.AnthropicSource{Type: types.SourceTypeURL, URL: ts.URL + "/notes.txt"}},
+		}},
+		{Role: types.RoleAssistant, Content: []types.AnthropicContentBlock{
+			{Type: types.ContentTypeToolUse, ID: "toolu_1", Name: "screenshot"},
+		}},
+		{Role: types.RoleUser, Content: []types.AnthropicContentBlock{
+			{Type: types.ContentTypeToolResult, ToolUseID: "toolu_1", Content: []interface{}{
+				map[string]interface{}{"type": "image", "source": map[string]interface{}{"type": "url", "url": ts.URL + "/untyped"}},
+			}},
+		}},
+	}
+
+	if err := fetcher.ResolveURLSources(context.Background(), messages); err != nil {
+		t.Fatalf("ResolveURLSources failed: %v", err)
+	}
+
+	blocks := messages[0].Content
+	if source := blocks[0].Source; source.Type != types.SourceTypeBase64 || source.MediaType != "image/png" ||
+		source.Data != base64.StdEncoding.EncodeToString(pngHeader) {
+		t.Errorf("unexpected image source: %+v", source)
+	}
+	if source := blocks[1].Source; source.Type != types.SourceTypeBase64 || source.MediaType != types.MediaTypePDF {
+		t.Errorf("unexpected PDF source: %+v", source)
+	}
+	if source := blocks[2].Source; source.Type != types.SourceTypeText || source.Data != "Meeting notes" {
+		t.Errorf("unexpected text source: %+v", source)
+	}
+
+	contents, err := ToCustomGeminiContents(messages)
+	if err != nil {
+		t.Fatalf("ToCustomGeminiContents failed: %v", err)
+	}
+	parts := contents[0].Parts
+	if len(parts) != 3 || parts[2].Text != "Document title: Notes\n\nMeeting notes" {
+		t.Fatalf("unexpected parts: %+v", parts)
+	}
+	if response := contents[2].Parts[0].FunctionResponse; len(response.Parts) != 1 || response.Parts[0].InlineData.MimeType != "image/png" {
+		t.Errorf("expected the tool result image to be fetched, got %+v", response)
+	}
+}
+
+func TestURLFetcher_ResolveURLSourcesError(t *testing.T) {
+	ts, fetcher := newFileServer(t)
+	messages := []types.AnthropicMessage{{Role: types.RoleUser, Content: []types.AnthropicContentBlock{
+		{Type: types.ContentTypeImage, Source: &types.AnthropicSource{Type: types.SourceTypeURL, URL: ts.URL + "/page.html"}},
+	}}}
+
+	err := fetcher.ResolveURLSources(context.Background(), messages)
+	var clientErr *ClientError
+	if !errors.As(err, &clientErr) || clientErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid request error, got %v", err)
+	}
+	if want := fmt.Sprintf("failed to fetch image source %s/page.html", ts.URL); !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error containing %q, got %q", want, err.Error())
+	}
+}
+
+func TestURLFetcher_ResolveURLSourcesGeminiFile(t *testing.T) {
+	// Files in the Gemini File API would be read with the proxy's API key
+	_, fetcher := newFileServer(t)
+	messages := []types.AnthropicMessage{{Role: types.RoleUser, Content: []types.AnthropicContentBlock{
+		{Type: types.ContentTypeDocument, Source: &types.AnthropicSource{
+			Type:      types.SourceTypeURL,
+			URL:       "https://generativelanguage.googleapis.com/v1beta/files/abc123",
+			MediaType: types.MediaTypePDF,
+		}},
+	}}}
+
+	err := fetcher.ResolveURLSources(context.Background(), messages)
+	var clientErr *ClientError
+	if !errors.As(err, &clientErr) || clientErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid request error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "refers to a Gemini file") {
+		t.Errorf("expected the Gemini file to be rejected, got %q", err.Error())
+	}
+}
+
+func TestURLFetcher_ResolveURLSourcesCache(t *testing.T) {
+	var fetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngHeader)
+	}))
+	t.Cleanup(ts.Close)
+
+	fetcher := NewURLFetcher(URLFetchPolicy{MaxBytes: 1024, Timeout: time.Second, CacheTTL: time.Minute, AllowPrivateNetworks: true})
+	now := time.Now()
+	fetcher.now = func() time.Time { return now }
+
+	resolve := func(blockType string) error {
+		t.Helper()
+		messages := []types.AnthropicMessage{{Role: types.RoleUser, Content: []types.AnthropicContentBlock{
+			{Type: blockType, Source: &types.AnthropicSource{Type: types.SourceTypeURL, URL: ts.URL + "/image.png"}},
+		}}}
+		return fetcher.ResolveURLSources(context.Background(), messages)
+	}
+
+	// Later turns of the conversation reuse the content
+	for i := 0; i < 3; i++ {
+		if err := resolve(types.ContentTypeImage); err != nil {
+			t.Fatalf("ResolveURLSources failed: %v", err)
+		}
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("expected the URL to be fetched once, got %d fetches", fetches.Load())
+	}
+
+	// Cached content is still checked against the block type
+	if err := resolve(types.ContentTypeDocument); err == nil {
+		t.Error("expected a cached image to be rejected as a document")
+	}
+
+	now = now.Add(time.Minute)
+	if err := resolve(types.ContentTypeImage); err != nil || fetches.Load() != 2 {
+		t.Errorf("expected the URL to be fetched again once the cache expired, got %d fetches (err=%v)", fetches.Load(), err)
+	}
+}
+
+func TestURLFetcher_ResolveURLSourcesLimits(t *testing.T) {
+	ts, _ := newFileServer(t)
+	messages := func() []types.AnthropicMessage {
+		return []types.AnthropicMessage{{Role: types.RoleUser, Content: []types.AnthropicContentBlock{
+			{Type: types.ContentTypeImage, Source: &types.AnthropicSource{Type: types.SourceTypeURL, URL: ts.URL + "/image.png"}},
+			{Type: types.ContentTypeDocument, Source: &types.AnthropicSource{Type: types.SourceTypeURL, URL: ts.URL + "/report.pdf"}},
+		}}}
+	}
+
+	tests := []struct {
+		name   string
+		policy URLFetchPolicy
+		want   string
+	}{
+		{"sources", URLFetchPolicy{MaxSources: 1}, "too many URL sources"},
+		{"bytes", URLFetchPolicy{MaxRequestBytes: int64(len(pngHeader)) + 1}, "exceed"},
+		{"within limits", URLFetchPolicy{MaxSources: 2, MaxRequestBytes: 1024}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.MaxBytes, tt.policy.Timeout, tt.policy.AllowPrivateNetworks = 1024, time.Second, true
+			err := NewURLFetcher(tt.policy).ResolveURLSources(context.Background(), messages())
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestConvertImage_UnfetchedURL(t *testing.T) {
+	_, err := ToCustomGeminiContents([]types.AnthropicMessage{{Role: types.RoleUser, Content: []types.AnthropicContentBlock{
+		{Type: types.ContentTypeImage, Source: &types.AnthropicSource{Type: types.SourceTypeURL, URL: "https://example.com/a.png"}},
+	}}})
+	if err == nil || !strings.Contains(err.Error(), "was not fetched") {
+		t.Errorf("expected an error for an unfetched URL, got %v", err)
+	}
+}
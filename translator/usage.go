@@ -0,0 +1,74 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+// ToAnthropicUsage converts Gemini usage metadata to Anthropic usage.
+//
+// Anthropic splits the prompt into input_tokens, cache_creation_input_tokens and
+// cache_read_input_tokens, while Gemini's promptTokenCount includes cached tokens. The
+// counts are mapped as follows:
+//   - cache_read_input_tokens is cachedContentTokenCount
+//   - cache_creation_input_tokens is also cachedContentTokenCount when the context cache
+//     was created for this request (cacheCreated), in which case nothing is a cache read;
+//     otherwise it is 0. Gemini bills cache storage by time rather than on creation.
+//   - input_tokens is promptTokenCount plus toolUsePromptTokenCount (the results of
+//     built-in tools such as search, which are fed back into the prompt) minus the cached
+//     tokens
+//   - output_tokens is candidatesTokenCount plus thoughtsTokenCount, as Anthropic counts
+//     thinking as output
+//
+// The three input counts therefore add up to the prompt Gemini processed, and the output
+// count to what it generated.
+func ToAnthropicUsage(usage *UsageMetadata, cacheCreated bool) types.AnthropicUsage {
+	cached := int(usage.CachedContentTokenCount)
+	result := types.AnthropicUsage{
+		InputTokens:  max(int(usage.PromptTokenCount)+int(usage.ToolUsePromptTokenCount)-cached, 0),
+		OutputTokens: int(usage.CandidatesTokenCount) + int(usage.ThoughtsTokenCount),
+	}
+	if cacheCreated {
+		result.CacheCreationInputTokens = cached
+	} else {
+		result.CacheReadInputTokens = cached
+	}
+	return result
+}
+
+// AddUsage returns the sum of two usages, e.g. of a response and of an earlier attempt at
+// it that was billed but discarded
+func AddUsage(a, b types.AnthropicUsage) types.AnthropicUsage {
+	return types.AnthropicUsage{
+		InputTokens:              a.InputTokens + b.InputTokens,
+		OutputTokens:             a.OutputTokens + b.OutputTokens,
+		CacheCreationInputTokens: a.CacheCreationInputTokens + b.CacheCreationInputTokens,
+		CacheReadInputTokens:     a.CacheReadInputTokens + b.CacheReadInputTokens,
+	}
+}
+
+// toUsageMetadata converts SDK usage metadata so that it is reported the same way as the
+// HTTP client's. The SDK doesn't expose thought or tool use prompt counts, and the SDK
+// path never uses a context cache.
+func toUsageMetadata(usage *genai.UsageMetadata) *UsageMetadata {
+	return &UsageMetadata{
+		PromptTokenCount:        usage.PromptTokenCount,
+		CachedContentTokenCount: usage.CachedContentTokenCount,
+		CandidatesTokenCount:    usage.CandidatesTokenCount,
+		TotalTokenCount:         usage.TotalTokenCount,
+	}
+}
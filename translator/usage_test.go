@@ -0,0 +1,117 @@
+// Copyright 2025 Matt Ho
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/savaki/twin-in-disguise/types"
+)
+
+func TestToAnthropicUsage(t *testing.T) {
+	tests := []struct {
+		name         string
+		usage        string
+		cacheCreated bool
+		expected     types.AnthropicUsage
+	}{
+		{
+			name:     "plain",
+			usage:    `{"promptTokenCount": 120, "candidatesTokenCount": 30, "totalTokenCount": 150}`,
+			expected: types.AnthropicUsage{InputTokens: 120, OutputTokens: 30},
+		},
+		{
+			name:     "cache read",
+			usage:    `{"promptTokenCount": 5000, "cachedContentTokenCount": 4096, "candidatesTokenCount": 30, "totalTokenCount": 5030}`,
+			expected: types.AnthropicUsage{InputTokens: 904, CacheReadInputTokens: 4096, OutputTokens: 30},
+		},
+		{
+			name:         "cache created",
+			usage:        `{"promptTokenCount": 5000, "cachedContentTokenCount": 4096, "candidatesTokenCount": 30, "totalTokenCount": 5030}`,
+			cacheCreated: true,
+			expected:     types.AnthropicUsage{InputTokens: 904, CacheCreationInputTokens: 4096, OutputTokens: 30},
+		},
+		{
+			name:     "thoughts and tool use prompt",
+			usage:    `{"promptTokenCount": 100, "toolUsePromptTokenCount": 250, "candidatesTokenCount": 40, "thoughtsTokenCount": 512, "totalTokenCount": 902}`,
+			expected: types.AnthropicUsage{InputTokens: 350, OutputTokens: 552},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp GenerateContentResponse
+			if err := json.Unmarshal([]byte(`{"usageMetadata": `+tt.usage+`}`), &resp); err != nil {
+				t.Fatalf("invalid usage metadata: %v", err)
+			}
+			if got := ToAnthropicUsage(resp.UsageMetadata, tt.cacheCreated); got != tt.expected {
+				t.Errorf("got %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestToAnthropicUsage_SDK(t *testing.T) {
+	// The SDK reports the same counts as the REST API for the fields it has
+	sdk := &genai.GenerateContentResponse{UsageMetadata: &genai.UsageMetadata{
+		PromptTokenCount:        5000,
+		CachedContentTokenCount: 4096,
+		CandidatesTokenCount:    30,
+		TotalTokenCount:         5030,
+	}}
+	custom := &GenerateContentResponse{UsageMetadata: &UsageMetadata{
+		PromptTokenCount:        5000,
+		CachedContentTokenCount: 4096,
+		CandidatesTokenCount:    30,
+		TotalTokenCount:         5030,
+	}}
+
+	sdkResp, err := ToAnthropicResponse(sdk, "gemini-2.5-flash")
+	if err != nil {
+		t.Fatalf("ToAnthropicResponse failed: %v", err)
+	}
+	customResp, err := ToAnthropicResponseFromCustom(custom, "gemini-2.5-flash", ResponseOptions{})
+	if err != nil {
+		t.Fatalf("ToAnthropicResponseFromCustom failed: %v", err)
+	}
+	if sdkResp.Usage != customResp.Usage {
+		t.Errorf("SDK usage %+v doesn't match HTTP usage %+v", sdkResp.Usage, customResp.Usage)
+	}
+	if sdkResp.Usage.CacheReadInputTokens != 4096 || sdkResp.Usage.InputTokens != 904 {
+		t.Errorf("unexpected usage: %+v", sdkResp.Usage)
+	}
+}
+
+func TestAddUsage(t *testing.T) {
+	a := types.AnthropicUsage{InputTokens: 10, OutputTokens: 5, CacheCreationInputTokens: 4096}
+	b := types.AnthropicUsage{InputTokens: 12, OutputTokens: 7, CacheReadInputTokens: 4096}
+	expected := types.AnthropicUsage{InputTokens: 22, OutputTokens: 12, CacheCreationInputTokens: 4096, CacheReadInputTokens: 4096}
+	if got := AddUsage(a, b); got != expected {
+		t.Errorf("got %+v, want %+v", got, expected)
+	}
+}
+
+func TestAnthropicUsage_JSON(t *testing.T) {
+	data, err := json.Marshal(types.AnthropicUsage{InputTokens: 10, OutputTokens: 5})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	const want = `{"input_tokens":10,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":5}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
@@ -14,15 +14,31 @@
 
 package types
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // AnthropicRequest represents an Anthropic API request
 type AnthropicRequest struct {
-	Messages  []AnthropicMessage `json:"messages"`
-	System    interface{}        `json:"system,omitempty"` // Can be string or array of content blocks
-	MaxTokens int                `json:"max_tokens,omitempty"`
-	Tools     []AnthropicTool    `json:"tools,omitempty"`
-	Model     string             `json:"model,omitempty"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	System        interface{}          `json:"system,omitempty"` // Can be string or array of content blocks
+	MaxTokens     int                  `json:"max_tokens,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	Model         string               `json:"model,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Thinking      *AnthropicThinking   `json:"thinking,omitempty"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	TopK          *int                 `json:"top_k,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+// AnthropicThinking represents the extended thinking configuration of a request
+type AnthropicThinking struct {
+	Type         string `json:"type"` // "enabled" or "disabled"
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
 }
 
 // AnthropicMessage represents a message in the conversation
@@ -75,42 +91,171 @@ func (m *AnthropicMessage) UnmarshalJSON(data []byte) error {
 type AnthropicContentBlock struct {
 	Type             string                 `json:"type,omitempty"`
 	Text             string                 `json:"text,omitempty"`
-	Source           *AnthropicImageSource  `json:"source,omitempty"`
+	Source           *AnthropicSource       `json:"source,omitempty"`
 	ID               string                 `json:"id,omitempty"`
 	Name             string                 `json:"name,omitempty"`
 	Input            map[string]interface{} `json:"input,omitempty"`
 	ThoughtSignature string                 `json:"thought_signature,omitempty"` // For tool use blocks
 	ToolUseID        string                 `json:"tool_use_id,omitempty"`       // For tool_result blocks
 	Content          interface{}            `json:"content,omitempty"`           // For tool_result blocks - can be string or array
+	IsError          bool                   `json:"is_error,omitempty"`          // For tool_result blocks
+	Thinking         string                 `json:"thinking,omitempty"`          // For thinking blocks
+	Signature        string                 `json:"signature,omitempty"`         // For thinking blocks
+	Data             string                 `json:"data,omitempty"`              // For redacted_thinking blocks
+	Title            string                 `json:"title,omitempty"`             // For document blocks
+	Context          string                 `json:"context,omitempty"`           // For document blocks
+	Citations        *AnthropicCitations    `json:"citations,omitempty"`         // For document blocks
+	CacheControl     *AnthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+// AnthropicSource represents the source of an image or document. Type is "base64" (Data
+// holds the base64 encoded bytes), "text" (Data holds the text itself), "content" (Content
+// holds a string or an array of content blocks), "url" or "file".
+type AnthropicSource struct {
+	Type      string      `json:"type"`
+	MediaType string      `json:"media_type,omitempty"`
+	Data      string      `json:"data,omitempty"`
+	URL       string      `json:"url,omitempty"`
+	FileID    string      `json:"file_id,omitempty"`
+	Content   interface{} `json:"content,omitempty"`
+
+	// GeminiFileURI is the URI of the Gemini file holding a file source's upload. It is
+	// only set by the proxy itself, never decoded from a request.
+	GeminiFileURI string `json:"-"`
+}
+
+// AnthropicCacheControl marks a prompt caching breakpoint: the prompt up to and including
+// the marked tool, system block or content block may be cached
+type AnthropicCacheControl struct {
+	Type string `json:"type"`          // Always "ephemeral"
+	TTL  string `json:"ttl,omitempty"` // "5m" (the default) or "1h"
 }
 
-// AnthropicImageSource represents an embedded image
-type AnthropicImageSource struct {
-	Type      string `json:"type"`
-	MediaType string `json:"media_type"`
-	Data      string `json:"data"`
+// AnthropicCitations configures citations for a document block
+type AnthropicCitations struct {
+	Enabled bool `json:"enabled"`
 }
 
-// AnthropicTool represents a function/tool definition
+// AnthropicTool represents a function/tool definition. Type is empty or "custom" for
+// user-defined tools, or a versioned built-in tool type such as "bash_20250124", which
+// comes without an input schema.
 type AnthropicTool struct {
+	Type        string                 `json:"type,omitempty"`
 	Name        string                 `json:"name"`
 	Description string                 `json:"description,omitempty"`
 	InputSchema map[string]interface{} `json:"input_schema"`
+
+	CacheControl *AnthropicCacheControl `json:"cache_control,omitempty"`
+
+	// Computer use tools only
+	DisplayWidthPx  int  `json:"display_width_px,omitempty"`
+	DisplayHeightPx int  `json:"display_height_px,omitempty"`
+	DisplayNumber   *int `json:"display_number,omitempty"`
+}
+
+// AnthropicToolChoice controls how the model uses the provided tools
+type AnthropicToolChoice struct {
+	Type                   string `json:"type"`           // "auto", "any", "tool" or "none"
+	Name                   string `json:"name,omitempty"` // For type "tool"
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
 }
 
 // AnthropicResponse represents an Anthropic API response
 type AnthropicResponse struct {
-	ID         string                  `json:"id"`
-	Type       string                  `json:"type"`
-	Role       string                  `json:"role"`
-	Content    []AnthropicContentBlock `json:"content"`
-	Usage      AnthropicUsage          `json:"usage"`
-	Model      string                  `json:"model"`
-	StopReason string                  `json:"stop_reason,omitempty"`
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Content      []AnthropicContentBlock `json:"content"`
+	Usage        AnthropicUsage          `json:"usage"`
+	Model        string                  `json:"model"`
+	StopReason   string                  `json:"stop_reason,omitempty"`
+	StopSequence string                  `json:"stop_sequence,omitempty"`
 }
 
 // AnthropicUsage represents token usage statistics
 type AnthropicUsage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+}
+
+// AnthropicTokenCount is the response of the count_tokens endpoint
+type AnthropicTokenCount struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// AnthropicStreamEvent represents a server-sent event in a streaming response
+type AnthropicStreamEvent struct {
+	Type         string              `json:"type"`
+	Message      *AnthropicResponse  `json:"message,omitempty"`       // For message_start events
+	Index        *int                `json:"index,omitempty"`         // For content_block_* events
+	ContentBlock interface{}         `json:"content_block,omitempty"` // For content_block_start events
+	Delta        *AnthropicDelta     `json:"delta,omitempty"`         // For content_block_delta and message_delta events
+	Usage        *AnthropicUsage     `json:"usage,omitempty"`         // For message_delta events
+	Error        *AnthropicErrorBody `json:"error,omitempty"`         // For error events
+}
+
+// AnthropicDelta represents an incremental update within a streaming response
+type AnthropicDelta struct {
+	Type         string `json:"type,omitempty"`
+	Text         string `json:"text,omitempty"`
+	PartialJSON  string `json:"partial_json,omitempty"`
+	Thinking     string `json:"thinking,omitempty"`
+	Signature    string `json:"signature,omitempty"`
+	StopReason   string `json:"stop_reason,omitempty"`
+	StopSequence string `json:"stop_sequence,omitempty"`
+}
+
+// AnthropicErrorResponse represents an Anthropic API error response
+type AnthropicErrorResponse struct {
+	Type  string             `json:"type"` // Always "error"
+	Error AnthropicErrorBody `json:"error"`
+}
+
+// AnthropicErrorBody represents the error details of an Anthropic error response
+type AnthropicErrorBody struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// AnthropicFile represents the metadata of a file uploaded through the Files API
+type AnthropicFile struct {
+	ID           string    `json:"id"`
+	Type         string    `json:"type"` // Always "file"
+	Filename     string    `json:"filename"`
+	MimeType     string    `json:"mime_type"`
+	SizeBytes    int64     `json:"size_bytes"`
+	CreatedAt    time.Time `json:"created_at"`
+	Downloadable bool      `json:"downloadable"`
+}
+
+// AnthropicFileList represents a page of files, newest first
+type AnthropicFileList struct {
+	Data    []AnthropicFile `json:"data"`
+	HasMore bool            `json:"has_more"`
+	FirstID string          `json:"first_id,omitempty"`
+	LastID  string          `json:"last_id,omitempty"`
+}
+
+// AnthropicFileDeleted represents the response to deleting a file
+type AnthropicFileDeleted struct {
+	ID   string `json:"id"`
+	Type string `json:"type"` // Always "file_deleted"
+}
+
+// AnthropicModel represents a model in the Models API
+type AnthropicModel struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"` // Always "model"
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AnthropicModelList represents a page of models
+type AnthropicModelList struct {
+	Data    []AnthropicModel `json:"data"`
+	HasMore bool             `json:"has_more"`
+	FirstID string           `json:"first_id,omitempty"`
+	LastID  string           `json:"last_id,omitempty"`
 }
@@ -20,6 +20,10 @@ const (
 	ContentTypeImage      = "image"
 	ContentTypeToolUse    = "tool_use"
 	ContentTypeToolResult = "tool_result"
+	ContentTypeDocument   = "document"
+
+	ContentTypeThinking         = "thinking"
+	ContentTypeRedactedThinking = "redacted_thinking"
 )
 
 // Role types
@@ -31,8 +35,106 @@ const (
 
 // Response types
 const (
-	ResponseTypeMessage = "message"
-	StopReasonEndTurn   = "end_turn"
+	ResponseTypeMessage    = "message"
+	ResponseTypeError      = "error"
+	StopReasonEndTurn      = "end_turn"
+	StopReasonStopSequence = "stop_sequence"
+	StopReasonToolUse      = "tool_use"
+	StopReasonMaxTokens    = "max_tokens"
+	StopReasonRefusal      = "refusal"
+)
+
+// Thinking configuration types
+const (
+	ThinkingTypeEnabled  = "enabled"
+	ThinkingTypeDisabled = "disabled"
+)
+
+// Tool choice types
+const (
+	ToolChoiceAuto = "auto"
+	ToolChoiceAny  = "any"
+	ToolChoiceTool = "tool"
+	ToolChoiceNone = "none"
+)
+
+// Image and document source types
+const (
+	SourceTypeBase64  = "base64"
+	SourceTypeText    = "text"
+	SourceTypeContent = "content"
+	SourceTypeURL     = "url"
+	SourceTypeFile    = "file"
+)
+
+// Media types
+const (
+	MediaTypePDF       = "application/pdf"
+	MediaTypePlainText = "text/plain"
+)
+
+// Cache control types
+const (
+	CacheControlTypeEphemeral = "ephemeral"
+)
+
+// Gemini File API file states
+const (
+	FileStateProcessing = "PROCESSING"
+	FileStateActive     = "ACTIVE"
+	FileStateFailed     = "FAILED"
+)
+
+// Files API object types
+const (
+	ObjectTypeFile        = "file"
+	ObjectTypeFileDeleted = "file_deleted"
+)
+
+// Models API object types
+const (
+	ObjectTypeModel = "model"
+)
+
+// GenerationMethodGenerateContent is the generation method of models that can serve
+// messages
+const GenerationMethodGenerateContent = "generateContent"
+
+// Tool types. Built-in client tools have versioned types such as "bash_20250124".
+const (
+	ToolTypeCustom = "custom"
+)
+
+// Stream event types
+const (
+	EventMessageStart      = "message_start"
+	EventMessageDelta      = "message_delta"
+	EventMessageStop       = "message_stop"
+	EventContentBlockStart = "content_block_start"
+	EventContentBlockDelta = "content_block_delta"
+	EventContentBlockStop  = "content_block_stop"
+	EventPing              = "ping"
+	EventError             = "error"
+)
+
+// Stream delta types
+const (
+	DeltaTypeText      = "text_delta"
+	DeltaTypeInputJSON = "input_json_delta"
+	DeltaTypeThinking  = "thinking_delta"
+	DeltaTypeSignature = "signature_delta"
+)
+
+// Error types
+const (
+	ErrorTypeInvalidRequest  = "invalid_request_error"
+	ErrorTypeAuthentication  = "authentication_error"
+	ErrorTypePermission      = "permission_error"
+	ErrorTypeNotFound        = "not_found_error"
+	ErrorTypeRequestTooLarge = "request_too_large"
+	ErrorTypeRateLimit       = "rate_limit_error"
+	ErrorTypeAPI             = "api_error"
+	ErrorTypeOverloaded      = "overloaded_error"
 )
 
 // JSON Schema field names
@@ -45,6 +147,16 @@ const (
 	SchemaFieldItems                = "items"
 	SchemaFieldDollarSchema         = "$schema"
 	SchemaFieldAdditionalProperties = "additionalProperties"
+	SchemaFieldRef                  = "$ref"
+	SchemaFieldDefs                 = "$defs"
+	SchemaFieldDefinitions          = "definitions"
+	SchemaFieldAllOf                = "allOf"
+	SchemaFieldAnyOf                = "anyOf"
+	SchemaFieldOneOf                = "oneOf"
+	SchemaFieldConst                = "const"
+	SchemaFieldFormat               = "format"
+	SchemaFieldNullable             = "nullable"
+	SchemaFieldTitle                = "title"
 )
 
 // JSON Schema type values
@@ -55,6 +167,7 @@ const (
 	SchemaTypeBoolean = "boolean"
 	SchemaTypeArray   = "array"
 	SchemaTypeObject  = "object"
+	SchemaTypeNull    = "null"
 )
 
 // Response field names
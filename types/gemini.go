@@ -14,12 +14,16 @@
 
 package types
 
+import "time"
+
 // GeminiPart represents a part in Gemini's content that may include a thought signature
 type GeminiPart struct {
 	Text             string                  `json:"text,omitempty"`
 	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
 	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
 	InlineData       *GeminiBlob             `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"` // True for thought summary parts
 	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
 }
 
@@ -29,10 +33,18 @@ type GeminiFunctionCall struct {
 	Args map[string]interface{} `json:"args,omitempty"`
 }
 
-// GeminiFunctionResponse represents a function response in Gemini format
+// GeminiFunctionResponse represents a function response in Gemini format. Parts carries
+// media returned by the function; only Gemini 3 models accept it.
 type GeminiFunctionResponse struct {
-	Name     string                 `json:"name"`
-	Response map[string]interface{} `json:"response"`
+	Name     string                       `json:"name"`
+	Response map[string]interface{}       `json:"response"`
+	Parts    []GeminiFunctionResponsePart `json:"parts,omitempty"`
+}
+
+// GeminiFunctionResponsePart represents media attached to a function response
+type GeminiFunctionResponsePart struct {
+	InlineData *GeminiBlob     `json:"inlineData,omitempty"`
+	FileData   *GeminiFileData `json:"fileData,omitempty"`
 }
 
 // GeminiBlob represents binary data in Gemini format
@@ -41,8 +53,47 @@ type GeminiBlob struct {
 	Data     string `json:"data"` // base64 encoded
 }
 
+// GeminiFileData references a file uploaded to the Gemini File API
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
 // GeminiContent represents a content message in Gemini format
 type GeminiContent struct {
 	Role  string       `json:"role"`
 	Parts []GeminiPart `json:"parts"`
 }
+
+// GeminiFile represents a file in the Gemini File API. Files are deleted by Gemini once
+// ExpirationTime passes.
+type GeminiFile struct {
+	Name           string           `json:"name"` // "files/{id}"
+	DisplayName    string           `json:"displayName,omitempty"`
+	MimeType       string           `json:"mimeType"`
+	SizeBytes      int64            `json:"sizeBytes,string"`
+	CreateTime     time.Time        `json:"createTime"`
+	ExpirationTime time.Time        `json:"expirationTime"`
+	URI            string           `json:"uri"`
+	State          string           `json:"state"`
+	Error          *GeminiFileError `json:"error,omitempty"` // Set when State is FAILED
+}
+
+// GeminiModel represents a model returned by Gemini's models.list
+type GeminiModel struct {
+	Name                       string   `json:"name"` // "models/{id}"
+	BaseModelID                string   `json:"baseModelId,omitempty"`
+	Version                    string   `json:"version,omitempty"`
+	DisplayName                string   `json:"displayName,omitempty"`
+	Description                string   `json:"description,omitempty"`
+	InputTokenLimit            int      `json:"inputTokenLimit,omitempty"`
+	OutputTokenLimit           int      `json:"outputTokenLimit,omitempty"`
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods,omitempty"`
+	Thinking                   bool     `json:"thinking,omitempty"`
+}
+
+// GeminiFileError describes why Gemini failed to process a file
+type GeminiFileError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}